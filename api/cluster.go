@@ -13,6 +13,7 @@ import (
 	"github.com/grafana/metrictank/api/models"
 	"github.com/grafana/metrictank/api/response"
 	"github.com/grafana/metrictank/cluster"
+	"github.com/grafana/metrictank/mdata/notifierKafka"
 	"github.com/grafana/metrictank/stats"
 	log "github.com/sirupsen/logrus"
 	"github.com/tinylib/msgp/msgp"
@@ -66,6 +67,42 @@ func (s *Server) appStatus(ctx *middleware.Context) {
 	response.Write(ctx, response.NewError(http.StatusServiceUnavailable, "node not ready"))
 }
 
+// getReadiness reports whether the node is ready, and - if kafka-cluster is enabled - whether
+// none of its partitions lag behind more than max-lag-for-ready.
+func (s *Server) getReadiness(ctx *middleware.Context) {
+	var lags []notifierKafka.PartitionLag
+	if notifierKafka.CliConfig.Enabled {
+		lags = notifierKafka.CliConfig.Lags()
+	}
+	readiness := newReadiness(cluster.Manager.IsReady(), lags, maxLagForReady)
+	code := http.StatusOK
+	if !readiness.Ready {
+		code = http.StatusServiceUnavailable
+	}
+	response.Write(ctx, response.NewJson(code, readiness, ""))
+}
+
+func newReadiness(nodeReady bool, lags []notifierKafka.PartitionLag, maxLag int64) models.Readiness {
+	readiness := models.Readiness{
+		Ready:      nodeReady,
+		NodeReady:  nodeReady,
+		MaxLag:     maxLag,
+		Partitions: lags,
+	}
+	if readiness.Partitions == nil {
+		readiness.Partitions = []notifierKafka.PartitionLag{}
+	}
+	if maxLag <= 0 {
+		return readiness
+	}
+	for _, lag := range lags {
+		if lag.Lag > maxLag {
+			readiness.Ready = false
+		}
+	}
+	return readiness
+}
+
 func (s *Server) getClusterStatus(ctx *middleware.Context) {
 	status := models.ClusterStatus{
 		ClusterName: cluster.ClusterName,
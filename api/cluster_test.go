@@ -0,0 +1,39 @@
+package api
+
+import (
+	"testing"
+
+	"github.com/grafana/metrictank/mdata/notifierKafka"
+)
+
+func TestNewReadiness(t *testing.T) {
+	lags := []notifierKafka.PartitionLag{
+		{Partition: 0, Offset: 90, LogSize: 100, Lag: 10},
+		{Partition: 1, Offset: 50, LogSize: 100, Lag: 50},
+	}
+	cases := []struct {
+		nodeReady bool
+		lags      []notifierKafka.PartitionLag
+		maxLag    int64
+		expReady  bool
+	}{
+		{true, nil, 10, true},
+		{false, nil, 10, false},
+		{true, lags, 0, true},
+		{true, lags, 50, true},
+		{true, lags, 49, false},
+		{false, lags, 100, false},
+	}
+	for i, c := range cases {
+		readiness := newReadiness(c.nodeReady, c.lags, c.maxLag)
+		if readiness.Ready != c.expReady {
+			t.Fatalf("case %d: expected ready %t, got %t", i, c.expReady, readiness.Ready)
+		}
+		if readiness.NodeReady != c.nodeReady {
+			t.Fatalf("case %d: expected node ready %t, got %t", i, c.nodeReady, readiness.NodeReady)
+		}
+		if len(readiness.Partitions) != len(c.lags) || readiness.Partitions == nil {
+			t.Fatalf("case %d: expected %d partitions, got %v", i, len(c.lags), readiness.Partitions)
+		}
+	}
+}
@@ -31,6 +31,7 @@ var (
 	getTargetsConcurrency int
 	tagdbDefaultLimit     uint
 	speculationThreshold  float64
+	maxLagForReady        int64
 
 	graphiteProxy *httputil.ReverseProxy
 	timeZone      *time.Location
@@ -54,6 +55,7 @@ func ConfigSetup() {
 	apiCfg.IntVar(&getTargetsConcurrency, "get-targets-concurrency", 20, "maximum number of concurrent threads for fetching data on the local node. Each thread handles a single series.")
 	apiCfg.UintVar(&tagdbDefaultLimit, "tagdb-default-limit", 100, "default limit for tagdb query results, can be overridden with query parameter \"limit\"")
 	apiCfg.Float64Var(&speculationThreshold, "speculation-threshold", 1, "ratio of peer responses after which speculation is used. Set to 1 to disable.")
+	apiCfg.Int64Var(&maxLagForReady, "max-lag-for-ready", 0, "max number of messages any kafka-cluster partition may lag behind for the /ready endpoint to report the node as ready. (0 disables the check)")
 	globalconf.Register("http", apiCfg, flag.ExitOnError)
 }
 
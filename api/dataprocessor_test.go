@@ -561,7 +561,7 @@ func TestGetSeriesCachedStore(t *testing.T) {
 						c.Add(metric, prevts, itgen)
 					}
 					if pattern[i] == 's' || pattern[i] == 'b' {
-						cwr := mdata.NewChunkWriteRequest(nil, metric, &chunks[i], 0, span, time.Now())
+						cwr := mdata.NewChunkWriteRequest(nil, metric, &chunks[i], 0, span, chunk.CodecGorilla, time.Now())
 						store.Add(&cwr)
 					}
 					prevts = chunks[i].Series.T0
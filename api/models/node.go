@@ -2,6 +2,7 @@ package models
 
 import (
 	"github.com/grafana/metrictank/cluster"
+	"github.com/grafana/metrictank/mdata/notifierKafka"
 	opentracing "github.com/opentracing/opentracing-go"
 	"github.com/raintank/schema"
 )
@@ -25,6 +26,13 @@ type ClusterMembersResp struct {
 	MembersAdded int    `json:"membersAdded"`
 }
 
+type Readiness struct {
+	Ready      bool                         `json:"ready"`
+	NodeReady  bool                         `json:"nodeReady"`
+	MaxLag     int64                        `json:"maxLag"`
+	Partitions []notifierKafka.PartitionLag `json:"partitions"`
+}
+
 type IndexList struct {
 	OrgId uint32 `json:"orgId" form:"orgId" binding:"Required"`
 }
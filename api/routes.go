@@ -27,6 +27,7 @@ func (s *Server) RegisterRoutes() {
 	noTrace := middleware.DisableTracing
 
 	r.Get("/", noTrace, s.appStatus)
+	r.Get("/ready", noTrace, s.getReadiness)
 	r.Get("/node", noTrace, s.getNodeStatus)
 	r.Post("/node", bind(models.NodeStatus{}), s.setNodeStatus)
 	r.Get("/priority", s.explainPriority)
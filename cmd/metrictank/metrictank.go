@@ -27,16 +27,22 @@ import (
 	"github.com/grafana/metrictank/idx/memory"
 	"github.com/grafana/metrictank/input"
 	inCarbon "github.com/grafana/metrictank/input/carbon"
+	inInflux "github.com/grafana/metrictank/input/influx"
 	inKafkaMdm "github.com/grafana/metrictank/input/kafkamdm"
+	inOTLP "github.com/grafana/metrictank/input/otlp"
 	inPrometheus "github.com/grafana/metrictank/input/prometheus"
+	inStatsd "github.com/grafana/metrictank/input/statsd"
 	"github.com/grafana/metrictank/logger"
 	"github.com/grafana/metrictank/mdata"
 	"github.com/grafana/metrictank/mdata/cache"
+	"github.com/grafana/metrictank/mdata/notifierAMQP"
 	"github.com/grafana/metrictank/mdata/notifierKafka"
+	"github.com/grafana/metrictank/mdata/notifierNSQ"
 	"github.com/grafana/metrictank/stats"
 	statsConfig "github.com/grafana/metrictank/stats/config"
 	bigtableStore "github.com/grafana/metrictank/store/bigtable"
 	cassandraStore "github.com/grafana/metrictank/store/cassandra"
+	"github.com/prometheus/client_golang/prometheus"
 	"github.com/raintank/dur"
 	log "github.com/sirupsen/logrus"
 )
@@ -109,6 +115,10 @@ func main() {
 	inCarbon.ConfigSetup()
 	inKafkaMdm.ConfigSetup()
 	inPrometheus.ConfigSetup()
+	inInflux.ConfigSetup()
+	inOTLP.ConfigSetup()
+	inStatsd.ConfigSetup()
+	input.ConfigSetup()
 
 	// load config for metricIndexers
 	memory.ConfigSetup()
@@ -179,14 +189,20 @@ func main() {
 	inKafkaMdm.ConfigProcess(*instance)
 	memory.ConfigProcess()
 	inPrometheus.ConfigProcess()
+	inInflux.ConfigProcess()
+	inOTLP.ConfigProcess()
+	inStatsd.ConfigProcess()
+	input.ConfigProcess()
 	notifierKafka.ConfigProcess(*instance)
+	notifierNSQ.ConfigProcess(*instance)
+	notifierAMQP.ConfigProcess(*instance)
 	statsConfig.ConfigProcess(*instance)
 	mdata.ConfigProcess()
 	cassandra.ConfigProcess()
 	bigtable.ConfigProcess()
 	bigtableStore.ConfigProcess(mdata.MaxChunkSpan())
 
-	inputEnabled := inCarbon.Enabled || inKafkaMdm.Enabled || inPrometheus.Enabled
+	inputEnabled := inCarbon.Enabled || inKafkaMdm.Enabled || inPrometheus.Enabled || inInflux.Enabled || inOTLP.Enabled || inStatsd.Enabled
 	wantInput := cluster.Mode == cluster.ModeDev || cluster.Mode == cluster.ModeShard
 	if !inputEnabled && wantInput {
 		log.Fatal("you should enable at least 1 input plugin in 'dev' or 'shard' cluster mode")
@@ -316,6 +332,18 @@ func main() {
 		inputs = append(inputs, inPrometheus.New())
 	}
 
+	if inInflux.Enabled {
+		inputs = append(inputs, inInflux.New())
+	}
+
+	if inOTLP.Enabled {
+		inputs = append(inputs, inOTLP.New())
+	}
+
+	if inStatsd.Enabled {
+		inputs = append(inputs, inStatsd.New())
+	}
+
 	if inKafkaMdm.Enabled {
 		sarama.Logger = l.New(os.Stdout, "[Sarama] ", l.LstdFlags)
 		inputs = append(inputs, inKafkaMdm.New())
@@ -398,14 +426,34 @@ func main() {
 	***********************************/
 	var notifiers []mdata.Notifier
 	if wantInput {
-		if notifierKafka.Enabled {
+		if notifierKafka.CliConfig.Enabled {
 			// The notifierKafka notifiers will block here until it has processed the backlog of metricPersist messages.
 			// it will block for at most kafka-cluster.backlog-process-timeout (default 60s)
-			notifiers = append(notifiers, notifierKafka.New(*instance, mdata.NewDefaultNotifierHandler(metrics, metricIndex)))
+			// expose the partition lag on the /prometheus/metrics endpoint
+			notifierKafka.CliConfig.Registerer = prometheus.DefaultRegisterer
+			notifierKafka.CliConfig.Version = version
+			if notifierKafka.CliConfig.TagRoutes != "" {
+				notifierKafka.CliConfig.PartitionResolver = notifierKafka.NewTagRouter(notifierKafka.CliConfig, metricIndex)
+			}
+			notifiers = append(notifiers, notifierKafka.New(notifierKafka.CliConfig, *instance, mdata.NewDefaultNotifierHandler(metrics, metricIndex)))
+		}
+		if notifierNSQ.CliConfig.Enabled {
+			// The notifierNSQ notifier will block here until it has processed the backlog of metricPersist messages.
+			// it will block for at most nsq-cluster.backlog-process-timeout (default 60s)
+			notifiers = append(notifiers, notifierNSQ.New(notifierNSQ.CliConfig, *instance, mdata.NewDefaultNotifierHandler(metrics, metricIndex)))
+		}
+		if notifierAMQP.CliConfig.Enabled {
+			// The notifierAMQP notifier will block here until it has processed the backlog of metricPersist messages.
+			// it will block for at most amqp-cluster.backlog-process-timeout (default 60s)
+			notifiers = append(notifiers, notifierAMQP.New(notifierAMQP.CliConfig, *instance, mdata.NewDefaultNotifierHandler(metrics, metricIndex)))
 		}
 		mdata.InitPersistNotifier(notifiers...)
+
+		// recover the points of the chunks that were not saved before a crash.
+		// this happens after the persist notifiers processed their backlog, so we know which chunks were saved
+		mdata.ReplayPointWAL(metrics, metricIndex)
 	}
-	if !wantInput && notifierKafka.Enabled {
+	if !wantInput && (notifierKafka.CliConfig.Enabled || notifierNSQ.CliConfig.Enabled || notifierAMQP.CliConfig.Enabled) {
 		log.Fatal("you should disable notifier plugins in 'query' cluster mode")
 	}
 
@@ -499,6 +547,7 @@ func shutdown() {
 	}
 
 	if cluster.Mode != cluster.ModeQuery {
+		mdata.ClosePointWAL()
 		log.Info("closing store")
 		store.Stop()
 		log.Info("closing index")
@@ -8,6 +8,7 @@ import (
 	"time"
 
 	"github.com/grafana/metrictank/conf"
+	"github.com/grafana/metrictank/idx"
 	"github.com/grafana/metrictank/idx/cassandra"
 	"github.com/grafana/metrictank/idx/memory"
 	"github.com/grafana/metrictank/logger"
@@ -103,7 +104,8 @@ func main() {
 		os.Exit(1)
 	}
 	now := time.Now()
-	cutoffs := indexRules.Cutoffs(now)
+	nowUnix := now.Unix()
+	maxAges := indexRules.MaxAges()
 
 	cassFlags.Parse(os.Args[cassI+1:])
 	cassandra.CliConfig.Enabled = true
@@ -139,16 +141,17 @@ func main() {
 		}
 
 		for name, defs := range defsByNameWithTags {
-			// find the latest LastUpdate ts
-			latest := int64(0)
-			for _, def := range defs {
-				if def.LastUpdate > latest {
-					latest = def.LastUpdate
+			// the metric is deprecated if all its defs are stale
+			irId, _ := indexRules.Match(name)
+			stale := true
+			for i := range defs {
+				if !idx.DefIsStale(&defs[i], nowUnix, maxAges[irId]) {
+					stale = false
+					break
 				}
 			}
 
-			irId, _ := indexRules.Match(name)
-			if latest < cutoffs[irId] {
+			if stale {
 				for _, def := range defs {
 					deprecatedDefs = append(deprecatedDefs, def)
 				}
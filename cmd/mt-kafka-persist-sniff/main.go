@@ -32,7 +32,7 @@ func main() {
 	notifierKafka.FlagSet.Usage = flag.Usage
 	notifierKafka.FlagSet.Parse(os.Args[1:])
 	// config may have had it disabled
-	notifierKafka.Enabled = true
+	notifierKafka.CliConfig.Enabled = true
 
 	stats.NewDevnull() // make sure metrics don't pile up without getting discarded
 
@@ -40,7 +40,7 @@ func main() {
 
 	done := make(chan struct{})
 	go func() {
-		notifierKafka.New(instance, NewPrintNotifierHandler())
+		notifierKafka.New(notifierKafka.CliConfig, instance, NewPrintNotifierHandler())
 		close(done)
 	}()
 	sigChan := make(chan os.Signal, 1)
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"math"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/grafana/globalconf"
+	"github.com/grafana/metrictank/logger"
+	"github.com/grafana/metrictank/mdata/notifierKafka"
+	log "github.com/sirupsen/logrus"
+)
+
+var (
+	confFile            = flag.String("config", "/etc/metrictank/metrictank.ini", "configuration file path")
+	verify              = flag.Bool("verify", false, "only verify that the topics exist with the expected settings, rather than creating them. exits with status 1 if they don't")
+	retention           = flag.Duration("retention", 24*time.Hour, "retention of the persist topics")
+	deadLetterTopic     = flag.String("dead-letter-topic", "", "topic to set up for persist messages that could not be processed. empty to skip")
+	deadLetterRetention = flag.Duration("dead-letter-retention", 7*24*time.Hour, "retention of the dead-letter topic")
+)
+
+// cluster is a kafka cluster and the topics to set up on it
+type cluster struct {
+	brokers []string
+	topics  []topicSpec
+}
+
+func main() {
+	flag.Usage = func() {
+		fmt.Fprintln(os.Stderr, "mt-kafka-topic-setup")
+		fmt.Fprintln(os.Stderr)
+		fmt.Fprintln(os.Stderr, "Creates the kafka topics for the persist messages of the kafka-cluster notifier,")
+		fmt.Fprintln(os.Stderr, "with the brokers, topics, partition count, replication factor and min.insync.replicas of the kafka-cluster section of the config file.")
+		fmt.Fprintln(os.Stderr, "topics that already exist are left alone. use -verify to check their settings.")
+		fmt.Fprintf(os.Stderr, "\nFlags:\n\n")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	formatter := &logger.TextFormatter{}
+	formatter.TimestampFormat = "2006-01-02 15:04:05.000"
+	log.SetFormatter(formatter)
+	log.SetLevel(log.InfoLevel)
+
+	// Only try and parse the conf file if it exists
+	path := ""
+	if _, err := os.Stat(*confFile); err == nil {
+		path = *confFile
+	}
+	conf, err := globalconf.NewWithOptions(&globalconf.Options{
+		Filename:  path,
+		EnvPrefix: "MT_",
+	})
+	if err != nil {
+		log.Fatalf("error with configuration file: %s", err.Error())
+	}
+	conf.ParseAll()
+
+	cfg := notifierKafka.CliConfig
+	kafkaVersion, err := sarama.ParseKafkaVersion(cfg.KafkaVersion)
+	if err != nil {
+		log.Fatalf("invalid kafka-version. %s", err)
+	}
+	config := sarama.NewConfig()
+	config.ClientID = "mt-kafka-topic-setup"
+	config.Version = kafkaVersion
+
+	clusters, err := getClusters(cfg)
+	if err != nil {
+		log.Fatal(err.Error())
+	}
+
+	ok := true
+	for _, c := range clusters {
+		if !setup(c, config, *verify) {
+			ok = false
+		}
+	}
+	if !ok {
+		os.Exit(1)
+	}
+}
+
+// getClusters returns the topics to set up according to the kafka-cluster config, by cluster.
+// we consume from all topics, but only publish to the first one (and the dead-letter topic), so
+// if we publish to a separate cluster, only those need to exist there.
+func getClusters(cfg *notifierKafka.NotifierKafkaConfig) ([]cluster, error) {
+	if cfg.TopicNumPartitions < 1 {
+		return nil, fmt.Errorf("kafka-cluster.topic-num-partitions must be >= 1")
+	}
+	if cfg.TopicReplicationFactor < 1 || cfg.TopicReplicationFactor > math.MaxInt16 {
+		return nil, fmt.Errorf("kafka-cluster.topic-replication-factor must be between 1 and %d", math.MaxInt16)
+	}
+	if cfg.TopicMinInsyncReplicas < 1 || cfg.TopicMinInsyncReplicas > cfg.TopicReplicationFactor {
+		return nil, fmt.Errorf("kafka-cluster.topic-min-insync-replicas must be >= 1 and <= topic-replication-factor (%d)", cfg.TopicReplicationFactor)
+	}
+	spec := func(topic string, retention time.Duration) topicSpec {
+		return newTopicSpec(topic, int32(cfg.TopicNumPartitions), int16(cfg.TopicReplicationFactor), cfg.TopicMinInsyncReplicas, retention)
+	}
+
+	consumerBrokers := cfg.Brokers
+	if cfg.ConsumerBrokers != "" {
+		consumerBrokers = cfg.ConsumerBrokers
+	}
+	producerBrokers := cfg.Brokers
+	if cfg.ProducerBrokers != "" {
+		producerBrokers = cfg.ProducerBrokers
+	}
+
+	topics := strings.Split(cfg.Topic, ",")
+	for _, topic := range topics {
+		if topic == "" || topic == *deadLetterTopic {
+			return nil, fmt.Errorf("invalid topic %q. kafka-cluster.topic must be a comma separated list of topic names, different from the dead-letter topic", topic)
+		}
+	}
+
+	consumer := cluster{brokers: strings.Split(consumerBrokers, ",")}
+	for _, topic := range topics {
+		consumer.topics = append(consumer.topics, spec(topic, *retention))
+	}
+	producer := &consumer
+	if producerBrokers != consumerBrokers {
+		producer = &cluster{
+			brokers: strings.Split(producerBrokers, ","),
+			topics:  []topicSpec{spec(topics[0], *retention)},
+		}
+	}
+	if *deadLetterTopic != "" {
+		producer.topics = append(producer.topics, spec(*deadLetterTopic, *deadLetterRetention))
+	}
+	if producer == &consumer {
+		return []cluster{consumer}, nil
+	}
+	return []cluster{consumer, *producer}, nil
+}
+
+// setup creates the topics of the cluster, or only verifies them.
+// it returns whether all topics are set up correctly.
+func setup(c cluster, config *sarama.Config, verifyOnly bool) bool {
+	client, err := sarama.NewClient(c.brokers, config)
+	if err != nil {
+		log.Fatalf("failed to connect to %v: %s", c.brokers, err)
+	}
+	defer client.Close()
+	admin, err := sarama.NewClusterAdmin(c.brokers, config)
+	if err != nil {
+		log.Fatalf("failed to create cluster admin for %v: %s", c.brokers, err)
+	}
+	defer admin.Close()
+
+	ok := true
+	for _, spec := range c.topics {
+		if !verifyOnly {
+			created, err := spec.create(client, admin)
+			if err != nil {
+				log.Errorf("%v: failed to create topic %s: %s", c.brokers, spec.name, err)
+				ok = false
+				continue
+			}
+			if created {
+				log.Infof("%v: created topic %s with %d partitions, replication factor %d and config %v", c.brokers, spec.name, spec.partitions, spec.replicationFactor, spec.configs)
+				continue
+			}
+			log.Infof("%v: topic %s already exists", c.brokers, spec.name)
+		}
+		problems, err := spec.verify(client, admin)
+		if err != nil {
+			log.Errorf("%v: failed to verify topic %s: %s", c.brokers, spec.name, err)
+			ok = false
+			continue
+		}
+		for _, problem := range problems {
+			log.Warnf("%v: topic %s: %s", c.brokers, spec.name, problem)
+		}
+		if len(problems) > 0 {
+			ok = false
+			continue
+		}
+		log.Infof("%v: topic %s is set up correctly", c.brokers, spec.name)
+	}
+	return ok
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/Shopify/sarama"
+)
+
+// topicSpec describes how a topic should be set up
+type topicSpec struct {
+	name              string
+	partitions        int32
+	replicationFactor int16
+	configs           map[string]string // topic level config entries, e.g. retention.ms
+}
+
+// newTopicSpec returns the spec of a topic with the given retention.
+// persist messages are published without a key, so the topics can't be compacted:
+// old messages are deleted once they're older than the retention instead.
+func newTopicSpec(name string, partitions int32, replicationFactor int16, minInsyncReplicas int, retention time.Duration) topicSpec {
+	return topicSpec{
+		name:              name,
+		partitions:        partitions,
+		replicationFactor: replicationFactor,
+		configs: map[string]string{
+			"cleanup.policy":      "delete",
+			"min.insync.replicas": strconv.Itoa(minInsyncReplicas),
+			"retention.ms":        strconv.FormatInt(int64(retention/time.Millisecond), 10),
+		},
+	}
+}
+
+// detail returns the TopicDetail to create the topic with
+func (s topicSpec) detail() *sarama.TopicDetail {
+	entries := make(map[string]*string, len(s.configs))
+	for name := range s.configs {
+		value := s.configs[name]
+		entries[name] = &value
+	}
+	return &sarama.TopicDetail{
+		NumPartitions:     s.partitions,
+		ReplicationFactor: s.replicationFactor,
+		ConfigEntries:     entries,
+	}
+}
+
+// configNames returns the names of the config entries of the spec, sorted
+func (s topicSpec) configNames() []string {
+	names := make([]string, 0, len(s.configs))
+	for name := range s.configs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// diffConfig returns a description of every config entry of the spec that
+// does not have the expected value in the given entries of the existing topic
+func (s topicSpec) diffConfig(entries []sarama.ConfigEntry) []string {
+	actual := make(map[string]string, len(entries))
+	for _, entry := range entries {
+		actual[entry.Name] = entry.Value
+	}
+	var problems []string
+	for _, name := range s.configNames() {
+		value, ok := actual[name]
+		if !ok {
+			problems = append(problems, fmt.Sprintf("%s is not set, expected %s", name, s.configs[name]))
+			continue
+		}
+		if value != s.configs[name] {
+			problems = append(problems, fmt.Sprintf("%s is %s, expected %s", name, value, s.configs[name]))
+		}
+	}
+	return problems
+}
+
+// verify checks that the topic exists with the partition count, replication factor
+// and config of the spec, and returns a description of every difference.
+func (s topicSpec) verify(client sarama.Client, admin sarama.ClusterAdmin) ([]string, error) {
+	partitions, err := client.Partitions(s.name)
+	if err == sarama.ErrUnknownTopicOrPartition {
+		return []string{"topic does not exist"}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get partitions: %s", err)
+	}
+	var problems []string
+	if int32(len(partitions)) != s.partitions {
+		problems = append(problems, fmt.Sprintf("has %d partitions, expected %d", len(partitions), s.partitions))
+	}
+	for _, partition := range partitions {
+		replicas, err := client.Replicas(s.name, partition)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get replicas of partition %d: %s", partition, err)
+		}
+		if int16(len(replicas)) != s.replicationFactor {
+			problems = append(problems, fmt.Sprintf("partition %d has %d replicas, expected %d", partition, len(replicas), s.replicationFactor))
+		}
+	}
+	entries, err := admin.DescribeConfig(sarama.ConfigResource{
+		Type:        sarama.TopicResource,
+		Name:        s.name,
+		ConfigNames: s.configNames(),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to describe config: %s", err)
+	}
+	return append(problems, s.diffConfig(entries)...), nil
+}
+
+// create creates the topic, unless it already exists. it returns whether it created the topic.
+func (s topicSpec) create(client sarama.Client, admin sarama.ClusterAdmin) (bool, error) {
+	topics, err := client.Topics()
+	if err != nil {
+		return false, fmt.Errorf("failed to list topics: %s", err)
+	}
+	for _, topic := range topics {
+		if topic == s.name {
+			return false, nil
+		}
+	}
+	err = admin.CreateTopic(s.name, s.detail(), false)
+	if err == sarama.ErrTopicAlreadyExists {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, client.RefreshMetadata(s.name)
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/Shopify/sarama"
+)
+
+func TestTopicSpecDiffConfig(t *testing.T) {
+	spec := newTopicSpec("metricpersist", 8, 3, 2, 24*time.Hour)
+	cases := []struct {
+		name    string
+		entries []sarama.ConfigEntry
+		exp     []string
+	}{
+		{
+			"matching",
+			[]sarama.ConfigEntry{
+				{Name: "cleanup.policy", Value: "delete"},
+				{Name: "min.insync.replicas", Value: "2"},
+				{Name: "retention.ms", Value: "86400000"},
+			},
+			nil,
+		},
+		{
+			"compacted with default retention",
+			[]sarama.ConfigEntry{
+				{Name: "cleanup.policy", Value: "compact"},
+				{Name: "min.insync.replicas", Value: "2"},
+			},
+			[]string{
+				"cleanup.policy is compact, expected delete",
+				"retention.ms is not set, expected 86400000",
+			},
+		},
+	}
+	for _, c := range cases {
+		got := spec.diffConfig(c.entries)
+		if !reflect.DeepEqual(got, c.exp) {
+			t.Fatalf("case %q: expected %v, got %v", c.name, c.exp, got)
+		}
+	}
+}
+
+func TestTopicSpecDetail(t *testing.T) {
+	detail := newTopicSpec("metricpersist", 8, 3, 2, time.Hour).detail()
+	if detail.NumPartitions != 8 || detail.ReplicationFactor != 3 {
+		t.Fatalf("expected 8 partitions and replication factor 3, got %d and %d", detail.NumPartitions, detail.ReplicationFactor)
+	}
+	exp := map[string]string{
+		"cleanup.policy":      "delete",
+		"min.insync.replicas": "2",
+		"retention.ms":        "3600000",
+	}
+	got := make(map[string]string)
+	for name, value := range detail.ConfigEntries {
+		got[name] = *value
+	}
+	if !reflect.DeepEqual(got, exp) {
+		t.Fatalf("expected config entries %v, got %v", exp, got)
+	}
+}
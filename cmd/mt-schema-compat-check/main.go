@@ -0,0 +1,116 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/gocql/gocql"
+	"github.com/grafana/metrictank/idx"
+	"github.com/grafana/metrictank/logger"
+	"github.com/raintank/schema"
+	log "github.com/sirupsen/logrus"
+)
+
+var (
+	logLevel = flag.String("log-level", "info", "log level. panic|fatal|error|warning|info|debug")
+	cassAddr = flag.String("cass-addr", "localhost", "Address of cassandra host.")
+	keyspace = flag.String("keyspace", "metrictank", "Cassandra keyspace to use.")
+	table    = flag.String("table", "metric_idx", "Cassandra table with the index entries.")
+	limit    = flag.Int("limit", 10000, "number of index entries to check. 0 to check all of them")
+	timeout  = flag.Duration("timeout", 10*time.Second, "cassandra query timeout")
+	verbose  = flag.Bool("verbose", false, "print every index entry that gets checked")
+)
+
+func main() {
+	flag.Usage = func() {
+		fmt.Fprintln(os.Stderr, "mt-schema-compat-check")
+		fmt.Fprintln(os.Stderr)
+		fmt.Fprintln(os.Stderr, "Checks that this version of metrictank can read the index entries in cassandra, e.g. before an upgrade.")
+		fmt.Fprintln(os.Stderr, "Reads a sample of index entries, decodes them into metric definitions the way the cassandra index does,")
+		fmt.Fprintln(os.Stderr, "and validates them. Prints the entries that fail, and exits with status 1 if there are any.")
+		fmt.Fprintf(os.Stderr, "\nFlags:\n\n")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	formatter := &logger.TextFormatter{}
+	formatter.TimestampFormat = "2006-01-02 15:04:05.000"
+	log.SetFormatter(formatter)
+	lvl, err := log.ParseLevel(*logLevel)
+	if err != nil {
+		log.Fatalf("failed to parse log-level, %s", err.Error())
+	}
+	log.SetLevel(lvl)
+
+	cluster := gocql.NewCluster(*cassAddr)
+	cluster.Consistency = gocql.ParseConsistency("one")
+	cluster.Timeout = *timeout
+	cluster.NumConns = 2
+	cluster.ProtoVersion = 4
+	cluster.Keyspace = *keyspace
+	session, err := cluster.CreateSession()
+	if err != nil {
+		log.Fatalf("failed to create cql session. %s", err.Error())
+	}
+	defer session.Close()
+
+	q := fmt.Sprintf("SELECT id, orgid, partition, name, interval, unit, mtype, tags, lastupdate FROM %s", *table)
+	if *limit > 0 {
+		q += fmt.Sprintf(" LIMIT %d", *limit)
+	}
+	iter := session.Query(q).Iter()
+
+	var checked, failed int
+	var id, name, unit, mtype string
+	var orgId, interval int
+	var partition int32
+	var lastupdate int64
+	var tags []string
+	for iter.Scan(&id, &orgId, &partition, &name, &interval, &unit, &mtype, &tags, &lastupdate) {
+		checked++
+		if *verbose {
+			fmt.Printf("checking %s %s\n", id, name)
+		}
+		if err := check(id, orgId, partition, name, interval, unit, mtype, tags, lastupdate); err != nil {
+			failed++
+			fmt.Printf("FAIL %s %s: %s\n", id, name, err)
+		}
+	}
+	// the iterator fails when the columns can't be decoded into the types we expect
+	if err := iter.Close(); err != nil {
+		fmt.Printf("FAIL reading index entries: %s\n", err)
+		fmt.Printf("checked %d index entries, %d failed\n", checked, failed)
+		os.Exit(1)
+	}
+
+	fmt.Printf("checked %d index entries, %d failed\n", checked, failed)
+	if failed > 0 {
+		os.Exit(1)
+	}
+}
+
+// check decodes the index entry into a metric definition, like the cassandra index does when loading it,
+// and validates it
+func check(id string, orgId int, partition int32, name string, interval int, unit, mtype string, tags []string, lastupdate int64) error {
+	mkey, err := schema.MKeyFromString(id)
+	if err != nil {
+		return fmt.Errorf("could not parse id: %s", err)
+	}
+	if orgId < 0 {
+		orgId = int(idx.OrgIdPublic)
+	}
+	def := schema.MetricDefinition{
+		Id:         mkey,
+		OrgId:      uint32(orgId),
+		Partition:  partition,
+		Name:       name,
+		Interval:   interval,
+		Unit:       unit,
+		Mtype:      mtype,
+		Tags:       tags,
+		LastUpdate: lastupdate,
+	}
+	return def.Validate()
+}
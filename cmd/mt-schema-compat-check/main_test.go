@@ -0,0 +1,27 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestCheck(t *testing.T) {
+	id := "1.01234567890123456789012345678901"
+	cases := []struct {
+		name  string
+		id    string
+		mtype string
+		tags  []string
+		ok    bool
+	}{
+		{"valid", id, "gauge", []string{"a=b"}, true},
+		{"bad id", "1.0123", "gauge", nil, false},
+		{"bad mtype", id, "histogram", nil, false},
+		{"bad tag", id, "gauge", []string{"a"}, false},
+	}
+	for _, c := range cases {
+		err := check(c.id, 1, 0, "some.metric", 10, "unknown", c.mtype, c.tags, 0)
+		if (err == nil) != c.ok {
+			t.Fatalf("%s: expected ok=%t, got err %v", c.name, c.ok, err)
+		}
+	}
+}
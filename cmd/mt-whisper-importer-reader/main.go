@@ -3,6 +3,7 @@ package main
 import (
 	"crypto/tls"
 	"encoding/base64"
+	"errors"
 	"flag"
 	"fmt"
 	"io"
@@ -22,7 +23,10 @@ import (
 	"github.com/grafana/metrictank/logger"
 	"github.com/grafana/metrictank/mdata/chunk"
 	"github.com/grafana/metrictank/mdata/chunk/archive"
+	"github.com/grafana/metrictank/stats"
+	"github.com/grafana/metrictank/util"
 	"github.com/kisielk/whisper-go/whisper"
+	"github.com/raintank/dur"
 	"github.com/raintank/schema"
 	log "github.com/sirupsen/logrus"
 )
@@ -88,23 +92,77 @@ var (
 		0,
 		"Only import after the specified timestamp",
 	)
+	from = flag.String(
+		"from",
+		"",
+		"Only import points at or after this time (inclusive). unix timestamp or relative to now, e.g. -90d. overrides import-after",
+	)
+	until = flag.String(
+		"until",
+		"",
+		"Only import points before this time (exclusive). unix timestamp or relative to now, e.g. -1d. overrides import-up-to",
+	)
 	positionFile = flag.String(
 		"position-file",
 		"",
 		"file to store position and load position from",
 	)
+	positionFileBinary = flag.Bool(
+		"position-file-binary",
+		false,
+		"use a binary format when creating a new position file, which loads faster for large amounts of files. the format of existing files is detected automatically",
+	)
+	dryRun = flag.Bool(
+		"dry-run",
+		false,
+		"only report what would be imported, without sending any data or updating the position file",
+	)
+	maxGapRatio = flag.Float64(
+		"max-gap-ratio",
+		1,
+		"skip whisper files in which the ratio of NaN points to all points exceeds this value (0-1)",
+	)
+	rateLimit = flag.Float64(
+		"rate-limit",
+		0,
+		"maximum amount of whisper files to send per second. every file counts as one, regardless of how many points it holds. 0 to disable",
+	)
+	statsAddr = flag.String(
+		"stats-addr",
+		"",
+		"graphite address to report the metrics of the importer to, such as whisper_xFilesFactor_violations_total. empty to disable",
+	)
+	statsPrefix = flag.String(
+		"stats-prefix",
+		"mt-whisper-importer-reader.stats",
+		"prefix of the metrics reported to stats-addr",
+	)
 	verbose = flag.Bool(
 		"verbose",
 		false,
 		"More detailed logging",
 	)
+	matchPatterns  globList
 	schemas        conf.Schemas
 	nameFilter     *regexp.Regexp
+	limiter        *fileLimiter
 	processedCount uint32
+	errTooManyGaps = errors.New("ratio of NaN points exceeds max-gap-ratio")
 	skippedCount   uint32
+
+	// metric whisper_xFilesFactor_violations_total is a counter of archives whose fill ratio is below the xFilesFactor of their whisper file
+	xFilesFactorViolations = stats.NewCounter32("whisper_xFilesFactor_violations_total")
+
+	// totals reported at the end of a dry run
+	dryRunPoints   uint64
+	dryRunBytes    uint64
+	dryRunFailedMu sync.Mutex
+	dryRunFailed   []string
 )
 
 func init() {
+	flag.Var(&matchPatterns, "match", "graphite-style glob pattern, supporting *, ?, [...] and {a,b}. only metrics whose names match it will be imported. may be given multiple times, metrics matching any of the patterns will be imported")
+
 	formatter := &logger.TextFormatter{}
 	formatter.TimestampFormat = "2006-01-02 15:04:05.000"
 	log.SetFormatter(formatter)
@@ -120,6 +178,18 @@ func main() {
 		log.SetLevel(log.InfoLevel)
 	}
 
+	after, upTo, err := parseTimeRange(*from, *until, time.Now())
+	if err != nil {
+		log.Fatalf("Invalid time range: %s", err.Error())
+	}
+	*importAfter, *importUpTo = uint(after), uint(upTo)
+
+	if *statsAddr != "" {
+		stats.NewGraphite(*statsPrefix, *statsAddr, 1, 1000, 10*time.Second)
+	} else {
+		stats.NewDevnull() // make sure metrics don't pile up without getting discarded
+	}
+
 	nameFilter = regexp.MustCompile(*nameFilterPattern)
 	schemas, err = conf.ReadSchemas(*dstSchemas)
 	if err != nil {
@@ -128,13 +198,24 @@ func main() {
 
 	var pos *posTracker
 	if len(*positionFile) > 0 {
-		pos, err = NewPositionTracker(*positionFile)
+		var opts []Option
+		if *positionFileBinary {
+			opts = append(opts, BinaryFormat)
+		}
+		pos, err = NewPositionTracker(*positionFile, opts...)
 		if err != nil {
 			log.Fatalf("Error instantiating position tracker: %s", err.Error())
 		}
 		defer pos.Close()
 	}
 
+	if *rateLimit > 0 && !*dryRun {
+		limiter = newFileLimiter(*rateLimit)
+		stop := make(chan struct{})
+		defer close(stop)
+		go limiter.report(10*time.Second, stop)
+	}
+
 	fileChan := make(chan string)
 
 	wg := &sync.WaitGroup{}
@@ -145,6 +226,21 @@ func main() {
 
 	getFileListIntoChan(pos, fileChan)
 	wg.Wait()
+
+	log.Infof("Found %d archives with a fill ratio below their xFilesFactor", xFilesFactorViolations.Peek())
+	if *statsAddr != "" {
+		// give the stats reporter a chance to send the final values
+		time.Sleep(2 * time.Second)
+	}
+
+	if *dryRun {
+		log.Infof("Dry run: %d files, %d points, %d bytes of chunk data would be imported", atomic.LoadUint32(&processedCount), atomic.LoadUint64(&dryRunPoints), atomic.LoadUint64(&dryRunBytes))
+		dryRunFailedMu.Lock()
+		for _, file := range dryRunFailed {
+			log.Infof("Dry run: %s would be skipped due to errors", file)
+		}
+		dryRunFailedMu.Unlock()
+	}
 }
 
 func processFromChan(pos *posTracker, files chan string, wg *sync.WaitGroup) {
@@ -157,74 +253,134 @@ func processFromChan(pos *posTracker, files chan string, wg *sync.WaitGroup) {
 		fd, err := os.Open(file)
 		if err != nil {
 			log.Errorf("Failed to open whisper file %q: %q\n", file, err.Error())
+			dryRunFailure(file)
 			continue
 		}
 		w, err := whisper.OpenWhisper(fd)
 		if err != nil {
 			log.Errorf("Failed to open whisper file %q: %q\n", file, err.Error())
+			fd.Close()
+			dryRunFailure(file)
 			continue
 		}
 
 		name := getMetricName(file)
 		log.Debugf("Processing file %s (%s)", file, name)
 		met, err := getMetric(w, file, name)
+		fd.Close()
+		if err == errTooManyGaps {
+			log.Warnf("Skipping file %s (%s): %s", file, name, err.Error())
+			atomic.AddUint32(&skippedCount, 1)
+			continue
+		}
 		if err != nil {
 			log.Errorf("Failed to get metric: %q", err.Error())
+			dryRunFailure(file)
 			continue
 		}
 
-		success := false
-		attempts := 0
-		for !success {
-			b, err := met.MarshalCompressed()
-			if err != nil {
-				log.Errorf("Failed to encode metric: %q", err.Error())
-				continue
+		if *dryRun {
+			reportDryRun(name, met)
+		} else {
+			if limiter != nil {
+				limiter.Wait()
 			}
-			size := b.Len()
-
-			req, err := http.NewRequest("POST", *httpEndpoint, io.Reader(b))
-			if err != nil {
-				log.Fatalf("Cannot construct request to http endpoint %q: %q", *httpEndpoint, err.Error())
+			postMetric(client, name, met)
+			if pos != nil {
+				pos.Done(file)
 			}
+		}
+		processed := atomic.AddUint32(&processedCount, 1)
+		if processed%100 == 0 {
+			skipped := atomic.LoadUint32(&skippedCount)
+			if pos != nil {
+				log.Infof("Processed %d files, %d skipped, %d done in total according to position file", processed, skipped, pos.Count())
+			} else {
+				log.Infof("Processed %d files, %d skipped", processed, skipped)
+			}
+		}
+	}
+	wg.Done()
+}
 
-			req.Header.Set("Content-Type", "application/json")
-			req.Header.Set("Content-Encoding", "gzip")
+// postMetric sends the metric to the http endpoint, retrying until it succeeds
+func postMetric(client *http.Client, name string, met archive.Metric) {
+	success := false
+	attempts := 0
+	for !success {
+		b, err := met.MarshalCompressed()
+		if err != nil {
+			log.Errorf("Failed to encode metric: %q", err.Error())
+			continue
+		}
+		size := b.Len()
 
-			if len(*httpAuth) > 0 {
-				req.Header.Add("Authorization", "Basic "+base64.StdEncoding.EncodeToString([]byte(*httpAuth)))
-			}
+		req, err := http.NewRequest("POST", *httpEndpoint, io.Reader(b))
+		if err != nil {
+			log.Fatalf("Cannot construct request to http endpoint %q: %q", *httpEndpoint, err.Error())
+		}
+
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Content-Encoding", "gzip")
 
-			pre := time.Now()
-			resp, err := client.Do(req)
-			passed := time.Now().Sub(pre).Seconds()
-			if err != nil || resp.StatusCode >= 300 {
-				if err != nil {
-					log.Warningf("Error posting %s (%d bytes), to endpoint %q (attempt %d/%fs, retrying): %s", name, size, *httpEndpoint, attempts, passed, err.Error())
-					attempts++
-					continue
-				} else {
-					log.Warningf("Error posting %s (%d bytes) to endpoint %q status %d (attempt %d/%fs, retrying)", name, size, *httpEndpoint, resp.StatusCode, attempts, passed)
-				}
+		if len(*httpAuth) > 0 {
+			req.Header.Add("Authorization", "Basic "+base64.StdEncoding.EncodeToString([]byte(*httpAuth)))
+		}
+
+		pre := time.Now()
+		resp, err := client.Do(req)
+		passed := time.Now().Sub(pre).Seconds()
+		if err != nil || resp.StatusCode >= 300 {
+			if err != nil {
+				log.Warningf("Error posting %s (%d bytes), to endpoint %q (attempt %d/%fs, retrying): %s", name, size, *httpEndpoint, attempts, passed, err.Error())
 				attempts++
+				continue
 			} else {
-				log.Debugf("Posted %s (%d bytes) to endpoint %q in %f seconds", name, size, *httpEndpoint, passed)
-				success = true
+				log.Warningf("Error posting %s (%d bytes) to endpoint %q status %d (attempt %d/%fs, retrying)", name, size, *httpEndpoint, resp.StatusCode, attempts, passed)
 			}
-			io.Copy(ioutil.Discard, resp.Body)
-			resp.Body.Close()
+			attempts++
+		} else {
+			log.Debugf("Posted %s (%d bytes) to endpoint %q in %f seconds", name, size, *httpEndpoint, passed)
+			success = true
 		}
+		io.Copy(ioutil.Discard, resp.Body)
+		resp.Body.Close()
+	}
+}
 
-		if pos != nil {
-			pos.Done(file)
-		}
-		processed := atomic.AddUint32(&processedCount, 1)
-		if processed%100 == 0 {
-			skipped := atomic.LoadUint32(&skippedCount)
-			log.Infof("Processed %d files, %d skipped", processed, skipped)
+// reportDryRun logs what would be imported for the given metric and adds it to the dry run totals
+func reportDryRun(name string, met archive.Metric) {
+	var points, size uint64
+	for i, arch := range met.Archives {
+		var archPoints, archSize uint64
+		for _, itgen := range arch.Chunks {
+			archSize += itgen.Size()
+			iter, err := itgen.Get()
+			if err != nil {
+				log.Warnf("Dry run: %s archive %d: failed to decode chunk at t0 %d: %s", name, i, itgen.T0, err)
+				continue
+			}
+			for iter.Next() {
+				archPoints++
+			}
 		}
+		log.Infof("Dry run: %s archive %d (%s): %d points in %d chunks, %d bytes", name, i, arch.RowKey, archPoints, len(arch.Chunks), archSize)
+		points += archPoints
+		size += archSize
 	}
-	wg.Done()
+	log.Infof("Dry run: %s: %d archives, %d points, %d bytes", name, len(met.Archives), points, size)
+	atomic.AddUint64(&dryRunPoints, points)
+	atomic.AddUint64(&dryRunBytes, size)
+}
+
+// dryRunFailure records that the file would be skipped due to errors, if we're doing a dry run
+func dryRunFailure(file string) {
+	if !*dryRun {
+		return
+	}
+	dryRunFailedMu.Lock()
+	dryRunFailed = append(dryRunFailed, file)
+	dryRunFailedMu.Unlock()
 }
 
 // generate the metric name based on the file name and given prefix
@@ -296,12 +452,24 @@ func getMetric(w *whisper.Whisper, file, name string) (archive.Metric, error) {
 	_, schem := schemas.Match(md.Name, int(w.Header.Archives[0].SecondsPerPoint))
 
 	points := make(map[int][]whisper.Point)
+	var total, gaps int
 	for i := range w.Header.Archives {
 		p, err := w.DumpArchive(i)
 		if err != nil {
 			return res, fmt.Errorf("Failed to dump archive %d from whisper file %s", i, file)
 		}
+		p, pointCount, gapCount := removeGaps(p)
 		points[i] = p
+		checkXFilesFactor(name, i, w.Header.Archives[i], w.Header.Metadata.XFilesFactor, pointCount-gapCount)
+		total += pointCount
+		gaps += gapCount
+	}
+	if gaps > 0 {
+		ratio := float64(gaps) / float64(total)
+		log.Infof("File %s has %d NaN points out of %d, gap ratio %f", file, gaps, total, ratio)
+		if ratio > *maxGapRatio {
+			return res, errTooManyGaps
+		}
 	}
 
 	conversion := newConversion(w.Header.Archives, points, method)
@@ -334,6 +502,64 @@ func getMetric(w *whisper.Whisper, file, name string) (archive.Metric, error) {
 	return res, nil
 }
 
+// removeGaps removes the NaN points, which some whisper files use to represent gaps,
+// from the given points. it returns the remaining points, the number of non-empty
+// slots that were seen and the number of NaN points that were removed
+func removeGaps(points []whisper.Point) ([]whisper.Point, int, int) {
+	var total, gaps int
+	res := points[:0]
+	for _, p := range points {
+		if p.Timestamp != 0 {
+			total++
+		}
+		if math.IsNaN(p.Value) {
+			gaps++
+			continue
+		}
+		res = append(res, p)
+	}
+	return res, total, gaps
+}
+
+// parseTimeRange parses the from (inclusive) and until (exclusive) specifications
+// into the inclusive range of timestamps to import.
+// when from or until are not set, import-after and import-up-to are used respectively
+func parseTimeRange(from, until string, now time.Time) (uint32, uint32, error) {
+	after, err := dur.ParseDateTime(from, time.UTC, now, uint32(*importAfter))
+	if err != nil {
+		return 0, 0, fmt.Errorf("could not parse from %q: %s", from, err)
+	}
+	upTo := uint32(*importUpTo)
+	if until != "" {
+		untilUnix, err := dur.ParseDateTime(until, time.UTC, now, 0)
+		if err != nil {
+			return 0, 0, fmt.Errorf("could not parse until %q: %s", until, err)
+		}
+		if untilUnix == 0 {
+			return 0, 0, fmt.Errorf("until must be after the epoch")
+		}
+		upTo = untilUnix - 1
+	}
+	if after > upTo {
+		return 0, 0, fmt.Errorf("from (%d) must be before until (%d)", after, upTo+1)
+	}
+	return after, upTo, nil
+}
+
+// checkXFilesFactor warns if the ratio of slots in the archive that have a value
+// is below the xFilesFactor of the whisper file. aggregates computed from such
+// sparse data can be misleading
+func checkXFilesFactor(name string, idx int, arch whisper.ArchiveInfo, xFilesFactor float32, valid int) {
+	if arch.Points == 0 {
+		return
+	}
+	fillRatio := float32(valid) / float32(arch.Points)
+	if fillRatio < xFilesFactor {
+		log.Warnf("Metric %s archive %d has a fill ratio of %f, which is below its xFilesFactor of %f", name, idx, fillRatio, xFilesFactor)
+		xFilesFactorViolations.Inc()
+	}
+}
+
 func getRowKey(retIdx int, mkey schema.MKey, meth string, secondsPerPoint int) schema.AMKey {
 	if retIdx == 0 {
 		return schema.AMKey{MKey: mkey}
@@ -412,6 +638,11 @@ func getFileListIntoChan(pos *posTracker, fileChan chan string) {
 			if len(path) < 4 || path[len(path)-4:] != ".wsp" {
 				return nil
 			}
+			if !matchPatterns.Match(name) {
+				log.Debugf("Skipping file %s with name %s because it does not match any of the match patterns", path, name)
+				atomic.AddUint32(&skippedCount, 1)
+				return nil
+			}
 			if pos != nil && pos.IsDone(path) {
 				log.Debugf("Skipping file %s because it was listed as already done", path)
 				return nil
@@ -424,3 +655,42 @@ func getFileListIntoChan(pos *posTracker, fileChan chan string) {
 
 	close(fileChan)
 }
+
+// globList is a list of graphite-style glob patterns that can be given as a repeatable flag.
+// patterns support *, ?, [...] and {a,b} alternatives. the latter get expanded into
+// separate patterns, like the memory index does.
+type globList []string
+
+func (g *globList) String() string {
+	return strings.Join(*g, ",")
+}
+
+func (g *globList) Set(pattern string) error {
+	patterns := util.ExpandQueries(pattern)
+	for _, p := range patterns {
+		// validate the pattern, filepath.Match only returns ErrBadPattern for invalid ones
+		_, err := filepath.Match(p, "")
+		if err != nil {
+			return fmt.Errorf("invalid match pattern %q: %s", pattern, err)
+		}
+	}
+	*g = append(*g, patterns...)
+	return nil
+}
+
+// Match returns whether the metric name matches any of the patterns.
+// an empty list matches everything
+func (g globList) Match(name string) bool {
+	if len(g) == 0 {
+		return true
+	}
+	// graphite separates by . -- Match separates by /
+	name = strings.Replace(name, ".", "/", -1)
+	for _, pattern := range g {
+		ok, _ := filepath.Match(strings.Replace(pattern, ".", "/", -1), name)
+		if ok {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,261 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/grafana/metrictank/conf"
+	"github.com/kisielk/whisper-go/whisper"
+	log "github.com/sirupsen/logrus"
+)
+
+func TestGlobListMatch(t *testing.T) {
+	cases := []struct {
+		patterns []string
+		name     string
+		exp      bool
+	}{
+		{nil, "servers.web1.cpu", true},
+		{[]string{"servers.web*.*"}, "servers.web1.cpu", true},
+		{[]string{"servers.web*.*"}, "servers.db1.cpu", false},
+		{[]string{"servers.*"}, "servers.web1.cpu", false},
+		{[]string{"servers.db?.cpu", "servers.web*.cpu"}, "servers.web1.cpu", true},
+		{[]string{"servers.db?.cpu", "servers.web*.cpu"}, "servers.db1.cpu", true},
+		{[]string{"servers.db?.cpu", "servers.web*.cpu"}, "servers.db1.mem", false},
+		{[]string{"servers.{web1,db1}.cpu"}, "servers.db1.cpu", true},
+		{[]string{"servers.{web1,db1}.cpu"}, "servers.web2.cpu", false},
+		{[]string{"servers.{web,db}*.{cpu,mem}"}, "servers.web3.mem", true},
+	}
+	for i, c := range cases {
+		var g globList
+		for _, p := range c.patterns {
+			if err := g.Set(p); err != nil {
+				t.Fatalf("case %d: unexpected error setting pattern %q: %s", i, p, err)
+			}
+		}
+		if got := g.Match(c.name); got != c.exp {
+			t.Fatalf("case %d: expected match of %q against %v to be %t, got %t", i, c.name, c.patterns, c.exp, got)
+		}
+	}
+}
+
+func TestGlobListSetInvalid(t *testing.T) {
+	var g globList
+	if err := g.Set("servers.[web"); err == nil {
+		t.Fatalf("expected an error for an invalid pattern")
+	}
+}
+
+func TestGetFileListIntoChanMatch(t *testing.T) {
+	dir, err := ioutil.TempDir("", "whisper")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	files := []string{
+		"servers/web1/cpu.wsp",
+		"servers/web2/cpu.wsp",
+		"servers/db1/cpu.wsp",
+		"servers/db1/mem.wsp",
+		"apps/foo/requests.wsp",
+	}
+	for _, f := range files {
+		path := filepath.Join(dir, f)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	origDir, origFilter, origPatterns := *whisperDirectory, nameFilter, matchPatterns
+	defer func() {
+		*whisperDirectory, nameFilter, matchPatterns = origDir, origFilter, origPatterns
+	}()
+	*whisperDirectory = dir
+	nameFilter = regexp.MustCompile("")
+	matchPatterns = globList{"servers.web*.cpu", "servers.db1.mem"}
+
+	fileChan := make(chan string)
+	go getFileListIntoChan(nil, fileChan)
+	var got []string
+	for path := range fileChan {
+		got = append(got, path)
+	}
+	sort.Strings(got)
+
+	exp := []string{
+		filepath.Join(dir, "servers/db1/mem.wsp"),
+		filepath.Join(dir, "servers/web1/cpu.wsp"),
+		filepath.Join(dir, "servers/web2/cpu.wsp"),
+	}
+	if !reflect.DeepEqual(got, exp) {
+		t.Fatalf("expected queued files %v, got %v", exp, got)
+	}
+}
+
+func TestRemoveGaps(t *testing.T) {
+	in := []whisper.Point{
+		{10, 1},
+		{20, math.NaN()},
+		{0, 0},
+		{30, 3},
+		{40, math.NaN()},
+	}
+	exp := []whisper.Point{
+		{10, 1},
+		{0, 0},
+		{30, 3},
+	}
+	got, total, gaps := removeGaps(in)
+	if !reflect.DeepEqual(got, exp) {
+		t.Fatalf("expected points %v, got %v", exp, got)
+	}
+	if total != 4 || gaps != 2 {
+		t.Fatalf("expected 4 points with 2 gaps, got %d points with %d gaps", total, gaps)
+	}
+}
+
+func TestParseTimeRange(t *testing.T) {
+	now := time.Unix(100*86400, 0)
+	cases := []struct {
+		from      string
+		until     string
+		expAfter  uint32
+		expUpTo   uint32
+		expectErr bool
+	}{
+		{"", "", 0, math.MaxUint32, false},
+		{"1000", "2000", 1000, 1999, false},
+		{"-90d", "", 10 * 86400, math.MaxUint32, false},
+		{"-90d", "-1d", 10 * 86400, 99*86400 - 1, false},
+		{"-2h", "now", 100*86400 - 7200, 100*86400 - 1, false},
+		{"-1d", "-2d", 0, 0, true},
+		{"2000", "2000", 0, 0, true},
+		{"-90x", "", 0, 0, true},
+		{"", "foo", 0, 0, true},
+	}
+	for i, c := range cases {
+		after, upTo, err := parseTimeRange(c.from, c.until, now)
+		if c.expectErr {
+			if err == nil {
+				t.Fatalf("case %d: expected an error for from %q until %q", i, c.from, c.until)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("case %d: unexpected error %s", i, err)
+		}
+		if after != c.expAfter || upTo != c.expUpTo {
+			t.Fatalf("case %d: expected range [%d, %d], got [%d, %d]", i, c.expAfter, c.expUpTo, after, upTo)
+		}
+	}
+}
+
+func TestCheckXFilesFactor(t *testing.T) {
+	arch := whisper.ArchiveInfo{SecondsPerPoint: 60, Points: 10}
+	cases := []struct {
+		xFilesFactor float32
+		valid        int
+		expViolation bool
+	}{
+		{0.5, 5, false},
+		{0.5, 4, true},
+		{0, 0, false},
+		{1, 10, false},
+		{1, 9, true},
+	}
+	for i, c := range cases {
+		before := xFilesFactorViolations.Peek()
+		checkXFilesFactor("some.metric", 0, arch, c.xFilesFactor, c.valid)
+		violation := xFilesFactorViolations.Peek() != before
+		if violation != c.expViolation {
+			t.Fatalf("case %d: expected violation %t, got %t", i, c.expViolation, violation)
+		}
+	}
+}
+
+func BenchmarkProcessFiles1Thread(b *testing.B) {
+	benchmarkProcessFiles(b, 1)
+}
+
+func BenchmarkProcessFiles2Threads(b *testing.B) {
+	benchmarkProcessFiles(b, 2)
+}
+
+func BenchmarkProcessFiles4Threads(b *testing.B) {
+	benchmarkProcessFiles(b, 4)
+}
+
+func BenchmarkProcessFiles8Threads(b *testing.B) {
+	benchmarkProcessFiles(b, 8)
+}
+
+// benchmarkProcessFiles reads and converts a directory of synthetic whisper files
+// using the given amount of threads. it runs in dry-run mode, so nothing gets sent.
+func benchmarkProcessFiles(b *testing.B, threads int) {
+	dir, err := ioutil.TempDir("", "whisper")
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	now := time.Now()
+	var files []string
+	for i := 0; i < 100; i++ {
+		path := filepath.Join(dir, fmt.Sprintf("metric%d.wsp", i))
+		w, err := whisper.Create(path, []whisper.ArchiveInfo{whisper.NewArchiveInfo(60, 1440)}, whisper.DefaultCreateOptions())
+		if err != nil {
+			b.Fatal(err)
+		}
+		var points []whisper.Point
+		for j := 0; j < 1440; j++ {
+			points = append(points, whisper.NewPoint(now.Add(-time.Duration(j)*time.Minute), float64(j)))
+		}
+		if err := w.UpdateMany(points); err != nil {
+			b.Fatal(err)
+		}
+		w.Close()
+		files = append(files, path)
+	}
+
+	origDir, origSchemas, origDryRun, origLevel := *whisperDirectory, schemas, *dryRun, log.GetLevel()
+	defer func() {
+		*whisperDirectory, schemas, *dryRun = origDir, origSchemas, origDryRun
+		log.SetLevel(origLevel)
+	}()
+	*whisperDirectory = dir
+	*dryRun = true
+	log.SetLevel(log.ErrorLevel)
+	schemas = conf.NewSchemas([]conf.Schema{{
+		Name:       "bench",
+		Pattern:    regexp.MustCompile(".*"),
+		Retentions: conf.Retentions{conf.NewRetentionMT(60, 86400, 3600, 2, 0)},
+	}})
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		fileChan := make(chan string)
+		wg := &sync.WaitGroup{}
+		wg.Add(threads)
+		for t := 0; t < threads; t++ {
+			go processFromChan(nil, fileChan, wg)
+		}
+		for _, f := range files {
+			fileChan <- f
+		}
+		close(fileChan)
+		wg.Wait()
+	}
+}
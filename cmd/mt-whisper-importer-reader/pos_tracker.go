@@ -2,22 +2,53 @@ package main
 
 import (
 	"bufio"
+	"bytes"
+	"encoding/binary"
 	"fmt"
 	"io"
 	"os"
+	"sort"
 	"sync"
+	"sync/atomic"
+
+	log "github.com/sirupsen/logrus"
 )
 
+// binaryMagic is the header of position files in binary format.
+// text position files can't start with it, as paths don't contain NUL bytes.
+const binaryMagic = "\x00mtpos1\n"
+
+// maxPathLen is the longest path we accept in a binary position file. it matches PATH_MAX on linux,
+// and protects us from allocating huge paths when reading a corrupt file.
+const maxPathLen = 4096
+
 type posTracker struct {
-	sync.Mutex
+	sync.Mutex   // serializes the updates of the position file
 	file         string
+	binary       bool   // whether the position file uses the binary format
+	lastPath     string // last path written to a binary position file
 	fd           *os.File
 	completedMap sync.Map
-	wg           sync.WaitGroup
+	count        int64 // number of entries in completedMap
+}
+
+// Option configures a posTracker
+type Option func(*posTracker)
+
+// BinaryFormat makes new position files use a binary format rather than one path per line.
+// Each path is stored as the length of the prefix it shares with the previous path, followed
+// by the length-prefixed remainder. Because the whisper directory is walked in lexical order,
+// consecutive paths share long prefixes, so this results in much smaller files that load faster.
+// the format of existing position files is auto-detected, regardless of this option.
+func BinaryFormat(p *posTracker) {
+	p.binary = true
 }
 
-func NewPositionTracker(file string) (*posTracker, error) {
+func NewPositionTracker(file string, opts ...Option) (*posTracker, error) {
 	p := &posTracker{file: file}
+	for _, opt := range opts {
+		opt(p)
+	}
 
 	fd, err := os.Open(file)
 	if err != nil {
@@ -25,55 +56,292 @@ func NewPositionTracker(file string) (*posTracker, error) {
 			return nil, err
 		}
 	} else {
-		reader := bufio.NewReader(fd)
-		var path string
-		for {
-			line, isPrefix, err := reader.ReadLine()
+		size, err := p.load(fd)
+		fd.Close()
+		if err != nil {
+			return nil, err
+		}
+		if size >= 0 {
+			// cut off the incomplete entry, so that the entries we append can be read back
+			err = os.Truncate(file, size)
 			if err != nil {
-				if err == io.EOF {
-					break
-				}
 				return nil, err
 			}
-
-			path += string(line)
-			if isPrefix {
-				continue
-			} else {
-				p.completedMap.Store(path, struct{}{})
-				path = ""
-			}
 		}
-
-		fd.Close()
 	}
 
 	p.fd, err = os.OpenFile(file, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
 	if err != nil {
 		return nil, err
 	}
+	if p.binary {
+		info, err := p.fd.Stat()
+		if err != nil {
+			p.fd.Close()
+			return nil, err
+		}
+		if info.Size() == 0 {
+			_, err = p.fd.WriteString(binaryMagic)
+			if err != nil {
+				p.fd.Close()
+				return nil, err
+			}
+		}
+	}
 
 	return p, nil
 }
 
+// load reads the position file, detecting its format.
+// a binary position file may end with an incomplete entry, if we crashed while writing it.
+// in that case, the entry is ignored and load returns the size of the file up to the last
+// complete entry, so that the incomplete one can be cut off. otherwise it returns -1.
+func (p *posTracker) load(r io.Reader) (int64, error) {
+	reader := bufio.NewReader(r)
+	header, err := reader.Peek(len(binaryMagic))
+	if err != nil && err != io.EOF {
+		return -1, err
+	}
+	if string(header) != binaryMagic {
+		if len(header) > 0 {
+			p.binary = false
+		}
+		return -1, p.ImportFromReader(reader)
+	}
+
+	p.binary = true
+	reader.Discard(len(binaryMagic))
+	size := int64(len(binaryMagic)) // size of the complete entries read so far
+	var path []byte
+	for {
+		next, n, err := readEntry(reader, path)
+		if err == io.EOF {
+			break
+		}
+		if err == io.ErrUnexpectedEOF {
+			log.Warnf("Position file %s ends with an incomplete entry, probably written during a crash. ignoring it", p.file)
+			p.lastPath = string(path)
+			return size, nil
+		}
+		if err != nil {
+			return -1, err
+		}
+		path = next
+		size += int64(n)
+		p.store(string(path))
+	}
+	p.lastPath = string(path)
+	return -1, nil
+}
+
+// readEntry reads an entry of a binary position file, and returns its path and size in bytes.
+// prev is the path of the previous entry, which gets overwritten.
+// it returns io.EOF if there are no more entries, and io.ErrUnexpectedEOF if the entry is incomplete.
+func readEntry(reader *bufio.Reader, prev []byte) ([]byte, int, error) {
+	var varint [binary.MaxVarintLen64]byte
+	shared, err := binary.ReadUvarint(reader)
+	if err != nil {
+		return nil, 0, err
+	}
+	size, err := binary.ReadUvarint(reader)
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+	if shared > uint64(len(prev)) {
+		return nil, 0, fmt.Errorf("corrupt position file: shared prefix of %d bytes exceeds previous path of %d bytes", shared, len(prev))
+	}
+	if size > maxPathLen || shared+size > maxPathLen {
+		return nil, 0, fmt.Errorf("corrupt position file: path of %d bytes exceeds the maximum of %d bytes", shared+size, maxPathLen)
+	}
+	path := append(prev[:shared], make([]byte, size)...)
+	_, err = io.ReadFull(reader, path[shared:])
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+	n := binary.PutUvarint(varint[:], shared) + binary.PutUvarint(varint[:], size) + int(size)
+	return path, n, nil
+}
+
+// encode appends the path to buf, in the format of the position file.
+// the caller must hold the lock, and write buf to the file in the order encode was called.
+func (p *posTracker) encode(buf *bytes.Buffer, path string) {
+	if !p.binary {
+		buf.WriteString(path)
+		buf.WriteByte('\n')
+		return
+	}
+	shared := 0
+	for shared < len(path) && shared < len(p.lastPath) && path[shared] == p.lastPath[shared] {
+		shared++
+	}
+	var varint [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(varint[:], uint64(shared))
+	buf.Write(varint[:n])
+	n = binary.PutUvarint(varint[:], uint64(len(path)-shared))
+	buf.Write(varint[:n])
+	buf.WriteString(path[shared:])
+	p.lastPath = path
+}
+
+// ImportFromReader marks all newline separated paths read from r as done.
+// note that they are not persisted to the position file.
+func (p *posTracker) ImportFromReader(r io.Reader) error {
+	reader := bufio.NewReader(r)
+	var path string
+	for {
+		line, isPrefix, err := reader.ReadLine()
+		if err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+
+		path += string(line)
+		if isPrefix {
+			continue
+		} else {
+			p.store(path)
+			path = ""
+		}
+	}
+}
+
+// ExportToWriter writes all paths that are done to w, newline separated
+func (p *posTracker) ExportToWriter(w io.Writer) error {
+	writer := bufio.NewWriter(w)
+	var err error
+	p.completedMap.Range(func(key, value interface{}) bool {
+		_, err = writer.WriteString(key.(string) + "\n")
+		return err == nil
+	})
+	if err != nil {
+		return err
+	}
+	return writer.Flush()
+}
+
+// store adds the path to the set of completed paths
+func (p *posTracker) store(path string) {
+	if _, loaded := p.completedMap.LoadOrStore(path, struct{}{}); !loaded {
+		atomic.AddInt64(&p.count, 1)
+	}
+}
+
 func (p *posTracker) IsDone(path string) bool {
 	_, ok := p.completedMap.Load(path)
 	return ok
 }
 
+// Done marks the path as done, and persists it to the position file
 func (p *posTracker) Done(path string) {
-	p.completedMap.Store(path, struct{}{})
-	p.wg.Add(1)
-	go func() {
-		p.Lock()
-		defer p.Unlock()
-		p.fd.WriteString(fmt.Sprintf("%s\n", path))
-		p.fd.Sync()
-		p.wg.Done()
-	}()
+	p.DoneAll([]string{path})
+}
+
+// DoneAll marks all given paths as done, persisting them with a single write
+func (p *posTracker) DoneAll(paths []string) {
+	if len(paths) == 0 {
+		return
+	}
+	p.Lock()
+	defer p.Unlock()
+	var buf bytes.Buffer
+	for _, path := range paths {
+		p.store(path)
+		p.encode(&buf, path)
+	}
+	p.fd.Write(buf.Bytes())
+	p.fd.Sync()
+}
+
+// Remove marks the path as not done, so it will be processed again.
+// the position file is rewritten without it.
+func (p *posTracker) Remove(path string) error {
+	// holding the lock makes sure no Done can write the path back after the rewrite
+	p.Lock()
+	defer p.Unlock()
+	if _, ok := p.completedMap.LoadAndDelete(path); ok {
+		atomic.AddInt64(&p.count, -1)
+	}
+	return p.rewrite()
+}
+
+// RemoveDone marks the path as not done, so that it will be processed again, and
+// persists the updated position file. it returns false if the path was not done.
+func (p *posTracker) RemoveDone(path string) bool {
+	if !p.IsDone(path) {
+		return false
+	}
+	err := p.Remove(path)
+	if err != nil {
+		log.Errorf("Failed to persist removal of %q from position file: %s", path, err)
+	}
+	return true
+}
+
+// rewrite replaces the position file with the current set of completed paths.
+// the caller must hold the lock.
+func (p *posTracker) rewrite() error {
+	// the new file is opened for appending, so that we can keep using it once it replaced
+	// the position file, and keep using the old one if replacing it fails.
+	tmpFile := p.file + ".tmp"
+	fd, err := os.OpenFile(tmpFile, os.O_APPEND|os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	if p.binary {
+		var buf bytes.Buffer
+		buf.WriteString(binaryMagic)
+		paths := p.Snapshot()
+		sort.Strings(paths)
+		p.lastPath = ""
+		for _, path := range paths {
+			p.encode(&buf, path)
+		}
+		_, err = fd.Write(buf.Bytes())
+	} else {
+		err = p.ExportToWriter(fd)
+	}
+	if err == nil {
+		err = fd.Sync()
+	}
+	if err == nil {
+		err = os.Rename(tmpFile, p.file)
+	}
+	if err != nil {
+		fd.Close()
+		os.Remove(tmpFile)
+		return err
+	}
+
+	p.fd.Close()
+	p.fd = fd
+	return nil
+}
+
+// Count returns the number of paths that are done
+func (p *posTracker) Count() int {
+	return int(atomic.LoadInt64(&p.count))
+}
+
+// Snapshot returns a copy of all paths that are done
+func (p *posTracker) Snapshot() []string {
+	paths := make([]string, 0, p.Count())
+	p.completedMap.Range(func(key, value interface{}) bool {
+		paths = append(paths, key.(string))
+		return true
+	})
+	return paths
 }
 
 func (p *posTracker) Close() {
-	p.wg.Wait()
+	p.Lock()
+	defer p.Unlock()
 	p.fd.Close()
 }
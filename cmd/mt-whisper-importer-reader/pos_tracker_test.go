@@ -1,7 +1,12 @@
 package main
 
 import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io/ioutil"
 	"os"
+	"sync"
 	"testing"
 )
 
@@ -57,3 +62,445 @@ func TestPositionTracker(t *testing.T) {
 		t.Fatalf("Expected %s, %s and %s to be done, but it was not", testValue1, testValue2, testValue3)
 	}
 }
+
+func TestPositionTrackerDoneAllConcurrent(t *testing.T) {
+	filePath := "/tmp/positionTrackerDoneAllTest"
+	clearFile := func() { os.Remove(filePath) }
+	clearFile()
+	defer clearFile()
+
+	p1, err := NewPositionTracker(filePath)
+	if err != nil {
+		t.Fatalf("Error instantiating position tracker: %s", err)
+	}
+
+	t.Run("group", func(t *testing.T) {
+		for i := 0; i < 100; i++ {
+			i := i
+			t.Run(fmt.Sprintf("worker%d", i), func(t *testing.T) {
+				t.Parallel()
+				p1.DoneAll([]string{fmt.Sprintf("file%d-a", i), fmt.Sprintf("file%d-b", i)})
+			})
+		}
+	})
+	p1.Close()
+
+	// read the file into new instance of position tracker
+	p2, err := NewPositionTracker(filePath)
+	if err != nil {
+		t.Fatalf("Error instantiating position tracker: %s", err)
+	}
+	defer p2.Close()
+	for i := 0; i < 100; i++ {
+		for _, v := range []string{fmt.Sprintf("file%d-a", i), fmt.Sprintf("file%d-b", i)} {
+			if !p2.IsDone(v) {
+				t.Fatalf("Expected %s to be done, but it was not", v)
+			}
+		}
+	}
+}
+
+func TestPositionTrackerCountSnapshot(t *testing.T) {
+	filePath := "/tmp/positionTrackerCountTest"
+	clearFile := func() { os.Remove(filePath) }
+	clearFile()
+	defer clearFile()
+
+	p, err := NewPositionTracker(filePath)
+	if err != nil {
+		t.Fatalf("Error instantiating position tracker: %s", err)
+	}
+	defer p.Close()
+
+	if p.Count() != 0 || len(p.Snapshot()) != 0 {
+		t.Fatalf("Expected empty position tracker, got count %d and snapshot %v", p.Count(), p.Snapshot())
+	}
+
+	done := make(chan struct{})
+	go func() {
+		for i := 0; i < 1000; i++ {
+			p.Done(fmt.Sprintf("file%d", i))
+		}
+		// marking a path as done twice must not count it twice
+		p.Done("file0")
+		close(done)
+	}()
+
+	// snapshots taken while Done is being called must only contain
+	// completed values and never shrink
+	var prev int
+	for running := true; running; {
+		select {
+		case <-done:
+			running = false
+		default:
+		}
+		snap := p.Snapshot()
+		if len(snap) < prev {
+			t.Fatalf("Expected snapshot to have at least %d entries, got %d", prev, len(snap))
+		}
+		for _, v := range snap {
+			if !p.IsDone(v) {
+				t.Fatalf("Snapshot returned %s which is not done", v)
+			}
+		}
+		prev = len(snap)
+	}
+
+	if p.Count() != 1000 {
+		t.Fatalf("Expected count 1000, got %d", p.Count())
+	}
+	if len(p.Snapshot()) != 1000 {
+		t.Fatalf("Expected snapshot of 1000 entries, got %d", len(p.Snapshot()))
+	}
+}
+
+func TestPositionTrackerRemove(t *testing.T) {
+	filePath := "/tmp/positionTrackerRemoveTest"
+	clearFile := func() { os.Remove(filePath) }
+	clearFile()
+	defer clearFile()
+
+	p1, err := NewPositionTracker(filePath)
+	if err != nil {
+		t.Fatalf("Error instantiating position tracker: %s", err)
+	}
+	p1.Done("file1")
+	p1.Done("file2")
+	err = p1.Remove("file1")
+	if err != nil {
+		t.Fatalf("Error removing file1: %s", err)
+	}
+	if p1.IsDone("file1") {
+		t.Fatalf("Expected file1 to not be done after removal, but it was")
+	}
+	if p1.Count() != 1 {
+		t.Fatalf("Expected count 1, got %d", p1.Count())
+	}
+	// the tracker must still be usable after the rewrite
+	p1.Done("file3")
+	p1.Close()
+
+	// read the file into new instance of position tracker
+	p2, err := NewPositionTracker(filePath)
+	if err != nil {
+		t.Fatalf("Error instantiating position tracker: %s", err)
+	}
+	defer p2.Close()
+	if p2.IsDone("file1") {
+		t.Fatalf("Expected file1 to not be done after reload, but it was")
+	}
+	if !p2.IsDone("file2") || !p2.IsDone("file3") {
+		t.Fatalf("Expected file2 and file3 to be done after reload, but they were not")
+	}
+}
+
+func TestPositionTrackerConcurrentRemove(t *testing.T) {
+	filePath := "/tmp/positionTrackerConcurrentRemoveTest"
+	clearFile := func() { os.Remove(filePath) }
+	clearFile()
+	defer clearFile()
+
+	p, err := NewPositionTracker(filePath)
+	if err != nil {
+		t.Fatalf("Error instantiating position tracker: %s", err)
+	}
+	defer p.Close()
+	p.DoneAll([]string{"file1", "file2"})
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(2)
+		go func() {
+			p.Remove("file1")
+			wg.Done()
+		}()
+		go func() {
+			p.Done("file3")
+			wg.Done()
+		}()
+	}
+	wg.Wait()
+	if p.IsDone("file1") {
+		t.Fatalf("Expected file1 to not be done after removal, but it was")
+	}
+	if p.Count() != 2 {
+		t.Fatalf("Expected count 2, got %d", p.Count())
+	}
+}
+
+func TestPositionTrackerRemoveRenameFailure(t *testing.T) {
+	filePath := "/tmp/positionTrackerRemoveRenameFailureTest"
+	clearFile := func() { os.RemoveAll(filePath) }
+	clearFile()
+	defer clearFile()
+
+	p, err := NewPositionTracker(filePath)
+	if err != nil {
+		t.Fatalf("Error instantiating position tracker: %s", err)
+	}
+	defer p.Close()
+	p.DoneAll([]string{"file1", "file2"})
+
+	// a non-empty directory can't be replaced by the rewritten position file
+	os.Remove(filePath)
+	if err := os.MkdirAll(filePath+"/dir", 0755); err != nil {
+		t.Fatalf("Error creating directory: %s", err)
+	}
+	if err := p.Remove("file1"); err == nil {
+		t.Fatalf("Expected an error when the position file can't be replaced")
+	}
+	if _, err := os.Stat(filePath + ".tmp"); !os.IsNotExist(err) {
+		t.Fatalf("Expected the temporary file to be removed, got %v", err)
+	}
+	// the tracker must keep using the old position file
+	if _, err := p.fd.Stat(); err != nil {
+		t.Fatalf("Expected the position file to still be open, got %s", err)
+	}
+}
+
+func TestPositionTrackerExportImport(t *testing.T) {
+	filePath1 := "/tmp/positionTrackerExportTest1"
+	filePath2 := "/tmp/positionTrackerExportTest2"
+	clearFiles := func() {
+		os.Remove(filePath1)
+		os.Remove(filePath2)
+	}
+	clearFiles()
+	defer clearFiles()
+
+	p1, err := NewPositionTracker(filePath1)
+	if err != nil {
+		t.Fatalf("Error instantiating position tracker: %s", err)
+	}
+	defer p1.Close()
+	p1.Done("file1")
+	p1.Done("file2")
+
+	var buf bytes.Buffer
+	err = p1.ExportToWriter(&buf)
+	if err != nil {
+		t.Fatalf("Error exporting: %s", err)
+	}
+
+	p2, err := NewPositionTracker(filePath2)
+	if err != nil {
+		t.Fatalf("Error instantiating position tracker: %s", err)
+	}
+	defer p2.Close()
+	err = p2.ImportFromReader(&buf)
+	if err != nil {
+		t.Fatalf("Error importing: %s", err)
+	}
+	if !p2.IsDone("file1") || !p2.IsDone("file2") {
+		t.Fatalf("Expected file1 and file2 to be done after import, but they were not")
+	}
+	if p2.Count() != 2 {
+		t.Fatalf("Expected count 2, got %d", p2.Count())
+	}
+}
+
+func TestPositionTrackerBinaryFormat(t *testing.T) {
+	filePath := "/tmp/positionTrackerBinaryTest"
+	clearFile := func() { os.Remove(filePath) }
+	clearFile()
+	defer clearFile()
+
+	p1, err := NewPositionTracker(filePath, BinaryFormat)
+	if err != nil {
+		t.Fatalf("Error instantiating position tracker: %s", err)
+	}
+	p1.Done("file1")
+	p1.DoneAll([]string{"file2", "dir/file3"})
+	p1.Close()
+
+	// the format is detected without passing the option
+	p2, err := NewPositionTracker(filePath)
+	if err != nil {
+		t.Fatalf("Error instantiating position tracker: %s", err)
+	}
+	if !p2.binary {
+		t.Fatalf("Expected binary format to be detected")
+	}
+	if !p2.IsDone("file1") || !p2.IsDone("file2") || !p2.IsDone("dir/file3") {
+		t.Fatalf("Expected file1, file2 and dir/file3 to be done, but they were not")
+	}
+	p2.Done("file4")
+	err = p2.Remove("file1")
+	if err != nil {
+		t.Fatalf("Error removing file1: %s", err)
+	}
+	p2.Close()
+
+	p3, err := NewPositionTracker(filePath)
+	if err != nil {
+		t.Fatalf("Error instantiating position tracker: %s", err)
+	}
+	defer p3.Close()
+	if p3.IsDone("file1") || !p3.IsDone("file2") || !p3.IsDone("dir/file3") || !p3.IsDone("file4") {
+		t.Fatalf("Unexpected state after reload: %v", p3.Snapshot())
+	}
+
+	// an existing text file keeps its format
+	clearFile()
+	p4, err := NewPositionTracker(filePath)
+	if err != nil {
+		t.Fatalf("Error instantiating position tracker: %s", err)
+	}
+	p4.Done("file1")
+	p4.Close()
+	p5, err := NewPositionTracker(filePath, BinaryFormat)
+	if err != nil {
+		t.Fatalf("Error instantiating position tracker: %s", err)
+	}
+	defer p5.Close()
+	if p5.binary || !p5.IsDone("file1") {
+		t.Fatalf("Expected text format with file1 done")
+	}
+}
+
+func TestPositionTrackerBinaryFormatIncompleteEntry(t *testing.T) {
+	filePath := "/tmp/positionTrackerBinaryIncompleteTest"
+	clearFile := func() { os.Remove(filePath) }
+	clearFile()
+	defer clearFile()
+
+	p1, err := NewPositionTracker(filePath, BinaryFormat)
+	if err != nil {
+		t.Fatalf("Error instantiating position tracker: %s", err)
+	}
+	p1.DoneAll([]string{"dir/file1", "dir/file2", "dir/file3"})
+	p1.Close()
+
+	// simulate a crash in the middle of writing the last entry
+	info, err := os.Stat(filePath)
+	if err != nil {
+		t.Fatalf("Error getting size of position file: %s", err)
+	}
+	err = os.Truncate(filePath, info.Size()-1)
+	if err != nil {
+		t.Fatalf("Error truncating position file: %s", err)
+	}
+
+	p2, err := NewPositionTracker(filePath)
+	if err != nil {
+		t.Fatalf("Expected the position file with an incomplete entry to load, got: %s", err)
+	}
+	if !p2.IsDone("dir/file1") || !p2.IsDone("dir/file2") || p2.IsDone("dir/file3") || p2.Count() != 2 {
+		t.Fatalf("Expected only the complete entries to be done, got %v", p2.Snapshot())
+	}
+	// entries written after the incomplete one was cut off must load too
+	p2.Done("dir/file4")
+	p2.Close()
+
+	p3, err := NewPositionTracker(filePath)
+	if err != nil {
+		t.Fatalf("Error instantiating position tracker: %s", err)
+	}
+	defer p3.Close()
+	if !p3.IsDone("dir/file2") || p3.IsDone("dir/file3") || !p3.IsDone("dir/file4") || p3.Count() != 3 {
+		t.Fatalf("Unexpected state after reload: %v", p3.Snapshot())
+	}
+}
+
+func TestPositionTrackerBinaryFormatCorruptEntry(t *testing.T) {
+	filePath := "/tmp/positionTrackerBinaryCorruptTest"
+	clearFile := func() { os.Remove(filePath) }
+	clearFile()
+	defer clearFile()
+
+	// an entry claiming a path of 1TiB
+	var buf bytes.Buffer
+	buf.WriteString(binaryMagic)
+	var varint [binary.MaxVarintLen64]byte
+	buf.Write(varint[:binary.PutUvarint(varint[:], 0)])
+	buf.Write(varint[:binary.PutUvarint(varint[:], 1<<40)])
+	buf.WriteString("dir/file1")
+	err := ioutil.WriteFile(filePath, buf.Bytes(), 0644)
+	if err != nil {
+		t.Fatalf("Error writing position file: %s", err)
+	}
+
+	_, err = NewPositionTracker(filePath)
+	if err == nil {
+		t.Fatalf("Expected an error loading a position file with a corrupt entry")
+	}
+}
+
+func BenchmarkPositionTrackerLoad1MText(b *testing.B) {
+	benchmarkPositionTrackerLoad(b, 1000000)
+}
+
+func BenchmarkPositionTrackerLoad1MBinary(b *testing.B) {
+	benchmarkPositionTrackerLoad(b, 1000000, BinaryFormat)
+}
+
+func benchmarkPositionTrackerLoad(b *testing.B, count int, opts ...Option) {
+	filePath := "/tmp/positionTrackerBenchmark"
+	clearFile := func() { os.Remove(filePath) }
+	clearFile()
+	defer clearFile()
+
+	p, err := NewPositionTracker(filePath, opts...)
+	if err != nil {
+		b.Fatalf("Error instantiating position tracker: %s", err)
+	}
+	paths := make([]string, 0, 1000)
+	for i := 0; i < count; i++ {
+		paths = append(paths, fmt.Sprintf("/opt/graphite/storage/whisper/some/metric/path/%d.wsp", i))
+		if len(paths) == cap(paths) {
+			p.DoneAll(paths)
+			paths = make([]string, 0, 1000)
+		}
+	}
+	p.DoneAll(paths)
+	p.Close()
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		p, err := NewPositionTracker(filePath)
+		if err != nil {
+			b.Fatalf("Error instantiating position tracker: %s", err)
+		}
+		if p.Count() != count {
+			b.Fatalf("Expected %d entries, got %d", count, p.Count())
+		}
+		p.Close()
+	}
+}
+
+func TestPositionTrackerRemoveDone(t *testing.T) {
+	filePath := "/tmp/positionTrackerRemoveDoneTest"
+	clearFile := func() { os.Remove(filePath) }
+	clearFile()
+	defer clearFile()
+
+	p1, err := NewPositionTracker(filePath)
+	if err != nil {
+		t.Fatalf("Error instantiating position tracker: %s", err)
+	}
+	p1.Done("file1")
+	p1.Done("file2")
+	if p1.RemoveDone("file3") {
+		t.Fatalf("Expected removal of file3 that is not done to return false, but it returned true")
+	}
+	if !p1.RemoveDone("file1") {
+		t.Fatalf("Expected removal of file1 to return true, but it returned false")
+	}
+	if p1.RemoveDone("file1") {
+		t.Fatalf("Expected second removal of file1 to return false, but it returned true")
+	}
+	if p1.IsDone("file1") || p1.Count() != 1 {
+		t.Fatalf("Expected only file2 to be done, got %v", p1.Snapshot())
+	}
+	p1.Close()
+
+	p2, err := NewPositionTracker(filePath)
+	if err != nil {
+		t.Fatalf("Error instantiating position tracker: %s", err)
+	}
+	defer p2.Close()
+	if p2.IsDone("file1") || !p2.IsDone("file2") {
+		t.Fatalf("Expected only file2 to be done after reload, got %v", p2.Snapshot())
+	}
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/time/rate"
+)
+
+// fileLimiter limits the rate at which whisper files are sent.
+// every file counts as one, regardless of how many points it holds.
+// it allows bursts of up to one second worth of files.
+type fileLimiter struct {
+	*rate.Limiter
+	taken uint64 // total amount of files let through. accessed atomically
+}
+
+func newFileLimiter(filesPerSecond float64) *fileLimiter {
+	burst := int(filesPerSecond)
+	if burst < 1 {
+		burst = 1
+	}
+	return &fileLimiter{
+		Limiter: rate.NewLimiter(rate.Limit(filesPerSecond), burst),
+	}
+}
+
+// Wait blocks until the next file may be sent
+func (l *fileLimiter) Wait() {
+	// only fails if the context gets canceled, which it never does
+	l.Limiter.Wait(context.Background())
+	atomic.AddUint64(&l.taken, 1)
+}
+
+// Taken returns the total amount of files let through
+func (l *fileLimiter) Taken() uint64 {
+	return atomic.LoadUint64(&l.taken)
+}
+
+// report logs the send rate at the given interval, until stop is closed
+func (l *fileLimiter) report(interval time.Duration, stop chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	prev := l.Taken()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			taken := l.Taken()
+			log.Infof("Rate limiter: sending %.2f files/s (limit %.2f)", float64(taken-prev)/interval.Seconds(), float64(l.Limit()))
+			prev = taken
+		}
+	}
+}
@@ -0,0 +1,29 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestFileLimiterWait(t *testing.T) {
+	l := newFileLimiter(100)
+	pre := time.Now()
+	// the first 100 files are let through straight away, the next 10 take ~100ms
+	for i := 0; i < 110; i++ {
+		l.Wait()
+	}
+	passed := time.Since(pre)
+	if passed < 80*time.Millisecond || passed > time.Second {
+		t.Fatalf("expected letting 110 files through at 100/s with a burst of 100 to take ~100ms, took %s", passed)
+	}
+	if taken := l.Taken(); taken != 110 {
+		t.Fatalf("expected 110 files taken, got %d", taken)
+	}
+}
+
+func TestFileLimiterSlowRate(t *testing.T) {
+	l := newFileLimiter(0.5)
+	if l.Burst() != 1 {
+		t.Fatalf("expected a burst of at least 1 file, got %d", l.Burst())
+	}
+}
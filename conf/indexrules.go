@@ -100,21 +100,13 @@ func (a IndexRules) Prunable() bool {
 	return (a.Default.MaxStale > 0)
 }
 
-// Cutoffs returns a set of cutoffs corresponding to a given timestamp and the set of all rules
-func (a IndexRules) Cutoffs(now time.Time) []int64 {
+// MaxAges returns the max-stale setting of all rules, in seconds, indexed by rule id.
+// the default rule comes last.
+// 0 means series matching the rule never become stale.
+func (a IndexRules) MaxAges() []int64 {
 	out := make([]int64, len(a.Rules)+1)
 	for i := 0; i <= len(a.Rules); i++ {
-		var rule IndexRule
-		if i < len(a.Rules) {
-			rule = a.Rules[i]
-		} else {
-			rule = a.Default
-		}
-		if rule.MaxStale == 0 {
-			out[i] = 0
-		} else {
-			out[i] = int64(now.Add(rule.MaxStale * -1).Unix())
-		}
+		out[i] = int64(a.Get(uint16(i)).MaxStale / time.Second)
 	}
 	return out
 }
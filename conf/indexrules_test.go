@@ -3,6 +3,7 @@ package conf
 import (
 	"io/ioutil"
 	"os"
+	"reflect"
 	"regexp"
 	"testing"
 	"time"
@@ -156,3 +157,18 @@ func TestIndexRulesMatch(t *testing.T) {
 	}
 
 }
+
+func TestIndexRulesMaxAges(t *testing.T) {
+	rules := IndexRules{
+		Rules: []IndexRule{
+			{Name: "a", MaxStale: time.Hour},
+			{Name: "b", MaxStale: 0},
+		},
+		Default: IndexRule{Name: "default", MaxStale: 90 * time.Second},
+	}
+	got := rules.MaxAges()
+	exp := []int64{3600, 0, 90}
+	if !reflect.DeepEqual(got, exp) {
+		t.Fatalf("expected max ages %v, got %v", exp, got)
+	}
+}
@@ -8,6 +8,7 @@ import (
 	"strings"
 
 	"github.com/alyu/configparser"
+	"github.com/grafana/metrictank/mdata/chunk"
 	"github.com/grafana/metrictank/util"
 )
 
@@ -31,6 +32,7 @@ type Schema struct {
 	Retentions    Retentions
 	Priority      int64
 	ReorderWindow uint32
+	Codec         chunk.Codec
 }
 
 func NewSchemas(schemas []Schema) Schemas {
@@ -60,6 +62,7 @@ func (s *Schemas) BuildIndex() {
 				Retentions:    schema.Retentions[pos:],
 				Priority:      schema.Priority,
 				ReorderWindow: schema.ReorderWindow,
+				Codec:         schema.Codec,
 			})
 		}
 	}
@@ -131,6 +134,13 @@ func ReadSchemas(file string) (Schemas, error) {
 			}
 		}
 
+		if codec := sec.ValueOf("codec"); codec != "" {
+			schema.Codec, err = chunk.ParseCodec(codec)
+			if err != nil {
+				return Schemas{}, fmt.Errorf("[%s]: %s", schema.Name, err)
+			}
+		}
+
 		schemas = append(schemas, schema)
 	}
 
@@ -1,9 +1,13 @@
 package conf
 
 import (
-	. "github.com/smartystreets/goconvey/convey"
+	"io/ioutil"
+	"os"
 	"regexp"
 	"testing"
+
+	"github.com/grafana/metrictank/mdata/chunk"
+	. "github.com/smartystreets/goconvey/convey"
 )
 
 func schemasForTest() Schemas {
@@ -164,3 +168,63 @@ func TestMaxChunkSpan(t *testing.T) {
 		So(max, ShouldEqual, 60*60*6)
 	})
 }
+
+func TestReadSchemasCodec(t *testing.T) {
+	cases := []struct {
+		in     string
+		expErr bool
+		exp    []chunk.Codec // of the schemas in the index, excluding the default schema
+	}{
+		{
+			in: `[a]
+pattern = ^a\.
+retentions = 1s:1d,1m:7d
+codec = lz4
+
+[b]
+pattern = .*
+retentions = 1s:1d
+`,
+			exp: []chunk.Codec{chunk.CodecLZ4, chunk.CodecLZ4, chunk.CodecGorilla},
+		},
+		{
+			in: `[a]
+pattern = .*
+retentions = 1s:1d
+codec = zstd
+`,
+			exp: []chunk.Codec{chunk.CodecZstd},
+		},
+		{
+			in: `[a]
+pattern = .*
+retentions = 1s:1d
+codec = snappy
+`,
+			expErr: true,
+		},
+	}
+	for i, c := range cases {
+		tmpfile, err := ioutil.TempFile("", "schemas-test-readschemascodec")
+		if err != nil {
+			panic(err)
+		}
+		if _, err := tmpfile.Write([]byte(c.in)); err != nil {
+			panic(err)
+		}
+		if err := tmpfile.Close(); err != nil {
+			panic(err)
+		}
+
+		schemas, err := ReadSchemas(tmpfile.Name())
+		os.Remove(tmpfile.Name())
+		if (err != nil) != c.expErr {
+			t.Fatalf("case %d, exp err %t, got err %v", i, c.expErr, err)
+		}
+		for j, exp := range c.exp {
+			if got := schemas.Get(uint16(j)).Codec; got != exp {
+				t.Fatalf("case %d, schema %d: exp codec %s, got %s", i, j, exp, got)
+			}
+		}
+	}
+}
@@ -344,15 +344,16 @@ func (b *BigtableIdx) LoadPartition(partition int32, defs []schema.MetricDefinit
 		log.Fatalf("bigtable-idx: failed to marshal row to metricDef. %s", marshalErr)
 	}
 
-	// getting all cutoffs once saves having to recompute everytime we have a match
-	cutoffs := memory.IndexRules.Cutoffs(now)
+	// getting all max ages once saves having to look up the rule everytime we have a match
+	maxAges := memory.IndexRules.MaxAges()
+	nowUnix := now.Unix()
 
 NAMES:
 	for nameWithTags, defsByName := range defsByNames {
 		irId, _ := memory.IndexRules.Match(nameWithTags)
-		cutoff := cutoffs[irId]
-		for _, def := range defsByName {
-			if def.LastUpdate > cutoff {
+		maxAge := maxAges[irId]
+		for i := range defsByName {
+			if !idx.DefIsStale(&defsByName[i], nowUnix, maxAge) {
 				// if any of the defs for a given nameWithTags is not stale, then we need to load
 				// all the defs for that nameWithTags.
 				defs = append(defs, defsByNames[nameWithTags]...)
@@ -85,6 +85,8 @@ func New(cfg *IdxConfig) *CasIdx {
 	if cfg.ssl {
 		cluster.SslOpts = &gocql.SslOptions{
 			CaPath:                 cfg.capath,
+			CertPath:               cfg.clientcertpath,
+			KeyPath:                cfg.clientkeypath,
 			EnableHostVerification: cfg.hostverification,
 		}
 	}
@@ -402,15 +404,16 @@ func (c *CasIdx) load(defs []schema.MetricDefinition, iter cqlIterator, now time
 		log.Fatalf("Could not close iterator: %s", err.Error())
 	}
 
-	// getting all cutoffs once saves having to recompute everytime we have a match
-	cutoffs := memory.IndexRules.Cutoffs(now)
+	// getting all max ages once saves having to look up the rule everytime we have a match
+	maxAges := memory.IndexRules.MaxAges()
+	nowUnix := now.Unix()
 
 NAMES:
 	for nameWithTags, defsByName := range defsByNames {
 		irId, _ := memory.IndexRules.Match(nameWithTags)
-		cutoff := cutoffs[irId]
+		maxAge := maxAges[irId]
 		for _, def := range defsByName {
-			if def.LastUpdate >= cutoff {
+			if !idx.DefIsStale(def, nowUnix, maxAge) {
 				// if any of the defs for a given nameWithTags is not stale, then we need to load
 				// all the defs for that nameWithTags.
 				for _, defToAdd := range defsByNames[nameWithTags] {
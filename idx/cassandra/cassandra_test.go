@@ -724,3 +724,27 @@ func (m MKeyAsc) Less(i, j int) bool {
 	}
 	return false
 }
+
+func TestValidateSSL(t *testing.T) {
+	cfg := NewIdxConfig()
+	cfg.ssl = true
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("expected the default ssl settings to be valid, got %s", err)
+	}
+	cfg.capath = ""
+	if err := cfg.Validate(); err == nil {
+		t.Fatalf("expected an error for host verification without ca-path")
+	}
+	cfg.hostverification = false
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("expected no ca-path to be valid without host verification, got %s", err)
+	}
+	cfg.clientcertpath = "/etc/client.pem"
+	if err := cfg.Validate(); err == nil {
+		t.Fatalf("expected an error for a client cert without key")
+	}
+	cfg.clientkeypath = "/etc/client.key"
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("expected client cert and key to be valid, got %s", err)
+	}
+}
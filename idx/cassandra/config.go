@@ -32,6 +32,8 @@ type IdxConfig struct {
 	keyspace                 string
 	hosts                    string
 	capath                   string
+	clientcertpath           string
+	clientkeypath            string
 	username                 string
 	password                 string
 	consistency              string
@@ -60,6 +62,8 @@ func NewIdxConfig() *IdxConfig {
 		disableInitialHostLookup: false,
 		ssl:                      false,
 		capath:                   "/etc/metrictank/ca.pem",
+		clientcertpath:           "",
+		clientkeypath:            "",
 		hostverification:         true,
 		auth:                     false,
 		username:                 "cassandra",
@@ -75,6 +79,12 @@ func (cfg *IdxConfig) Validate() error {
 	if cfg.timeout == 0 {
 		return errors.New("timeout must be greater than 0. " + timeUnits)
 	}
+	if cfg.ssl && cfg.hostverification && cfg.capath == "" {
+		return errors.New("ca-path must be set when host-verification is enabled")
+	}
+	if cfg.ssl && (cfg.clientcertpath == "") != (cfg.clientkeypath == "") {
+		return errors.New("client-cert-path and client-key-path must be set together")
+	}
 	return nil
 }
 
@@ -98,7 +108,9 @@ func ConfigSetup() *flag.FlagSet {
 	casIdx.BoolVar(&CliConfig.disableInitialHostLookup, "disable-initial-host-lookup", CliConfig.disableInitialHostLookup, "instruct the driver to not attempt to get host info from the system.peers table")
 	casIdx.BoolVar(&CliConfig.ssl, "ssl", CliConfig.ssl, "enable SSL connection to cassandra")
 	casIdx.StringVar(&CliConfig.capath, "ca-path", CliConfig.capath, "cassandra CA certficate path when using SSL")
-	casIdx.BoolVar(&CliConfig.hostverification, "host-verification", CliConfig.hostverification, "host (hostname and server cert) verification when using SSL")
+	casIdx.StringVar(&CliConfig.clientcertpath, "client-cert-path", CliConfig.clientcertpath, "client certificate path when using SSL, for cassandra clusters that require client authentication. requires client-key-path")
+	casIdx.StringVar(&CliConfig.clientkeypath, "client-key-path", CliConfig.clientkeypath, "client private key path when using SSL. requires client-cert-path")
+	casIdx.BoolVar(&CliConfig.hostverification, "host-verification", CliConfig.hostverification, "host (hostname and server cert) verification when using SSL. requires ca-path")
 
 	casIdx.BoolVar(&CliConfig.auth, "auth", CliConfig.auth, "enable cassandra user authentication")
 	casIdx.StringVar(&CliConfig.username, "username", CliConfig.username, "username for authentication")
@@ -0,0 +1,122 @@
+package idx
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/raintank/schema"
+)
+
+// maxDefinitionRecordSize is the size above which a record in a definitions file is considered corrupt
+const maxDefinitionRecordSize = 1 << 20
+
+// DumpDefinitionsToFile writes the given definitions, sorted by id, to a file that can be read by LoadDefinitionsFromFile.
+// every definition is stored as a msgp encoded record, preceded by its length as a 4-byte little endian integer.
+// the file is written to a temporary file first, so that it is replaced atomically.
+func DumpDefinitionsToFile(path string, defs []schema.MetricDefinition) error {
+	sorted := make([]*schema.MetricDefinition, len(defs))
+	for i := range defs {
+		sorted[i] = &defs[i]
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		return lessMKey(sorted[i].Id, sorted[j].Id)
+	})
+
+	tmp, err := os.Create(filepath.Join(filepath.Dir(path), "."+filepath.Base(path)+".tmp"))
+	if err != nil {
+		return err
+	}
+	err = writeDefinitions(tmp, sorted)
+	if err == nil {
+		err = tmp.Sync()
+	}
+	closeErr := tmp.Close()
+	if err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), path)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+	}
+	return err
+}
+
+func writeDefinitions(w io.Writer, defs []*schema.MetricDefinition) error {
+	buf := bufio.NewWriter(w)
+	var record []byte
+	var size [4]byte
+	var err error
+	for _, def := range defs {
+		record, err = def.MarshalMsg(record[:0])
+		if err != nil {
+			return fmt.Errorf("failed to encode definition %s: %s", def.Id, err)
+		}
+		binary.LittleEndian.PutUint32(size[:], uint32(len(record)))
+		buf.Write(size[:])
+		buf.Write(record)
+	}
+	return buf.Flush()
+}
+
+// LoadDefinitionsFromFile reads the definitions from a file written by DumpDefinitionsToFile
+// and calls handler for each of them, in the order of the file.
+// if the file is corrupt, the definitions up to the corruption are handled and an error is returned.
+func LoadDefinitionsFromFile(path string, handler func(*schema.MetricDefinition)) error {
+	fd, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer fd.Close()
+	reader := bufio.NewReaderSize(fd, 1<<16)
+
+	var offset int64
+	var size [4]byte
+	var record []byte
+	for {
+		_, err := io.ReadFull(reader, size[:])
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("corrupt definitions file %s at offset %d: %s", path, offset, err)
+		}
+		recordSize := binary.LittleEndian.Uint32(size[:])
+		if recordSize > maxDefinitionRecordSize {
+			return fmt.Errorf("corrupt definitions file %s at offset %d: record size %d exceeds maximum of %d", path, offset, recordSize, maxDefinitionRecordSize)
+		}
+		if cap(record) < int(recordSize) {
+			record = make([]byte, recordSize)
+		}
+		record = record[:recordSize]
+		_, err = io.ReadFull(reader, record)
+		if err != nil {
+			return fmt.Errorf("corrupt definitions file %s at offset %d: %s", path, offset, err)
+		}
+		def := new(schema.MetricDefinition)
+		left, err := def.UnmarshalMsg(record)
+		if err == nil && len(left) != 0 {
+			err = fmt.Errorf("%d trailing bytes", len(left))
+		}
+		if err != nil {
+			return fmt.Errorf("corrupt definitions file %s at offset %d: %s", path, offset, err)
+		}
+		handler(def)
+		offset += int64(len(size) + len(record))
+	}
+}
+
+// lessMKey orders keys by org, then by key
+func lessMKey(a, b schema.MKey) bool {
+	if a.Org != b.Org {
+		return a.Org < b.Org
+	}
+	return bytes.Compare(a.Key[:], b.Key[:]) < 0
+}
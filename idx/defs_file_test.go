@@ -0,0 +1,152 @@
+package idx
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/raintank/schema"
+)
+
+func getDefinitions(count int) []schema.MetricDefinition {
+	defs := make([]schema.MetricDefinition, count)
+	for i := range defs {
+		defs[i] = schema.MetricDefinition{
+			OrgId:      uint32(i%3 + 1),
+			Name:       fmt.Sprintf("some.metric.%d", i),
+			Interval:   10,
+			Mtype:      "gauge",
+			Tags:       []string{fmt.Sprintf("series=%d", i)},
+			LastUpdate: int64(1500000000 + i),
+			Partition:  int32(i % 8),
+		}
+		defs[i].SetId()
+	}
+	return defs
+}
+
+func tempDefinitionsFile(t testing.TB) (string, func()) {
+	dir, err := ioutil.TempDir("", "defs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return filepath.Join(dir, "defs"), func() { os.RemoveAll(dir) }
+}
+
+func loadDefinitions(path string) ([]schema.MetricDefinition, error) {
+	var defs []schema.MetricDefinition
+	err := LoadDefinitionsFromFile(path, func(def *schema.MetricDefinition) {
+		defs = append(defs, *def)
+	})
+	return defs, err
+}
+
+func TestDefinitionsFileRoundTrip(t *testing.T) {
+	path, cleanup := tempDefinitionsFile(t)
+	defer cleanup()
+
+	defs := getDefinitions(100)
+	err := DumpDefinitionsToFile(path, defs)
+	if err != nil {
+		t.Fatalf("failed to dump definitions: %s", err)
+	}
+	got, err := loadDefinitions(path)
+	if err != nil {
+		t.Fatalf("failed to load definitions: %s", err)
+	}
+	if len(got) != len(defs) {
+		t.Fatalf("expected %d definitions, got %d", len(defs), len(got))
+	}
+	byId := make(map[schema.MKey]schema.MetricDefinition)
+	for _, def := range defs {
+		byId[def.Id] = def
+	}
+	for i, def := range got {
+		if i > 0 && !lessMKey(got[i-1].Id, def.Id) {
+			t.Fatalf("definitions not sorted: %s after %s", def.Id, got[i-1].Id)
+		}
+		if !reflect.DeepEqual(def, byId[def.Id]) {
+			t.Fatalf("expected %v, got %v", byId[def.Id], def)
+		}
+	}
+
+	// dumping again replaces the file
+	err = DumpDefinitionsToFile(path, defs[:10])
+	if err != nil {
+		t.Fatalf("failed to dump definitions: %s", err)
+	}
+	got, err = loadDefinitions(path)
+	if err != nil || len(got) != 10 {
+		t.Fatalf("expected 10 definitions and no error, got %d definitions and error %v", len(got), err)
+	}
+}
+
+func TestDefinitionsFileCorruption(t *testing.T) {
+	path, cleanup := tempDefinitionsFile(t)
+	defer cleanup()
+
+	err := DumpDefinitionsToFile(path, getDefinitions(10))
+	if err != nil {
+		t.Fatalf("failed to dump definitions: %s", err)
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// all records have the same size, because the definitions only differ in digits
+	recordSize := len(data) / 10
+
+	corrupt := func(name string, data []byte, expLoaded int) {
+		err := ioutil.WriteFile(path, data, 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := loadDefinitions(path)
+		if err == nil {
+			t.Fatalf("%s: expected an error", name)
+		}
+		if len(got) != expLoaded {
+			t.Fatalf("%s: expected the %d definitions before the corruption to be loaded, got %d", name, expLoaded, len(got))
+		}
+	}
+
+	corrupt("truncated record", data[:len(data)-5], 9)
+	corrupt("truncated size", data[:len(data)-recordSize+2], 9)
+
+	badSize := append([]byte{}, data...)
+	badSize[3*recordSize+3] = 0xff
+	corrupt("invalid size", badSize, 3)
+
+	badRecord := append([]byte{}, data...)
+	badRecord[5*recordSize+4] = 0xc1 // never used msgp type
+	corrupt("invalid record", badRecord, 5)
+
+	if _, err := loadDefinitions(path + ".missing"); err == nil {
+		t.Fatal("expected an error loading a missing file")
+	}
+}
+
+func BenchmarkLoadDefinitionsFromFile(b *testing.B) {
+	path, cleanup := tempDefinitionsFile(b)
+	defer cleanup()
+
+	defs := getDefinitions(100000)
+	err := DumpDefinitionsToFile(path, defs)
+	if err != nil {
+		b.Fatalf("failed to dump definitions: %s", err)
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		count := 0
+		err := LoadDefinitionsFromFile(path, func(def *schema.MetricDefinition) {
+			count++
+		})
+		if err != nil || count != len(defs) {
+			b.Fatalf("expected %d definitions and no error, got %d definitions and error %v", len(defs), count, err)
+		}
+	}
+}
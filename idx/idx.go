@@ -3,6 +3,7 @@
 package idx
 
 import (
+	"sync/atomic"
 	"time"
 
 	"github.com/raintank/schema"
@@ -26,6 +27,28 @@ type Archive struct {
 	LastSave uint32 // last time the metricDefinition was saved to a backend store (cassandra)
 }
 
+// AgeSeconds returns how many seconds before now the archive was last updated
+func (a *Archive) AgeSeconds(now int64) int64 {
+	return DefAgeSeconds(&a.MetricDefinition, now)
+}
+
+// IsStale returns whether the archive has not been updated for more than maxAge seconds as of now.
+// a maxAge of 0 means the archive never becomes stale.
+func (a *Archive) IsStale(now, maxAge int64) bool {
+	return DefIsStale(&a.MetricDefinition, now, maxAge)
+}
+
+// DefAgeSeconds is like Archive.AgeSeconds, for definitions that are not in the index, such as
+// the ones loaded from a persistent index.
+func DefAgeSeconds(def *schema.MetricDefinition, now int64) int64 {
+	return now - atomic.LoadInt64(&def.LastUpdate)
+}
+
+// DefIsStale is like Archive.IsStale, for definitions that are not in the index.
+func DefIsStale(def *schema.MetricDefinition, now, maxAge int64) bool {
+	return maxAge > 0 && DefAgeSeconds(def, now) > maxAge
+}
+
 // used primarily by tests, for convenience
 func NewArchiveBare(name string) Archive {
 	return Archive{
@@ -122,6 +145,10 @@ type MetricIndex interface {
 	// time is >= the from timestamp will be included.
 	TagDetails(orgId uint32, key string, filter string, from int64) (map[string]uint64, error)
 
+	// TagKeyCardinality returns the number of distinct values the given tag key
+	// has amongst the metrics of the given org.
+	TagKeyCardinality(orgId uint32, key string) (int, error)
+
 	// DeleteTagged deletes the specified series from the tag index and also the
 	// DefById index.
 	DeleteTagged(orgId uint32, paths []string) ([]Archive, error)
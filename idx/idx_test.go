@@ -0,0 +1,109 @@
+package idx
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/raintank/schema"
+	"github.com/tinylib/msgp/msgp"
+)
+
+func TestMetricDefinitionMsgpRoundTrip(t *testing.T) {
+	var manyTags []string
+	for i := 0; i < 50; i++ {
+		manyTags = append(manyTags, fmt.Sprintf("tag%d=value%d", i, i))
+	}
+	deepName := strings.Repeat("node.", 99) + "leaf"
+
+	newDef := func(name, mtype string, tags []string) schema.MetricDefinition {
+		md := schema.MetricDefinition{
+			OrgId:      1,
+			Name:       name,
+			Interval:   10,
+			Unit:       "ms",
+			Mtype:      mtype,
+			Tags:       tags,
+			LastUpdate: 1234567890,
+			Partition:  3,
+		}
+		md.SetId()
+		return md
+	}
+
+	// note: msgp decodes an empty Tags slice as nil, so we use nil for definitions without tags
+	cases := []struct {
+		name string
+		def  schema.MetricDefinition
+	}{
+		{"empty name", newDef("", "gauge", nil)},
+		{"single node", newDef("foo", "gauge", nil)},
+		{"deep name", newDef(deepName, "gauge", []string{"a=b"})},
+		{"50 tags", newDef("some.metric.name", "gauge", manyTags)},
+		{"mtype rate", newDef("some.metric.name", "rate", nil)},
+		{"mtype count", newDef("some.metric.name", "count", nil)},
+		{"mtype counter", newDef("some.metric.name", "counter", nil)},
+		{"mtype timestamp", newDef("some.metric.name", "timestamp", nil)},
+	}
+
+	for _, c := range cases {
+		// MarshalMsg -> UnmarshalMsg
+		data, err := c.def.MarshalMsg(nil)
+		if err != nil {
+			t.Fatalf("%s: failed to marshal: %s", c.name, err)
+		}
+		var got schema.MetricDefinition
+		left, err := got.UnmarshalMsg(data)
+		if err != nil {
+			t.Fatalf("%s: failed to unmarshal: %s", c.name, err)
+		}
+		if len(left) != 0 {
+			t.Fatalf("%s: %d bytes left over after unmarshal", c.name, len(left))
+		}
+		if !reflect.DeepEqual(c.def, got) {
+			t.Fatalf("%s: MarshalMsg/UnmarshalMsg round trip mismatch.\nexpected %+v\ngot      %+v", c.name, c.def, got)
+		}
+
+		// EncodeMsg -> DecodeMsg, as part of an Archive
+		archive := Archive{MetricDefinition: c.def, LastSave: 1234567890}
+		var buf bytes.Buffer
+		err = msgp.Encode(&buf, &archive)
+		if err != nil {
+			t.Fatalf("%s: failed to encode archive: %s", c.name, err)
+		}
+		var gotArchive Archive
+		err = msgp.Decode(&buf, &gotArchive)
+		if err != nil {
+			t.Fatalf("%s: failed to decode archive: %s", c.name, err)
+		}
+		if !reflect.DeepEqual(archive, gotArchive) {
+			t.Fatalf("%s: EncodeMsg/DecodeMsg round trip mismatch.\nexpected %+v\ngot      %+v", c.name, archive, gotArchive)
+		}
+	}
+}
+
+func TestArchiveIsStale(t *testing.T) {
+	a := NewArchiveBare("some.metric")
+	a.LastUpdate = 1000
+	cases := []struct {
+		now      int64
+		maxAge   int64
+		expAge   int64
+		expStale bool
+	}{
+		{1000, 60, 0, false},
+		{1060, 60, 60, false},
+		{1061, 60, 61, true},
+		{5000, 0, 4000, false},
+	}
+	for i, c := range cases {
+		if age := a.AgeSeconds(c.now); age != c.expAge {
+			t.Fatalf("case %d: expected age %d, got %d", i, c.expAge, age)
+		}
+		if stale := a.IsStale(c.now, c.maxAge); stale != c.expStale {
+			t.Fatalf("case %d: expected stale %t, got %t", i, c.expStale, stale)
+		}
+	}
+}
@@ -17,6 +17,7 @@ import (
 	"github.com/grafana/metrictank/idx"
 	"github.com/grafana/metrictank/mdata"
 	"github.com/grafana/metrictank/stats"
+	"github.com/grafana/metrictank/util"
 	"github.com/raintank/schema"
 	log "github.com/sirupsen/logrus"
 )
@@ -44,6 +45,9 @@ var (
 	// metric idx.memory.filtered is number of series that have been excluded from responses due to their lastUpdate property
 	statFiltered = stats.NewCounter32("idx.memory.filtered")
 
+	// metric idx.memory.mkey-collisions is the number of metric keys that were found to be shared by metrics with different properties
+	statMkeyCollisions = stats.NewCounter32("idx.memory.mkey-collisions")
+
 	// metric idx.metrics_active is the number of currently known metrics in the index
 	statMetricsActive = stats.NewGauge32("idx.metrics_active")
 
@@ -242,6 +246,9 @@ type UnpartitionedMemoryIdx struct {
 	tags        map[uint32]TagIndex // by orgId
 
 	findCache *FindCache
+
+	// keys for which we have reported a collision, so we only report them once
+	reportedCollisions sync.Map
 }
 
 func NewUnpartitionedMemoryIdx() *UnpartitionedMemoryIdx {
@@ -311,6 +318,7 @@ func (m *UnpartitionedMemoryIdx) AddOrUpdate(mkey schema.MKey, data *schema.Metr
 	existing, ok := m.defById[mkey]
 	if ok {
 		log.Debugf("memory-idx: metricDef with id %s already in index.", mkey)
+		m.checkCollision(existing, data)
 		bumpLastUpdate(&existing.LastUpdate, data.Time)
 		oldPart := atomic.SwapInt32(&existing.Partition, partition)
 		statUpdate.Inc()
@@ -336,6 +344,22 @@ func (m *UnpartitionedMemoryIdx) AddOrUpdate(mkey schema.MKey, data *schema.Metr
 	return archive, 0, false
 }
 
+// checkCollision reports when the given data has the same key as the existing definition,
+// but describes a different metric, which means two metrics hash to the same key.
+// tags are not compared, because they may not be in the same order.
+// every key is only reported once.
+func (m *UnpartitionedMemoryIdx) checkCollision(existing *idx.Archive, data *schema.MetricData) {
+	if existing.Name == data.Name && existing.Interval == data.Interval && existing.Unit == data.Unit && existing.Mtype == data.Mtype {
+		return
+	}
+	if _, reported := m.reportedCollisions.LoadOrStore(existing.Id, struct{}{}); reported {
+		return
+	}
+	statMkeyCollisions.Inc()
+	log.Errorf("memory-idx: mkey collision for id %s: existing metric %s (interval %d, unit %q, mtype %q, tags %v) vs new metric %s (interval %d, unit %q, mtype %q, tags %v)",
+		existing.Id, existing.Name, existing.Interval, existing.Unit, existing.Mtype, existing.Tags, data.Name, data.Interval, data.Unit, data.Mtype, data.Tags)
+}
+
 // UpdateArchive updates the archive information
 func (m *UnpartitionedMemoryIdx) UpdateArchive(archive idx.Archive) {
 	m.Lock()
@@ -637,6 +661,24 @@ func (m *UnpartitionedMemoryIdx) TagDetails(orgId uint32, key, filter string, fr
 	return res, nil
 }
 
+// TagKeyCardinality returns the number of distinct values of the given tag key in the given org
+func (m *UnpartitionedMemoryIdx) TagKeyCardinality(orgId uint32, key string) (int, error) {
+	if !TagSupport {
+		log.Warn("memory-idx: received tag query, but tag support is disabled")
+		return 0, nil
+	}
+
+	m.RLock()
+	defer m.RUnlock()
+
+	tags, ok := m.tags[orgId]
+	if !ok {
+		return 0, nil
+	}
+
+	return len(tags[key]), nil
+}
+
 // FindTags returns tags matching the specified conditions
 // prefix:      prefix match
 // expressions: tagdb expressions in the same format as graphite
@@ -1350,15 +1392,16 @@ func (m *UnpartitionedMemoryIdx) Prune(now time.Time) ([]idx.Archive, error) {
 	}
 	pre := time.Now()
 
-	// getting all cutoffs once saves having to recompute everytime we have a match
-	cutoffs := IndexRules.Cutoffs(now)
+	// getting all max ages once saves having to look up the rule everytime we have a match
+	maxAges := IndexRules.MaxAges()
+	nowUnix := now.Unix()
 
 	m.RLock()
 
 DEFS:
 	for _, def := range m.defById {
-		cutoff := cutoffs[def.IrId]
-		if atomic.LoadInt64(&def.LastUpdate) >= cutoff {
+		maxAge := maxAges[def.IrId]
+		if !def.IsStale(nowUnix, maxAge) {
 			continue DEFS
 		}
 
@@ -1374,7 +1417,7 @@ DEFS:
 			}
 
 			for _, id := range n.Defs {
-				if atomic.LoadInt64(&m.defById[id].LastUpdate) >= cutoff {
+				if !m.defById[id].IsStale(nowUnix, maxAge) {
 					continue DEFS
 				}
 			}
@@ -1385,7 +1428,7 @@ DEFS:
 			// if any other MetricDef with the same tag set is not expired yet,
 			// then we do not want to prune any of them
 			for def := range defs {
-				if atomic.LoadInt64(&def.LastUpdate) >= cutoff {
+				if !m.defById[def.Id].IsStale(nowUnix, maxAge) {
 					continue DEFS
 				}
 			}
@@ -1477,7 +1520,7 @@ func getMatcher(path string) (func([]string) []string, error) {
 
 	var patterns []string
 	if strings.ContainsAny(path, "{}") {
-		patterns = expandQueries(path)
+		patterns = util.ExpandQueries(path)
 	} else {
 		patterns = []string{path}
 	}
@@ -1524,46 +1567,6 @@ func getMatcher(path string) (func([]string) []string, error) {
 	}, nil
 }
 
-// We don't use filepath.Match as it doesn't support {} because that's not posix, it's a bashism
-// the easiest way of implementing this extra feature is just expanding single queries
-// that contain these queries into multiple queries, which will be checked separately
-// and the results of which will be ORed.
-func expandQueries(query string) []string {
-	queries := []string{query}
-
-	// as long as we find a { followed by a }, split it up into subqueries, and process
-	// all queries again
-	// we only stop once there are no more queries that still have {..} in them
-	keepLooking := true
-	for keepLooking {
-		expanded := make([]string, 0)
-		keepLooking = false
-		for _, query := range queries {
-			lbrace := strings.Index(query, "{")
-			rbrace := -1
-			if lbrace > -1 {
-				rbrace = strings.Index(query[lbrace:], "}")
-				if rbrace > -1 {
-					rbrace += lbrace
-				}
-			}
-
-			if lbrace > -1 && rbrace > -1 {
-				keepLooking = true
-				expansion := query[lbrace+1 : rbrace]
-				options := strings.Split(expansion, ",")
-				for _, option := range options {
-					expanded = append(expanded, query[:lbrace]+option+query[rbrace+1:])
-				}
-			} else {
-				expanded = append(expanded, query)
-			}
-		}
-		queries = expanded
-	}
-	return queries
-}
-
 func toRegexp(pattern string) string {
 	p := pattern
 	p = strings.Replace(p, "*", ".*", -1)
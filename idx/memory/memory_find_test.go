@@ -325,6 +325,33 @@ func testTagDetailsWithoutFilters(t *testing.T) {
 	queryAndCompareTagValues(t, "dc", "", 0, expected)
 }
 
+func TestTagKeyCardinality(t *testing.T) {
+	withAndWithoutPartitonedIndex(testTagKeyCardinality)(t)
+}
+
+func testTagKeyCardinality(t *testing.T) {
+	InitSmallIndex()
+
+	cases := []struct {
+		orgId uint32
+		key   string
+		exp   int
+	}{
+		{1, "dc", 5},
+		{1, "unknown", 0},
+		{2, "dc", 0},
+	}
+	for _, c := range cases {
+		card, err := ix.TagKeyCardinality(c.orgId, c.key)
+		if err != nil {
+			t.Fatalf("Unexpected error: %s", err.Error())
+		}
+		if card != c.exp {
+			t.Fatalf("Expected cardinality %d for org %d key %s, but got %d", c.exp, c.orgId, c.key, card)
+		}
+	}
+}
+
 func TestTagDetailsWithFrom(t *testing.T) {
 	withAndWithoutPartitonedIndex(testTagDetailsWithFrom)(t)
 }
@@ -699,6 +726,24 @@ func benchmarkTagDetailsWithoutFromNorFilter(b *testing.B) {
 	}
 }
 
+func BenchmarkTagKeyCardinality(b *testing.B) {
+	benchWithAndWithoutPartitonedIndex(benchmarkTagKeyCardinality)(b)
+}
+
+func benchmarkTagKeyCardinality(b *testing.B) {
+	InitLargeIndex()
+
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for n := 0; n < b.N; n++ {
+		card, _ := ix.TagKeyCardinality(1, "dc")
+		if card != 5 {
+			b.Fatalf("Expected cardinality 5, but got %d", card)
+		}
+	}
+}
+
 func BenchmarkTagDetailsWithFromAndFilter(b *testing.B) {
 	benchWithAndWithoutPartitonedIndex(benchmarkTagDetailsWithFromAndFilter)(b)
 }
@@ -985,6 +985,49 @@ func testSingleNodeMetric(t *testing.T) {
 	ix.AddOrUpdate(mkey, data, getPartition(data))
 }
 
+func TestMkeyCollision(t *testing.T) {
+	withAndWithoutPartitonedIndex(testMkeyCollision)(t)
+}
+
+func testMkeyCollision(t *testing.T) {
+	ix := New()
+	ix.Init()
+
+	data := &schema.MetricData{
+		Name:     "some.metric",
+		Interval: 10,
+		OrgId:    1,
+	}
+	data.SetId()
+	mkey, err := schema.MKeyFromString(data.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	before := statMkeyCollisions.Peek()
+	ix.AddOrUpdate(mkey, data, getPartition(data))
+	ix.AddOrUpdate(mkey, data, getPartition(data))
+	if got := statMkeyCollisions.Peek() - before; got != 0 {
+		t.Fatalf("expected no collisions for the same metric, got %d", got)
+	}
+
+	// a different metric with the same id, as if they hashed to the same key
+	colliding := &schema.MetricData{
+		Id:       data.Id,
+		Name:     "other.metric",
+		Interval: 10,
+		OrgId:    1,
+	}
+	ix.AddOrUpdate(mkey, colliding, getPartition(data))
+	ix.AddOrUpdate(mkey, colliding, getPartition(data))
+	if got := statMkeyCollisions.Peek() - before; got != 1 {
+		t.Fatalf("expected the collision to be reported once, got %d", got)
+	}
+	def, ok := ix.Get(mkey)
+	if !ok || def.Name != "some.metric" {
+		t.Fatalf("expected the existing definition to be kept, got %v", def)
+	}
+}
+
 // TestMemoryIndexHeapUsageWithTagsUniqueNone5 gives a rough estimate of how much memory the index is using with tag support turned on.
 // It uses 0 unique tags and 10 identical tags
 func TestMemoryIndexHeapUsageWithTagsUniqueNone5(t *testing.T) {
@@ -474,6 +474,18 @@ func (p *PartitionedMemoryIdx) TagDetails(orgId uint32, key string, filter strin
 	return merged, nil
 }
 
+// TagKeyCardinality returns the number of distinct values the given tag key
+// has amongst the metrics of the given org.
+// values may be present in multiple partitions, so we need to deduplicate
+// them, which TagDetails does for us.
+func (p *PartitionedMemoryIdx) TagKeyCardinality(orgId uint32, key string) (int, error) {
+	values, err := p.TagDetails(orgId, key, "", 0)
+	if err != nil {
+		return 0, err
+	}
+	return len(values), nil
+}
+
 // DeleteTagged deletes the specified series from the tag index and also the
 // DefById index.
 func (p *PartitionedMemoryIdx) DeleteTagged(orgId uint32, paths []string) ([]idx.Archive, error) {
@@ -4,18 +4,24 @@ package carbon
 
 import (
 	"bufio"
+	"bytes"
 	"context"
+	"errors"
 	"flag"
+	"fmt"
 	"io"
+	"math"
 	"net"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/grafana/globalconf"
 	"github.com/grafana/metrictank/cluster"
 	"github.com/grafana/metrictank/input"
 	"github.com/grafana/metrictank/stats"
 	"github.com/metrics20/go-metrics20/carbon20"
+	"github.com/pelletier/go-toml"
 	"github.com/raintank/schema"
 	log "github.com/sirupsen/logrus"
 )
@@ -26,6 +32,11 @@ var metricsPerMessage = stats.NewMeter32("input.carbon.metrics_per_message", fal
 // metric input.carbon.metrics_decode_err is a count of times an input message (MetricData, MetricDataArray or carbon line) failed to parse
 var metricsDecodeErr = stats.NewCounterRate32("input.carbon.metrics_decode_err")
 
+// metric input.carbon.auth_failures is a count of connections that were closed because they didn't start with a valid api key
+var authFailures = stats.NewCounterRate32("input.carbon.auth_failures")
+
+var errLineTooLong = errors.New("line too long")
+
 type Carbon struct {
 	input.Handler
 	addrStr          string
@@ -35,6 +46,9 @@ type Carbon struct {
 	quit             chan struct{}
 	connTrack        *ConnTrack
 	intervalGetter   IntervalGetter
+	readTimeout      time.Duration
+	maxLineLength    int
+	apiKeys          map[string]uint32 // api key -> org id. nil if connections don't need to authenticate
 }
 
 type ConnTrack struct {
@@ -75,12 +89,20 @@ func (c *Carbon) Name() string {
 var Enabled bool
 var addr string
 var partitionId int
+var readTimeout time.Duration
+var maxLineLength int
+var apiKeysFile string
+
+var apiKeys map[string]uint32
 
 func ConfigSetup() {
 	inCarbon := flag.NewFlagSet("carbon-in", flag.ExitOnError)
 	inCarbon.BoolVar(&Enabled, "enabled", false, "")
 	inCarbon.StringVar(&addr, "addr", ":2003", "tcp listen address")
 	inCarbon.IntVar(&partitionId, "partition", 0, "partition Id.")
+	inCarbon.DurationVar(&readTimeout, "read-timeout", 0, "close connections that haven't sent a line for this long. 0 to disable")
+	inCarbon.IntVar(&maxLineLength, "max-line-length", 4096, "lines longer than this many bytes are discarded")
+	inCarbon.StringVar(&apiKeysFile, "api-keys-file", "", "path to a toml file with the api keys of the orgs that can send data. if set, the first line of every connection must be an api key, and its data goes to the org of the key. if empty, all data goes to org 1")
 	globalconf.Register("carbon-in", inCarbon, flag.ExitOnError)
 }
 
@@ -88,18 +110,69 @@ func ConfigProcess() {
 	if !Enabled {
 		return
 	}
+	if readTimeout < 0 {
+		log.Fatal("carbon-in: read-timeout must be >= 0")
+	}
+	if maxLineLength < 16 {
+		log.Fatal("carbon-in: max-line-length must be >= 16")
+	}
+	if apiKeysFile != "" {
+		var err error
+		apiKeys, err = ReadAPIKeys(apiKeysFile)
+		if err != nil {
+			log.Fatalf("carbon-in: %s", err)
+		}
+	}
 	cluster.Manager.SetPartitions([]int32{int32(partitionId)})
 }
 
+// ReadAPIKeys reads the api keys of the orgs from a toml file of the form:
+//
+//	[[key]]
+//	key = "some secret"
+//	org-id = 2
+func ReadAPIKeys(path string) (map[string]uint32, error) {
+	tree, err := toml.LoadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var file struct {
+		Key []struct {
+			Key   string `toml:"key"`
+			OrgId int64  `toml:"org-id"`
+		} `toml:"key"`
+	}
+	if err := tree.Unmarshal(&file); err != nil {
+		return nil, fmt.Errorf("%s: %s", path, err)
+	}
+	keys := make(map[string]uint32, len(file.Key))
+	for i, k := range file.Key {
+		if k.Key == "" {
+			return nil, fmt.Errorf("%s: key %d is empty", path, i)
+		}
+		if k.OrgId <= 0 || k.OrgId > math.MaxUint32 {
+			return nil, fmt.Errorf("%s: key %d has invalid org-id %d", path, i, k.OrgId)
+		}
+		if _, ok := keys[k.Key]; ok {
+			return nil, fmt.Errorf("%s: key %d is listed more than once", path, i)
+		}
+		keys[k.Key] = uint32(k.OrgId)
+	}
+	return keys, nil
+}
+
 func New() *Carbon {
 	addrT, err := net.ResolveTCPAddr("tcp", addr)
 	if err != nil {
 		log.Fatalf("carbon-in: %s", err.Error())
 	}
 	return &Carbon{
-		addrStr:   addr,
-		addr:      addrT,
-		connTrack: NewConnTrack(),
+		addrStr:       addr,
+		addr:          addrT,
+		connTrack:     NewConnTrack(),
+		readTimeout:   readTimeout,
+		maxLineLength: maxLineLength,
+		apiKeys:       apiKeys,
 	}
 }
 
@@ -163,22 +236,33 @@ func (c *Carbon) handle(conn net.Conn) {
 		conn.Close()
 		c.connTrack.Remove(conn)
 	}()
-	// TODO c.SetTimeout(60e9)
-	r := bufio.NewReaderSize(conn, 4096)
+	r := bufio.NewReaderSize(conn, c.maxLineLength)
+	orgId := uint32(1) // admin org
+	if c.apiKeys != nil {
+		buf, err := c.readLine(conn, r)
+		if err != nil {
+			c.recvError(err)
+			c.handlerWaitGroup.Done()
+			return
+		}
+		var ok bool
+		orgId, ok = c.apiKeys[string(bytes.TrimSpace(buf))]
+		if !ok {
+			authFailures.Inc()
+			log.Warnf("carbon-in: closing connection from %s: invalid api key", conn.RemoteAddr())
+			c.handlerWaitGroup.Done()
+			return
+		}
+	}
 	for {
-		// note that we don't support lines longer than 4096B. that seems very reasonable..
-		buf, _, err := r.ReadLine()
-
+		buf, err := c.readLine(conn, r)
+		if err == errLineTooLong {
+			metricsDecodeErr.Inc()
+			log.Errorf("carbon-in: invalid metric: line longer than %d bytes", c.maxLineLength)
+			continue
+		}
 		if nil != err {
-			select {
-			case <-c.quit:
-				// we are shutting down.
-				break
-			default:
-			}
-			if io.EOF != err {
-				log.Errorf("carbon-in: Recv error: %s", err.Error())
-			}
+			c.recvError(err)
 			break
 		}
 
@@ -198,7 +282,7 @@ func (c *Carbon) handle(conn net.Conn) {
 			Time:     int64(ts),
 			Mtype:    "gauge",
 			Tags:     nameSplits[1:],
-			OrgId:    1, // admin org
+			OrgId:    int(orgId),
 		}
 		md.SetId()
 		metricsPerMessage.ValueUint32(1)
@@ -206,3 +290,38 @@ func (c *Carbon) handle(conn net.Conn) {
 	}
 	c.handlerWaitGroup.Done()
 }
+
+// readLine reads the next line, honoring the read timeout.
+// lines longer than the buffer of the reader are discarded, and reported with errLineTooLong
+func (c *Carbon) readLine(conn net.Conn, r *bufio.Reader) ([]byte, error) {
+	if c.readTimeout > 0 {
+		conn.SetReadDeadline(time.Now().Add(c.readTimeout))
+	}
+	buf, isPrefix, err := r.ReadLine()
+	if err != nil || !isPrefix {
+		return buf, err
+	}
+	for isPrefix {
+		_, isPrefix, err = r.ReadLine()
+		if err != nil {
+			return nil, err
+		}
+	}
+	return nil, errLineTooLong
+}
+
+func (c *Carbon) recvError(err error) {
+	select {
+	case <-c.quit:
+		// we are shutting down.
+		return
+	default:
+	}
+	if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+		log.Infof("carbon-in: closing connection: no data received for %s", c.readTimeout)
+		return
+	}
+	if io.EOF != err {
+		log.Errorf("carbon-in: Recv error: %s", err.Error())
+	}
+}
@@ -0,0 +1,128 @@
+package carbon
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/grafana/metrictank/input/inputtest"
+)
+
+type fixedIntervalGetter int
+
+func (i fixedIntervalGetter) GetInterval(name string) int {
+	return int(i)
+}
+
+func newTestCarbon(t *testing.T, apiKeys map[string]uint32, readTimeout time.Duration) (*Carbon, *inputtest.RecordingHandler) {
+	addrT, _ := net.ResolveTCPAddr("tcp", "127.0.0.1:0")
+	c := &Carbon{
+		addr:           addrT,
+		connTrack:      NewConnTrack(),
+		intervalGetter: fixedIntervalGetter(10),
+		readTimeout:    readTimeout,
+		maxLineLength:  64,
+		apiKeys:        apiKeys,
+	}
+	handler := &inputtest.RecordingHandler{}
+	if err := c.Start(handler, nil); err != nil {
+		t.Fatalf("failed to start: %s", err)
+	}
+	return c, handler
+}
+
+func send(t *testing.T, c *Carbon, lines ...string) net.Conn {
+	conn, err := net.Dial("tcp", c.listener.Addr().String())
+	if err != nil {
+		t.Fatalf("failed to dial: %s", err)
+	}
+	for _, line := range lines {
+		fmt.Fprintf(conn, "%s\n", line)
+	}
+	return conn
+}
+
+func TestCarbon(t *testing.T) {
+	c, handler := newTestCarbon(t, nil, 0)
+	defer c.Stop()
+	conn := send(t, c,
+		"some.metric 1.5 1540000000",
+		"invalid",
+		"some.metric.that.is.way.too.long.for.the.max.line.length.of.the.test 1 1540000000",
+		"tagged.metric;dc=us-west;host=web01 2 1540000010",
+	)
+	defer conn.Close()
+	mds := handler.WaitFor(t, 2)
+	if len(mds) != 2 {
+		t.Fatalf("expected 2 MetricData's, got %d", len(mds))
+	}
+	if md := mds[0]; md.Name != "some.metric" || md.Value != 1.5 || md.Time != 1540000000 || md.Interval != 10 || md.OrgId != 1 {
+		t.Fatalf("unexpected MetricData %+v", md)
+	}
+	if md := mds[1]; md.Name != "tagged.metric" || md.Value != 2 || strings.Join(md.Tags, ",") != "dc=us-west,host=web01" || md.OrgId != 1 {
+		t.Fatalf("unexpected MetricData %+v", md)
+	}
+}
+
+func TestCarbonAPIKey(t *testing.T) {
+	c, handler := newTestCarbon(t, map[string]uint32{"secret": 2}, 0)
+	defer c.Stop()
+
+	invalid := send(t, c, "wrong", "some.metric 1 1540000000")
+	defer invalid.Close()
+	valid := send(t, c, "secret", "some.metric 2 1540000000")
+	defer valid.Close()
+
+	handler.WaitFor(t, 1)
+	// the connection with the invalid key gets closed by the server
+	invalid.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, err := invalid.Read(make([]byte, 1)); err == nil {
+		t.Fatalf("expected the connection with the invalid api key to be closed")
+	}
+	mds := handler.MetricData()
+	if len(mds) != 1 || mds[0].Value != 2 || mds[0].OrgId != 2 {
+		t.Fatalf("expected 1 MetricData for org 2, got %v", mds)
+	}
+}
+
+func TestCarbonReadTimeout(t *testing.T) {
+	c, _ := newTestCarbon(t, nil, 50*time.Millisecond)
+	defer c.Stop()
+	conn := send(t, c)
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, err := conn.Read(make([]byte, 1)); err == nil {
+		t.Fatalf("expected the idle connection to be closed")
+	} else if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+		t.Fatalf("expected the server to close the idle connection")
+	}
+}
+
+func TestReadAPIKeys(t *testing.T) {
+	f, err := ioutil.TempFile("", "api-keys")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	f.WriteString(`
+[[key]]
+key = "secret"
+org-id = 2
+
+[[key]]
+key = "other secret"
+org-id = 3
+`)
+	f.Close()
+	keys, err := ReadAPIKeys(f.Name())
+	if err != nil {
+		t.Fatalf("unexpected error %s", err)
+	}
+	if len(keys) != 2 || keys["secret"] != 2 || keys["other secret"] != 3 {
+		t.Fatalf("unexpected keys %v", keys)
+	}
+}
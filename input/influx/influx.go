@@ -0,0 +1,204 @@
+package influx
+
+import (
+	"bufio"
+	"context"
+	"flag"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/grafana/globalconf"
+	"github.com/grafana/metrictank/cluster"
+	"github.com/grafana/metrictank/input"
+	"github.com/grafana/metrictank/stats"
+	"github.com/raintank/schema"
+	log "github.com/sirupsen/logrus"
+)
+
+// metric input.influx.parse_errors is a count of times a line of line protocol failed to parse
+var parseErrors = stats.NewCounterRate32("input.influx.parse_errors")
+
+// metric input.influx.too_many_tags is a count of lines of line protocol that were rejected because they had more than max-tags tags
+var tooManyTags = stats.NewCounterRate32("input.influx.too_many_tags")
+
+// metric input.influx.metrics_per_message is how many metrics per line were seen, i.e. the number of fields of the line
+var metricsPerMessage = stats.NewMeter32("input.influx.metrics_per_message", false)
+
+var (
+	Enabled     bool
+	addr        string
+	partitionID int
+	maxTags     int
+)
+
+// maxLineSize is the size of the longest line we accept
+const maxLineSize = 1024 * 1024
+
+func ConfigSetup() {
+	inInflux := flag.NewFlagSet("influx-in", flag.ExitOnError)
+	inInflux.BoolVar(&Enabled, "enabled", false, "")
+	inInflux.StringVar(&addr, "addr", ":8086", "http listen address. line protocol is accepted on /api/v1/influx/write")
+	inInflux.IntVar(&partitionID, "partition", 0, "partition Id.")
+	inInflux.IntVar(&maxTags, "max-tags", 32, "lines with more tags than this are rejected. 0 to disable")
+	globalconf.Register("influx-in", inInflux, flag.ExitOnError)
+}
+
+func ConfigProcess() {
+	if !Enabled {
+		return
+	}
+	if maxTags < 0 {
+		log.Fatal("influx-in: max-tags must be >= 0")
+	}
+	cluster.Manager.SetPartitions([]int32{int32(partitionID)})
+}
+
+// Influx is an input plugin that accepts InfluxDB line protocol over HTTP.
+// every field of a line becomes a metric named <measurement>.<field>,
+// with the tags of the line.
+type Influx struct {
+	input.Handler
+	addr        string
+	partition   int32
+	maxTags     int
+	getInterval func(name string) int
+	server      *http.Server
+}
+
+func New() *Influx {
+	return &Influx{
+		addr:        addr,
+		partition:   int32(partitionID),
+		maxTags:     maxTags,
+		getInterval: input.SchemaInterval,
+	}
+}
+
+func (i *Influx) Name() string {
+	return "influx"
+}
+
+func (i *Influx) Start(handler input.Handler, cancel context.CancelFunc) error {
+	i.Handler = handler
+	l, err := net.Listen("tcp", i.addr)
+	if err != nil {
+		log.Errorf("influx-in: %s", err.Error())
+		return err
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/v1/influx/write", i.handle)
+	i.server = &http.Server{
+		Handler: mux,
+	}
+	log.Infof("influx-in: listening on %v", l.Addr())
+	go i.server.Serve(l)
+	return nil
+}
+
+func (i *Influx) MaintainPriority() {
+	cluster.Manager.SetPriority(0)
+}
+
+func (i *Influx) ExplainPriority() interface{} {
+	return "influx-in: priority=0 (always in sync)"
+}
+
+func (i *Influx) Stop() {
+	log.Info("influx-in: shutting down")
+	if i.server != nil {
+		i.server.Close()
+	}
+}
+
+// handle handles write requests. like InfluxDB, it responds with 204 if all lines
+// were written, and with 400 if some of them were rejected. the valid lines are written either way,
+// unless the request is rate limited.
+func (i *Influx) handle(w http.ResponseWriter, req *http.Request) {
+	if req.Method != "POST" {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		w.Write([]byte("only POST is supported"))
+		return
+	}
+	precision, err := parsePrecision(req.URL.Query().Get("precision"))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return
+	}
+	defer req.Body.Close()
+
+	now := time.Now()
+	var mds []*schema.MetricData
+	var rejected int
+	var firstErr error
+	scanner := bufio.NewScanner(req.Body)
+	scanner.Buffer(make([]byte, 0, 4096), maxLineSize)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		lineMds, err := i.toMetricData(line, precision, now)
+		if err != nil {
+			log.Debugf("influx-in: rejected line %q: %s", line, err)
+			if firstErr == nil {
+				firstErr = err
+			}
+			rejected++
+			continue
+		}
+		mds = append(mds, lineMds...)
+	}
+	if err := scanner.Err(); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(fmt.Sprintf("Read Error, %v", err)))
+		log.Errorf("influx-in: Read Error, %v", err)
+		return
+	}
+	if input.RateLimited(w, 1, len(mds)) {
+		return
+	}
+	for _, md := range mds {
+		i.ProcessMetricData(md, i.partition)
+	}
+	if rejected > 0 {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(fmt.Sprintf("partial write: %d lines rejected. first error: %s", rejected, firstErr)))
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// toMetricData parses the line and returns the metrics of its fields
+func (i *Influx) toMetricData(line string, precision time.Duration, now time.Time) ([]*schema.MetricData, error) {
+	p, err := parseLine(line, precision, now)
+	if err != nil {
+		parseErrors.Inc()
+		return nil, err
+	}
+	if i.maxTags > 0 && len(p.tags) > i.maxTags {
+		tooManyTags.Inc()
+		return nil, fmt.Errorf("%d tags exceeds max-tags %d", len(p.tags), i.maxTags)
+	}
+	metricsPerMessage.Value(len(p.fields))
+	mds := make([]*schema.MetricData, 0, len(p.fields))
+	for _, f := range p.fields {
+		name := p.measurement + "." + f.key
+		md := &schema.MetricData{
+			Name:     name,
+			Interval: i.getInterval(name),
+			Value:    f.value,
+			Unit:     "unknown",
+			Time:     p.time,
+			Mtype:    "gauge",
+			Tags:     input.CopyTags(p.tags),
+			OrgId:    1,
+		}
+		md.SetId()
+		mds = append(mds, md)
+	}
+	return mds, nil
+}
@@ -0,0 +1,98 @@
+package influx
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/grafana/metrictank/input/inputtest"
+	"github.com/raintank/schema"
+)
+
+func newTestInflux(maxTags int) (*Influx, *inputtest.RecordingHandler) {
+	handler := &inputtest.RecordingHandler{}
+	i := &Influx{
+		Handler:     handler,
+		maxTags:     maxTags,
+		getInterval: func(name string) int { return 10 },
+	}
+	return i, handler
+}
+
+func write(i *Influx, precision, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest("POST", "/api/v1/influx/write?precision="+precision, strings.NewReader(body))
+	w := httptest.NewRecorder()
+	i.handle(w, req)
+	return w
+}
+
+func TestHandleFixture(t *testing.T) {
+	body, err := ioutil.ReadFile("testdata/telegraf.txt")
+	if err != nil {
+		t.Fatalf("failed to read fixture: %s", err)
+	}
+	i, handler := newTestInflux(32)
+	w := write(i, "s", string(body))
+	if w.Code != http.StatusNoContent {
+		t.Fatalf("expected status %d, got %d: %s", http.StatusNoContent, w.Code, w.Body.String())
+	}
+	// every numeric and boolean field is a metric. the uptime_format string field is skipped.
+	mds := handler.MetricData()
+	if len(mds) != 29 {
+		t.Fatalf("expected 29 MetricData's, got %d", len(mds))
+	}
+	byName := make(map[string]*schema.MetricData)
+	for _, md := range mds {
+		if md.Id == "" || md.Interval != 10 || md.OrgId != 1 {
+			t.Fatalf("unexpected MetricData %+v", md)
+		}
+		byName[md.Name] = md
+	}
+	md := byName["mem.total"]
+	if md == nil || md.Value != 16717619200 || md.Time != 1540000000 || strings.Join(md.Tags, ",") != "host=web01" {
+		t.Fatalf("unexpected mem.total %+v", md)
+	}
+	md = byName["docker_container_status.oom_killed"]
+	if md == nil || md.Value != 0 || strings.Join(md.Tags, ",") != "container_name=db primary,engine_host=web01" {
+		t.Fatalf("unexpected docker_container_status.oom_killed %+v", md)
+	}
+	md = byName["weather.temperature"]
+	if md == nil || md.Value != 82 || md.Time != 1540000010 {
+		t.Fatalf("unexpected weather.temperature %+v", md)
+	}
+}
+
+func TestHandleRejectedLines(t *testing.T) {
+	i, handler := newTestInflux(2)
+	body := strings.Join([]string{
+		"cpu,host=web01 usage=1 1540000000",
+		"cpu,host=web01,dc=us-west,rack=r1 usage=2 1540000000",
+		"cpu,host=web01 usage= 1540000000",
+		"cpu,host=web02 usage=3 1540000000",
+	}, "\n")
+	w := write(i, "s", body)
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("expected status %d, got %d", http.StatusBadRequest, w.Code)
+	}
+	if !strings.Contains(w.Body.String(), "2 lines rejected") {
+		t.Fatalf("expected 2 lines to be rejected, got %q", w.Body.String())
+	}
+	mds := handler.MetricData()
+	if len(mds) != 2 || mds[0].Value != 1 || mds[1].Value != 3 {
+		t.Fatalf("expected the 2 valid lines to be written, got %v", mds)
+	}
+}
+
+func TestHandleInvalidPrecision(t *testing.T) {
+	i, handler := newTestInflux(32)
+	w := write(i, "h", "cpu usage=1 1540000000")
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("expected status %d, got %d", http.StatusBadRequest, w.Code)
+	}
+	mds := handler.MetricData()
+	if len(mds) != 0 {
+		t.Fatalf("expected no MetricData's, got %d", len(mds))
+	}
+}
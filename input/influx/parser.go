@@ -0,0 +1,185 @@
+package influx
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// point is a parsed line of InfluxDB line protocol:
+// measurement[,tag=value...] field=value[,field=value...] [timestamp]
+type point struct {
+	measurement string
+	tags        []string // as key=value
+	fields      []field
+	time        int64 // unix timestamp in seconds
+}
+
+type field struct {
+	key   string
+	value float64
+}
+
+var errNoFields = errors.New("no fields")
+var errNonFinite = errors.New("value is not a finite number")
+
+// parsePrecision returns the unit of timestamps for the given value of the precision
+// query parameter. an empty precision means nanoseconds, like in InfluxDB.
+func parsePrecision(precision string) (time.Duration, error) {
+	switch precision {
+	case "", "n", "ns":
+		return time.Nanosecond, nil
+	case "u", "us", "µ", "µs":
+		return time.Microsecond, nil
+	case "ms":
+		return time.Millisecond, nil
+	case "s":
+		return time.Second, nil
+	}
+	return 0, fmt.Errorf("invalid precision %q. must be one of ns, u, ms or s", precision)
+}
+
+// parseLine parses a line of line protocol. timestamps are interpreted in the given precision.
+// points without timestamp get the given time.
+// string fields are skipped, as they can't be stored as metrics. booleans are stored as 1 or 0.
+func parseLine(line string, precision time.Duration, now time.Time) (point, error) {
+	var p point
+
+	keyEnd := scan(line, 0, ' ', false)
+	if keyEnd == len(line) {
+		return p, errNoFields
+	}
+	key := line[:keyEnd]
+	fieldsStart := keyEnd + 1
+	fieldsEnd := scan(line, fieldsStart, ' ', true)
+	fields := line[fieldsStart:fieldsEnd]
+	ts := strings.TrimSpace(line[fieldsEnd:])
+
+	parts := split(key, ',', false)
+	p.measurement = unescape(parts[0])
+	if p.measurement == "" {
+		return p, errors.New("missing measurement")
+	}
+	for _, tag := range parts[1:] {
+		k, v, err := splitKV(tag)
+		if err != nil {
+			return p, fmt.Errorf("invalid tag %q: %s", tag, err)
+		}
+		p.tags = append(p.tags, unescape(k)+"="+unescape(v))
+	}
+
+	if fields == "" {
+		return p, errNoFields
+	}
+	for _, f := range split(fields, ',', true) {
+		k, v, err := splitKV(f)
+		if err != nil {
+			return p, fmt.Errorf("invalid field %q: %s", f, err)
+		}
+		if strings.HasPrefix(v, `"`) {
+			if len(v) < 2 || !strings.HasSuffix(v, `"`) {
+				return p, fmt.Errorf("invalid field %q: unterminated string", f)
+			}
+			continue
+		}
+		value, err := parseFieldValue(v)
+		if err != nil {
+			return p, fmt.Errorf("invalid field %q: %s", f, err)
+		}
+		p.fields = append(p.fields, field{key: unescape(k), value: value})
+	}
+
+	if ts == "" {
+		p.time = now.Unix()
+		return p, nil
+	}
+	t, err := strconv.ParseInt(ts, 10, 64)
+	if err != nil {
+		return p, fmt.Errorf("invalid timestamp %q", ts)
+	}
+	p.time = t / int64(time.Second/precision)
+	return p, nil
+}
+
+// parseFieldValue parses a float, integer (1i), unsigned integer (1u) or boolean field value.
+// NaN and infinite floats are rejected, like influxdb does.
+func parseFieldValue(v string) (float64, error) {
+	switch v {
+	case "t", "T", "true", "True", "TRUE":
+		return 1, nil
+	case "f", "F", "false", "False", "FALSE":
+		return 0, nil
+	}
+	if strings.HasSuffix(v, "i") {
+		i, err := strconv.ParseInt(v[:len(v)-1], 10, 64)
+		return float64(i), err
+	}
+	if strings.HasSuffix(v, "u") {
+		u, err := strconv.ParseUint(v[:len(v)-1], 10, 64)
+		return float64(u), err
+	}
+	f, err := strconv.ParseFloat(v, 64)
+	if err == nil && (math.IsNaN(f) || math.IsInf(f, 0)) {
+		return 0, errNonFinite
+	}
+	return f, err
+}
+
+// scan returns the position of the first occurrence of sep in s, from position i on,
+// that is not escaped with a backslash, nor - if quoted is set - within a double quoted string.
+// it returns len(s) if there is none.
+func scan(s string, i int, sep byte, quoted bool) int {
+	inQuotes := false
+	for ; i < len(s); i++ {
+		switch {
+		case s[i] == '\\':
+			i++
+		case quoted && s[i] == '"':
+			inQuotes = !inQuotes
+		case s[i] == sep && !inQuotes:
+			return i
+		}
+	}
+	return len(s)
+}
+
+// split splits s around the occurrences of sep that are not escaped or quoted. see scan
+func split(s string, sep byte, quoted bool) []string {
+	var parts []string
+	for {
+		i := scan(s, 0, sep, quoted)
+		if i == len(s) {
+			return append(parts, s)
+		}
+		parts = append(parts, s[:i])
+		s = s[i+1:]
+	}
+}
+
+// splitKV splits a key=value pair around the first unescaped '='
+func splitKV(kv string) (string, string, error) {
+	i := scan(kv, 0, '=', false)
+	if i == len(kv) {
+		return "", "", errors.New("missing '='")
+	}
+	if i == 0 {
+		return "", "", errors.New("empty key")
+	}
+	if i == len(kv)-1 {
+		return "", "", errors.New("empty value")
+	}
+	return kv[:i], kv[i+1:], nil
+}
+
+var unescaper = strings.NewReplacer(`\,`, ",", `\ `, " ", `\=`, "=")
+
+// unescape removes the backslashes that escape commas, spaces and equal signs
+func unescape(s string) string {
+	if strings.IndexByte(s, '\\') < 0 {
+		return s
+	}
+	return unescaper.Replace(s)
+}
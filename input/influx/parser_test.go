@@ -0,0 +1,104 @@
+package influx
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParseLine(t *testing.T) {
+	now := time.Unix(1540000100, 0)
+	cases := []struct {
+		line      string
+		precision time.Duration
+		exp       point
+	}{
+		{
+			"cpu,host=web01,region=us-west usage=0.64,count=3i 1540000000000000000",
+			time.Nanosecond,
+			point{"cpu", []string{"host=web01", "region=us-west"}, []field{{"usage", 0.64}, {"count", 3}}, 1540000000},
+		},
+		{
+			"cpu usage=1 1540000000000000",
+			time.Microsecond,
+			point{"cpu", nil, []field{{"usage", 1}}, 1540000000},
+		},
+		{
+			"cpu usage=1 1540000000123",
+			time.Millisecond,
+			point{"cpu", nil, []field{{"usage", 1}}, 1540000000},
+		},
+		{
+			"cpu usage=1 1540000000",
+			time.Second,
+			point{"cpu", nil, []field{{"usage", 1}}, 1540000000},
+		},
+		{
+			"cpu usage=1",
+			time.Nanosecond,
+			point{"cpu", nil, []field{{"usage", 1}}, 1540000100},
+		},
+		{
+			`disk\ io,path=C:\,\ drive,a\=b=c reads=7u,ok=t,failed=false 1540000000`,
+			time.Second,
+			point{"disk io", []string{"path=C:, drive", "a=b=c"}, []field{{"reads", 7}, {"ok", 1}, {"failed", 0}}, 1540000000},
+		},
+		{
+			`log,host=web01 msg="a, b=c \"quoted\"",level=3i 1540000000`,
+			time.Second,
+			point{"log", []string{"host=web01"}, []field{{"level", 3}}, 1540000000},
+		},
+	}
+	for _, c := range cases {
+		got, err := parseLine(c.line, c.precision, now)
+		if err != nil {
+			t.Fatalf("line %q: unexpected error %s", c.line, err)
+		}
+		if !reflect.DeepEqual(got, c.exp) {
+			t.Fatalf("line %q: expected %+v, got %+v", c.line, c.exp, got)
+		}
+	}
+}
+
+func TestParseLineErrors(t *testing.T) {
+	lines := []string{
+		"cpu",
+		"cpu ",
+		",host=web01 usage=1",
+		"cpu,host usage=1",
+		"cpu,host= usage=1",
+		"cpu usage",
+		"cpu usage=abc",
+		"cpu count=3.5i",
+		"cpu usage=NaN",
+		"cpu usage=Inf",
+		"cpu usage=-Infinity",
+		`cpu msg="unterminated`,
+		"cpu usage=1 yesterday",
+	}
+	for _, line := range lines {
+		_, err := parseLine(line, time.Nanosecond, time.Now())
+		if err == nil {
+			t.Fatalf("line %q: expected an error", line)
+		}
+	}
+}
+
+func TestParsePrecision(t *testing.T) {
+	cases := map[string]time.Duration{
+		"":   time.Nanosecond,
+		"ns": time.Nanosecond,
+		"u":  time.Microsecond,
+		"ms": time.Millisecond,
+		"s":  time.Second,
+	}
+	for precision, exp := range cases {
+		got, err := parsePrecision(precision)
+		if err != nil || got != exp {
+			t.Fatalf("precision %q: expected %s, got %s (err %v)", precision, exp, got, err)
+		}
+	}
+	if _, err := parsePrecision("h"); err == nil {
+		t.Fatalf("expected an error for precision h")
+	}
+}
@@ -0,0 +1,48 @@
+// package inputtest contains helpers for testing input plugins
+package inputtest
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/raintank/schema"
+	"github.com/raintank/schema/msg"
+)
+
+// RecordingHandler is an input.Handler that records the MetricData's it is given.
+// MetricPoint's are ignored. it is safe for concurrent use.
+type RecordingHandler struct {
+	sync.Mutex
+	mds []*schema.MetricData
+}
+
+func (h *RecordingHandler) ProcessMetricData(md *schema.MetricData, partition int32) {
+	h.Lock()
+	h.mds = append(h.mds, md)
+	h.Unlock()
+}
+
+func (h *RecordingHandler) ProcessMetricPoint(point schema.MetricPoint, format msg.Format, partition int32) {
+}
+
+// MetricData returns a copy of the MetricData's received so far
+func (h *RecordingHandler) MetricData() []*schema.MetricData {
+	h.Lock()
+	defer h.Unlock()
+	return append([]*schema.MetricData(nil), h.mds...)
+}
+
+// WaitFor waits until the handler has received n MetricData's, and returns them.
+// it fails the test if that takes more than 5 seconds.
+func (h *RecordingHandler) WaitFor(t testing.TB, n int) []*schema.MetricData {
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		if mds := h.MetricData(); len(mds) >= n {
+			return mds
+		}
+		time.Sleep(time.Millisecond)
+	}
+	t.Fatalf("expected %d MetricData's, got %d", n, len(h.MetricData()))
+	return nil
+}
@@ -0,0 +1,293 @@
+package otlp
+
+import (
+	"compress/gzip"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net"
+	"net/http"
+	"strconv"
+
+	"github.com/gogo/protobuf/proto"
+	"github.com/grafana/globalconf"
+	"github.com/grafana/metrictank/cluster"
+	"github.com/grafana/metrictank/input"
+	"github.com/grafana/metrictank/stats"
+	"github.com/raintank/schema"
+	log "github.com/sirupsen/logrus"
+)
+
+// metric input.otlp.decode_errors is a count of export requests that could not be decoded
+var decodeErrors = stats.NewCounterRate32("input.otlp.decode_errors")
+
+// metric input.otlp.unsupported_metrics is a count of metrics that were rejected because their type is not supported, e.g. histograms
+var unsupportedMetrics = stats.NewCounterRate32("input.otlp.unsupported_metrics")
+
+var (
+	Enabled     bool
+	addr        string
+	partitionID int
+)
+
+// maxRequestSize is the size of the largest (uncompressed) export request we accept
+const maxRequestSize = 64 * 1024 * 1024
+
+func ConfigSetup() {
+	inOTLP := flag.NewFlagSet("otlp-in", flag.ExitOnError)
+	inOTLP.BoolVar(&Enabled, "enabled", false, "")
+	inOTLP.StringVar(&addr, "addr", ":4318", "http listen address. OTLP/HTTP metrics are accepted on /v1/metrics")
+	inOTLP.IntVar(&partitionID, "partition", 0, "partition Id.")
+	globalconf.Register("otlp-in", inOTLP, flag.ExitOnError)
+}
+
+func ConfigProcess() {
+	if !Enabled {
+		return
+	}
+	cluster.Manager.SetPartitions([]int32{int32(partitionID)})
+}
+
+// OTLP is an input plugin that accepts OpenTelemetry metrics over OTLP/HTTP, in the protobuf encoding.
+// gauges and sums are supported.
+type OTLP struct {
+	input.Handler
+	addr        string
+	partition   int32
+	getInterval func(name string) int
+	server      *http.Server
+}
+
+func New() *OTLP {
+	return &OTLP{
+		addr:        addr,
+		partition:   int32(partitionID),
+		getInterval: input.SchemaInterval,
+	}
+}
+
+func (o *OTLP) Name() string {
+	return "otlp"
+}
+
+func (o *OTLP) Start(handler input.Handler, cancel context.CancelFunc) error {
+	o.Handler = handler
+	l, err := net.Listen("tcp", o.addr)
+	if err != nil {
+		log.Errorf("otlp-in: %s", err.Error())
+		return err
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v1/metrics", o.handle)
+	o.server = &http.Server{
+		Handler: mux,
+	}
+	log.Infof("otlp-in: listening on %v", l.Addr())
+	go o.server.Serve(l)
+	return nil
+}
+
+func (o *OTLP) MaintainPriority() {
+	cluster.Manager.SetPriority(0)
+}
+
+func (o *OTLP) ExplainPriority() interface{} {
+	return "otlp-in: priority=0 (always in sync)"
+}
+
+func (o *OTLP) Stop() {
+	log.Info("otlp-in: shutting down")
+	if o.server != nil {
+		o.server.Close()
+	}
+}
+
+// handle handles OTLP/HTTP export requests: protobuf encoded ExportMetricsServiceRequest's, optionally gzip compressed.
+// requests that contain metrics of an unsupported type are rejected as a whole.
+func (o *OTLP) handle(w http.ResponseWriter, req *http.Request) {
+	if req.Method != "POST" {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		w.Write([]byte("only POST is supported"))
+		return
+	}
+	ct := req.Header.Get("Content-Type")
+	if mediaType, _, _ := mime.ParseMediaType(ct); mediaType != "application/x-protobuf" {
+		w.WriteHeader(http.StatusUnsupportedMediaType)
+		w.Write([]byte(fmt.Sprintf("unsupported content type %q. only application/x-protobuf is supported", ct)))
+		return
+	}
+	defer req.Body.Close()
+	exportReq, err := decodeExportRequest(req.Body, req.Header.Get("Content-Encoding"))
+	if err != nil {
+		decodeErrors.Inc()
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		log.Errorf("otlp-in: %s", err)
+		return
+	}
+	mds, err := o.toMetricData(exportReq)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		log.Warnf("otlp-in: %s", err)
+		return
+	}
+	if input.RateLimited(w, 1, len(mds)) {
+		return
+	}
+	for _, md := range mds {
+		o.ProcessMetricData(md, o.partition)
+	}
+	// the response is an ExportMetricsServiceResponse. an empty one encodes to 0 bytes.
+	w.Header().Set("Content-Type", "application/x-protobuf")
+	w.WriteHeader(http.StatusOK)
+}
+
+// decodeExportRequest reads and decodes a protobuf encoded ExportMetricsServiceRequest
+func decodeExportRequest(body io.Reader, encoding string) (*ExportMetricsServiceRequest, error) {
+	switch encoding {
+	case "", "identity":
+	case "gzip":
+		gz, err := gzip.NewReader(body)
+		if err != nil {
+			return nil, fmt.Errorf("Decode Error, %v", err)
+		}
+		defer gz.Close()
+		body = gz
+	default:
+		return nil, fmt.Errorf("unsupported content encoding %q", encoding)
+	}
+	buf, err := ioutil.ReadAll(io.LimitReader(body, maxRequestSize+1))
+	if err != nil {
+		return nil, fmt.Errorf("Read Error, %v", err)
+	}
+	if len(buf) > maxRequestSize {
+		return nil, fmt.Errorf("request exceeds %d bytes", maxRequestSize)
+	}
+	var exportReq ExportMetricsServiceRequest
+	if err := proto.Unmarshal(buf, &exportReq); err != nil {
+		return nil, fmt.Errorf("Unmarshal Error, %v", err)
+	}
+	return &exportReq, nil
+}
+
+// toMetricData converts the data points of all gauges and sums of the request to MetricData's.
+// the attributes of the resource and of the data point become the tags.
+// delta sums become count metrics, cumulative monotonic sums counter metrics and all others gauges.
+func (o *OTLP) toMetricData(req *ExportMetricsServiceRequest) ([]*schema.MetricData, error) {
+	var mds []*schema.MetricData
+	for _, rm := range req.ResourceMetrics {
+		var resourceTags map[string]string
+		if rm.Resource != nil {
+			resourceTags = attributes(rm.Resource.Attributes, nil)
+		}
+		for _, sm := range rm.ScopeMetrics {
+			for _, m := range sm.Metrics {
+				if m.Name == "" {
+					return nil, errors.New("invalid metric received: name can not be empty")
+				}
+				var points []*NumberDataPoint
+				mtype := "gauge"
+				switch {
+				case m.Gauge != nil:
+					points = m.Gauge.DataPoints
+				case m.Sum != nil:
+					points = m.Sum.DataPoints
+					switch m.Sum.AggregationTemporality {
+					case AggregationTemporalityDelta:
+						mtype = "count"
+					case AggregationTemporalityCumulative:
+						if m.Sum.IsMonotonic {
+							mtype = "counter"
+						}
+					default:
+						return nil, fmt.Errorf("metric %q: sum has unspecified aggregation temporality", m.Name)
+					}
+				case m.Histogram != nil, m.ExponentialHistogram != nil, m.Summary != nil:
+					unsupportedMetrics.Inc()
+					return nil, fmt.Errorf("metric %q: histograms and summaries are not supported", m.Name)
+				default:
+					return nil, fmt.Errorf("metric %q: no data", m.Name)
+				}
+				unit := m.Unit
+				if unit == "" {
+					unit = "unknown"
+				}
+				interval := o.getInterval(m.Name)
+				for _, p := range points {
+					if p.Flags&DataPointFlagNoRecordedValue != 0 {
+						continue
+					}
+					var value float64
+					switch {
+					case p.AsDouble != nil:
+						value = *p.AsDouble
+					case p.AsInt != nil:
+						value = float64(*p.AsInt)
+					default:
+						return nil, fmt.Errorf("metric %q: data point without value", m.Name)
+					}
+					md := &schema.MetricData{
+						Name:     m.Name,
+						Interval: interval,
+						Value:    value,
+						Unit:     unit,
+						Time:     int64(p.TimeUnixNano / 1e9),
+						Mtype:    mtype,
+						Tags:     tags(attributes(p.Attributes, resourceTags)),
+						OrgId:    1,
+					}
+					md.SetId()
+					mds = append(mds, md)
+				}
+			}
+		}
+	}
+	return mds, nil
+}
+
+// attributes returns the attributes as a map of key to value, on top of a copy of base.
+// attributes with an empty key or value, or of which the value is not a scalar, are left out.
+func attributes(kvs []*KeyValue, base map[string]string) map[string]string {
+	attrs := make(map[string]string, len(base)+len(kvs))
+	for k, v := range base {
+		attrs[k] = v
+	}
+	for _, kv := range kvs {
+		if kv.Key == "" || kv.Value == nil {
+			continue
+		}
+		var value string
+		switch {
+		case kv.Value.StringValue != nil:
+			value = *kv.Value.StringValue
+		case kv.Value.BoolValue != nil:
+			value = strconv.FormatBool(*kv.Value.BoolValue)
+		case kv.Value.IntValue != nil:
+			value = strconv.FormatInt(*kv.Value.IntValue, 10)
+		case kv.Value.DoubleValue != nil:
+			value = strconv.FormatFloat(*kv.Value.DoubleValue, 'f', -1, 64)
+		}
+		if value == "" {
+			continue
+		}
+		attrs[kv.Key] = value
+	}
+	return attrs
+}
+
+// tags returns the attributes as key=value tags
+func tags(attrs map[string]string) []string {
+	if len(attrs) == 0 {
+		return nil
+	}
+	tags := make([]string, 0, len(attrs))
+	for k, v := range attrs {
+		tags = append(tags, k+"="+v)
+	}
+	return tags
+}
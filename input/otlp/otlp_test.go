@@ -0,0 +1,188 @@
+package otlp
+
+import (
+	"bytes"
+	"compress/gzip"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gogo/protobuf/proto"
+	"github.com/grafana/metrictank/input/inputtest"
+)
+
+func newTestOTLP() (*OTLP, *inputtest.RecordingHandler) {
+	handler := &inputtest.RecordingHandler{}
+	o := &OTLP{
+		Handler:     handler,
+		getInterval: func(name string) int { return 10 },
+	}
+	return o, handler
+}
+
+func stringValue(s string) *AnyValue {
+	return &AnyValue{StringValue: &s}
+}
+
+func doubleValue(f float64) *float64 {
+	return &f
+}
+
+func intValue(i int64) *int64 {
+	return &i
+}
+
+func newExportRequest(metrics ...*Metric) *ExportMetricsServiceRequest {
+	return &ExportMetricsServiceRequest{
+		ResourceMetrics: []*ResourceMetrics{
+			{
+				Resource: &Resource{
+					Attributes: []*KeyValue{
+						{Key: "service.name", Value: stringValue("checkout")},
+						{Key: "host.name", Value: stringValue("web01")},
+					},
+				},
+				ScopeMetrics: []*ScopeMetrics{
+					{Metrics: metrics},
+				},
+			},
+		},
+	}
+}
+
+func post(t *testing.T, o *OTLP, req *ExportMetricsServiceRequest, compress bool) *httptest.ResponseRecorder {
+	body, err := proto.Marshal(req)
+	if err != nil {
+		t.Fatalf("failed to marshal request: %s", err)
+	}
+	if compress {
+		var buf bytes.Buffer
+		gz := gzip.NewWriter(&buf)
+		gz.Write(body)
+		gz.Close()
+		body = buf.Bytes()
+	}
+	httpReq := httptest.NewRequest("POST", "/v1/metrics", bytes.NewReader(body))
+	httpReq.Header.Set("Content-Type", "application/x-protobuf")
+	if compress {
+		httpReq.Header.Set("Content-Encoding", "gzip")
+	}
+	w := httptest.NewRecorder()
+	o.handle(w, httpReq)
+	return w
+}
+
+func TestHandle(t *testing.T) {
+	req := newExportRequest(
+		&Metric{
+			Name: "system.memory.usage",
+			Unit: "By",
+			Gauge: &Gauge{
+				DataPoints: []*NumberDataPoint{
+					{
+						// the data point attribute overrides the resource attribute
+						Attributes:   []*KeyValue{{Key: "host.name", Value: stringValue("web02")}, {Key: "state", Value: stringValue("used")}},
+						TimeUnixNano: 1540000000123456789,
+						AsInt:        intValue(1024),
+					},
+					{
+						TimeUnixNano: 1540000010000000000,
+						Flags:        DataPointFlagNoRecordedValue,
+					},
+				},
+			},
+		},
+		&Metric{
+			Name: "http.server.requests",
+			Sum: &Sum{
+				AggregationTemporality: AggregationTemporalityDelta,
+				IsMonotonic:            true,
+				DataPoints:             []*NumberDataPoint{{TimeUnixNano: 1540000000000000000, AsDouble: doubleValue(5)}},
+			},
+		},
+		&Metric{
+			Name: "http.server.requests.total",
+			Sum: &Sum{
+				AggregationTemporality: AggregationTemporalityCumulative,
+				IsMonotonic:            true,
+				DataPoints:             []*NumberDataPoint{{TimeUnixNano: 1540000000000000000, AsDouble: doubleValue(500)}},
+			},
+		},
+		&Metric{
+			Name: "http.server.active_requests",
+			Sum: &Sum{
+				AggregationTemporality: AggregationTemporalityCumulative,
+				DataPoints:             []*NumberDataPoint{{TimeUnixNano: 1540000000000000000, AsInt: intValue(-2)}},
+			},
+		},
+	)
+	for _, compress := range []bool{false, true} {
+		o, handler := newTestOTLP()
+		w := post(t, o, req, compress)
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected status %d, got %d: %s", http.StatusOK, w.Code, w.Body.String())
+		}
+		mds := handler.MetricData()
+		if len(mds) != 4 {
+			t.Fatalf("expected 4 MetricData's, got %d", len(mds))
+		}
+		exp := []struct {
+			name  string
+			value float64
+			unit  string
+			mtype string
+			tags  string
+		}{
+			{"system.memory.usage", 1024, "By", "gauge", "host.name=web02,service.name=checkout,state=used"},
+			{"http.server.requests", 5, "unknown", "count", "host.name=web01,service.name=checkout"},
+			{"http.server.requests.total", 500, "unknown", "counter", "host.name=web01,service.name=checkout"},
+			{"http.server.active_requests", -2, "unknown", "gauge", "host.name=web01,service.name=checkout"},
+		}
+		for i, e := range exp {
+			md := mds[i]
+			if md.Name != e.name || md.Value != e.value || md.Unit != e.unit || md.Mtype != e.mtype || strings.Join(md.Tags, ",") != e.tags {
+				t.Fatalf("MetricData %d: expected %+v, got %+v", i, e, md)
+			}
+			if md.Time != 1540000000 || md.Interval != 10 || md.OrgId != 1 || md.Id == "" {
+				t.Fatalf("MetricData %d: unexpected %+v", i, md)
+			}
+		}
+	}
+}
+
+func TestHandleRejectsHistograms(t *testing.T) {
+	cases := []*Metric{
+		{Name: "http.server.duration", Histogram: &Histogram{}},
+		{Name: "http.server.duration", ExponentialHistogram: &ExponentialHistogram{}},
+	}
+	for _, m := range cases {
+		req := newExportRequest(
+			&Metric{
+				Name:  "system.memory.usage",
+				Gauge: &Gauge{DataPoints: []*NumberDataPoint{{TimeUnixNano: 1540000000000000000, AsInt: intValue(1024)}}},
+			},
+			m,
+		)
+		o, handler := newTestOTLP()
+		w := post(t, o, req, false)
+		if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), "not supported") {
+			t.Fatalf("expected status %d, got %d: %s", http.StatusBadRequest, w.Code, w.Body.String())
+		}
+		mds := handler.MetricData()
+		if len(mds) != 0 {
+			t.Fatalf("expected no MetricData's, got %d", len(mds))
+		}
+	}
+}
+
+func TestHandleUnsupportedContentType(t *testing.T) {
+	o, _ := newTestOTLP()
+	req := httptest.NewRequest("POST", "/v1/metrics", strings.NewReader("{}"))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	o.handle(w, req)
+	if w.Code != http.StatusUnsupportedMediaType {
+		t.Fatalf("expected status %d, got %d", http.StatusUnsupportedMediaType, w.Code)
+	}
+}
@@ -0,0 +1,158 @@
+package otlp
+
+import (
+	"github.com/gogo/protobuf/proto"
+)
+
+// the types below are the subset of the OTLP metrics protocol
+// (https://github.com/open-telemetry/opentelemetry-proto, as generated in go.opentelemetry.io/proto/otlp)
+// that we need to decode an ExportMetricsServiceRequest.
+// the generated package requires a newer protobuf runtime than the one we vendor, so we declare
+// them ourselves, with the field numbers and wire types of the original definitions.
+// fields we don't use are left out: the decoder skips them.
+// members of a oneof are declared as optional fields, which is wire compatible.
+
+// ExportMetricsServiceRequest is the body of an OTLP/HTTP metrics export request
+type ExportMetricsServiceRequest struct {
+	ResourceMetrics []*ResourceMetrics `protobuf:"bytes,1,rep,name=resource_metrics"`
+}
+
+func (m *ExportMetricsServiceRequest) Reset()         { *m = ExportMetricsServiceRequest{} }
+func (m *ExportMetricsServiceRequest) String() string { return proto.CompactTextString(m) }
+func (*ExportMetricsServiceRequest) ProtoMessage()    {}
+
+// ResourceMetrics is a collection of metrics from a Resource
+type ResourceMetrics struct {
+	Resource     *Resource       `protobuf:"bytes,1,opt,name=resource"`
+	ScopeMetrics []*ScopeMetrics `protobuf:"bytes,2,rep,name=scope_metrics"`
+}
+
+func (m *ResourceMetrics) Reset()         { *m = ResourceMetrics{} }
+func (m *ResourceMetrics) String() string { return proto.CompactTextString(m) }
+func (*ResourceMetrics) ProtoMessage()    {}
+
+// Resource is the entity producing the metrics, e.g. a service or a host
+type Resource struct {
+	Attributes []*KeyValue `protobuf:"bytes,1,rep,name=attributes"`
+}
+
+func (m *Resource) Reset()         { *m = Resource{} }
+func (m *Resource) String() string { return proto.CompactTextString(m) }
+func (*Resource) ProtoMessage()    {}
+
+// ScopeMetrics is a collection of metrics produced by an instrumentation scope
+type ScopeMetrics struct {
+	Metrics []*Metric `protobuf:"bytes,2,rep,name=metrics"`
+}
+
+func (m *ScopeMetrics) Reset()         { *m = ScopeMetrics{} }
+func (m *ScopeMetrics) String() string { return proto.CompactTextString(m) }
+func (*ScopeMetrics) ProtoMessage()    {}
+
+// Metric is a metric with its data points. exactly one of the data fields is set.
+type Metric struct {
+	Name                 string                `protobuf:"bytes,1,opt,name=name,proto3"`
+	Description          string                `protobuf:"bytes,2,opt,name=description,proto3"`
+	Unit                 string                `protobuf:"bytes,3,opt,name=unit,proto3"`
+	Gauge                *Gauge                `protobuf:"bytes,5,opt,name=gauge"`
+	Sum                  *Sum                  `protobuf:"bytes,7,opt,name=sum"`
+	Histogram            *Histogram            `protobuf:"bytes,9,opt,name=histogram"`
+	ExponentialHistogram *ExponentialHistogram `protobuf:"bytes,10,opt,name=exponential_histogram"`
+	Summary              *Summary              `protobuf:"bytes,11,opt,name=summary"`
+}
+
+func (m *Metric) Reset()         { *m = Metric{} }
+func (m *Metric) String() string { return proto.CompactTextString(m) }
+func (*Metric) ProtoMessage()    {}
+
+// Gauge is a metric of which every data point is a sampled value
+type Gauge struct {
+	DataPoints []*NumberDataPoint `protobuf:"bytes,1,rep,name=data_points"`
+}
+
+func (m *Gauge) Reset()         { *m = Gauge{} }
+func (m *Gauge) String() string { return proto.CompactTextString(m) }
+func (*Gauge) ProtoMessage()    {}
+
+// AggregationTemporality defines how the values of a Sum relate to each other
+type AggregationTemporality int32
+
+const (
+	AggregationTemporalityUnspecified AggregationTemporality = 0
+	// every data point is the change since the previous one
+	AggregationTemporalityDelta AggregationTemporality = 1
+	// every data point is the total since the start time
+	AggregationTemporalityCumulative AggregationTemporality = 2
+)
+
+// Sum is a metric of which the data points are the sum of measurements
+type Sum struct {
+	DataPoints             []*NumberDataPoint     `protobuf:"bytes,1,rep,name=data_points"`
+	AggregationTemporality AggregationTemporality `protobuf:"varint,2,opt,name=aggregation_temporality,proto3"`
+	IsMonotonic            bool                   `protobuf:"varint,3,opt,name=is_monotonic,proto3"`
+}
+
+func (m *Sum) Reset()         { *m = Sum{} }
+func (m *Sum) String() string { return proto.CompactTextString(m) }
+func (*Sum) ProtoMessage()    {}
+
+// Histogram is not supported. we only need to know whether a metric is one
+type Histogram struct{}
+
+func (m *Histogram) Reset()         { *m = Histogram{} }
+func (m *Histogram) String() string { return proto.CompactTextString(m) }
+func (*Histogram) ProtoMessage()    {}
+
+// ExponentialHistogram is not supported. we only need to know whether a metric is one
+type ExponentialHistogram struct{}
+
+func (m *ExponentialHistogram) Reset()         { *m = ExponentialHistogram{} }
+func (m *ExponentialHistogram) String() string { return proto.CompactTextString(m) }
+func (*ExponentialHistogram) ProtoMessage()    {}
+
+// Summary is not supported. we only need to know whether a metric is one
+type Summary struct{}
+
+func (m *Summary) Reset()         { *m = Summary{} }
+func (m *Summary) String() string { return proto.CompactTextString(m) }
+func (*Summary) ProtoMessage()    {}
+
+// DataPointFlagNoRecordedValue marks a data point that is only a placeholder for a missing value
+const DataPointFlagNoRecordedValue = 1
+
+// NumberDataPoint is a value at a point in time. one of AsDouble and AsInt is set.
+type NumberDataPoint struct {
+	Attributes        []*KeyValue `protobuf:"bytes,7,rep,name=attributes"`
+	StartTimeUnixNano uint64      `protobuf:"fixed64,2,opt,name=start_time_unix_nano,proto3"`
+	TimeUnixNano      uint64      `protobuf:"fixed64,3,opt,name=time_unix_nano,proto3"`
+	AsDouble          *float64    `protobuf:"fixed64,4,opt,name=as_double"`
+	AsInt             *int64      `protobuf:"fixed64,6,opt,name=as_int"`
+	Flags             uint32      `protobuf:"varint,8,opt,name=flags,proto3"`
+}
+
+func (m *NumberDataPoint) Reset()         { *m = NumberDataPoint{} }
+func (m *NumberDataPoint) String() string { return proto.CompactTextString(m) }
+func (*NumberDataPoint) ProtoMessage()    {}
+
+// KeyValue is an attribute of a resource or data point
+type KeyValue struct {
+	Key   string    `protobuf:"bytes,1,opt,name=key,proto3"`
+	Value *AnyValue `protobuf:"bytes,2,opt,name=value"`
+}
+
+func (m *KeyValue) Reset()         { *m = KeyValue{} }
+func (m *KeyValue) String() string { return proto.CompactTextString(m) }
+func (*KeyValue) ProtoMessage()    {}
+
+// AnyValue is the value of an attribute. at most one of the fields is set.
+// array, kvlist and bytes values are left out.
+type AnyValue struct {
+	StringValue *string  `protobuf:"bytes,1,opt,name=string_value"`
+	BoolValue   *bool    `protobuf:"varint,2,opt,name=bool_value"`
+	IntValue    *int64   `protobuf:"varint,3,opt,name=int_value"`
+	DoubleValue *float64 `protobuf:"fixed64,4,opt,name=double_value"`
+}
+
+func (m *AnyValue) Reset()         { *m = AnyValue{} }
+func (m *AnyValue) String() string { return proto.CompactTextString(m) }
+func (*AnyValue) ProtoMessage()    {}
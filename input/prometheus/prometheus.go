@@ -2,8 +2,10 @@ package prometheus
 
 import (
 	"context"
+	"errors"
 	"flag"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"net/http"
 
@@ -42,6 +44,7 @@ func (p *prometheusWriteHandler) Start(handler input.Handler, cancel context.Can
 
 	mux := http.NewServeMux()
 	mux.HandleFunc("/write", p.handle)
+	mux.HandleFunc("/api/v1/push", p.handle)
 	server := http.Server{
 		Addr:    addr,
 		Handler: mux,
@@ -62,76 +65,91 @@ func (p *prometheusWriteHandler) Stop() {
 	log.Info("prometheus-in: shutting down")
 }
 
+// handle handles prometheus remote write requests: snappy compressed, protobuf encoded WriteRequest's
 func (p *prometheusWriteHandler) handle(w http.ResponseWriter, req *http.Request) {
-	if req.Body != nil {
-		defer req.Body.Close()
-		compressed, err := ioutil.ReadAll(req.Body)
+	if req.Body == nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("no data"))
+		return
+	}
+	defer req.Body.Close()
+	writeReq, err := decodeWriteRequest(req.Body)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		log.Errorf("prometheus-in: %s", err)
+		return
+	}
 
+	var mds []*schema.MetricData
+	for _, ts := range writeReq.Timeseries {
+		tsMds, err := toMetricData(ts)
 		if err != nil {
-			w.WriteHeader(400)
-			w.Write([]byte(fmt.Sprintf("Read Error, %v", err)))
-			log.Errorf("Read Error, %v", err)
-			return
-		}
-		reqBuf, err := snappy.Decode(nil, compressed)
-		if err != nil {
-			w.WriteHeader(400)
-			w.Write([]byte(fmt.Sprintf("Decode Error, %v", err)))
-			log.Errorf("Decode Error, %v", err)
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(err.Error()))
+			log.Warnf("prometheus-in: %s: %v", err, ts.String())
 			return
 		}
+		mds = append(mds, tsMds...)
+	}
+	if input.RateLimited(w, 1, len(mds)) {
+		return
+	}
+	for _, md := range mds {
+		p.ProcessMetricData(md, int32(partitionID))
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
 
-		var req prompb.WriteRequest
-		if err := proto.Unmarshal(reqBuf, &req); err != nil {
-			w.WriteHeader(400)
-			w.Write([]byte(fmt.Sprintf("Unmarshal Error, %v", err)))
-			log.Errorf("Unmarshal Error, %v", err)
-			return
-		}
+// decodeWriteRequest reads and decodes a snappy compressed, protobuf encoded WriteRequest
+func decodeWriteRequest(body io.Reader) (*prompb.WriteRequest, error) {
+	compressed, err := ioutil.ReadAll(body)
+	if err != nil {
+		return nil, fmt.Errorf("Read Error, %v", err)
+	}
+	reqBuf, err := snappy.Decode(nil, compressed)
+	if err != nil {
+		return nil, fmt.Errorf("Decode Error, %v", err)
+	}
+	var writeReq prompb.WriteRequest
+	if err := proto.Unmarshal(reqBuf, &writeReq); err != nil {
+		return nil, fmt.Errorf("Unmarshal Error, %v", err)
+	}
+	return &writeReq, nil
+}
 
-		for _, ts := range req.Timeseries {
-			var name string
-			var tagSet []string
-
-			for _, l := range ts.Labels {
-				if l.Name == model.MetricNameLabel {
-					name = l.Value
-				} else {
-					tagSet = append(tagSet, l.Name+"="+l.Value)
-				}
-			}
-			if name != "" {
-				for _, sample := range ts.Samples {
-					md := &schema.MetricData{
-						Name:     name,
-						Interval: 15,
-						Value:    sample.Value,
-						Unit:     "unknown",
-						Time:     (sample.Timestamp / 1000),
-						Mtype:    "gauge",
-						Tags:     tagSet,
-						OrgId:    1,
-					}
-					md.SetId()
-					p.ProcessMetricData(md, int32(partitionID))
-				}
-			} else {
-				w.WriteHeader(400)
-				w.Write([]byte("invalid metric received: __name__ label can not equal \"\""))
-				log.Warnf("prometheus metric received with empty name: %v", ts.String())
-				return
-			}
+// toMetricData converts the samples of the timeseries to MetricData's,
+// with the __name__ label as name and all other labels as tags
+func toMetricData(ts *prompb.TimeSeries) ([]*schema.MetricData, error) {
+	var name string
+	var tagSet []string
+
+	for _, l := range ts.Labels {
+		if l.Name == model.MetricNameLabel {
+			name = l.Value
+		} else {
+			tagSet = append(tagSet, l.Name+"="+l.Value)
 		}
-		if err != nil {
-			w.WriteHeader(http.StatusInternalServerError)
-			w.Write([]byte(err.Error()))
-			return
+	}
+	if name == "" {
+		return nil, errors.New("invalid metric received: __name__ label can not equal \"\"")
+	}
+	mds := make([]*schema.MetricData, 0, len(ts.Samples))
+	for _, sample := range ts.Samples {
+		md := &schema.MetricData{
+			Name:     name,
+			Interval: 15,
+			Value:    sample.Value,
+			Unit:     "unknown",
+			Time:     (sample.Timestamp / 1000),
+			Mtype:    "gauge",
+			Tags:     tagSet,
+			OrgId:    1,
 		}
-		w.Write([]byte("ok"))
-		return
+		md.SetId()
+		mds = append(mds, md)
 	}
-	w.WriteHeader(400)
-	w.Write([]byte("no data"))
+	return mds, nil
 }
 
 func ConfigSetup() {
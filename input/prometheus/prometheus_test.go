@@ -0,0 +1,102 @@
+package prometheus
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gogo/protobuf/proto"
+	"github.com/golang/snappy"
+	"github.com/grafana/metrictank/input/inputtest"
+	"github.com/prometheus/prometheus/prompb"
+)
+
+func encodeWriteRequest(t *testing.T, writeReq *prompb.WriteRequest) []byte {
+	data, err := proto.Marshal(writeReq)
+	if err != nil {
+		t.Fatalf("failed to marshal WriteRequest: %s", err)
+	}
+	return snappy.Encode(nil, data)
+}
+
+func push(p *prometheusWriteHandler, body []byte) *httptest.ResponseRecorder {
+	req := httptest.NewRequest("POST", "/api/v1/push", bytes.NewReader(body))
+	w := httptest.NewRecorder()
+	p.handle(w, req)
+	return w
+}
+
+func TestHandleWriteRequest(t *testing.T) {
+	handler := &inputtest.RecordingHandler{}
+	p := &prometheusWriteHandler{Handler: handler}
+
+	body := encodeWriteRequest(t, &prompb.WriteRequest{
+		Timeseries: []*prompb.TimeSeries{
+			{
+				Labels: []*prompb.Label{
+					{Name: "__name__", Value: "http_requests_total"},
+					{Name: "job", Value: "api"},
+					{Name: "instance", Value: "host1:9090"},
+				},
+				Samples: []*prompb.Sample{
+					{Value: 10, Timestamp: 1540000000000},
+					{Value: 12, Timestamp: 1540000015000},
+				},
+			},
+		},
+	})
+	w := push(p, body)
+	if w.Code != http.StatusNoContent {
+		t.Fatalf("expected status %d, got %d: %s", http.StatusNoContent, w.Code, w.Body.String())
+	}
+	mds := handler.MetricData()
+	if len(mds) != 2 {
+		t.Fatalf("expected 2 MetricData's, got %d", len(mds))
+	}
+	for i, exp := range []struct {
+		value float64
+		time  int64
+	}{{10, 1540000000}, {12, 1540000015}} {
+		md := mds[i]
+		if md.Name != "http_requests_total" || md.Value != exp.value || md.Time != exp.time || md.OrgId != 1 {
+			t.Fatalf("MetricData %d: unexpected %+v", i, md)
+		}
+		if strings.Join(md.Tags, ",") != "instance=host1:9090,job=api" {
+			t.Fatalf("MetricData %d: unexpected tags %v", i, md.Tags)
+		}
+		if md.Id == "" {
+			t.Fatalf("MetricData %d: id not set", i)
+		}
+	}
+}
+
+func TestHandleInvalidWriteRequest(t *testing.T) {
+	cases := []struct {
+		name string
+		body []byte
+	}{
+		{"not snappy compressed", []byte("http_requests_total 10")},
+		{"not a protobuf", snappy.Encode(nil, []byte("http_requests_total 10"))},
+		{"no name", encodeWriteRequest(t, &prompb.WriteRequest{
+			Timeseries: []*prompb.TimeSeries{
+				{
+					Labels:  []*prompb.Label{{Name: "job", Value: "api"}},
+					Samples: []*prompb.Sample{{Value: 10, Timestamp: 1540000000000}},
+				},
+			},
+		})},
+	}
+	for _, c := range cases {
+		handler := &inputtest.RecordingHandler{}
+		w := push(&prometheusWriteHandler{Handler: handler}, c.body)
+		if w.Code != http.StatusBadRequest {
+			t.Fatalf("case %q: expected status %d, got %d", c.name, http.StatusBadRequest, w.Code)
+		}
+		mds := handler.MetricData()
+		if len(mds) != 0 {
+			t.Fatalf("case %q: expected no MetricData's, got %d", c.name, len(mds))
+		}
+	}
+}
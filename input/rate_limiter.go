@@ -0,0 +1,214 @@
+package input
+
+import (
+	"flag"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/grafana/globalconf"
+	"github.com/grafana/metrictank/stats"
+	"github.com/pelletier/go-toml"
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/time/rate"
+)
+
+// metric input.rate_limit.rejected_points is a count of points that were rejected because their org exceeded its ingest rate limit
+var rateLimitRejectedPoints = stats.NewCounterRate32("input.rate_limit.rejected_points")
+
+var (
+	rateLimitEnabled     bool
+	rateLimitRate        float64
+	rateLimitBurst       int
+	rateLimitIdleTimeout time.Duration
+	rateLimitOverrides   string
+
+	// IngestRateLimiter limits the per-org ingest rate of the http based inputs. nil if rate limiting is disabled
+	IngestRateLimiter *RateLimiter
+)
+
+func ConfigSetup() {
+	rateLimit := flag.NewFlagSet("ingest-rate-limit", flag.ExitOnError)
+	rateLimit.BoolVar(&rateLimitEnabled, "enabled", false, "limit the rate at which each org can ingest points through the prometheus, influx and otlp inputs")
+	rateLimit.Float64Var(&rateLimitRate, "rate", 100000, "sustained number of points per second an org can ingest")
+	rateLimit.IntVar(&rateLimitBurst, "burst", 200000, "number of points an org can ingest at once. requests with more points are rejected")
+	rateLimit.DurationVar(&rateLimitIdleTimeout, "idle-timeout", 10*time.Minute, "how long to keep tracking the rate of an org after it stopped ingesting")
+	rateLimit.StringVar(&rateLimitOverrides, "overrides-file", "", "path to a toml file with the limits of orgs that don't get the defaults. empty to disable")
+	globalconf.Register("ingest-rate-limit", rateLimit, flag.ExitOnError)
+}
+
+func ConfigProcess() {
+	if !rateLimitEnabled {
+		return
+	}
+	if rateLimitRate <= 0 || rateLimitBurst <= 0 {
+		log.Fatal("ingest-rate-limit: rate and burst must be > 0")
+	}
+	if rateLimitIdleTimeout <= 0 {
+		log.Fatal("ingest-rate-limit: idle-timeout must be > 0")
+	}
+	var overrides map[uint32]Limit
+	if rateLimitOverrides != "" {
+		var err error
+		overrides, err = ReadLimitOverrides(rateLimitOverrides)
+		if err != nil {
+			log.Fatalf("ingest-rate-limit: %s", err)
+		}
+	}
+	IngestRateLimiter = NewRateLimiter(Limit{Rate: rateLimitRate, Burst: rateLimitBurst}, overrides, rateLimitIdleTimeout)
+}
+
+// Limit is a sustained rate, in points per second, and a burst, in points
+type Limit struct {
+	Rate  float64
+	Burst int
+}
+
+// ReadLimitOverrides reads the per-org limits from a toml file of the form:
+//
+//	[[org]]
+//	id = 2
+//	rate = 500000.0
+//	burst = 1000000
+func ReadLimitOverrides(path string) (map[uint32]Limit, error) {
+	tree, err := toml.LoadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var file struct {
+		Org []struct {
+			Id    int64   `toml:"id"`
+			Rate  float64 `toml:"rate"`
+			Burst int64   `toml:"burst"`
+		} `toml:"org"`
+	}
+	if err := tree.Unmarshal(&file); err != nil {
+		return nil, fmt.Errorf("%s: %s", path, err)
+	}
+	overrides := make(map[uint32]Limit, len(file.Org))
+	for _, o := range file.Org {
+		if o.Id <= 0 || o.Id > math.MaxUint32 {
+			return nil, fmt.Errorf("%s: invalid org id %d", path, o.Id)
+		}
+		if o.Rate <= 0 || o.Burst <= 0 || o.Burst > math.MaxInt32 {
+			return nil, fmt.Errorf("%s: org %d: rate and burst must be > 0", path, o.Id)
+		}
+		if _, ok := overrides[uint32(o.Id)]; ok {
+			return nil, fmt.Errorf("%s: org %d is listed more than once", path, o.Id)
+		}
+		overrides[uint32(o.Id)] = Limit{Rate: o.Rate, Burst: int(o.Burst)}
+	}
+	return overrides, nil
+}
+
+// RateLimiter limits the rate at which each org can ingest points, using a token bucket per org.
+// orgs get the default limit, unless they have an override.
+// the buckets of orgs that haven't ingested anything for the idle timeout are evicted.
+type RateLimiter struct {
+	defaultLimit Limit
+	overrides    map[uint32]Limit
+	idleTimeout  time.Duration
+	limiters     sync.Map // orgId -> *orgLimiter
+	quit         chan struct{}
+}
+
+type orgLimiter struct {
+	*rate.Limiter
+	lastUsed int64 // unix timestamp in nanoseconds. accessed atomically
+}
+
+func NewRateLimiter(defaultLimit Limit, overrides map[uint32]Limit, idleTimeout time.Duration) *RateLimiter {
+	r := &RateLimiter{
+		defaultLimit: defaultLimit,
+		overrides:    overrides,
+		idleTimeout:  idleTimeout,
+		quit:         make(chan struct{}),
+	}
+	go r.evictLoop()
+	return r
+}
+
+func (r *RateLimiter) limiter(orgId uint32) *orgLimiter {
+	if l, ok := r.limiters.Load(orgId); ok {
+		return l.(*orgLimiter)
+	}
+	limit, ok := r.overrides[orgId]
+	if !ok {
+		limit = r.defaultLimit
+	}
+	l, _ := r.limiters.LoadOrStore(orgId, &orgLimiter{Limiter: rate.NewLimiter(rate.Limit(limit.Rate), limit.Burst)})
+	return l.(*orgLimiter)
+}
+
+// Allow reports whether the org can ingest n points at time now.
+// if not, it returns how long to wait before the points can be ingested.
+// a wait of 0 means that n exceeds the burst of the org, so the points can never be ingested at once.
+func (r *RateLimiter) Allow(orgId uint32, n int, now time.Time) (bool, time.Duration) {
+	l := r.limiter(orgId)
+	atomic.StoreInt64(&l.lastUsed, now.UnixNano())
+	res := l.ReserveN(now, n)
+	if !res.OK() {
+		return false, 0
+	}
+	if delay := res.DelayFrom(now); delay > 0 {
+		res.CancelAt(now)
+		return false, delay
+	}
+	return true, 0
+}
+
+// Stop stops evicting idle orgs
+func (r *RateLimiter) Stop() {
+	close(r.quit)
+}
+
+func (r *RateLimiter) evictLoop() {
+	ticker := time.NewTicker(r.idleTimeout / 2)
+	defer ticker.Stop()
+	for {
+		select {
+		case now := <-ticker.C:
+			r.evict(now)
+		case <-r.quit:
+			return
+		}
+	}
+}
+
+// evict removes the limiters of the orgs that have been idle for the idle timeout
+func (r *RateLimiter) evict(now time.Time) {
+	cutoff := now.Add(-r.idleTimeout).UnixNano()
+	r.limiters.Range(func(key, value interface{}) bool {
+		if atomic.LoadInt64(&value.(*orgLimiter).lastUsed) < cutoff {
+			r.limiters.Delete(key)
+		}
+		return true
+	})
+}
+
+// RateLimited checks whether the org can ingest n points according to the IngestRateLimiter.
+// if not, it responds with a 429 and a Retry-After header, or a 413 if n exceeds the burst of the org,
+// and returns true.
+func RateLimited(w http.ResponseWriter, orgId uint32, n int) bool {
+	if IngestRateLimiter == nil {
+		return false
+	}
+	ok, delay := IngestRateLimiter.Allow(orgId, n, time.Now())
+	if ok {
+		return false
+	}
+	rateLimitRejectedPoints.Add(n)
+	if delay == 0 {
+		w.WriteHeader(http.StatusRequestEntityTooLarge)
+		w.Write([]byte(fmt.Sprintf("request with %d points exceeds the ingest burst limit of org %d", n, orgId)))
+		return true
+	}
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(delay.Seconds()))))
+	w.WriteHeader(http.StatusTooManyRequests)
+	w.Write([]byte(fmt.Sprintf("org %d exceeded its ingest rate limit", orgId)))
+	return true
+}
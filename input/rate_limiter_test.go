@@ -0,0 +1,111 @@
+package input
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestRateLimiter(t *testing.T) {
+	r := NewRateLimiter(Limit{Rate: 10, Burst: 20}, map[uint32]Limit{2: {Rate: 100, Burst: 200}}, time.Minute)
+	defer r.Stop()
+	now := time.Unix(1540000000, 0)
+
+	if ok, _ := r.Allow(1, 20, now); !ok {
+		t.Fatalf("expected the burst of org 1 to be allowed")
+	}
+	ok, delay := r.Allow(1, 5, now)
+	if ok || delay != 500*time.Millisecond {
+		t.Fatalf("expected org 1 to have to wait 500ms, got ok %t, delay %s", ok, delay)
+	}
+	// a rejected request doesn't consume tokens
+	if ok, _ := r.Allow(1, 5, now.Add(500*time.Millisecond)); !ok {
+		t.Fatalf("expected org 1 to be allowed after waiting")
+	}
+	if ok, delay := r.Allow(1, 21, now.Add(time.Hour)); ok || delay != 0 {
+		t.Fatalf("expected more than the burst of org 1 to never be allowed, got ok %t, delay %s", ok, delay)
+	}
+
+	// org 2 has its own, higher limit
+	if ok, _ := r.Allow(2, 200, now); !ok {
+		t.Fatalf("expected the burst of org 2 to be allowed")
+	}
+	if ok, _ := r.Allow(3, 20, now); !ok {
+		t.Fatalf("expected the burst of org 3 to be allowed")
+	}
+}
+
+func TestRateLimiterEvict(t *testing.T) {
+	r := NewRateLimiter(Limit{Rate: 10, Burst: 20}, nil, time.Minute)
+	defer r.Stop()
+	now := time.Unix(1540000000, 0)
+	r.Allow(1, 20, now)
+	r.Allow(2, 20, now.Add(30*time.Second))
+
+	r.evict(now.Add(80 * time.Second))
+	if _, ok := r.limiters.Load(uint32(1)); ok {
+		t.Fatalf("expected idle org 1 to be evicted")
+	}
+	if _, ok := r.limiters.Load(uint32(2)); !ok {
+		t.Fatalf("expected org 2 to not be evicted")
+	}
+	// an evicted org starts with a full bucket
+	if ok, _ := r.Allow(1, 20, now.Add(80*time.Second)); !ok {
+		t.Fatalf("expected the burst of evicted org 1 to be allowed")
+	}
+}
+
+func TestRateLimited(t *testing.T) {
+	IngestRateLimiter = NewRateLimiter(Limit{Rate: 10, Burst: 20}, nil, time.Minute)
+	defer func() {
+		IngestRateLimiter.Stop()
+		IngestRateLimiter = nil
+	}()
+
+	w := httptest.NewRecorder()
+	if RateLimited(w, 1, 20) {
+		t.Fatalf("expected the burst to be allowed")
+	}
+	w = httptest.NewRecorder()
+	if !RateLimited(w, 1, 15) || w.Code != http.StatusTooManyRequests || w.Header().Get("Retry-After") != "2" {
+		t.Fatalf("expected a 429 with Retry-After 2, got %d with Retry-After %q", w.Code, w.Header().Get("Retry-After"))
+	}
+	w = httptest.NewRecorder()
+	if !RateLimited(w, 1, 21) || w.Code != http.StatusRequestEntityTooLarge {
+		t.Fatalf("expected a 413, got %d", w.Code)
+	}
+}
+
+func TestReadLimitOverrides(t *testing.T) {
+	f, err := ioutil.TempFile("", "rate-limits")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	f.WriteString(`
+[[org]]
+id = 2
+rate = 500000.0
+burst = 1000000
+
+[[org]]
+id = 3
+rate = 10.5
+burst = 20
+`)
+	f.Close()
+	overrides, err := ReadLimitOverrides(f.Name())
+	if err != nil {
+		t.Fatalf("unexpected error %s", err)
+	}
+	exp := map[uint32]Limit{
+		2: {Rate: 500000, Burst: 1000000},
+		3: {Rate: 10.5, Burst: 20},
+	}
+	if len(overrides) != len(exp) || overrides[2] != exp[2] || overrides[3] != exp[3] {
+		t.Fatalf("expected overrides %v, got %v", exp, overrides)
+	}
+}
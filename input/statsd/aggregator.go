@@ -0,0 +1,161 @@
+package statsd
+
+import (
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/grafana/metrictank/input"
+	"github.com/raintank/schema"
+)
+
+// series is the name and tags of a statsd metric
+type series struct {
+	name string
+	tags []string
+}
+
+type counterValue struct {
+	series
+	value float64
+}
+
+type gauge struct {
+	series
+	value   float64
+	updated bool // whether the gauge was updated since the last flush
+}
+
+type timer struct {
+	series
+	values []float64
+}
+
+// aggregator accumulates the samples of a flush interval
+type aggregator struct {
+	sync.Mutex
+	interval    int // flush interval in seconds
+	percentiles []float64
+	counters    map[string]*counterValue
+	gauges      map[string]*gauge
+	timers      map[string]*timer
+}
+
+func newAggregator(interval int, percentiles []float64) *aggregator {
+	return &aggregator{
+		interval:    interval,
+		percentiles: percentiles,
+		counters:    make(map[string]*counterValue),
+		gauges:      make(map[string]*gauge),
+		timers:      make(map[string]*timer),
+	}
+}
+
+// seriesKey returns a key that identifies the series, in the graphite tagged format.
+// it sorts the tags in place.
+func seriesKey(name string, tags []string) string {
+	if len(tags) == 0 {
+		return name
+	}
+	sort.Strings(tags)
+	return name + ";" + strings.Join(tags, ";")
+}
+
+func (a *aggregator) add(s sample) {
+	key := seriesKey(s.name, s.tags)
+	a.Lock()
+	switch s.kind {
+	case counterKind:
+		c, ok := a.counters[key]
+		if !ok {
+			c = &counterValue{series: series{s.name, s.tags}}
+			a.counters[key] = c
+		}
+		c.value += s.value / s.rate
+	case gaugeKind:
+		g, ok := a.gauges[key]
+		if !ok {
+			g = &gauge{series: series{s.name, s.tags}}
+			a.gauges[key] = g
+		}
+		if s.relative {
+			g.value += s.value
+		} else {
+			g.value = s.value
+		}
+		g.updated = true
+	case timerKind:
+		t, ok := a.timers[key]
+		if !ok {
+			t = &timer{series: series{s.name, s.tags}}
+			a.timers[key] = t
+		}
+		t.values = append(t.values, s.value)
+	}
+	a.Unlock()
+}
+
+// flush returns the MetricData's for the samples accumulated since the last flush, timestamped ts:
+// * counters: the sum of the counts, as a count metric
+// * gauges: the last value, for gauges that were updated. the value is kept for relative updates
+// * timers: <name>.count, .min, .max, .mean and .p<percentile> for each configured percentile, e.g. .p99_9 for 99.9
+func (a *aggregator) flush(ts int64) []*schema.MetricData {
+	a.Lock()
+	counters, timers := a.counters, a.timers
+	a.counters = make(map[string]*counterValue)
+	a.timers = make(map[string]*timer)
+	var mds []*schema.MetricData
+	for _, g := range a.gauges {
+		if !g.updated {
+			continue
+		}
+		g.updated = false
+		mds = append(mds, a.metricData(g.series, "", g.value, "gauge", "unknown", ts))
+	}
+	a.Unlock()
+
+	for _, c := range counters {
+		mds = append(mds, a.metricData(c.series, "", c.value, "count", "unknown", ts))
+	}
+	for _, t := range timers {
+		sort.Float64s(t.values)
+		var sum float64
+		for _, v := range t.values {
+			sum += v
+		}
+		count := float64(len(t.values))
+		mds = append(mds,
+			a.metricData(t.series, ".count", count, "count", "unknown", ts),
+			a.metricData(t.series, ".min", t.values[0], "gauge", "ms", ts),
+			a.metricData(t.series, ".max", t.values[len(t.values)-1], "gauge", "ms", ts),
+			a.metricData(t.series, ".mean", sum/count, "gauge", "ms", ts),
+		)
+		for _, p := range a.percentiles {
+			// nearest rank
+			rank := int(math.Ceil(p/100*count)) - 1
+			if rank < 0 {
+				rank = 0
+			}
+			suffix := ".p" + strings.Replace(strconv.FormatFloat(p, 'f', -1, 64), ".", "_", -1)
+			mds = append(mds, a.metricData(t.series, suffix, t.values[rank], "gauge", "ms", ts))
+		}
+	}
+	return mds
+}
+
+func (a *aggregator) metricData(s series, suffix string, value float64, mtype, unit string, ts int64) *schema.MetricData {
+	md := &schema.MetricData{
+		Name:     s.name + suffix,
+		Interval: a.interval,
+		Value:    value,
+		Unit:     unit,
+		Time:     ts,
+		Mtype:    mtype,
+		Tags:     input.CopyTags(s.tags),
+		OrgId:    1,
+	}
+	md.SetId()
+	return md
+}
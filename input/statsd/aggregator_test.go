@@ -0,0 +1,71 @@
+package statsd
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/raintank/schema"
+)
+
+func flushed(a *aggregator) map[string]*schema.MetricData {
+	byName := make(map[string]*schema.MetricData)
+	for _, md := range a.flush(1540000000) {
+		byName[md.Name+";"+strings.Join(md.Tags, ";")] = md
+	}
+	return byName
+}
+
+func TestAggregator(t *testing.T) {
+	a := newAggregator(10, []float64{50, 99.9})
+	for _, line := range []string{
+		"page.views:1|c",
+		"page.views:2|c|@0.5",
+		"page.views:1|c|#host:web01",
+		"queue.size:10|g",
+		"queue.size:-3|g",
+		"request.time:30|ms",
+		"request.time:10|ms",
+		"request.time:20|ms",
+	} {
+		s, err := parseLine([]byte(line))
+		if err != nil {
+			t.Fatalf("line %q: unexpected error %s", line, err)
+		}
+		a.add(s)
+	}
+	exp := map[string]struct {
+		value float64
+		mtype string
+	}{
+		"page.views;":           {5, "count"},
+		"page.views;host=web01": {1, "count"},
+		"queue.size;":           {7, "gauge"},
+		"request.time.count;":   {3, "count"},
+		"request.time.min;":     {10, "gauge"},
+		"request.time.max;":     {30, "gauge"},
+		"request.time.mean;":    {20, "gauge"},
+		"request.time.p50;":     {20, "gauge"},
+		"request.time.p99_9;":   {30, "gauge"},
+	}
+	got := flushed(a)
+	if len(got) != len(exp) {
+		t.Fatalf("expected %d MetricData's, got %d: %v", len(exp), len(got), got)
+	}
+	for key, e := range exp {
+		md := got[key]
+		if md == nil || md.Value != e.value || md.Mtype != e.mtype || md.Interval != 10 || md.Time != 1540000000 {
+			t.Fatalf("%s: expected value %f and mtype %s, got %+v", key, e.value, e.mtype, md)
+		}
+	}
+
+	// counters and timers are reset. gauges are only flushed when updated, but keep their value
+	if got := flushed(a); len(got) != 0 {
+		t.Fatalf("expected no MetricData's, got %v", got)
+	}
+	s, _ := parseLine([]byte("queue.size:+1|g"))
+	a.add(s)
+	got = flushed(a)
+	if len(got) != 1 || got["queue.size;"] == nil || got["queue.size;"].Value != 8 {
+		t.Fatalf("expected queue.size to be 8, got %v", got)
+	}
+}
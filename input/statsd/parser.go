@@ -0,0 +1,96 @@
+package statsd
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strconv"
+)
+
+type kind byte
+
+const (
+	counterKind kind = 'c'
+	gaugeKind   kind = 'g'
+	timerKind   kind = 't'
+)
+
+// sample is a measurement of a line of statsd wire format
+type sample struct {
+	name     string
+	value    float64
+	kind     kind
+	relative bool    // for gauges: value is a change to the current value rather than a new value
+	rate     float64 // sample rate
+	tags     []string
+}
+
+var (
+	errNoValue  = errors.New("no value")
+	errNoType   = errors.New("no type")
+	errNoName   = errors.New("empty name")
+	errBadRate  = errors.New("invalid sample rate")
+	errBadValue = errors.New("invalid value")
+)
+
+// parseLine parses a line of the statsd wire format, with the DogStatsD tags extension:
+// <name>:<value>|<type>[|@<sample rate>][|#<tag>:<value>,...]
+// supported types are c (counter), g (gauge), and ms, h and d, which are all handled as timers.
+// tags without a value are left out.
+func parseLine(line []byte) (sample, error) {
+	s := sample{rate: 1}
+	fields := bytes.Split(line, []byte("|"))
+	if len(fields) < 2 {
+		return s, errNoType
+	}
+	colon := bytes.LastIndexByte(fields[0], ':')
+	if colon == -1 {
+		return s, errNoValue
+	}
+	if colon == 0 {
+		return s, errNoName
+	}
+	s.name = string(fields[0][:colon])
+	value := fields[0][colon+1:]
+
+	switch string(fields[1]) {
+	case "c":
+		s.kind = counterKind
+	case "g":
+		s.kind = gaugeKind
+		if len(value) > 0 && (value[0] == '+' || value[0] == '-') {
+			s.relative = true
+		}
+	case "ms", "h", "d":
+		s.kind = timerKind
+	default:
+		return s, fmt.Errorf("unsupported type %q", fields[1])
+	}
+	var err error
+	s.value, err = strconv.ParseFloat(string(value), 64)
+	if err != nil {
+		return s, errBadValue
+	}
+
+	for _, field := range fields[2:] {
+		if len(field) == 0 {
+			continue
+		}
+		switch field[0] {
+		case '@':
+			s.rate, err = strconv.ParseFloat(string(field[1:]), 64)
+			if err != nil || s.rate <= 0 || s.rate > 1 {
+				return s, errBadRate
+			}
+		case '#':
+			for _, tag := range bytes.Split(field[1:], []byte(",")) {
+				colon := bytes.IndexByte(tag, ':')
+				if colon <= 0 || colon == len(tag)-1 {
+					continue
+				}
+				s.tags = append(s.tags, string(tag[:colon])+"="+string(tag[colon+1:]))
+			}
+		}
+	}
+	return s, nil
+}
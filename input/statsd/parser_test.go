@@ -0,0 +1,55 @@
+package statsd
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseLine(t *testing.T) {
+	cases := []struct {
+		line string
+		exp  sample
+	}{
+		{"page.views:1|c", sample{name: "page.views", value: 1, kind: counterKind, rate: 1}},
+		{"page.views:3|c|@0.1", sample{name: "page.views", value: 3, kind: counterKind, rate: 0.1}},
+		{"queue.size:42|g", sample{name: "queue.size", value: 42, kind: gaugeKind, rate: 1}},
+		{"queue.size:-2|g", sample{name: "queue.size", value: -2, kind: gaugeKind, relative: true, rate: 1}},
+		{"queue.size:+2.5|g", sample{name: "queue.size", value: 2.5, kind: gaugeKind, relative: true, rate: 1}},
+		{"request.time:320|ms", sample{name: "request.time", value: 320, kind: timerKind, rate: 1}},
+		{"request.time:320|h", sample{name: "request.time", value: 320, kind: timerKind, rate: 1}},
+		{"request.time:320|d", sample{name: "request.time", value: 320, kind: timerKind, rate: 1}},
+		{
+			"page.views:1|c|@0.5|#env:prod,host:web01,beta,url:http://x",
+			sample{name: "page.views", value: 1, kind: counterKind, rate: 0.5, tags: []string{"env=prod", "host=web01", "url=http://x"}},
+		},
+		{"a:b:1|c", sample{name: "a:b", value: 1, kind: counterKind, rate: 1}},
+	}
+	for _, c := range cases {
+		got, err := parseLine([]byte(c.line))
+		if err != nil {
+			t.Fatalf("line %q: unexpected error %s", c.line, err)
+		}
+		if !reflect.DeepEqual(got, c.exp) {
+			t.Fatalf("line %q: expected %+v, got %+v", c.line, c.exp, got)
+		}
+	}
+}
+
+func TestParseLineErrors(t *testing.T) {
+	lines := []string{
+		"page.views",
+		"page.views:1",
+		":1|c",
+		"page.views:|c",
+		"page.views:abc|c",
+		"users:42|s",
+		"page.views:1|c|@0",
+		"page.views:1|c|@2",
+		"page.views:1|c|@x",
+	}
+	for _, line := range lines {
+		if _, err := parseLine([]byte(line)); err == nil {
+			t.Fatalf("line %q: expected an error", line)
+		}
+	}
+}
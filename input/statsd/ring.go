@@ -0,0 +1,77 @@
+package statsd
+
+import (
+	"sync/atomic"
+)
+
+// ring is a lock-free, single producer single consumer ring buffer of datagrams.
+// the slots and their buffers are allocated up front, so that the producer can read datagrams
+// straight into them, and the consumer can process them without allocating.
+// this lets the reader keep draining the socket while the consumer is paused, e.g. by GC.
+type ring struct {
+	slots  []slot
+	mask   uint64
+	head   uint64        // sequence number of the next slot to consume. only written by the consumer
+	tail   uint64        // sequence number of the next slot to produce. only written by the producer
+	notify chan struct{} // signals the consumer that a slot was produced
+}
+
+type slot struct {
+	buf []byte
+	n   int
+}
+
+// newRing returns a ring with room for size datagrams of up to slotSize bytes.
+// size is rounded up to the next power of 2.
+func newRing(size, slotSize int) *ring {
+	n := 1
+	for n < size {
+		n <<= 1
+	}
+	r := &ring{
+		slots:  make([]slot, n),
+		mask:   uint64(n - 1),
+		notify: make(chan struct{}, 1),
+	}
+	for i := range r.slots {
+		r.slots[i].buf = make([]byte, slotSize)
+	}
+	return r
+}
+
+// reserve returns the slot to produce into next, or nil if the ring is full.
+// the slot only becomes visible to the consumer after commit.
+func (r *ring) reserve() *slot {
+	tail := atomic.LoadUint64(&r.tail)
+	if tail-atomic.LoadUint64(&r.head) == uint64(len(r.slots)) {
+		return nil
+	}
+	return &r.slots[tail&r.mask]
+}
+
+// commit publishes the reserved slot, holding n bytes, to the consumer
+func (r *ring) commit(n int) {
+	tail := atomic.LoadUint64(&r.tail)
+	r.slots[tail&r.mask].n = n
+	atomic.StoreUint64(&r.tail, tail+1)
+	select {
+	case r.notify <- struct{}{}:
+	default:
+	}
+}
+
+// peek returns the data of the next slot to consume, or nil if the ring is empty.
+// the data is only valid until release is called.
+func (r *ring) peek() []byte {
+	head := atomic.LoadUint64(&r.head)
+	if head == atomic.LoadUint64(&r.tail) {
+		return nil
+	}
+	s := &r.slots[head&r.mask]
+	return s.buf[:s.n]
+}
+
+// release returns the slot last returned by peek to the producer
+func (r *ring) release() {
+	atomic.StoreUint64(&r.head, atomic.LoadUint64(&r.head)+1)
+}
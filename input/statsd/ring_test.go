@@ -0,0 +1,68 @@
+package statsd
+
+import (
+	"runtime"
+	"strconv"
+	"sync"
+	"testing"
+)
+
+func TestRing(t *testing.T) {
+	r := newRing(3, 16)
+	if len(r.slots) != 4 {
+		t.Fatalf("expected size to be rounded up to 4, got %d", len(r.slots))
+	}
+	if r.peek() != nil {
+		t.Fatalf("expected an empty ring")
+	}
+	for i := 0; i < 4; i++ {
+		slot := r.reserve()
+		if slot == nil {
+			t.Fatalf("expected a slot for datagram %d", i)
+		}
+		r.commit(copy(slot.buf, strconv.Itoa(i)))
+	}
+	if r.reserve() != nil {
+		t.Fatalf("expected a full ring")
+	}
+	for i := 0; i < 4; i++ {
+		if got := string(r.peek()); got != strconv.Itoa(i) {
+			t.Fatalf("expected datagram %d, got %q", i, got)
+		}
+		r.release()
+	}
+	if r.peek() != nil {
+		t.Fatalf("expected an empty ring")
+	}
+}
+
+func TestRingConcurrent(t *testing.T) {
+	r := newRing(8, 16)
+	n := 10000
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < n; i++ {
+			slot := r.reserve()
+			for slot == nil {
+				runtime.Gosched()
+				slot = r.reserve()
+			}
+			r.commit(copy(slot.buf, strconv.Itoa(i)))
+		}
+	}()
+	for i := 0; i < n; {
+		packet := r.peek()
+		if packet == nil {
+			<-r.notify
+			continue
+		}
+		if got := string(packet); got != strconv.Itoa(i) {
+			t.Fatalf("expected datagram %d, got %q", i, got)
+		}
+		r.release()
+		i++
+	}
+	wg.Wait()
+}
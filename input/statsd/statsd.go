@@ -0,0 +1,244 @@
+// package statsd provides a statsd input for metrictank, with support for the DogStatsD tags extension.
+// it aggregates counters, gauges and timers over a flush interval, like statsd does, and ingests the results.
+package statsd
+
+import (
+	"bytes"
+	"context"
+	"flag"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/grafana/globalconf"
+	"github.com/grafana/metrictank/cluster"
+	"github.com/grafana/metrictank/input"
+	"github.com/grafana/metrictank/stats"
+	log "github.com/sirupsen/logrus"
+)
+
+// metric input.statsd.packets_received is a count of datagrams received
+var packetsReceived = stats.NewCounterRate32("input.statsd.packets_received")
+
+// metric input.statsd.packets_dropped is a count of datagrams dropped because the buffer was full
+var packetsDropped = stats.NewCounterRate32("input.statsd.packets_dropped")
+
+// metric input.statsd.parse_errors is a count of lines that failed to parse
+var parseErrors = stats.NewCounterRate32("input.statsd.parse_errors")
+
+var (
+	Enabled        bool
+	addr           string
+	partitionID    int
+	flushInterval  time.Duration
+	percentilesStr string
+	bufferSize     int
+	maxPacketSize  int
+
+	percentiles []float64
+)
+
+func ConfigSetup() {
+	inStatsd := flag.NewFlagSet("statsd-in", flag.ExitOnError)
+	inStatsd.BoolVar(&Enabled, "enabled", false, "")
+	inStatsd.StringVar(&addr, "addr", ":8125", "udp listen address")
+	inStatsd.IntVar(&partitionID, "partition", 0, "partition Id.")
+	inStatsd.DurationVar(&flushInterval, "flush-interval", 10*time.Second, "interval to aggregate and flush counters, gauges and timers at. must be a whole number of seconds. this is also the interval of the metrics")
+	inStatsd.StringVar(&percentilesStr, "percentiles", "90", "comma separated list of percentiles to compute for timers")
+	inStatsd.IntVar(&bufferSize, "buffer-size", 1024, "number of datagrams to buffer between receiving and parsing them. datagrams that don't fit are dropped")
+	inStatsd.IntVar(&maxPacketSize, "max-packet-size", 8192, "maximum size of a datagram. bigger ones are truncated")
+	globalconf.Register("statsd-in", inStatsd, flag.ExitOnError)
+}
+
+func ConfigProcess() {
+	if !Enabled {
+		return
+	}
+	if flushInterval < time.Second || flushInterval%time.Second != 0 {
+		log.Fatal("statsd-in: flush-interval must be a whole number of seconds")
+	}
+	percentiles = percentiles[:0]
+	for _, p := range strings.Split(percentilesStr, ",") {
+		p = strings.TrimSpace(p)
+		if p == "" {
+			continue
+		}
+		f, err := strconv.ParseFloat(p, 64)
+		if err != nil || f <= 0 || f > 100 {
+			log.Fatalf("statsd-in: invalid percentile %q", p)
+		}
+		percentiles = append(percentiles, f)
+	}
+	if bufferSize < 1 {
+		log.Fatal("statsd-in: buffer-size must be > 0")
+	}
+	if maxPacketSize < 1 {
+		log.Fatal("statsd-in: max-packet-size must be > 0")
+	}
+	cluster.Manager.SetPartitions([]int32{int32(partitionID)})
+}
+
+// Statsd is an input plugin that accepts the statsd wire format over UDP.
+// a reader goroutine receives datagrams into a ring buffer, from which a parser goroutine
+// feeds the samples into an aggregator, which is flushed every flush interval.
+type Statsd struct {
+	input.Handler
+	addr          string
+	partition     int32
+	flushInterval time.Duration
+	maxPacketSize int
+	conn          *net.UDPConn
+	ring          *ring
+	agg           *aggregator
+	quit          chan struct{}
+	wg            sync.WaitGroup
+}
+
+func New() *Statsd {
+	return &Statsd{
+		addr:          addr,
+		partition:     int32(partitionID),
+		flushInterval: flushInterval,
+		maxPacketSize: maxPacketSize,
+		ring:          newRing(bufferSize, maxPacketSize),
+		agg:           newAggregator(int(flushInterval/time.Second), percentiles),
+	}
+}
+
+func (s *Statsd) Name() string {
+	return "statsd"
+}
+
+func (s *Statsd) Start(handler input.Handler, cancel context.CancelFunc) error {
+	s.Handler = handler
+	udpAddr, err := net.ResolveUDPAddr("udp", s.addr)
+	if err != nil {
+		log.Errorf("statsd-in: %s", err.Error())
+		return err
+	}
+	s.conn, err = net.ListenUDP("udp", udpAddr)
+	if err != nil {
+		log.Errorf("statsd-in: %s", err.Error())
+		return err
+	}
+	log.Infof("statsd-in: listening on %v/udp", s.conn.LocalAddr())
+	s.quit = make(chan struct{})
+	s.wg.Add(3)
+	go s.read()
+	go s.consume()
+	go s.flushLoop()
+	return nil
+}
+
+func (s *Statsd) MaintainPriority() {
+	cluster.Manager.SetPriority(0)
+}
+
+func (s *Statsd) ExplainPriority() interface{} {
+	return "statsd-in: priority=0 (always in sync)"
+}
+
+// Stop stops receiving and flushes what has been received so far
+func (s *Statsd) Stop() {
+	log.Info("statsd-in: shutting down")
+	close(s.quit)
+	s.conn.Close()
+	s.wg.Wait()
+	for packet := s.ring.peek(); packet != nil; packet = s.ring.peek() {
+		s.process(packet)
+		s.ring.release()
+	}
+	s.flush()
+}
+
+// read receives datagrams into the ring, and drops them when it is full
+func (s *Statsd) read() {
+	defer s.wg.Done()
+	scratch := make([]byte, s.maxPacketSize)
+	for {
+		slot := s.ring.reserve()
+		buf := scratch
+		if slot != nil {
+			buf = slot.buf
+		}
+		n, err := s.conn.Read(buf)
+		if err != nil {
+			select {
+			case <-s.quit:
+				return
+			default:
+			}
+			log.Errorf("statsd-in: Recv error: %s", err.Error())
+			continue
+		}
+		packetsReceived.Inc()
+		if slot == nil {
+			packetsDropped.Inc()
+			continue
+		}
+		s.ring.commit(n)
+	}
+}
+
+// consume parses the datagrams in the ring and feeds their samples to the aggregator
+func (s *Statsd) consume() {
+	defer s.wg.Done()
+	for {
+		packet := s.ring.peek()
+		if packet == nil {
+			select {
+			case <-s.ring.notify:
+				continue
+			case <-s.quit:
+				return
+			}
+		}
+		s.process(packet)
+		s.ring.release()
+	}
+}
+
+// process feeds the samples of all lines of the packet to the aggregator
+func (s *Statsd) process(packet []byte) {
+	for len(packet) > 0 {
+		var line []byte
+		if i := bytes.IndexByte(packet, '\n'); i >= 0 {
+			line, packet = packet[:i], packet[i+1:]
+		} else {
+			line, packet = packet, nil
+		}
+		line = bytes.TrimSpace(line)
+		if len(line) == 0 {
+			continue
+		}
+		sample, err := parseLine(line)
+		if err != nil {
+			parseErrors.Inc()
+			log.Debugf("statsd-in: invalid line %q: %s", line, err)
+			continue
+		}
+		s.agg.add(sample)
+	}
+}
+
+func (s *Statsd) flushLoop() {
+	defer s.wg.Done()
+	ticker := time.NewTicker(s.flushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			s.flush()
+		case <-s.quit:
+			return
+		}
+	}
+}
+
+func (s *Statsd) flush() {
+	for _, md := range s.agg.flush(time.Now().Unix()) {
+		s.ProcessMetricData(md, s.partition)
+	}
+}
@@ -0,0 +1,105 @@
+package statsd
+
+import (
+	"net"
+	"runtime"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/grafana/metrictank/input/inputtest"
+)
+
+func newTestStatsd(t testing.TB, bufferSize int) (*Statsd, *inputtest.RecordingHandler) {
+	s := &Statsd{
+		addr:          "127.0.0.1:0",
+		flushInterval: time.Hour,
+		maxPacketSize: 1024,
+		ring:          newRing(bufferSize, 1024),
+		agg:           newAggregator(10, nil),
+	}
+	handler := &inputtest.RecordingHandler{}
+	if err := s.Start(handler, nil); err != nil {
+		t.Fatalf("failed to start: %s", err)
+	}
+	return s, handler
+}
+
+func dial(t testing.TB, s *Statsd) net.Conn {
+	conn, err := net.Dial("udp", s.conn.LocalAddr().String())
+	if err != nil {
+		t.Fatalf("failed to dial: %s", err)
+	}
+	return conn
+}
+
+// parsed returns the number of datagrams the statsd input has parsed
+func parsed(s *Statsd) uint64 {
+	return atomic.LoadUint64(&s.ring.head)
+}
+
+// waitFor waits until the statsd input has parsed n datagrams, or stopped making progress
+func waitFor(s *Statsd, n uint64) {
+	last, lastProgress := parsed(s), time.Now()
+	for last < n && time.Since(lastProgress) < time.Second {
+		time.Sleep(time.Millisecond)
+		if p := parsed(s); p != last {
+			last, lastProgress = p, time.Now()
+		}
+	}
+}
+
+func TestStatsd(t *testing.T) {
+	s, handler := newTestStatsd(t, 16)
+	conn := dial(t, s)
+	conn.Write([]byte("page.views:1|c\npage.views:2|c\n"))
+	conn.Write([]byte("queue.size:10|g|#host:web01"))
+	conn.Write([]byte("invalid"))
+	conn.Close()
+	waitFor(s, 3)
+	s.Stop()
+
+	mds := handler.MetricData()
+	if len(mds) != 2 {
+		t.Fatalf("expected 2 MetricData's, got %d", len(mds))
+	}
+	for _, md := range mds {
+		switch md.Name {
+		case "page.views":
+			if md.Value != 3 || md.Mtype != "count" {
+				t.Fatalf("unexpected page.views %+v", md)
+			}
+		case "queue.size":
+			if md.Value != 10 || md.Mtype != "gauge" || len(md.Tags) != 1 || md.Tags[0] != "host=web01" {
+				t.Fatalf("unexpected queue.size %+v", md)
+			}
+		default:
+			t.Fatalf("unexpected MetricData %+v", md)
+		}
+	}
+}
+
+// BenchmarkLoopback measures the throughput of receiving and parsing datagrams sent over loopback.
+// ns/op is the time per packet: 1e9 / ns/op is the number of packets per second.
+// the sender keeps at most maxInFlight packets in flight, so that it doesn't overflow the socket's receive buffer.
+func BenchmarkLoopback(b *testing.B) {
+	maxInFlight := uint64(128)
+	s, _ := newTestStatsd(b, 4096)
+	conn := dial(b, s)
+	packet := []byte("request.time:320|ms|#env:prod,host:web01")
+	start := parsed(s)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for start+uint64(i)-parsed(s) >= maxInFlight {
+			runtime.Gosched()
+		}
+		conn.Write(packet)
+	}
+	waitFor(s, start+uint64(b.N))
+	b.StopTimer()
+	if dropped := start + uint64(b.N) - parsed(s); dropped > 0 {
+		b.Logf("%d of %d packets were dropped", dropped, b.N)
+	}
+	conn.Close()
+	s.Stop()
+}
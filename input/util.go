@@ -0,0 +1,20 @@
+package input
+
+import "github.com/grafana/metrictank/mdata"
+
+// SchemaInterval returns the raw interval of the storage schema that matches the name
+func SchemaInterval(name string) int {
+	_, schema := mdata.MatchSchema(name, 0)
+	return schema.Retentions[0].SecondsPerPoint
+}
+
+// CopyTags returns a copy of the tags, or nil if there are none.
+// each MetricData needs its own copy of the tags, as SetId sorts them in place.
+func CopyTags(tags []string) []string {
+	if len(tags) == 0 {
+		return nil
+	}
+	cp := make([]string, len(tags))
+	copy(cp, tags)
+	return cp
+}
@@ -38,10 +38,11 @@ type AggMetric struct {
 	aggregators     []*Aggregator
 	dropFirstChunk  bool
 	ttl             uint32
-	lastSaveStart   uint32 // last chunk T0 that was added to the write Queue.
-	lastSaveFinish  uint32 // last chunk T0 successfully written to Cassandra.
-	lastWrite       uint32 // wall clock time of when last point was successfully added (possibly to the ROB)
-	firstTs         uint32 // timestamp of first point seen
+	lastSaveStart   uint32      // last chunk T0 that was added to the write Queue.
+	lastSaveFinish  uint32      // last chunk T0 successfully written to Cassandra.
+	lastWrite       uint32      // wall clock time of when last point was successfully added (possibly to the ROB)
+	firstTs         uint32      // timestamp of first point seen
+	codec           chunk.Codec // codec to compress chunks with when persisting them
 }
 
 // NewAggMetric creates a metric with given key, it retains the given number of chunks each chunkSpan seconds long
@@ -90,6 +91,19 @@ func (a *AggMetric) SyncChunkSaveState(ts uint32) {
 	log.Debugf("AM: metric %s at chunk T0=%d has been saved.", a.key, ts)
 }
 
+// setCodec sets the codec to persist the chunks of the metric and its rollups with.
+// it must be called before the metric receives any data.
+func (a *AggMetric) setCodec(codec chunk.Codec) {
+	a.codec = codec
+	for _, agg := range a.aggregators {
+		for _, m := range []*AggMetric{agg.minMetric, agg.maxMetric, agg.sumMetric, agg.cntMetric, agg.lstMetric} {
+			if m != nil {
+				m.codec = codec
+			}
+		}
+	}
+}
+
 // Sync the saved state of a chunk by its T0.
 func (a *AggMetric) SyncAggregatedChunkSaveState(ts uint32, consolidator consolidation.Consolidator, aggSpan uint32) {
 	// no lock needed cause aggregators don't change at runtime
@@ -354,7 +368,7 @@ func (a *AggMetric) persist(pos int) {
 	// create an array of chunks that need to be sent to the writeQueue.
 	pending := make([]*ChunkWriteRequest, 1)
 	// add the current chunk to the list of chunks to send to the writeQueue
-	cwr := NewChunkWriteRequest(a, a.key, chunk, a.ttl, a.chunkSpan, time.Now())
+	cwr := NewChunkWriteRequest(a, a.key, chunk, a.ttl, a.chunkSpan, a.codec, time.Now())
 	pending[0] = &cwr
 
 	// if we recently became the primary, there may be older chunks
@@ -367,7 +381,7 @@ func (a *AggMetric) persist(pos int) {
 	previousChunk := a.chunks[previousPos]
 	for (previousChunk.Series.T0 < chunk.Series.T0) && (a.lastSaveStart < previousChunk.Series.T0) {
 		log.Debugf("AM: persist(): old chunk needs saving. Adding %s:%d to writeQueue", a.key, previousChunk.Series.T0)
-		cwr := NewChunkWriteRequest(a, a.key, previousChunk, a.ttl, a.chunkSpan, time.Now())
+		cwr := NewChunkWriteRequest(a, a.key, previousChunk, a.ttl, a.chunkSpan, a.codec, time.Now())
 		pending = append(pending, &cwr)
 		previousPos--
 		if previousPos < 0 {
@@ -391,9 +405,18 @@ func (a *AggMetric) persist(pos int) {
 	// last-to-first ensuring that older data is added to the store
 	// before newer data.
 	for pendingChunk >= 0 {
-		log.Debugf("AM: persist(): sealing chunk %d/%d (%s:%d) and adding to write queue.", pendingChunk, len(pending), a.key, chunk.Series.T0)
-		a.store.Add(pending[pendingChunk])
+		cwr := pending[pendingChunk]
 		pendingChunk--
+		if chunkDedup != nil && chunkDedup.Saved(a.key, cwr.Chunk.Series.T0) {
+			log.Debugf("AM: persist(): chunk %s:%d has already been saved. skipping", a.key, cwr.Chunk.Series.T0)
+			chunksDeduplicated.Inc()
+			if cwr.Chunk.Series.T0 > a.lastSaveFinish {
+				a.lastSaveFinish = cwr.Chunk.Series.T0
+			}
+			continue
+		}
+		log.Debugf("AM: persist(): sealing chunk %d/%d (%s:%d) and adding to write queue.", pendingChunk+1, len(pending), a.key, chunk.Series.T0)
+		a.store.Add(cwr)
 	}
 	persistDuration.Value(time.Now().Sub(pre))
 	return
@@ -401,15 +424,24 @@ func (a *AggMetric) persist(pos int) {
 
 // don't ever call with a ts of 0, cause we use 0 to mean not initialized!
 func (a *AggMetric) Add(ts uint32, val float64) {
+	// only sample the raw points. the rollup archives are fed by our aggregators and
+	// as such already only see the sampled points.
+	if sampler != nil && a.key.Archive == 0 && !sampler(a.key, ts, val) {
+		metricsSampledOut.Inc()
+		return
+	}
+
 	a.Lock()
 	defer a.Unlock()
 
+	var accepted bool
 	if a.rob == nil {
 		// write directly
-		a.add(ts, val)
+		accepted = a.add(ts, val)
 	} else {
 		// write through reorder buffer
-		res, accepted := a.rob.Add(ts, val)
+		var res []schema.Point
+		res, accepted = a.rob.Add(ts, val)
 
 		if len(res) == 0 && accepted {
 			a.lastWrite = uint32(time.Now().Unix())
@@ -419,11 +451,27 @@ func (a *AggMetric) Add(ts uint32, val float64) {
 			a.add(p.Ts, p.Val)
 		}
 	}
+
+	// only log the raw points that made it into the reorder buffer or the chunks,
+	// so that replaying the wal doesn't add points that were rejected.
+	if accepted && a.key.Archive == 0 {
+		if w := loadPointWAL(); w != nil {
+			w.Append(a.key.MKey, ts, val)
+		}
+	}
+}
+
+// chunkSaved returns whether the chunk the given ts belongs into has been saved already
+func (a *AggMetric) chunkSaved(ts uint32) bool {
+	a.RLock()
+	defer a.RUnlock()
+	return a.lastSaveFinish != 0 && ts-(ts%a.chunkSpan) <= a.lastSaveFinish
 }
 
 // don't ever call with a ts of 0, cause we use 0 to mean not initialized!
 // caller must hold write lock
-func (a *AggMetric) add(ts uint32, val float64) {
+// returns whether the point was added to a chunk
+func (a *AggMetric) add(ts uint32, val float64) bool {
 	t0 := ts - (ts % a.chunkSpan)
 
 	if len(a.chunks) == 0 {
@@ -446,7 +494,7 @@ func (a *AggMetric) add(ts uint32, val float64) {
 			a.lastSaveFinish = t0
 		}
 		a.addAggregators(ts, val)
-		return
+		return true
 	}
 
 	currentChunk := a.chunks[a.currentChunkPos]
@@ -457,13 +505,13 @@ func (a *AggMetric) add(ts uint32, val float64) {
 			// if we've already 'finished' the chunk, it means it has the end-of-stream marker and any new points behind it wouldn't be read by an iterator
 			// you should monitor this metric closely, it indicates that maybe your GC settings don't match how you actually send data (too late)
 			addToClosedChunk.Inc()
-			return
+			return false
 		}
 
 		if err := currentChunk.Push(ts, val); err != nil {
 			log.Debugf("AM: failed to add metric to chunk for %s. %s", a.key, err)
 			metricsTooOld.Inc()
-			return
+			return false
 		}
 		totalPoints.Inc()
 		a.lastWrite = uint32(time.Now().Unix())
@@ -471,7 +519,7 @@ func (a *AggMetric) add(ts uint32, val float64) {
 	} else if t0 < currentChunk.Series.T0 {
 		log.Debugf("AM: Point at %d has t0 %d, goes back into previous chunk. CurrentChunk t0: %d, LastTs: %d", ts, t0, currentChunk.Series.T0, currentChunk.Series.T)
 		metricsTooOld.Inc()
-		return
+		return false
 	} else {
 		// Data belongs in a new chunk.
 
@@ -514,6 +562,7 @@ func (a *AggMetric) add(ts uint32, val float64) {
 
 	}
 	a.addAggregators(ts, val)
+	return true
 }
 
 // collectable returns whether the AggMetric is garbage collectable
@@ -47,6 +47,9 @@ type FlatAccnt struct {
 	// the size limit, once this is reached we'll start evicting data
 	maxSize uint64
 
+	// the limit on the number of chunks, once this is reached we'll start evicting data. 0 means no limit
+	maxChunks uint64
+
 	// a last-recently-used implementation that keeps track of all chunks
 	// and which hasn't been used for the longest time. the eviction
 	// function relies on this to know what to evict.
@@ -121,13 +124,16 @@ type GetTotalPayload struct {
 	res_chan chan uint64
 }
 
-func NewFlatAccnt(maxSize uint64) *FlatAccnt {
+// NewFlatAccnt creates a FlatAccnt that evicts chunks once their total size exceeds maxSize,
+// or their number exceeds maxChunks, if it is not 0
+func NewFlatAccnt(maxSize, maxChunks uint64) *FlatAccnt {
 	accnt := FlatAccnt{
-		metrics: make(map[schema.AMKey]*FlatAccntMet),
-		maxSize: maxSize,
-		lru:     NewLRU(),
-		evictQ:  make(chan *EvictTarget, evictQSize),
-		eventQ:  make(chan FlatAccntEvent, EventQSize),
+		metrics:   make(map[schema.AMKey]*FlatAccntMet),
+		maxSize:   maxSize,
+		maxChunks: maxChunks,
+		lru:       NewLRU(),
+		evictQ:    make(chan *EvictTarget, evictQSize),
+		eventQ:    make(chan FlatAccntEvent, EventQSize),
 	}
 	cacheSizeMax.SetUint64(maxSize)
 	accntEventQueueMax.SetUint64(uint64(EventQSize))
@@ -241,13 +247,14 @@ func (a *FlatAccnt) eventLoop() {
 				a.metrics = make(map[schema.AMKey]*FlatAccntMet)
 				a.lru.reset()
 				cacheSizeUsed.SetUint64(0)
+				cacheSizeChunks.SetUint64(0)
 				cacheOverheadChunk.SetUint64(0)
 				cacheOverheadFlat.SetUint64(0)
 				cacheOverheadLru.SetUint64(0)
 			}
 
 			// evict until we're below the max
-			for cacheSizeUsed.Peek() > a.maxSize {
+			for cacheSizeUsed.Peek() > a.maxSize || (a.maxChunks > 0 && cacheSizeChunks.Peek() > a.maxChunks) {
 				a.evict()
 			}
 		}
@@ -266,6 +273,7 @@ func (a *FlatAccnt) delMet(metric schema.AMKey) {
 
 	lenChunks := len(met.chunks)
 	cacheSizeUsed.DecUint64(met.total)
+	cacheSizeChunks.DecUint64(uint64(lenChunks))
 	cacheOverheadFlat.DecUint64(uint64(lenChunks*famChunkSize + famSize))
 	cacheOverheadLru.DecUint64(uint64(lenChunks * lruItemSize))
 	cacheOverheadChunk.DecUint64(uint64(lenChunks*ccmChunkSize + ccmSize))
@@ -311,6 +319,7 @@ func (a *FlatAccnt) add(metric schema.AMKey, ts uint32, size uint64) {
 	totalLru += lruItemSize
 	met.total = met.total + size
 	cacheSizeUsed.AddUint64(size)
+	cacheSizeChunks.AddUint64(1)
 	cacheOverheadFlat.AddUint64(totalFlat)
 	cacheOverheadChunk.AddUint64(totalChunk)
 	cacheOverheadLru.AddUint64(totalLru)
@@ -332,7 +341,7 @@ func (a *FlatAccnt) addRange(metric schema.AMKey, chunks []chunk.IterGen) {
 		totalChunk += ccmSize
 	}
 
-	var sizeDiff uint64
+	var sizeDiff, added uint64
 
 	for _, chunk := range chunks {
 		if _, ok = met.chunks[chunk.T0]; ok {
@@ -341,6 +350,7 @@ func (a *FlatAccnt) addRange(metric schema.AMKey, chunks []chunk.IterGen) {
 		}
 		size := chunk.Size()
 		sizeDiff += size
+		added++
 		met.chunks[chunk.T0] = size
 		totalFlat += famChunkSize
 		totalChunk += ccmChunkSize
@@ -350,6 +360,7 @@ func (a *FlatAccnt) addRange(metric schema.AMKey, chunks []chunk.IterGen) {
 
 	met.total = met.total + sizeDiff
 	cacheSizeUsed.AddUint64(sizeDiff)
+	cacheSizeChunks.AddUint64(added)
 	cacheOverheadFlat.AddUint64(totalFlat)
 	cacheOverheadChunk.AddUint64(totalChunk)
 	cacheOverheadLru.AddUint64(totalLru)
@@ -397,6 +408,7 @@ func (a *FlatAccnt) evict() {
 		size = met.chunks[ts]
 		met.total = met.total - size
 		cacheSizeUsed.DecUint64(size)
+		cacheSizeChunks.DecUint64(1)
 		cacheChunkEvict.Inc()
 		a.evictQ <- &EvictTarget{
 			Metric: target.Metric,
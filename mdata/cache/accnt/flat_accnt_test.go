@@ -12,11 +12,12 @@ func resetCounters() {
 	cacheChunkAdd.SetUint32(0)
 	cacheChunkEvict.SetUint32(0)
 	cacheSizeUsed.SetUint64(0)
+	cacheSizeChunks.SetUint64(0)
 }
 
 func TestAddingEvicting(t *testing.T) {
 	resetCounters()
-	a := NewFlatAccnt(10)
+	a := NewFlatAccnt(10, 0)
 	evictQ := a.GetEvictQ()
 
 	// some test data
@@ -84,7 +85,7 @@ func TestAddingEvicting(t *testing.T) {
 
 func TestLRUOrdering(t *testing.T) {
 	resetCounters()
-	a := NewFlatAccnt(6)
+	a := NewFlatAccnt(6, 0)
 	evictQ := a.GetEvictQ()
 
 	// some test data
@@ -117,9 +118,40 @@ func TestLRUOrdering(t *testing.T) {
 	a.Stop()
 }
 
+func TestMaxChunks(t *testing.T) {
+	resetCounters()
+	a := NewFlatAccnt(100, 2)
+	evictQ := a.GetEvictQ()
+
+	metric1 := schema.GetAMKey(test.GetMKey(1), schema.Cnt, 600)
+	metric2 := schema.GetAMKey(test.GetMKey(2), schema.Cnt, 600)
+	metric3 := schema.GetAMKey(test.GetMKey(3), schema.Cnt, 600)
+
+	a.AddChunk(metric1, 1, 1)
+	a.AddChunk(metric2, 1, 1)
+	a.HitChunk(metric1, 1)
+	// the size is well below the max, but we exceed the max number of chunks
+	a.AddChunk(metric3, 1, 1)
+
+	et := <-evictQ
+	if et.Metric != metric2 || et.Ts != 1 {
+		t.Fatalf("Returned evict target is not as expected, got %+v", et)
+	}
+
+	if total := a.GetTotal(); total != 2 {
+		t.Fatalf("Expected total size to be 2, got %d", total)
+	}
+
+	if peek := cacheSizeChunks.Peek(); peek != 2 {
+		t.Fatalf("Expected chunk count to be at 2, got %d", peek)
+	}
+
+	a.Stop()
+}
+
 func TestMetricDeleting(t *testing.T) {
 	resetCounters()
-	a := NewFlatAccnt(12)
+	a := NewFlatAccnt(12, 0)
 
 	metric1 := schema.GetAMKey(test.GetMKey(1), schema.Cnt, 600)
 	metric2 := schema.GetAMKey(test.GetMKey(2), schema.Cnt, 600)
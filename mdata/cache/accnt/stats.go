@@ -37,6 +37,9 @@ var (
 	// metric cache.size.used is how much of the cache is used (sum of the chunk data without overhead)
 	cacheSizeUsed = stats.NewGauge64("cache.size.used")
 
+	// metric cache.size.chunks is the number of chunks in the cache
+	cacheSizeChunks = stats.NewGauge64("cache.size.chunks")
+
 	// metric cache.overhead.chunk is an approximation of the overhead used to store chunks in the cache
 	cacheOverheadChunk = stats.NewGauge64("cache.overhead.chunk")
 
@@ -19,6 +19,7 @@ import (
 
 var (
 	maxSize         uint64
+	maxChunks       uint64
 	searchFwdBug    = stats.NewCounter32("recovered_errors.cache.metric.searchForwardBug")
 	ErrInvalidRange = errors.New("CCache: invalid range: from must be less than to")
 )
@@ -27,6 +28,7 @@ func init() {
 	flags := flag.NewFlagSet("chunk-cache", flag.ExitOnError)
 	// (1024 ^ 3) * 4 = 4294967296 = 4G
 	flags.Uint64Var(&maxSize, "max-size", 4294967296, "Maximum size of chunk cache in bytes. 0 disables cache")
+	flags.Uint64Var(&maxChunks, "max-chunks", 0, "Maximum number of chunks in the chunk cache. 0 means no limit")
 	globalconf.Register("chunk-cache", flags, flag.ExitOnError)
 }
 
@@ -60,7 +62,7 @@ func NewCCache() *CCache {
 	cc := &CCache{
 		metricCache:   make(map[schema.AMKey]*CCacheMetric),
 		metricRawKeys: make(map[schema.MKey]map[schema.Archive]struct{}),
-		accnt:         accnt.NewFlatAccnt(maxSize),
+		accnt:         accnt.NewFlatAccnt(maxSize, maxChunks),
 		stop:          make(chan interface{}),
 		tracer:        opentracing.NoopTracer{},
 	}
@@ -56,5 +56,15 @@ func (c *Chunk) Finish() {
 // so for formats that encode it, it needs to be passed in.
 // the returned value contains no references to the chunk. data is copied.
 func (c *Chunk) Encode(span uint32) []byte {
-	return encode(span, FormatGoTszLongWithSpan, c.Series.Bytes())
+	return encode(span, FormatGoTszLongWithSpan, CodecGorilla, c.Series.Bytes())
+}
+
+// EncodeWithCodec encodes the chunk like Encode, but compresses the data with the given codec.
+// CodecGorilla chunks are encoded like Encode does, so that they can be read by versions that
+// don't know about codecs.
+func (c *Chunk) EncodeWithCodec(span uint32, codec Codec) []byte {
+	if codec == CodecGorilla {
+		return c.Encode(span)
+	}
+	return encode(span, FormatGoTszLongWithSpanCodec, codec, c.Series.Bytes())
 }
@@ -0,0 +1,130 @@
+package chunk
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/pierrec/lz4"
+)
+
+// Codec identifies how the tsz data of a chunk is compressed, on top of the
+// Gorilla compression that tsz itself applies.
+// it is stored in the chunk header of the FormatGoTszLongWithSpanCodec format.
+// chunks in the other formats are always CodecGorilla.
+type Codec uint8
+
+const (
+	CodecGorilla Codec = iota // plain tsz data
+	CodecLZ4                  // tsz data compressed with lz4, preceded by its uncompressed size as uvarint
+	CodecZstd                 // tsz data compressed as a zstd frame
+)
+
+// maxLZ4Ratio is the highest compression ratio lz4 can achieve.
+// it bounds the uncompressed size we accept when decoding, so that corrupt data can't make us allocate huge buffers.
+const maxLZ4Ratio = 255
+
+// maxZstdSize bounds the size of decoded zstd data, for the same reason.
+// chunks are typically a few KB, at most a few MB.
+const maxZstdSize = 64 << 20
+
+var (
+	errUnknownCodec = errors.New("unrecognized chunk codec")
+	errCorruptLZ4   = errors.New("corrupt lz4 chunk data")
+
+	zstdOnce    sync.Once
+	zstdEncoder *zstd.Encoder
+	zstdDecoder *zstd.Decoder
+)
+
+func (c Codec) String() string {
+	switch c {
+	case CodecGorilla:
+		return "gorilla"
+	case CodecLZ4:
+		return "lz4"
+	case CodecZstd:
+		return "zstd"
+	}
+	return fmt.Sprintf("Codec(%d)", c)
+}
+
+// ParseCodec returns the codec with the given name, as returned by Codec.String
+func ParseCodec(s string) (Codec, error) {
+	for _, c := range []Codec{CodecGorilla, CodecLZ4, CodecZstd} {
+		if s == c.String() {
+			return c, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown codec %q. valid codecs are gorilla, lz4 and zstd", s)
+}
+
+// initZstd creates the zstd encoder and decoder on first use, as the decoder starts goroutines
+func initZstd() {
+	zstdOnce.Do(func() {
+		var err error
+		zstdEncoder, err = zstd.NewWriter(nil)
+		if err != nil {
+			panic(fmt.Sprintf("failed to create zstd encoder: %s", err))
+		}
+		zstdDecoder, err = zstd.NewReader(nil, zstd.WithDecoderMaxMemory(maxZstdSize))
+		if err != nil {
+			panic(fmt.Sprintf("failed to create zstd decoder: %s", err))
+		}
+	})
+}
+
+// compress appends data, compressed with the codec, to dst.
+// if the codec does not make the data any smaller, the data is appended as is, and CodecGorilla is returned.
+func compress(codec Codec, data, dst []byte) ([]byte, Codec) {
+	switch codec {
+	case CodecLZ4:
+		var size [binary.MaxVarintLen64]byte
+		n := binary.PutUvarint(size[:], uint64(len(data)))
+		buf := make([]byte, n+lz4.CompressBlockBound(len(data)))
+		copy(buf, size[:n])
+		compressed, err := lz4.CompressBlock(data, buf[n:], 0)
+		// 0 means the data is incompressible
+		if err == nil && compressed > 0 && n+compressed < len(data) {
+			return append(dst, buf[:n+compressed]...), CodecLZ4
+		}
+	case CodecZstd:
+		initZstd()
+		compressed := zstdEncoder.EncodeAll(data, nil)
+		if len(compressed) < len(data) {
+			return append(dst, compressed...), CodecZstd
+		}
+	}
+	return append(dst, data...), CodecGorilla
+}
+
+// decompress returns the data, decompressed with the codec.
+// the returned data never shares memory with the input.
+func decompress(codec Codec, data []byte) ([]byte, error) {
+	switch codec {
+	case CodecGorilla:
+		dest := make([]byte, len(data))
+		copy(dest, data)
+		return dest, nil
+	case CodecLZ4:
+		size, n := binary.Uvarint(data)
+		if n <= 0 || size > uint64(len(data)-n)*maxLZ4Ratio {
+			return nil, errCorruptLZ4
+		}
+		dest := make([]byte, size)
+		decompressed, err := lz4.UncompressBlock(data[n:], dest, 0)
+		if err != nil {
+			return nil, err
+		}
+		if uint64(decompressed) != size {
+			return nil, errCorruptLZ4
+		}
+		return dest, nil
+	case CodecZstd:
+		initZstd()
+		return zstdDecoder.DecodeAll(data, nil)
+	}
+	return nil, errUnknownCodec
+}
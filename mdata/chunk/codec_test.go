@@ -0,0 +1,137 @@
+package chunk
+
+import (
+	"testing"
+)
+
+// sparseChunk returns a chunk of a sparse event metric: mostly zeroes, with a spike every hour
+func sparseChunk(t0 uint32) *Chunk {
+	c := New(t0)
+	for i := uint32(0); i < 360; i++ {
+		v := 0.0
+		if i%60 == 0 {
+			v = float64(i)
+		}
+		c.Push(t0+i*10, v)
+	}
+	c.Finish()
+	return c
+}
+
+func TestEncodeWithCodec(t *testing.T) {
+	t0 := uint32(3600)
+	span := uint32(3600)
+	c := sparseChunk(t0)
+	for _, codec := range []Codec{CodecGorilla, CodecLZ4, CodecZstd} {
+		data := c.EncodeWithCodec(span, codec)
+		itgen, err := NewIterGen(t0, 10, data)
+		if err != nil {
+			t.Fatalf("%s: could not construct itergen: %s", codec, err)
+		}
+		if itgen.Codec() != codec {
+			t.Fatalf("%s: expected the chunk to be compressed with %s, got %s", codec, codec, itgen.Codec())
+		}
+		if itgen.Span() != span {
+			t.Fatalf("%s: expected span %d, got %d", codec, span, itgen.Span())
+		}
+		if codec != CodecGorilla && len(data) >= len(c.Encode(span)) {
+			t.Fatalf("%s: expected the chunk to be smaller than %d bytes, got %d", codec, len(c.Encode(span)), len(data))
+		}
+		iter, err := itgen.Get()
+		if err != nil {
+			t.Fatalf("%s: could not get iterator: %s", codec, err)
+		}
+		i := uint32(0)
+		for iter.Next() {
+			ts, val := iter.Values()
+			expVal := 0.0
+			if i%60 == 0 {
+				expVal = float64(i)
+			}
+			if ts != t0+i*10 || val != expVal {
+				t.Fatalf("%s: point %d: expected (%d, %f), got (%d, %f)", codec, i, t0+i*10, expVal, ts, val)
+			}
+			i++
+		}
+		if iter.Err() != nil {
+			t.Fatalf("%s: unexpected iterator error: %s", codec, iter.Err())
+		}
+		if i != 360 {
+			t.Fatalf("%s: expected 360 points, got %d", codec, i)
+		}
+	}
+}
+
+func TestEncodeWithCodecGorillaIsBackwardCompatible(t *testing.T) {
+	c := sparseChunk(3600)
+	data := c.EncodeWithCodec(3600, CodecGorilla)
+	if Format(data[0]) != FormatGoTszLongWithSpan {
+		t.Fatalf("expected format %s, got %s", FormatGoTszLongWithSpan, Format(data[0]))
+	}
+}
+
+func TestEncodeWithCodecIncompressible(t *testing.T) {
+	c := New(3600)
+	c.Push(3610, 1)
+	c.Finish()
+	data := c.EncodeWithCodec(3600, CodecLZ4)
+	itgen, err := NewIterGen(3600, 10, data)
+	if err != nil {
+		t.Fatalf("could not construct itergen: %s", err)
+	}
+	if itgen.Codec() != CodecGorilla {
+		t.Fatalf("expected a chunk that doesn't compress to fall back to %s, got %s", CodecGorilla, itgen.Codec())
+	}
+	iter, err := itgen.Get()
+	if err != nil {
+		t.Fatalf("could not get iterator: %s", err)
+	}
+	if !iter.Next() {
+		t.Fatalf("expected a point")
+	}
+	if ts, val := iter.Values(); ts != 3610 || val != 1 {
+		t.Fatalf("expected point (3610, 1), got (%d, %f)", ts, val)
+	}
+}
+
+func TestCodecCorruptData(t *testing.T) {
+	spanCode := byte(RevChunkSpans[3600])
+	cases := []struct {
+		name   string
+		data   []byte
+		newErr bool // whether NewIterGen should fail, or only Get
+	}{
+		{"no data", []byte{byte(FormatGoTszLongWithSpanCodec), spanCode, byte(CodecLZ4)}, true},
+		{"unknown codec", []byte{byte(FormatGoTszLongWithSpanCodec), spanCode, 200, 1, 2}, true},
+		{"lz4 huge size", []byte{byte(FormatGoTszLongWithSpanCodec), spanCode, byte(CodecLZ4), 0xff, 0xff, 0xff, 0xff, 0x0f, 1, 2}, false},
+		{"lz4 garbage", []byte{byte(FormatGoTszLongWithSpanCodec), spanCode, byte(CodecLZ4), 10, 0xff, 0xff}, false},
+		{"zstd garbage", []byte{byte(FormatGoTszLongWithSpanCodec), spanCode, byte(CodecZstd), 1, 2, 3, 4, 5, 6}, false},
+	}
+	for _, c := range cases {
+		itgen, err := NewIterGen(3600, 10, c.data)
+		if c.newErr {
+			if err == nil {
+				t.Fatalf("%s: expected NewIterGen to fail", c.name)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("%s: could not construct itergen: %s", c.name, err)
+		}
+		if _, err := itgen.Get(); err == nil {
+			t.Fatalf("%s: expected Get to fail", c.name)
+		}
+	}
+}
+
+func TestParseCodec(t *testing.T) {
+	for _, codec := range []Codec{CodecGorilla, CodecLZ4, CodecZstd} {
+		got, err := ParseCodec(codec.String())
+		if err != nil || got != codec {
+			t.Fatalf("expected %s, got %s (err: %v)", codec, got, err)
+		}
+	}
+	if _, err := ParseCodec("snappy"); err == nil {
+		t.Fatalf("expected an error for an unknown codec")
+	}
+}
@@ -8,8 +8,21 @@ import (
 
 // encode is a helper function to encode a chunk of data into various formats
 // input data is copied
-func encode(span uint32, format Format, data []byte) []byte {
+// the codec is only used by FormatGoTszLongWithSpanCodec
+func encode(span uint32, format Format, codec Codec, data []byte) []byte {
 	switch format {
+	case FormatGoTszLongWithSpanCodec:
+		spanCode, ok := RevChunkSpans[span]
+		if !ok {
+			// it's probably better to panic than to persist the chunk with a wrong length
+			panic(fmt.Sprintf("Chunk span invalid: %d", span))
+		}
+		buf := make([]byte, 3, 3+len(data))
+		buf[0] = byte(format)
+		buf[1] = byte(spanCode)
+		buf, codec = compress(codec, data, buf)
+		buf[2] = byte(codec)
+		return buf
 	case FormatStandardGoTszWithSpan, FormatGoTszLongWithSpan:
 		buf := new(bytes.Buffer)
 		binary.Write(buf, binary.LittleEndian, format)
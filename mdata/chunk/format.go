@@ -11,5 +11,6 @@ type Format uint8
 const (
 	FormatStandardGoTsz Format = iota
 	FormatStandardGoTszWithSpan
-	FormatGoTszLongWithSpan // like FormatStandardGoTszWithSpan but using tsz.SeriesLong
+	FormatGoTszLongWithSpan      // like FormatStandardGoTszWithSpan but using tsz.SeriesLong
+	FormatGoTszLongWithSpanCodec // like FormatGoTszLongWithSpan but with a Codec byte after the span, saying how the tsz.SeriesLong data is compressed
 )
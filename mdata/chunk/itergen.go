@@ -35,6 +35,16 @@ func NewIterGen(t0, intervalHint uint32, b []byte) (IterGen, error) {
 		if int(b[1]) >= len(ChunkSpans) {
 			return IterGen{}, errUnknownSpanCode
 		}
+	case FormatGoTszLongWithSpanCodec:
+		if len(b) <= 3 {
+			return IterGen{}, errShort
+		}
+		if int(b[1]) >= len(ChunkSpans) {
+			return IterGen{}, errUnknownSpanCode
+		}
+		if Codec(b[2]) > CodecZstd {
+			return IterGen{}, errUnknownCodec
+		}
 	default:
 		return IterGen{}, errUnknownChunkFormat
 	}
@@ -64,10 +74,25 @@ func (ig *IterGen) Get() (tsz.Iter, error) {
 		dest := make([]byte, len(src))
 		copy(dest, src)
 		return tsz.NewIteratorLong(ig.T0, dest)
+	case FormatGoTszLongWithSpanCodec:
+		// decompress always returns a copy
+		dest, err := decompress(ig.Codec(), ig.B[3:])
+		if err != nil {
+			return nil, err
+		}
+		return tsz.NewIteratorLong(ig.T0, dest)
 	}
 	return nil, errUnknownChunkFormat
 }
 
+// Codec returns the codec the chunk data is compressed with
+func (ig IterGen) Codec() Codec {
+	if ig.Format() == FormatGoTszLongWithSpanCodec {
+		return Codec(ig.B[2])
+	}
+	return CodecGorilla
+}
+
 func (ig *IterGen) Span() uint32 {
 	if ig.Format() == FormatStandardGoTsz {
 		return 0 // we don't know what the span is. sorry.
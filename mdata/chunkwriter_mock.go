@@ -0,0 +1,64 @@
+package mdata
+
+import (
+	"context"
+	"sync"
+
+	"github.com/raintank/schema"
+)
+
+// ChunkWriteRecord is a chunk written to a MockChunkWriter
+type ChunkWriteRecord struct {
+	Key  schema.AMKey
+	T0   uint32
+	TTL  uint32
+	Data []byte
+}
+
+// MockChunkWriter is an in-memory ChunkWriter implementation for unit tests.
+// like a batching writer, it only considers chunks written once they have been flushed.
+type MockChunkWriter struct {
+	sync.Mutex
+	pending []ChunkWriteRecord
+	written []ChunkWriteRecord
+	// if set, returned by WriteChunk instead of accepting the chunk
+	Err error
+}
+
+func NewMockChunkWriter() *MockChunkWriter {
+	return &MockChunkWriter{}
+}
+
+// WriteChunk buffers the chunk until the next Flush
+func (c *MockChunkWriter) WriteChunk(key schema.AMKey, t0, ttl uint32, data []byte) error {
+	c.Lock()
+	defer c.Unlock()
+	if c.Err != nil {
+		return c.Err
+	}
+	buf := make([]byte, len(data))
+	copy(buf, data)
+	c.pending = append(c.pending, ChunkWriteRecord{key, t0, ttl, buf})
+	return nil
+}
+
+// Flush marks all buffered chunks as written
+func (c *MockChunkWriter) Flush(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	c.Lock()
+	c.written = append(c.written, c.pending...)
+	c.pending = nil
+	c.Unlock()
+	return nil
+}
+
+// Written returns the chunks that have been written and flushed, in the order they were written
+func (c *MockChunkWriter) Written() []ChunkWriteRecord {
+	c.Lock()
+	defer c.Unlock()
+	written := make([]ChunkWriteRecord, len(c.written))
+	copy(written, c.written)
+	return written
+}
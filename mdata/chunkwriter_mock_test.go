@@ -0,0 +1,43 @@
+package mdata
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/raintank/schema"
+)
+
+func TestMockChunkWriter(t *testing.T) {
+	var w ChunkWriter = NewMockChunkWriter()
+	mock := w.(*MockChunkWriter)
+	key := schema.AMKey{MKey: schema.MKey{Key: [16]byte{1}, Org: 1}}
+
+	data := []byte{1, 2, 3}
+	if err := w.WriteChunk(key, 600, 3600, data); err != nil {
+		t.Fatalf("unexpected error %s", err)
+	}
+	data[0] = 9
+	if written := mock.Written(); len(written) != 0 {
+		t.Fatalf("expected no chunks to be written before flushing, got %v", written)
+	}
+	if err := w.Flush(context.Background()); err != nil {
+		t.Fatalf("unexpected error %s", err)
+	}
+	exp := []ChunkWriteRecord{{Key: key, T0: 600, TTL: 3600, Data: []byte{1, 2, 3}}}
+	if written := mock.Written(); !reflect.DeepEqual(written, exp) {
+		t.Fatalf("expected %v, got %v", exp, written)
+	}
+
+	mock.Err = errors.New("store unavailable")
+	if err := w.WriteChunk(key, 1200, 3600, data); err != mock.Err {
+		t.Fatalf("expected error %q, got %v", mock.Err, err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := w.Flush(ctx); err == nil {
+		t.Fatalf("expected an error flushing with a canceled context")
+	}
+}
@@ -14,10 +14,11 @@ type ChunkWriteRequest struct {
 	Chunk     *chunk.Chunk
 	TTL       uint32
 	Span      uint32
+	Codec     chunk.Codec
 	Timestamp time.Time
 }
 
 // NewChunkWriteRequest creates a new ChunkWriteRequest
-func NewChunkWriteRequest(metric *AggMetric, key schema.AMKey, chunk *chunk.Chunk, ttl, span uint32, ts time.Time) ChunkWriteRequest {
-	return ChunkWriteRequest{metric, key, chunk, ttl, span, ts}
+func NewChunkWriteRequest(metric *AggMetric, key schema.AMKey, c *chunk.Chunk, ttl, span uint32, codec chunk.Codec, ts time.Time) ChunkWriteRequest {
+	return ChunkWriteRequest{metric, key, c, ttl, span, codec, ts}
 }
@@ -0,0 +1,43 @@
+package mdata
+
+import (
+	"errors"
+
+	"github.com/grafana/metrictank/mdata/chunk"
+	"github.com/raintank/schema"
+)
+
+var (
+	ErrUnknownEncoding = errors.New("unknown chunk encoding")
+	errEmptyChunk      = errors.New("chunk is empty")
+)
+
+// Decode decodes the points of a chunk in any of the supported formats.
+// the format is identified by the byte the chunk data starts with, so chunks
+// written in older formats can be read alongside newer ones.
+// t0 is not part of the chunk data (in cassandra it's stored in the ts column)
+// but it is needed to decode chunks in the FormatGoTszLongWithSpan(Codec) formats.
+func Decode(t0 uint32, data []byte) ([]schema.Point, error) {
+	if len(data) == 0 {
+		return nil, errEmptyChunk
+	}
+	switch chunk.Format(data[0]) {
+	case chunk.FormatStandardGoTsz, chunk.FormatStandardGoTszWithSpan, chunk.FormatGoTszLongWithSpan, chunk.FormatGoTszLongWithSpanCodec:
+	default:
+		return nil, ErrUnknownEncoding
+	}
+	itgen, err := chunk.NewIterGen(t0, 0, data)
+	if err != nil {
+		return nil, err
+	}
+	iter, err := itgen.Get()
+	if err != nil {
+		return nil, err
+	}
+	var points []schema.Point
+	for iter.Next() {
+		ts, val := iter.Values()
+		points = append(points, schema.Point{Val: val, Ts: ts})
+	}
+	return points, iter.Err()
+}
@@ -0,0 +1,64 @@
+package mdata
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/grafana/metrictank/mdata/chunk"
+	"github.com/grafana/metrictank/mdata/chunk/tsz"
+	"github.com/raintank/schema"
+)
+
+func TestDecode(t *testing.T) {
+	t0 := uint32(3600)
+	points := []schema.Point{
+		{Val: 1, Ts: 3600},
+		{Val: 2.5, Ts: 3660},
+		{Val: 100, Ts: 3720},
+	}
+
+	series4h := tsz.NewSeries4h(t0)
+	for _, p := range points {
+		series4h.Push(p.Ts, p.Val)
+	}
+	series4h.Finish()
+
+	long := chunk.New(t0)
+	for _, p := range points {
+		long.Push(p.Ts, p.Val)
+	}
+	long.Finish()
+
+	spanCode := chunk.RevChunkSpans[600]
+
+	cases := []struct {
+		name string
+		data []byte
+	}{
+		{"FormatStandardGoTsz", append([]byte{byte(chunk.FormatStandardGoTsz)}, series4h.Bytes()...)},
+		{"FormatStandardGoTszWithSpan", append([]byte{byte(chunk.FormatStandardGoTszWithSpan), byte(spanCode)}, series4h.Bytes()...)},
+		{"FormatGoTszLongWithSpan", long.Encode(600)},
+		{"FormatGoTszLongWithSpanCodec lz4", long.EncodeWithCodec(600, chunk.CodecLZ4)},
+		{"FormatGoTszLongWithSpanCodec zstd", long.EncodeWithCodec(600, chunk.CodecZstd)},
+	}
+	for _, c := range cases {
+		got, err := Decode(t0, c.data)
+		if err != nil {
+			t.Fatalf("%s: unexpected error %s", c.name, err)
+		}
+		if !reflect.DeepEqual(got, points) {
+			t.Fatalf("%s: expected points %v, got %v", c.name, points, got)
+		}
+	}
+}
+
+func TestDecodeUnknownEncoding(t *testing.T) {
+	_, err := Decode(0, []byte{200, 1, 2, 3})
+	if err != ErrUnknownEncoding {
+		t.Fatalf("expected ErrUnknownEncoding, got %v", err)
+	}
+	_, err = Decode(0, nil)
+	if err == nil {
+		t.Fatalf("expected an error decoding an empty chunk")
+	}
+}
@@ -0,0 +1,81 @@
+package mdata
+
+import (
+	"encoding/binary"
+	"sync"
+	"time"
+
+	"github.com/grafana/metrictank/stats"
+	"github.com/raintank/schema"
+	"github.com/willf/bloom"
+)
+
+// metric tank.chunks_deduplicated_total is the number of chunks that were not written to the store,
+// because a persist notification said they were already saved
+var chunksDeduplicated = stats.NewCounter32("tank.chunks_deduplicated_total")
+
+// chunkDedup is set via ConfigProcess when chunk deduplication is enabled. nil otherwise
+var chunkDedup *ChunkDedup
+
+// ChunkDedup remembers which chunks other instances (or ourselves, before a restart) have saved,
+// according to the persist notifications, so that we don't write them again.
+// this complements the save state tracked per AggMetric, which is only updated for series that
+// are already in the index when the notification comes in.
+//
+// as a bloom filter can't forget entries, it uses two of them, each sized for the chunks saved
+// during one window. every window the oldest one is discarded, so entries are remembered for
+// at least one window, and at most two.
+// false positives make us skip writing a chunk that was not saved, so the false positive rate should be kept low.
+type ChunkDedup struct {
+	sync.RWMutex
+	expected  uint
+	fpRate    float64
+	current   *bloom.BloomFilter
+	previous  *bloom.BloomFilter
+	rotatedAt time.Time
+	window    time.Duration
+}
+
+// NewChunkDedup returns a ChunkDedup sized for the given number of chunks saved per window,
+// at the given false positive rate.
+func NewChunkDedup(expected uint, fpRate float64, window time.Duration, now time.Time) *ChunkDedup {
+	return &ChunkDedup{
+		expected:  expected,
+		fpRate:    fpRate,
+		current:   bloom.NewWithEstimates(expected, fpRate),
+		previous:  bloom.NewWithEstimates(expected, fpRate),
+		rotatedAt: now,
+		window:    window,
+	}
+}
+
+// dedupKey returns the bloom filter key of the chunk of the given series starting at t0
+func dedupKey(key schema.AMKey, t0 uint32) []byte {
+	buf := make([]byte, 26)
+	copy(buf, key.MKey.Key[:])
+	binary.LittleEndian.PutUint32(buf[16:], key.MKey.Org)
+	binary.LittleEndian.PutUint16(buf[20:], uint16(key.Archive))
+	binary.LittleEndian.PutUint32(buf[22:], t0)
+	return buf
+}
+
+// Add records that the given chunk has been saved
+func (d *ChunkDedup) Add(key schema.AMKey, t0 uint32, now time.Time) {
+	k := dedupKey(key, t0)
+	d.Lock()
+	if now.Sub(d.rotatedAt) >= d.window {
+		d.previous, d.current = d.current, bloom.NewWithEstimates(d.expected, d.fpRate)
+		d.rotatedAt = now
+	}
+	d.current.Add(k)
+	d.Unlock()
+}
+
+// Saved returns whether the given chunk has (probably) been saved
+func (d *ChunkDedup) Saved(key schema.AMKey, t0 uint32) bool {
+	k := dedupKey(key, t0)
+	d.RLock()
+	saved := d.current.Test(k) || d.previous.Test(k)
+	d.RUnlock()
+	return saved
+}
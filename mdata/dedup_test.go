@@ -0,0 +1,80 @@
+package mdata
+
+import (
+	"testing"
+	"time"
+
+	"github.com/grafana/metrictank/cluster"
+	"github.com/grafana/metrictank/conf"
+	"github.com/grafana/metrictank/mdata/cache"
+	"github.com/grafana/metrictank/test"
+	"github.com/raintank/schema"
+)
+
+func TestChunkDedup(t *testing.T) {
+	now := time.Unix(1000, 0)
+	d := NewChunkDedup(1000, 0.0001, time.Hour, now)
+	key := test.GetAMKey(1)
+	rollup := schema.AMKey{MKey: key.MKey, Archive: schema.NewArchive(schema.Sum, 600)}
+
+	d.Add(key, 600, now)
+	if !d.Saved(key, 600) {
+		t.Fatalf("expected chunk to be saved")
+	}
+	if d.Saved(key, 1200) {
+		t.Fatalf("expected chunk with other t0 not to be saved")
+	}
+	if d.Saved(test.GetAMKey(2), 600) {
+		t.Fatalf("expected chunk of other series not to be saved")
+	}
+	if d.Saved(rollup, 600) {
+		t.Fatalf("expected chunk of other archive not to be saved")
+	}
+
+	// after one window, the entry is still remembered
+	d.Add(key, 1200, now.Add(time.Hour))
+	if !d.Saved(key, 600) || !d.Saved(key, 1200) {
+		t.Fatalf("expected both chunks to be saved")
+	}
+
+	// after two windows, it is forgotten
+	d.Add(key, 1800, now.Add(2*time.Hour))
+	if d.Saved(key, 600) {
+		t.Fatalf("expected chunk to be forgotten after two windows")
+	}
+	if !d.Saved(key, 1200) || !d.Saved(key, 1800) {
+		t.Fatalf("expected the more recent chunks to be saved")
+	}
+}
+
+func TestAggMetricPersistDedup(t *testing.T) {
+	mockstore.Reset()
+	defer mockstore.Reset()
+	chunksDeduplicated.SetUint32(0)
+
+	cluster.Init("default", "test", time.Now(), "http", 6060)
+	cluster.Manager.SetPrimary(true)
+
+	key := test.GetAMKey(42)
+	chunkDedup = NewChunkDedup(1000, 0.0001, time.Hour, time.Now())
+	defer func() { chunkDedup = nil }()
+	chunkDedup.Add(key, 300, time.Now())
+	chunkDedup.Add(key, 600, time.Now())
+
+	ret := []conf.Retention{conf.NewRetentionMT(1, 1, 300, 5, 0)}
+	agg := NewAggMetric(mockstore, &cache.MockCache{}, key, ret, 0, nil, false)
+	for ts := uint32(300); ts <= 1200; ts += 300 {
+		agg.Add(ts, 1)
+	}
+
+	// the chunks at 300, 600 and 900 are complete. only 900 should have been written
+	if mockstore.Items() != 1 {
+		t.Fatalf("expected 1 chunk to be written to the store, got %d", mockstore.Items())
+	}
+	if chunksDeduplicated.Peek() != 2 {
+		t.Fatalf("expected 2 chunks to be deduplicated, got %d", chunksDeduplicated.Peek())
+	}
+	if agg.lastSaveFinish != 600 {
+		t.Fatalf("expected lastSaveFinish to be 600, got %d", agg.lastSaveFinish)
+	}
+}
@@ -27,3 +27,25 @@ type Store interface {
 	Stop()
 	SetTracer(t opentracing.Tracer)
 }
+
+// ChunkWriter writes encoded chunks to a store.
+// writes may be buffered until Flush is called.
+type ChunkWriter interface {
+	WriteChunk(key schema.AMKey, t0, ttl uint32, data []byte) error
+	Flush(ctx context.Context) error
+}
+
+// ChunkScanner is implemented by stores that can stream the chunks of all metrics of an org,
+// for administrative bulk queries.
+type ChunkScanner interface {
+	// Scan streams the chunks of all metrics of the org that hold data between from (inclusive) and until (exclusive).
+	// the channel is closed when the scan is done, the context is canceled, or after a result with an error.
+	Scan(ctx context.Context, orgId uint32, from, until uint32) (<-chan ScanResult, error)
+}
+
+// ScanResult holds the chunks of a metric found by a scan
+type ScanResult struct {
+	Key    schema.AMKey
+	Chunks []chunk.IterGen
+	Err    error // if set, the scan failed and this is the last result
+}
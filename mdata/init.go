@@ -6,6 +6,7 @@ package mdata
 import (
 	"flag"
 	"io/ioutil"
+	"time"
 
 	"github.com/grafana/globalconf"
 	"github.com/grafana/metrictank/conf"
@@ -69,6 +70,15 @@ var (
 	schemasFile = "/etc/metrictank/storage-schemas.conf"
 	aggFile     = "/etc/metrictank/storage-aggregation.conf"
 
+	dedupEnabled  bool
+	dedupExpected int
+	dedupFPRate   float64
+	dedupWindow   time.Duration
+
+	walEnabled bool
+	walPath    string
+	walSize    int
+
 	promActiveMetrics = promauto.NewGaugeVec(prometheus.GaugeOpts{
 		Namespace: "metrictank",
 		Name:      "metrics_active",
@@ -81,6 +91,19 @@ func ConfigSetup() {
 	retentionConf.StringVar(&schemasFile, "schemas-file", "/etc/metrictank/storage-schemas.conf", "path to storage-schemas.conf file")
 	retentionConf.StringVar(&aggFile, "aggregations-file", "/etc/metrictank/storage-aggregation.conf", "path to storage-aggregation.conf file")
 	globalconf.Register("retention", retentionConf, flag.ExitOnError)
+
+	dedupConf := flag.NewFlagSet("chunk-dedup", flag.ExitOnError)
+	dedupConf.BoolVar(&dedupEnabled, "enabled", false, "skip writing chunks that persist notifications say have already been saved, e.g. when replaying data after a restart")
+	dedupConf.IntVar(&dedupExpected, "expected-chunks", 10000000, "number of chunks expected to be saved per window. used to size the bloom filters")
+	dedupConf.Float64Var(&dedupFPRate, "false-positive-rate", 0.0001, "target false positive rate of the bloom filters. a false positive means a chunk does not get saved")
+	dedupConf.DurationVar(&dedupWindow, "window", 24*time.Hour, "how long to remember saved chunks for. should be at least the retention of the persist notifications, e.g. the kafka retention of the persist topic")
+	globalconf.Register("chunk-dedup", dedupConf, flag.ExitOnError)
+
+	walConf := flag.NewFlagSet("point-wal", flag.ExitOnError)
+	walConf.BoolVar(&walEnabled, "enabled", false, "log the points added to the in-memory chunks to a file, and replay it at startup, so that chunks that were not saved yet survive a crash")
+	walConf.StringVar(&walPath, "path", "/var/lib/metrictank/point.wal", "path to the wal file")
+	walConf.IntVar(&walSize, "size", 268435456, "size of the wal in bytes. must be a power of two. each point takes 32 bytes, and once the wal is full the oldest points are overwritten, so it should hold all points of the chunks that have not been saved yet")
+	globalconf.Register("point-wal", walConf, flag.ExitOnError)
 }
 
 func ConfigProcess() {
@@ -115,4 +138,30 @@ func ConfigProcess() {
 		log.Infof("Could not read %s: %s: using defaults", aggFile, err)
 		Aggregations = conf.NewAggregations()
 	}
+
+	// === chunk deduplication ===
+
+	if dedupEnabled {
+		if dedupExpected <= 0 {
+			log.Fatal("chunk-dedup: expected-chunks must be > 0")
+		}
+		if dedupFPRate <= 0 || dedupFPRate >= 1 {
+			log.Fatal("chunk-dedup: false-positive-rate must be > 0 and < 1")
+		}
+		if dedupWindow <= 0 {
+			log.Fatal("chunk-dedup: window must be > 0")
+		}
+		chunkDedup = NewChunkDedup(uint(dedupExpected), dedupFPRate, dedupWindow, time.Now())
+	}
+
+	// === point wal ===
+
+	if walEnabled {
+		if walPath == "" {
+			log.Fatal("point-wal: path must be set")
+		}
+		if walSize < walRecordSize || walSize&(walSize-1) != 0 {
+			log.Fatalf("point-wal: size must be a power of two of at least %d", walRecordSize)
+		}
+	}
 }
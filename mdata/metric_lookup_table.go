@@ -0,0 +1,92 @@
+package mdata
+
+import (
+	"sync"
+
+	"github.com/raintank/schema"
+)
+
+const lookupTableShards = 256
+
+// MetricLookupTable maps MKeys to MetricDefinitions.
+// it is safe for concurrent use. the keys are spread over 256 shards
+// by the first byte of their Key, each with their own lock,
+// so that concurrent access to different metrics rarely contends.
+// the zero value is not usable: use NewMetricLookupTable.
+type MetricLookupTable struct {
+	shards [lookupTableShards]lookupTableShard
+}
+
+type lookupTableShard struct {
+	sync.RWMutex
+	defs map[schema.MKey]*schema.MetricDefinition
+}
+
+func NewMetricLookupTable() *MetricLookupTable {
+	t := &MetricLookupTable{}
+	for i := range t.shards {
+		t.shards[i].defs = make(map[schema.MKey]*schema.MetricDefinition)
+	}
+	return t
+}
+
+func (t *MetricLookupTable) shard(key schema.MKey) *lookupTableShard {
+	return &t.shards[key.Key[0]]
+}
+
+// Set stores the definition under the given key, replacing any previous one
+func (t *MetricLookupTable) Set(key schema.MKey, def *schema.MetricDefinition) {
+	s := t.shard(key)
+	s.Lock()
+	s.defs[key] = def
+	s.Unlock()
+}
+
+// Get returns the definition stored under the given key, and whether there is one
+func (t *MetricLookupTable) Get(key schema.MKey) (*schema.MetricDefinition, bool) {
+	s := t.shard(key)
+	s.RLock()
+	def, ok := s.defs[key]
+	s.RUnlock()
+	return def, ok
+}
+
+// Delete removes the definition stored under the given key, if any
+func (t *MetricLookupTable) Delete(key schema.MKey) {
+	s := t.shard(key)
+	s.Lock()
+	delete(s.defs, key)
+	s.Unlock()
+}
+
+// Len returns the number of definitions in the table.
+// as shards are counted one by one, the result may be off
+// if definitions get added or removed concurrently.
+func (t *MetricLookupTable) Len() int {
+	var n int
+	for i := range t.shards {
+		s := &t.shards[i]
+		s.RLock()
+		n += len(s.defs)
+		s.RUnlock()
+	}
+	return n
+}
+
+// Range calls f for every definition in the table, until f returns false.
+// shards are visited one by one, under a read lock, so f must not modify the table.
+// like with sync.Map, definitions that are added or removed concurrently
+// may or may not be visited.
+func (t *MetricLookupTable) Range(f func(key schema.MKey, def *schema.MetricDefinition) bool) {
+	for i := range t.shards {
+		s := &t.shards[i]
+		s.RLock()
+		for key, def := range s.defs {
+			if !f(key, def) {
+				s.RUnlock()
+				return
+			}
+		}
+		s.RUnlock()
+	}
+}
@@ -0,0 +1,91 @@
+package mdata
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/raintank/schema"
+)
+
+func getLookupTableDef(i int) *schema.MetricDefinition {
+	def := &schema.MetricDefinition{
+		OrgId:    1,
+		Name:     fmt.Sprintf("some.metric.%d", i),
+		Interval: 10,
+		Mtype:    "gauge",
+	}
+	def.SetId()
+	return def
+}
+
+func TestMetricLookupTable(t *testing.T) {
+	table := NewMetricLookupTable()
+	var defs []*schema.MetricDefinition
+	for i := 0; i < 1000; i++ {
+		def := getLookupTableDef(i)
+		defs = append(defs, def)
+		table.Set(def.Id, def)
+	}
+	if table.Len() != 1000 {
+		t.Fatalf("expected 1000 definitions, got %d", table.Len())
+	}
+	for _, def := range defs {
+		got, ok := table.Get(def.Id)
+		if !ok || got != def {
+			t.Fatalf("expected to get %s back, got %v, %t", def.Id, got, ok)
+		}
+	}
+
+	for _, def := range defs[:500] {
+		table.Delete(def.Id)
+	}
+	if table.Len() != 500 {
+		t.Fatalf("expected 500 definitions after deleting, got %d", table.Len())
+	}
+	if _, ok := table.Get(defs[0].Id); ok {
+		t.Fatalf("expected %s to be deleted", defs[0].Id)
+	}
+
+	seen := make(map[schema.MKey]bool)
+	table.Range(func(key schema.MKey, def *schema.MetricDefinition) bool {
+		if key != def.Id {
+			t.Fatalf("key %s does not match id of definition %s", key, def.Id)
+		}
+		seen[key] = true
+		return true
+	})
+	if len(seen) != 500 {
+		t.Fatalf("expected Range to visit 500 definitions, visited %d", len(seen))
+	}
+
+	var visited int
+	table.Range(func(key schema.MKey, def *schema.MetricDefinition) bool {
+		visited++
+		return visited < 10
+	})
+	if visited != 10 {
+		t.Fatalf("expected Range to stop after 10 definitions, visited %d", visited)
+	}
+}
+
+func TestMetricLookupTableConcurrent(t *testing.T) {
+	table := NewMetricLookupTable()
+	var wg sync.WaitGroup
+	for w := 0; w < 8; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := w * 100; i < (w+1)*100; i++ {
+				def := getLookupTableDef(i)
+				table.Set(def.Id, def)
+				table.Get(def.Id)
+				table.Len()
+			}
+		}(w)
+	}
+	wg.Wait()
+	if table.Len() != 800 {
+		t.Fatalf("expected 800 definitions, got %d", table.Len())
+	}
+}
@@ -0,0 +1,31 @@
+package mdata
+
+import (
+	"sync"
+
+	"github.com/raintank/schema"
+)
+
+// MetricDataPool is a pool of MetricData objects, to avoid allocating
+// a new one for every metric that is ingested.
+// note that MetricData's obtained from the pool must not be referenced
+// anymore after they have been put back.
+type MetricDataPool struct {
+	pool sync.Pool
+}
+
+func NewMetricDataPool() *MetricDataPool {
+	return &MetricDataPool{pool: sync.Pool{
+		New: func() interface{} { return new(schema.MetricData) },
+	}}
+}
+
+func (p *MetricDataPool) Get() *schema.MetricData {
+	return p.pool.Get().(*schema.MetricData)
+}
+
+// Put clears all fields of the MetricData and puts it back in the pool
+func (p *MetricDataPool) Put(md *schema.MetricData) {
+	*md = schema.MetricData{}
+	p.pool.Put(md)
+}
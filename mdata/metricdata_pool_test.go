@@ -0,0 +1,73 @@
+package mdata
+
+import (
+	"testing"
+
+	"github.com/raintank/schema"
+)
+
+func TestMetricDataPoolPutClears(t *testing.T) {
+	pool := NewMetricDataPool()
+	md := pool.Get()
+	md.Name = "some.metric"
+	md.OrgId = 1
+	md.Tags = []string{"a=b"}
+	pool.Put(md)
+	if md.Name != "" || md.OrgId != 0 || md.Tags != nil {
+		t.Fatalf("expected Put to clear the MetricData, got %+v", md)
+	}
+}
+
+// mdSink makes sure the MetricData escapes to the heap, like it does when it's passed to the input handler
+var mdSink *schema.MetricData
+
+func getMetricDataMsg(b *testing.B) []byte {
+	md := schema.MetricData{
+		OrgId:    1,
+		Name:     "some.metric.name",
+		Interval: 10,
+		Value:    1.5,
+		Unit:     "ms",
+		Time:     1234567890,
+		Mtype:    "gauge",
+		Tags:     []string{"host=a", "dc=b"},
+	}
+	md.SetId()
+	data, err := md.MarshalMsg(nil)
+	if err != nil {
+		b.Fatal(err)
+	}
+	return data
+}
+
+// simulates the ingest path, decoding every incoming message into a new MetricData
+func BenchmarkMetricDataDecodeAlloc(b *testing.B) {
+	data := getMetricDataMsg(b)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		md := &schema.MetricData{}
+		_, err := md.UnmarshalMsg(data)
+		if err != nil {
+			b.Fatal(err)
+		}
+		mdSink = md
+	}
+}
+
+// simulates the ingest path, decoding every incoming message into a MetricData from the pool
+func BenchmarkMetricDataDecodePool(b *testing.B) {
+	data := getMetricDataMsg(b)
+	pool := NewMetricDataPool()
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		md := pool.Get()
+		_, err := md.UnmarshalMsg(data)
+		if err != nil {
+			b.Fatal(err)
+		}
+		mdSink = md
+		pool.Put(md)
+	}
+}
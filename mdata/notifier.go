@@ -1,7 +1,12 @@
 package mdata
 
 import (
+	"bytes"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
 
 	"github.com/raintank/schema"
 
@@ -23,13 +28,142 @@ type Notifier interface {
 }
 
 //PersistMessage format version
-const PersistMessageBatchV1 = 1
+const (
+	PersistMessageBatchV1 = 1 // json encoded
+	PersistMessageBatchV2 = 2 // msgp encoded, with binary encoded SavedChunks
+)
+
+var errEmptyPersistMessage = errors.New("empty persist message")
+
+// maxBatchSize is the maximum number of SavedChunks in a valid PersistMessageBatch.
+// notifiers flush their buffered chunks before they exceed it.
+const maxBatchSize = 5000
+
+//go:generate msgp
+//msgp:ignore DefaultNotifierHandler
+//msgp:ignore SavedChunk
+//msgp:ignore ValidationError
 
 type PersistMessageBatch struct {
 	Instance    string       `json:"instance"`
 	SavedChunks []SavedChunk `json:"saved_chunks"`
 }
 
+// FilterByOrg returns a new batch with only the chunks belonging to the given org.
+// chunks whose key can't be parsed are left out. the original batch is not modified.
+func (b *PersistMessageBatch) FilterByOrg(orgId uint32) *PersistMessageBatch {
+	filtered := &PersistMessageBatch{Instance: b.Instance}
+	for _, c := range b.SavedChunks {
+		amkey, err := schema.AMKeyFromString(c.Key)
+		if err != nil {
+			continue
+		}
+		if amkey.MKey.Org == orgId {
+			filtered.SavedChunks = append(filtered.SavedChunks, c)
+		}
+	}
+	return filtered
+}
+
+// GroupByOrg splits the batch into a batch per org, keeping the order of the chunks.
+// chunks whose key can't be parsed are left out, and returned as errors by key.
+// the original batch is not modified.
+func GroupByOrg(batch *PersistMessageBatch) (map[uint32]*PersistMessageBatch, map[string]error) {
+	grouped := make(map[uint32]*PersistMessageBatch)
+	var errs map[string]error
+	for _, c := range batch.SavedChunks {
+		amkey, err := schema.AMKeyFromString(c.Key)
+		if err != nil {
+			if errs == nil {
+				errs = make(map[string]error)
+			}
+			errs[c.Key] = err
+			continue
+		}
+		b, ok := grouped[amkey.MKey.Org]
+		if !ok {
+			b = &PersistMessageBatch{Instance: batch.Instance}
+			grouped[amkey.MKey.Org] = b
+		}
+		b.SavedChunks = append(b.SavedChunks, c)
+	}
+	return grouped, errs
+}
+
+// ValidationError lists all the problems found while validating a PersistMessageBatch
+type ValidationError []error
+
+func (v ValidationError) Error() string {
+	msgs := make([]string, len(v))
+	for i, err := range v {
+		msgs[i] = err.Error()
+	}
+	return fmt.Sprintf("invalid persist message batch: %s", strings.Join(msgs, "; "))
+}
+
+// Validate checks the integrity of a batch decoded from untrusted data.
+// it returns a ValidationError listing all violations, or nil if the batch is valid.
+func (b *PersistMessageBatch) Validate() error {
+	var errs ValidationError
+	if b.Instance == "" {
+		errs = append(errs, errors.New("instance is empty"))
+	}
+	for i := 0; i < len(b.Instance); i++ {
+		if b.Instance[i] < 0x20 || b.Instance[i] > 0x7e {
+			errs = append(errs, fmt.Errorf("instance %q contains non printable ASCII character at position %d", b.Instance, i))
+			break
+		}
+	}
+	if len(b.SavedChunks) > maxBatchSize {
+		errs = append(errs, fmt.Errorf("batch contains %d chunks, more than the maximum of %d", len(b.SavedChunks), maxBatchSize))
+	}
+	for i, c := range b.SavedChunks {
+		_, err := schema.AMKeyFromString(c.Key)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("chunk %d has invalid key %q: %s", i, c.Key, err))
+		}
+	}
+	if len(errs) == 0 {
+		return nil
+	}
+	return errs
+}
+
+// EncodePersistMessageBatch appends the batch to buf, in the format of the given PersistMessageBatch version,
+// prefixed with the version byte.
+// version 1 must be used as long as not all instances in the cluster understand version 2.
+func EncodePersistMessageBatch(buf []byte, version int, batch *PersistMessageBatch) ([]byte, error) {
+	buf = append(buf, uint8(version))
+	switch version {
+	case PersistMessageBatchV1:
+		w := bytes.NewBuffer(buf)
+		err := json.NewEncoder(w).Encode(batch)
+		return w.Bytes(), err
+	case PersistMessageBatchV2:
+		return batch.MarshalMsg(buf)
+	}
+	return buf, fmt.Errorf("unknown persist message version %d", version)
+}
+
+// DecodePersistMessageBatch decodes a PersistMessageBatch in any of the supported formats,
+// as identified by the version byte the data starts with.
+func DecodePersistMessageBatch(data []byte) (PersistMessageBatch, error) {
+	batch := PersistMessageBatch{}
+	if len(data) == 0 {
+		return batch, errEmptyPersistMessage
+	}
+	version := uint8(data[0])
+	switch version {
+	case uint8(PersistMessageBatchV1):
+		err := json.Unmarshal(data[1:], &batch)
+		return batch, err
+	case uint8(PersistMessageBatchV2):
+		_, err := batch.UnmarshalMsg(data[1:])
+		return batch, err
+	}
+	return batch, fmt.Errorf("unknown version %d", version)
+}
+
 // SavedChunk represents a chunk persisted to the store
 // Key is a stringified schema.AMKey
 type SavedChunk struct {
@@ -73,39 +207,35 @@ func (dn DefaultNotifierHandler) PartitionOf(key schema.MKey) (int32, bool) {
 }
 
 func (dn DefaultNotifierHandler) Handle(data []byte) {
-	version := uint8(data[0])
-	if version == uint8(PersistMessageBatchV1) {
-		batch := PersistMessageBatch{}
-		err := json.Unmarshal(data[1:], &batch)
+	batch, err := DecodePersistMessageBatch(data)
+	if err != nil {
+		log.Errorf("notifier: failed to decode batch message: %s -- skipping", err)
+		return
+	}
+	messagesReceived.Add(len(batch.SavedChunks))
+	for _, c := range batch.SavedChunks {
+		amkey, err := schema.AMKeyFromString(c.Key)
 		if err != nil {
-			log.Errorf("failed to unmarsh batch message: %s -- skipping", err)
-			return
+			log.Errorf("notifier: failed to convert %q to AMKey: %s -- skipping", c.Key, err)
+			continue
 		}
-		messagesReceived.Add(len(batch.SavedChunks))
-		for _, c := range batch.SavedChunks {
-			amkey, err := schema.AMKeyFromString(c.Key)
-			if err != nil {
-				log.Errorf("notifier: failed to convert %q to AMKey: %s -- skipping", c.Key, err)
-				continue
-			}
-			// we only need to handle saves for series that we know about.
-			// if the series is not in the index, then we dont need to worry about it.
-			def, ok := dn.idx.Get(amkey.MKey)
-			if !ok {
-				log.Debugf("notifier: skipping metric with MKey %s as it is not in the index", amkey.MKey)
-				continue
-			}
-			agg := dn.metrics.GetOrCreate(amkey.MKey, def.SchemaId, def.AggId)
-			if amkey.Archive != 0 {
-				consolidator := consolidation.FromArchive(amkey.Archive.Method())
-				aggSpan := amkey.Archive.Span()
-				agg.(*AggMetric).SyncAggregatedChunkSaveState(c.T0, consolidator, aggSpan)
-			} else {
-				agg.(*AggMetric).SyncChunkSaveState(c.T0)
-			}
+		if chunkDedup != nil {
+			chunkDedup.Add(amkey, c.T0, time.Now())
+		}
+		// we only need to handle saves for series that we know about.
+		// if the series is not in the index, then we dont need to worry about it.
+		def, ok := dn.idx.Get(amkey.MKey)
+		if !ok {
+			log.Debugf("notifier: skipping metric with MKey %s as it is not in the index", amkey.MKey)
+			continue
+		}
+		agg := dn.metrics.GetOrCreate(amkey.MKey, def.SchemaId, def.AggId)
+		if amkey.Archive != 0 {
+			consolidator := consolidation.FromArchive(amkey.Archive.Method())
+			aggSpan := amkey.Archive.Span()
+			agg.(*AggMetric).SyncAggregatedChunkSaveState(c.T0, consolidator, aggSpan)
+		} else {
+			agg.(*AggMetric).SyncChunkSaveState(c.T0)
 		}
-	} else {
-		log.Errorf("notifier: unknown version %d", version)
 	}
-	return
 }
@@ -0,0 +1,157 @@
+package notifierAMQP
+
+import (
+	"fmt"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	reconnectBackoffMin = time.Second
+	reconnectBackoffMax = time.Minute
+)
+
+// connection holds the amqp connection of a NotifierAMQP, with a channel to publish on
+// and one to consume from. any error on either of them closes the whole connection,
+// which is then replaced by a new one.
+type connection struct {
+	conn       *amqp.Connection
+	pub        *amqp.Channel
+	sub        *amqp.Channel
+	deliveries <-chan amqp.Delivery
+
+	// receive the error that closed the connection or one of its channels
+	connClosed chan *amqp.Error
+	pubClosed  chan *amqp.Error
+	subClosed  chan *amqp.Error
+}
+
+// connect connects to the broker, declares the exchange and our queue, binds the queue
+// to the routing keys of our partitions and starts consuming from it.
+func connect(cfg *NotifierAMQPConfig) (*connection, error) {
+	conn, err := amqp.Dial(cfg.URL)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect: %s", err)
+	}
+	c := &connection{conn: conn}
+	c.connClosed = conn.NotifyClose(make(chan *amqp.Error, 1))
+
+	c.pub, err = conn.Channel()
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("failed to open channel: %s", err)
+	}
+	c.pubClosed = c.pub.NotifyClose(make(chan *amqp.Error, 1))
+	err = c.pub.ExchangeDeclare(cfg.Exchange, cfg.ExchangeType, true, false, false, false, nil)
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("failed to declare exchange %s: %s", cfg.Exchange, err)
+	}
+	// the queue is durable and not auto-deleted, so that the messages published while
+	// we're down are waiting for us when we come back.
+	_, err = c.pub.QueueDeclare(cfg.queue, true, false, false, false, nil)
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("failed to declare queue %s: %s", cfg.queue, err)
+	}
+	for _, key := range cfg.bindingKeys() {
+		err = c.pub.QueueBind(cfg.queue, key, cfg.Exchange, false, nil)
+		if err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("failed to bind queue %s to %s with key %s: %s", cfg.queue, cfg.Exchange, key, err)
+		}
+	}
+
+	c.sub, err = conn.Channel()
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("failed to open channel: %s", err)
+	}
+	c.subClosed = c.sub.NotifyClose(make(chan *amqp.Error, 1))
+	err = c.sub.Qos(cfg.PrefetchCount, 0, false)
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("failed to set prefetch count: %s", err)
+	}
+	c.deliveries, err = c.sub.Consume(cfg.queue, "", false, false, false, false, nil)
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("failed to consume from queue %s: %s", cfg.queue, err)
+	}
+	return c, nil
+}
+
+// close closes the connection, along with its channels. this ends the deliveries.
+func (c *connection) close() {
+	if !c.conn.IsClosed() {
+		c.conn.Close()
+	}
+}
+
+// supervise replaces the connection when it, or one of its channels, gets closed,
+// until the notifier is stopped.
+func (c *NotifierAMQP) supervise() {
+	for {
+		conn := c.connection()
+		var err *amqp.Error
+		select {
+		case <-c.shutdown:
+			return
+		case err = <-conn.connClosed:
+		case err = <-conn.pubClosed:
+		case err = <-conn.subClosed:
+		}
+		select {
+		case <-c.shutdown:
+			// we closed the connection ourselves
+			return
+		default:
+		}
+		log.Warnf("amqp-cluster: connection lost: %v. reconnecting", err)
+		conn.close()
+		if !c.reestablish() {
+			return
+		}
+	}
+}
+
+// reestablish sets up a new connection, retrying with exponential backoff until
+// it succeeds or the notifier is stopped. it returns whether it succeeded.
+func (c *NotifierAMQP) reestablish() bool {
+	backoff := reconnectBackoffMin
+	for {
+		reconnects.Inc()
+		conn, err := connect(c.cfg)
+		if err == nil {
+			c.mu.Lock()
+			if c.stopped {
+				c.mu.Unlock()
+				conn.close()
+				return false
+			}
+			c.conn = conn
+			c.consume(conn)
+			c.mu.Unlock()
+			log.Info("amqp-cluster: reconnected")
+			return true
+		}
+		log.Errorf("amqp-cluster: failed to reconnect: %s. retrying in %s", err, backoff)
+		select {
+		case <-c.shutdown:
+			return false
+		case <-time.After(backoff):
+		}
+		backoff = nextBackoff(backoff)
+	}
+}
+
+// nextBackoff doubles the given backoff, up to reconnectBackoffMax
+func nextBackoff(backoff time.Duration) time.Duration {
+	backoff *= 2
+	if backoff > reconnectBackoffMax {
+		backoff = reconnectBackoffMax
+	}
+	return backoff
+}
@@ -0,0 +1,252 @@
+package notifierAMQP
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/grafana/metrictank/mdata"
+	amqp "github.com/rabbitmq/amqp091-go"
+	"github.com/raintank/schema"
+	log "github.com/sirupsen/logrus"
+)
+
+// NotifierAMQP publishes and consumes persist messages through an AMQP broker such as RabbitMQ.
+// persist messages are published with the partition of the metric as routing key, and every
+// instance consumes from its own queue, bound to the routing keys of the partitions it handles,
+// similar to how the kafka notifier uses kafka partitions.
+type NotifierAMQP struct {
+	cfg      *NotifierAMQPConfig
+	instance string
+	in       chan mdata.SavedChunk
+	buf      []mdata.SavedChunk
+	handler  mdata.NotifierHandler
+
+	mu      sync.Mutex
+	conn    *connection
+	stopped bool
+	wg      sync.WaitGroup // tracks the consumers of all connections
+
+	// number of messages received from, and acked to, the broker. accessed atomically.
+	// the difference is the number of messages we are handling.
+	received uint64
+	acked    uint64
+
+	shutdown chan struct{}
+	StopChan chan int
+}
+
+// New creates a NotifierAMQP based on the given config, which must have been processed already.
+// it blocks until the backlog of persist messages has been processed.
+func New(cfg *NotifierAMQPConfig, instance string, handler mdata.NotifierHandler) *NotifierAMQP {
+	conn, err := connect(cfg)
+	if err != nil {
+		log.Fatalf("amqp-cluster: %s", err)
+	}
+	log.Infof("amqp-cluster: consuming from queue %s bound to exchange %s with keys %v", cfg.queue, cfg.Exchange, cfg.bindingKeys())
+
+	c := &NotifierAMQP{
+		cfg:      cfg,
+		instance: instance,
+		in:       make(chan mdata.SavedChunk),
+		handler:  handler,
+		conn:     conn,
+		shutdown: make(chan struct{}),
+		StopChan: make(chan int),
+	}
+	c.consume(conn)
+	go c.supervise()
+
+	c.processBacklog()
+	go c.trackDepth()
+	go c.produce()
+
+	return c
+}
+
+// connection returns the current connection
+func (c *NotifierAMQP) connection() *connection {
+	c.mu.Lock()
+	conn := c.conn
+	c.mu.Unlock()
+	return conn
+}
+
+// consume starts handling the deliveries of the given connection.
+// the consumer ends when the connection gets closed.
+func (c *NotifierAMQP) consume(conn *connection) {
+	c.wg.Add(1)
+	go func() {
+		defer c.wg.Done()
+		for d := range conn.deliveries {
+			atomic.AddUint64(&c.received, 1)
+			log.Debugf("amqp-cluster: received message: RoutingKey: %s, DeliveryTag: %d", d.RoutingKey, d.DeliveryTag)
+			c.handler.Handle(d.Body)
+			// if the ack fails because the connection got lost, the message gets redelivered
+			// on the next connection. handling a persist message twice is harmless.
+			err := d.Ack(false)
+			if err != nil {
+				log.Warnf("amqp-cluster: failed to ack message: %s", err)
+			}
+			atomic.AddUint64(&c.acked, 1)
+		}
+	}()
+}
+
+// processBacklog waits for the messages that were queued while we were down to be processed.
+// This will block metrictank from consuming metrics until we have processed old metricPersist messages.
+// The end result is that we wont overwrite chunks in cassandra that have already been previously written.
+// We don't wait more than backlog-process-timeout for the backlog to be processed.
+// the queue depth only counts the messages that were not delivered yet. up to prefetch-count
+// messages may have been delivered to us but not handled yet, so once the queue is empty,
+// we also wait for our consumer to have handled all messages it received, and to not receive
+// any new ones for a tick.
+func (c *NotifierAMQP) processBacklog() {
+	pre := time.Now()
+	log.Info("amqp-cluster: waiting for metricPersist backlog to be processed.")
+	timer := time.NewTimer(c.cfg.backlogProcessTimeout)
+	defer timer.Stop()
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	var lastReceived uint64
+	for i := 0; ; i++ {
+		// we can only tell whether the consumer is idle once we watched it for a tick
+		received := atomic.LoadUint64(&c.received)
+		idle := i > 0 && received == lastReceived && atomic.LoadUint64(&c.acked) == received
+		lastReceived = received
+		depth, err := c.depth()
+		if err != nil {
+			log.Warnf("amqp-cluster: failed to get queue depth: %s", err)
+		} else {
+			queueDepth.Set(depth)
+			if depth == 0 && idle {
+				log.Infof("amqp-cluster: metricPersist backlog processed in %s.", time.Since(pre))
+				return
+			}
+		}
+		select {
+		case <-ticker.C:
+		case <-timer.C:
+			log.Warnf("amqp-cluster: Processing metricPersist backlog has taken too long, giving up lock after %s.", time.Since(pre))
+			return
+		}
+	}
+}
+
+// depth returns the number of messages in our queue that have not been delivered yet
+func (c *NotifierAMQP) depth() (int, error) {
+	q, err := c.connection().pub.QueueInspect(c.cfg.queue)
+	return q.Messages, err
+}
+
+// trackDepth periodically updates the queue depth metric, until the notifier is stopped
+func (c *NotifierAMQP) trackDepth() {
+	ticker := time.NewTicker(5 * time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-c.shutdown:
+			return
+		case <-ticker.C:
+			depth, err := c.depth()
+			if err != nil {
+				log.Errorf("amqp-cluster: failed to get queue depth: %s", err)
+				continue
+			}
+			queueDepth.Set(depth)
+		}
+	}
+}
+
+// Stop will initiate a graceful stop of the Consumer (permanent)
+//
+// NOTE: receive on StopChan to block until this process completes
+func (c *NotifierAMQP) Stop() {
+	c.mu.Lock()
+	c.stopped = true
+	close(c.shutdown)
+	c.conn.close()
+	c.mu.Unlock()
+	go func() {
+		c.wg.Wait()
+		close(c.StopChan)
+	}()
+}
+
+func (c *NotifierAMQP) Send(sc mdata.SavedChunk) {
+	c.in <- sc
+}
+
+func (c *NotifierAMQP) produce() {
+	ticker := time.NewTicker(time.Second)
+	max := 5000
+	for {
+		select {
+		case chunk := <-c.in:
+			c.buf = append(c.buf, chunk)
+			if len(c.buf) == max {
+				c.flush()
+			}
+		case <-ticker.C:
+			c.flush()
+		}
+	}
+}
+
+// flush makes sure the batch gets sent, asynchronously.
+// the chunks are published as one message per partition, with the partition as routing key.
+func (c *NotifierAMQP) flush() {
+	if len(c.buf) == 0 {
+		return
+	}
+	byPartition := make(map[int32][]mdata.SavedChunk)
+	for _, chunk := range c.buf {
+		amkey, err := schema.AMKeyFromString(chunk.Key)
+		if err != nil {
+			log.Errorf("amqp-cluster: failed to parse key %q", chunk.Key)
+			messagesDropped.Inc()
+			continue
+		}
+		partition, ok := c.handler.PartitionOf(amkey.MKey)
+		if !ok {
+			log.Errorf("amqp-cluster: failed to lookup metricDef with id %s", chunk.Key)
+			messagesDropped.Inc()
+			continue
+		}
+		byPartition[partition] = append(byPartition[partition], chunk)
+	}
+	c.buf = nil
+
+	for partition, chunks := range byPartition {
+		msg, err := mdata.EncodePersistMessageBatch(nil, c.cfg.PersistMessageVersion, &mdata.PersistMessageBatch{Instance: c.instance, SavedChunks: chunks})
+		if err != nil {
+			log.Fatalf("amqp-cluster: failed to marshal persistMessage: %s", err)
+		}
+		messagesSize.Value(len(msg))
+		go c.send(routingKey(partition), msg)
+	}
+}
+
+// send publishes the message with the given routing key, retrying until it succeeds
+// or the notifier is stopped. failures are expected while the connection is being reestablished.
+func (c *NotifierAMQP) send(key string, msg []byte) {
+	publishing := amqp.Publishing{
+		ContentType:  "application/octet-stream",
+		DeliveryMode: amqp.Persistent,
+		Body:         msg,
+	}
+	for {
+		err := c.connection().pub.Publish(c.cfg.Exchange, key, false, false, publishing)
+		if err == nil {
+			messagesPublished.Inc()
+			return
+		}
+		log.Warnf("amqp-cluster: failed to publish message with routing key %s: %s", key, err)
+		flushErrors.Inc()
+		select {
+		case <-c.shutdown:
+			return
+		case <-time.After(time.Second):
+		}
+	}
+}
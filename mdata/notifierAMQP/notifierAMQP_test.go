@@ -0,0 +1,105 @@
+package notifierAMQP
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/grafana/metrictank/mdata"
+	amqp "github.com/rabbitmq/amqp091-go"
+	"github.com/raintank/schema"
+)
+
+type recordingHandler struct {
+	messages [][]byte
+}
+
+func (h *recordingHandler) Handle(data []byte) {
+	h.messages = append(h.messages, data)
+}
+
+func (h *recordingHandler) PartitionOf(key schema.MKey) (int32, bool) {
+	return 0, false
+}
+
+type recordingAcknowledger struct {
+	acked []uint64
+}
+
+func (a *recordingAcknowledger) Ack(tag uint64, multiple bool) error {
+	a.acked = append(a.acked, tag)
+	return nil
+}
+
+func (a *recordingAcknowledger) Nack(tag uint64, multiple bool, requeue bool) error {
+	return nil
+}
+
+func (a *recordingAcknowledger) Reject(tag uint64, requeue bool) error {
+	return nil
+}
+
+func TestConsume(t *testing.T) {
+	chunks := []mdata.SavedChunk{
+		{Key: "1.01234567890123456789012345678901", T0: 600},
+		{Key: "1.01234567890123456789012345678901_sum_3600", T0: 3600},
+	}
+	exp := mdata.PersistMessageBatch{Instance: "mt1", SavedChunks: chunks}
+	handler := &recordingHandler{}
+	ack := &recordingAcknowledger{}
+	deliveries := make(chan amqp.Delivery)
+	c := &NotifierAMQP{handler: handler}
+	c.consume(&connection{deliveries: deliveries})
+
+	for i, version := range []int{mdata.PersistMessageBatchV1, mdata.PersistMessageBatchV2} {
+		msg, err := mdata.EncodePersistMessageBatch(nil, version, &exp)
+		if err != nil {
+			t.Fatalf("version %d: unexpected error encoding: %s", version, err)
+		}
+		deliveries <- amqp.Delivery{Acknowledger: ack, DeliveryTag: uint64(i + 1), Body: msg}
+	}
+	close(deliveries)
+	c.wg.Wait()
+
+	if len(handler.messages) != 2 {
+		t.Fatalf("expected 2 messages to be handled, got %d", len(handler.messages))
+	}
+	for _, msg := range handler.messages {
+		batch, err := mdata.DecodePersistMessageBatch(msg)
+		if err != nil {
+			t.Fatalf("failed to decode message: %s", err)
+		}
+		if !reflect.DeepEqual(batch, exp) {
+			t.Fatalf("expected %+v, got %+v", exp, batch)
+		}
+	}
+	if !reflect.DeepEqual(ack.acked, []uint64{1, 2}) {
+		t.Fatalf("expected deliveries 1 and 2 to be acked, got %v", ack.acked)
+	}
+	if c.received != 2 || c.acked != 2 {
+		t.Fatalf("expected 2 messages received and acked, got %d received and %d acked", c.received, c.acked)
+	}
+}
+
+func TestBindingKeys(t *testing.T) {
+	cases := []struct {
+		exchangeType string
+		partitions   string
+		exp          []string
+	}{
+		{"topic", "*", []string{"partition.*"}},
+		{"topic", "0", []string{"partition.0"}},
+		{"direct", "3, 1,12", []string{"partition.3", "partition.1", "partition.12"}},
+	}
+	for _, c := range cases {
+		cfg := NewNotifierAMQPConfig()
+		cfg.ExchangeType = c.exchangeType
+		cfg.Partitions = c.partitions
+		cfg.Process("mt1")
+		if got := cfg.bindingKeys(); !reflect.DeepEqual(got, c.exp) {
+			t.Fatalf("%s exchange with partitions %q: expected binding keys %v, got %v", c.exchangeType, c.partitions, c.exp, got)
+		}
+		if cfg.queue != "metricpersist.mt1" {
+			t.Fatalf("expected queue metricpersist.mt1, got %s", cfg.queue)
+		}
+	}
+}
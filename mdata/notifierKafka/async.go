@@ -0,0 +1,79 @@
+package notifierKafka
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/Shopify/sarama"
+	log "github.com/sirupsen/logrus"
+)
+
+// asyncBatch tracks the messages of a flush while the async producer publishes them,
+// so that the wal segments of the flush can be removed once all of them have been acknowledged.
+type asyncBatch struct {
+	pending  int32
+	segments []string
+}
+
+// sendAsync hands the messages to the async producer. handleAsync takes care of the results.
+func (c *NotifierKafka) sendAsync(payload []*sarama.ProducerMessage, segments []string) {
+	batch := &asyncBatch{
+		pending:  int32(len(payload)),
+		segments: segments,
+	}
+	for _, msg := range payload {
+		msg.Metadata = batch
+		c.produceAsync(msg)
+	}
+}
+
+// produceAsync hands the message to the async producer of the current connection.
+// while the connection is being replaced, it waits for the new one.
+func (c *NotifierKafka) produceAsync(msg *sarama.ProducerMessage) {
+	for !c.connection().produceAsync(msg) {
+		select {
+		case <-c.shutdown:
+			return
+		case <-time.After(time.Second):
+		}
+	}
+}
+
+// handleAsync starts processing the successes and errors of the async producer of the connection.
+// failed messages are retried, like the sync producer's are.
+// must be called before the connection can get closed.
+func (c *NotifierKafka) handleAsync(conn *connection) {
+	conn.asyncWg.Add(2)
+	go func() {
+		defer conn.asyncWg.Done()
+		for msg := range conn.asyncProducer.Successes() {
+			c.metrics.messagesPublished.Inc()
+			batch := msg.Metadata.(*asyncBatch)
+			if atomic.AddInt32(&batch.pending, -1) == 0 && c.wal != nil {
+				c.wal.remove(batch.segments)
+			}
+			c.bPool.Put([]byte(msg.Value.(sarama.ByteEncoder)))
+		}
+	}()
+	go func() {
+		defer conn.asyncWg.Done()
+		for perr := range conn.asyncProducer.Errors() {
+			log.Warnf("kafka-cluster: async publisher %s", perr.Err)
+			c.metrics.asyncProduceErrors.Inc()
+			c.requestReconnect(conn.generation)
+			// retry from a separate goroutine, so we keep draining the errors,
+			// which the producer needs to make progress.
+			go c.retryAsync(perr.Msg)
+		}
+	}()
+}
+
+// retryAsync hands a failed message to the async producer again, after a second
+func (c *NotifierKafka) retryAsync(msg *sarama.ProducerMessage) {
+	select {
+	case <-c.shutdown:
+		return
+	case <-time.After(time.Second):
+	}
+	c.produceAsync(msg)
+}
@@ -0,0 +1,97 @@
+package notifierKafka
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/grafana/metrictank/util"
+)
+
+// fakeAsyncProducer acknowledges all messages it is given, except for the first `failures` ones, which it fails.
+type fakeAsyncProducer struct {
+	failures  int
+	input     chan *sarama.ProducerMessage
+	successes chan *sarama.ProducerMessage
+	errors    chan *sarama.ProducerError
+}
+
+func newFakeAsyncProducer(failures int) *fakeAsyncProducer {
+	p := &fakeAsyncProducer{
+		failures:  failures,
+		input:     make(chan *sarama.ProducerMessage),
+		successes: make(chan *sarama.ProducerMessage),
+		errors:    make(chan *sarama.ProducerError),
+	}
+	go func() {
+		for msg := range p.input {
+			if p.failures > 0 {
+				p.failures--
+				p.errors <- &sarama.ProducerError{Msg: msg, Err: errors.New("leader not available")}
+				continue
+			}
+			p.successes <- msg
+		}
+		close(p.successes)
+		close(p.errors)
+	}()
+	return p
+}
+
+func (p *fakeAsyncProducer) AsyncClose()                               { close(p.input) }
+func (p *fakeAsyncProducer) Close() error                              { p.AsyncClose(); return nil }
+func (p *fakeAsyncProducer) Input() chan<- *sarama.ProducerMessage     { return p.input }
+func (p *fakeAsyncProducer) Successes() <-chan *sarama.ProducerMessage { return p.successes }
+func (p *fakeAsyncProducer) Errors() <-chan *sarama.ProducerError      { return p.errors }
+
+func TestSendAsync(t *testing.T) {
+	conn := &connection{
+		generation:    1,
+		asyncProducer: newFakeAsyncProducer(1),
+	}
+	c := &NotifierKafka{
+		bPool:     util.NewBufferPool(),
+		conn:      conn,
+		reconnect: make(chan uint64, 1),
+		shutdown:  make(chan struct{}),
+		metrics:   newNotifierMetrics("test.send-async"),
+	}
+	c.handleAsync(conn)
+
+	published := c.metrics.messagesPublished.Peek()
+	errs := c.metrics.asyncProduceErrors.Peek()
+	var payload []*sarama.ProducerMessage
+	for i := 0; i < 3; i++ {
+		payload = append(payload, &sarama.ProducerMessage{Value: sarama.ByteEncoder([]byte("chunk"))})
+	}
+	c.sendAsync(payload, nil)
+
+	// the failed message gets retried after a second, so wait for it to be published before closing
+	batch := payload[0].Metadata.(*asyncBatch)
+	for i := 0; i < 50; i++ {
+		if c.metrics.messagesPublished.Peek()-published == 3 {
+			break
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	conn.closeProducer()
+
+	if got := c.metrics.messagesPublished.Peek() - published; got != 3 {
+		t.Fatalf("expected 3 messages to be published, got %d", got)
+	}
+	if got := c.metrics.asyncProduceErrors.Peek() - errs; got != 1 {
+		t.Fatalf("expected 1 async produce error, got %d", got)
+	}
+	if batch.pending != 0 {
+		t.Fatalf("expected no pending messages in the batch, got %d", batch.pending)
+	}
+	select {
+	case generation := <-c.reconnect:
+		if generation != 1 {
+			t.Fatalf("expected a reconnect of generation 1, got %d", generation)
+		}
+	default:
+		t.Fatalf("expected a reconnect to be requested")
+	}
+}
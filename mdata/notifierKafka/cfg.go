@@ -3,6 +3,8 @@ package notifierKafka
 import (
 	"flag"
 	"fmt"
+	"math"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
@@ -10,138 +12,511 @@ import (
 	"github.com/Shopify/sarama"
 	"github.com/grafana/globalconf"
 	"github.com/grafana/metrictank/kafka"
+	"github.com/grafana/metrictank/mdata"
 	"github.com/grafana/metrictank/stats"
+	"github.com/prometheus/client_golang/prometheus"
 	log "github.com/sirupsen/logrus"
 )
 
-var Enabled bool
-var kafkaVersionStr string
-var brokerStr string
-var brokers []string
-var topic string
-var offsetStr string
-var config *sarama.Config
-var offsetDuration time.Duration
-var partitionStr string
-var partitions []int32
-var bootTimeOffsets map[int32]int64
-var backlogProcessTimeout time.Duration
-var backlogProcessTimeoutStr string
-var partitionOffset map[int32]*stats.Gauge64
-var partitionLogSize map[int32]*stats.Gauge64
-var partitionLag map[int32]*stats.Gauge64
+// NotifierKafkaConfig holds the settings of a NotifierKafka.
+// the exported fields are the user facing settings, Process validates them
+// and derives the settings that New needs.
+// it allows running multiple notifiers within the same process, each with their own config.
+type NotifierKafkaConfig struct {
+	Enabled                bool
+	KafkaVersion           string
+	Brokers                string
+	ProducerBrokers        string
+	ConsumerBrokers        string
+	Topic                  string
+	Partitions             string
+	Offset                 string
+	BacklogProcessTimeout  string
+	BacklogProcessTimeouts string
+	CreateTopicIfMissing   bool
+	TopicNumPartitions     int
+	TopicReplicationFactor int
+	TopicMinInsyncReplicas int
+	WALDirectory           string
+	PartitionCacheTTL      string
+	SigningKeys            string
+	ConsumeEnabled         bool
+	TagRoutes              string
+	CheckpointFile         string
+	CheckpointInterval     string
+	UseAsyncProducer       bool
+	PersistMessageVersion  int
+
+	// prefix of the names of the metrics of the notifier. defaults to cluster.notifier.kafka.
+	// notifiers in the same process must use different prefixes, or they share their metrics.
+	MetricPrefix string
+
+	// if set, New registers a LagCollector with it
+	Registerer prometheus.Registerer
+
+	// if set, determines the partitions persist messages are published to,
+	// instead of the handler. see TagRouter
+	PartitionResolver PartitionResolver
+
+	// version of the binary. if set, and the kafka version supports headers,
+	// it is added to the produced messages as the x-mt-version header
+	Version string
+
+	// set by Process
+	producerBrokers       []string
+	consumerBrokers       []string
+	topics                []string // the first one is the one we publish to
+	config                *sarama.Config
+	offsetDuration        time.Duration
+	partitions            []int32
+	bootTimeOffsets       map[string]map[int32]int64 // by topic and partition
+	backlogProcessTimeout time.Duration
+	partitionTimeouts     map[int32]time.Duration // per partition overrides of backlogProcessTimeout
+	partitionCacheTTL     time.Duration
+	signingKeys           [][]byte
+	tagRoutes             map[string]partitionRange
+	checkpointInterval    time.Duration
+	partitionOffset       map[string]map[int32]*stats.Gauge64 // by topic and partition
+	partitionLogSize      map[string]map[int32]*stats.Gauge64 // by topic and partition
+	partitionLag          map[string]map[int32]*stats.Gauge64 // by topic and partition
+}
+
+// return NotifierKafkaConfig with default values set.
+func NewNotifierKafkaConfig() *NotifierKafkaConfig {
+	return &NotifierKafkaConfig{
+		Enabled:                false,
+		KafkaVersion:           "2.0.0",
+		Brokers:                "kafka:9092",
+		ProducerBrokers:        "",
+		ConsumerBrokers:        "",
+		Topic:                  "metricpersist",
+		Partitions:             "*",
+		Offset:                 "newest",
+		BacklogProcessTimeout:  "60s",
+		BacklogProcessTimeouts: "",
+		CreateTopicIfMissing:   false,
+		TopicNumPartitions:     1,
+		TopicReplicationFactor: 1,
+		TopicMinInsyncReplicas: 1,
+		WALDirectory:           "",
+		PartitionCacheTTL:      "0s",
+		SigningKeys:            "",
+		ConsumeEnabled:         true,
+		TagRoutes:              "",
+		CheckpointFile:         "",
+		CheckpointInterval:     "30s",
+		UseAsyncProducer:       false,
+		PersistMessageVersion:  mdata.PersistMessageBatchV1,
+	}
+}
+
+// CliConfig is the config populated from the kafka-cluster flags / config section
+var CliConfig = NewNotifierKafkaConfig()
 
 var FlagSet *flag.FlagSet
 
-// metric cluster.notifier.kafka.messages-published is a counter of messages published to the kafka cluster notifier
-var messagesPublished = stats.NewCounter32("cluster.notifier.kafka.messages-published")
+// notifierMetrics holds the metrics of a NotifierKafka
+type notifierMetrics struct {
+	messagesPublished  *stats.Counter32
+	messagesDropped    *stats.Counter32
+	flushErrors        *stats.Counter32
+	authFailures       *stats.Counter32
+	reconnects         *stats.Counter32
+	asyncProduceErrors *stats.Counter32
+	messagesSize       *stats.Meter32
+}
 
-// metric cluster.notifier.kafka.message_size is the sizes seen of messages through the kafka cluster notifier
-var messagesSize = stats.NewMeter32("cluster.notifier.kafka.message_size", false)
+// newNotifierMetrics creates the metrics of a NotifierKafka, named after the given prefix
+func newNotifierMetrics(prefix string) notifierMetrics {
+	return notifierMetrics{
+		// metric cluster.notifier.kafka.messages-published is a counter of messages published to the kafka cluster notifier
+		messagesPublished: stats.NewCounter32(prefix + ".messages-published"),
+		// metric cluster.notifier.kafka.messages-dropped is a counter of messages that could not be published because their key or partition could not be determined
+		messagesDropped: stats.NewCounter32(prefix + ".messages-dropped"),
+		// metric cluster.notifier.kafka.flush-errors is a counter of failed attempts to publish messages to the kafka cluster notifier
+		flushErrors: stats.NewCounter32(prefix + ".flush-errors"),
+		// metric cluster.notifier.kafka.auth-failures is a counter of received messages that were dropped because their signature could not be verified
+		authFailures: stats.NewCounter32(prefix + ".auth-failures"),
+		// metric cluster.notifier.kafka.reconnects is a counter of attempts to reconnect to the kafka cluster after losing the connection
+		reconnects: stats.NewCounter32(prefix + ".reconnects"),
+		// metric cluster.notifier.kafka.async-produce-errors is a counter of messages that the async producer failed to publish. they are retried
+		asyncProduceErrors: stats.NewCounter32(prefix + ".async-produce-errors"),
+		// metric cluster.notifier.kafka.message_size is the sizes seen of messages through the kafka cluster notifier
+		messagesSize: stats.NewMeter32(prefix+".message_size", false),
+	}
+}
 
 func init() {
 	FlagSet = flag.NewFlagSet("kafka-cluster", flag.ExitOnError)
-	FlagSet.BoolVar(&Enabled, "enabled", false, "")
-	FlagSet.StringVar(&brokerStr, "brokers", "kafka:9092", "tcp address for kafka (may be given multiple times as comma separated list)")
-	FlagSet.StringVar(&kafkaVersionStr, "kafka-version", "2.0.0", "Kafka version in semver format. All brokers must be this version or newer.")
-	FlagSet.StringVar(&topic, "topic", "metricpersist", "kafka topic")
-	FlagSet.StringVar(&partitionStr, "partitions", "*", "kafka partitions to consume. use '*' or a comma separated list of id's. This should match the partitions used for kafka-mdm-in")
-	FlagSet.StringVar(&offsetStr, "offset", "newest", "Set the offset to start consuming from. Can be oldest, newest or a time duration")
-	FlagSet.StringVar(&backlogProcessTimeoutStr, "backlog-process-timeout", "60s", "Maximum time backlog processing can block during metrictank startup. Setting to a low value may result in data loss")
+	FlagSet.BoolVar(&CliConfig.Enabled, "enabled", CliConfig.Enabled, "")
+	FlagSet.StringVar(&CliConfig.Brokers, "brokers", CliConfig.Brokers, "tcp address for kafka (may be given multiple times as comma separated list)")
+	FlagSet.StringVar(&CliConfig.ProducerBrokers, "producer-brokers", CliConfig.ProducerBrokers, "tcp address of the kafka cluster to publish to, if different from brokers (may be given multiple times as a comma-separated list)")
+	FlagSet.StringVar(&CliConfig.ConsumerBrokers, "consumer-brokers", CliConfig.ConsumerBrokers, "tcp address of the kafka cluster to consume from, if different from brokers (may be given multiple times as a comma-separated list)")
+	FlagSet.StringVar(&CliConfig.KafkaVersion, "kafka-version", CliConfig.KafkaVersion, "Kafka version in semver format. All brokers must be this version or newer.")
+	FlagSet.StringVar(&CliConfig.Topic, "topic", CliConfig.Topic, "kafka topic (may be given multiple times as a comma-separated list). persist messages are published to the first one and consumed from all of them")
+	FlagSet.StringVar(&CliConfig.Partitions, "partitions", CliConfig.Partitions, "kafka partitions to consume. use '*' or a comma separated list of id's. This should match the partitions used for kafka-mdm-in")
+	FlagSet.StringVar(&CliConfig.Offset, "offset", CliConfig.Offset, "Set the offset to start consuming from. Can be oldest, newest, checkpoint (resume from checkpoint-file) or a time duration")
+	FlagSet.StringVar(&CliConfig.BacklogProcessTimeout, "backlog-process-timeout", CliConfig.BacklogProcessTimeout, "Maximum time backlog processing can block during metrictank startup. Setting to a low value may result in data loss")
+	FlagSet.StringVar(&CliConfig.BacklogProcessTimeouts, "backlog-process-timeouts", CliConfig.BacklogProcessTimeouts, "backlog-process-timeout overrides for specific partitions, e.g. for high volume ones, as a comma separated list of partition:timeout. e.g. 3:120s,7:90s")
+	FlagSet.BoolVar(&CliConfig.CreateTopicIfMissing, "create-topic-if-missing", CliConfig.CreateTopicIfMissing, "create the topic at startup if it does not exist yet")
+	FlagSet.IntVar(&CliConfig.TopicNumPartitions, "topic-num-partitions", CliConfig.TopicNumPartitions, "number of partitions to use when creating the topic")
+	FlagSet.IntVar(&CliConfig.TopicReplicationFactor, "topic-replication-factor", CliConfig.TopicReplicationFactor, "replication factor to use when creating the topic")
+	FlagSet.IntVar(&CliConfig.TopicMinInsyncReplicas, "topic-min-insync-replicas", CliConfig.TopicMinInsyncReplicas, "min.insync.replicas setting to use when creating the topic. must not exceed topic-replication-factor")
+	FlagSet.StringVar(&CliConfig.WALDirectory, "wal-directory", CliConfig.WALDirectory, "directory to persist messages in until kafka acknowledged them, so they survive a restart. empty to disable")
+	FlagSet.StringVar(&CliConfig.PartitionCacheTTL, "partition-cache-ttl", CliConfig.PartitionCacheTTL, "how long to cache the partition of a metric when publishing persist messages, to avoid index lookups. 0 to disable")
+	FlagSet.StringVar(&CliConfig.SigningKeys, "signing-keys", CliConfig.SigningKeys, "comma separated list of secrets to sign persist messages with (HMAC-SHA256). the first key is used for signing, all keys are tried for verification, which allows rotating keys. must be set on all instances or none. empty to disable")
+	FlagSet.BoolVar(&CliConfig.ConsumeEnabled, "consume-enabled", CliConfig.ConsumeEnabled, "consume persist messages from other instances. can be disabled on instances that only need to publish them, such as dedicated write nodes")
+	FlagSet.StringVar(&CliConfig.TagRoutes, "tag-routes", CliConfig.TagRoutes, "publish the persist messages of metrics with the given tags to the given partitions, instead of the partition of the metric, as a comma separated list of tag=value:partitions. e.g. env=prod:0-3,env=dev:4-7. only use this if metrics are partitioned the same way when ingested. empty to disable")
+	FlagSet.StringVar(&CliConfig.CheckpointFile, "checkpoint-file", CliConfig.CheckpointFile, "file to periodically save the consumed offsets to, so that offset = checkpoint can resume from them after a restart. empty to disable")
+	FlagSet.StringVar(&CliConfig.CheckpointInterval, "checkpoint-interval", CliConfig.CheckpointInterval, "how often to save the consumed offsets to checkpoint-file")
+	FlagSet.BoolVar(&CliConfig.UseAsyncProducer, "use-async-producer", CliConfig.UseAsyncProducer, "publish with an async producer, which doesn't wait for a batch of messages to be acknowledged before sending the next one. for very high throughput. messages are still retried until they are acknowledged")
+	FlagSet.IntVar(&CliConfig.PersistMessageVersion, "persist-message-version", CliConfig.PersistMessageVersion, "format of the published persist messages. 1 for json, 2 for the more compact and faster msgp. only use 2 once all instances of the cluster run a version that understands it")
 	globalconf.Register("kafka-cluster", FlagSet, flag.ExitOnError)
 }
 
 func ConfigProcess(instance string) {
-	if !Enabled {
+	if !CliConfig.Enabled {
 		return
 	}
+	CliConfig.Process(instance)
+}
 
-	kafkaVersion, err := sarama.ParseKafkaVersion(kafkaVersionStr)
+// Process validates the config and prepares it for use by New
+func (cfg *NotifierKafkaConfig) Process(instance string) {
+	kafkaVersion, err := sarama.ParseKafkaVersion(cfg.KafkaVersion)
 	if err != nil {
 		log.Fatalf("kafka-cluster: invalid kafka-version. %s", err)
 	}
 
-	switch offsetStr {
+	if cfg.PersistMessageVersion != mdata.PersistMessageBatchV1 && cfg.PersistMessageVersion != mdata.PersistMessageBatchV2 {
+		log.Fatalf("kafka-cluster: invalid persist-message-version %d. must be %d or %d", cfg.PersistMessageVersion, mdata.PersistMessageBatchV1, mdata.PersistMessageBatchV2)
+	}
+
+	switch cfg.Offset {
 	case "oldest":
 	case "newest":
+	case "checkpoint":
+		if cfg.CheckpointFile == "" {
+			log.Fatalf("kafka-cluster: offset checkpoint requires checkpoint-file to be set")
+		}
 	default:
-		offsetDuration, err = time.ParseDuration(offsetStr)
+		cfg.offsetDuration, err = time.ParseDuration(cfg.Offset)
 		if err != nil {
 			log.Fatalf("kafka-cluster: invalid offest format. %s", err)
 		}
 	}
-	brokers = strings.Split(brokerStr, ",")
+	cfg.producerBrokers = strings.Split(cfg.Brokers, ",")
+	if cfg.ProducerBrokers != "" {
+		cfg.producerBrokers = strings.Split(cfg.ProducerBrokers, ",")
+	}
+	cfg.consumerBrokers = strings.Split(cfg.Brokers, ",")
+	if cfg.ConsumerBrokers != "" {
+		cfg.consumerBrokers = strings.Split(cfg.ConsumerBrokers, ",")
+	}
+	cfg.topics = strings.Split(cfg.Topic, ",")
+	seenTopics := make(map[string]bool)
+	for _, topic := range cfg.topics {
+		if topic == "" || seenTopics[topic] {
+			log.Fatalf("kafka-cluster: invalid topic %q. topic must be a comma separated list of unique topic names", topic)
+		}
+		seenTopics[topic] = true
+	}
 
-	config = sarama.NewConfig()
-	config.ClientID = instance + "-cluster"
-	config.Version = kafkaVersion
-	config.Producer.RequiredAcks = sarama.WaitForAll // Wait for all in-sync replicas to ack the message
-	config.Producer.Retry.Max = 10                   // Retry up to 10 times to produce the message
-	config.Producer.Compression = sarama.CompressionSnappy
-	config.Producer.Return.Successes = true
-	config.Producer.Partitioner = sarama.NewManualPartitioner
-	err = config.Validate()
+	cfg.config = sarama.NewConfig()
+	cfg.config.ClientID = instance + "-cluster"
+	cfg.config.Version = kafkaVersion
+	cfg.config.Producer.RequiredAcks = sarama.WaitForAll // Wait for all in-sync replicas to ack the message
+	cfg.config.Producer.Retry.Max = 10                   // Retry up to 10 times to produce the message
+	cfg.config.Producer.Compression = sarama.CompressionSnappy
+	cfg.config.Producer.Return.Successes = true
+	cfg.config.Producer.Partitioner = sarama.NewManualPartitioner
+	err = cfg.config.Validate()
 	if err != nil {
 		log.Fatalf("kafka-cluster: invalid consumer config: %s", err)
 	}
 
-	backlogProcessTimeout, err = time.ParseDuration(backlogProcessTimeoutStr)
+	cfg.backlogProcessTimeout, err = time.ParseDuration(cfg.BacklogProcessTimeout)
 	if err != nil {
 		log.Fatalf("kafka-cluster: unable to parse backlog-process-timeout. %s", err)
 	}
+	if cfg.BacklogProcessTimeouts != "" {
+		for _, spec := range strings.Split(cfg.BacklogProcessTimeouts, ",") {
+			parts := strings.SplitN(spec, ":", 2)
+			if len(parts) != 2 {
+				log.Fatalf("kafka-cluster: invalid backlog-process-timeouts entry %q. must be partition:timeout", spec)
+			}
+			partition, err := strconv.Atoi(parts[0])
+			if err != nil {
+				log.Fatalf("kafka-cluster: invalid partition in backlog-process-timeouts entry %q. %s", spec, err)
+			}
+			timeout, err := time.ParseDuration(parts[1])
+			if err != nil {
+				log.Fatalf("kafka-cluster: invalid timeout in backlog-process-timeouts entry %q. %s", spec, err)
+			}
+			cfg.PartitionBacklogTimeout(int32(partition), timeout)
+		}
+	}
+
+	cfg.checkpointInterval, err = time.ParseDuration(cfg.CheckpointInterval)
+	if err != nil || cfg.checkpointInterval <= 0 {
+		log.Fatalf("kafka-cluster: checkpoint-interval must be a positive duration")
+	}
+
+	cfg.partitionCacheTTL, err = time.ParseDuration(cfg.PartitionCacheTTL)
+	if err != nil {
+		log.Fatalf("kafka-cluster: unable to parse partition-cache-ttl. %s", err)
+	}
+
+	if cfg.SigningKeys != "" {
+		for _, key := range strings.Split(cfg.SigningKeys, ",") {
+			if key == "" {
+				log.Fatalf("kafka-cluster: signing-keys must not contain empty keys")
+			}
+			cfg.signingKeys = append(cfg.signingKeys, []byte(key))
+		}
+	}
+
+	if cfg.TagRoutes != "" {
+		cfg.tagRoutes, err = parseTagRoutes(cfg.TagRoutes)
+		if err != nil {
+			log.Fatalf("kafka-cluster: invalid tag-routes. %s", err)
+		}
+	}
 
-	if partitionStr != "*" {
-		parts := strings.Split(partitionStr, ",")
+	if cfg.CreateTopicIfMissing {
+		if cfg.TopicNumPartitions < 1 {
+			log.Fatalf("kafka-cluster: topic-num-partitions must be >= 1")
+		}
+		if cfg.TopicReplicationFactor < 1 || cfg.TopicReplicationFactor > math.MaxInt16 {
+			log.Fatalf("kafka-cluster: topic-replication-factor must be between 1 and %d", math.MaxInt16)
+		}
+		if cfg.TopicMinInsyncReplicas < 1 || cfg.TopicMinInsyncReplicas > cfg.TopicReplicationFactor {
+			log.Fatalf("kafka-cluster: topic-min-insync-replicas must be >= 1 and <= topic-replication-factor (%d)", cfg.TopicReplicationFactor)
+		}
+	}
+
+	if cfg.Partitions != "*" {
+		parts := strings.Split(cfg.Partitions, ",")
 		for _, part := range parts {
 			i, err := strconv.Atoi(part)
 			if err != nil {
 				log.Fatalf("kafka-cluster: could not parse partition %q. partitions must be '*' or a comma separated list of id's", part)
 			}
-			partitions = append(partitions, int32(i))
+			cfg.partitions = append(cfg.partitions, int32(i))
 		}
 	}
 	// validate our partitions
-	client, err := sarama.NewClient(brokers, config)
+	client, err := sarama.NewClient(cfg.consumerBrokers, cfg.config)
 	if err != nil {
 		log.Fatalf("kafka-cluster: failed to create client. %s", err)
 	}
 	defer client.Close()
 
-	availParts, err := kafka.GetPartitions(client, []string{topic})
+	if cfg.CreateTopicIfMissing {
+		for _, topic := range cfg.topics {
+			err = cfg.createTopic(client, cfg.consumerBrokers, topic)
+			if err != nil {
+				log.Fatalf("kafka-cluster: %s", err.Error())
+			}
+		}
+		if cfg.SeparateClusters() {
+			producerClient, err := sarama.NewClient(cfg.producerBrokers, cfg.config)
+			if err != nil {
+				log.Fatalf("kafka-cluster: failed to create producer client. %s", err)
+			}
+			err = cfg.createTopic(producerClient, cfg.producerBrokers, cfg.publishTopic())
+			producerClient.Close()
+			if err != nil {
+				log.Fatalf("kafka-cluster: %s", err.Error())
+			}
+		}
+	}
+
+	availParts, err := kafka.GetPartitions(client, cfg.topics)
 	if err != nil {
 		log.Fatalf("kafka-cluster: %s", err.Error())
 	}
-	if partitionStr == "*" {
-		partitions = availParts
+	if cfg.Partitions == "*" {
+		cfg.partitions = availParts
 	} else {
-		missing := kafka.DiffPartitions(partitions, availParts)
+		missing := kafka.DiffPartitions(cfg.partitions, availParts)
 		if len(missing) > 0 {
 			log.Fatalf("kafka-cluster: configured partitions not in list of available partitions. missing %v", missing)
 		}
 	}
+	for tag, route := range cfg.tagRoutes {
+		missing := kafka.DiffPartitions(route.partitions(), availParts)
+		if len(missing) > 0 {
+			log.Fatalf("kafka-cluster: tag-routes partitions for %s not in list of available partitions. missing %v", tag, missing)
+		}
+	}
+
+	if !cfg.ConsumeEnabled {
+		log.Info("kafka-cluster: consuming disabled, only publishing persist messages")
+		return
+	}
 
 	// initialize our offset metrics
-	partitionOffset = make(map[int32]*stats.Gauge64)
-	partitionLogSize = make(map[int32]*stats.Gauge64)
-	partitionLag = make(map[int32]*stats.Gauge64)
+	cfg.partitionOffset = make(map[string]map[int32]*stats.Gauge64)
+	cfg.partitionLogSize = make(map[string]map[int32]*stats.Gauge64)
+	cfg.partitionLag = make(map[string]map[int32]*stats.Gauge64)
 
 	// get the "newest" offset for all partitions.
 	// when booting up, we will delay consuming metrics until we have
 	// caught up to these offsets.
-	bootTimeOffsets = make(map[int32]int64)
-	for _, part := range partitions {
-		offset, err := client.GetOffset(topic, part, sarama.OffsetNewest)
-		if err != nil {
-			log.Fatalf("kafka-cluster: failed to get newest offset for topic %s part %d: %s", topic, part, err)
-		}
-		bootTimeOffsets[part] = offset
-		// metric cluster.notifier.kafka.partition.%d.offset is the current offset for the partition (%d) that we have consumed
-		partitionOffset[part] = stats.NewGauge64(fmt.Sprintf("cluster.notifier.kafka.partition.%d.offset", part))
-		// metric cluster.notifier.kafka.partition.%d.log_size is the size of the kafka partition (%d), aka the newest available offset.
-		partitionLogSize[part] = stats.NewGauge64(fmt.Sprintf("cluster.notifier.kafka.partition.%d.log_size", part))
-		// metric cluster.notifier.kafka.partition.%d.lag is how many messages (mechunkWriteRequestsrics) there are in the kafka
-		// partition (%d) that we have not yet consumed.
-		partitionLag[part] = stats.NewGauge64(fmt.Sprintf("cluster.notifier.kafka.partition.%d.lag", part))
-	}
-	log.Infof("kafka-cluster: consuming from partitions %v", partitions)
+	cfg.bootTimeOffsets = make(map[string]map[int32]int64)
+	for _, topic := range cfg.topics {
+		cfg.partitionOffset[topic] = make(map[int32]*stats.Gauge64)
+		cfg.partitionLogSize[topic] = make(map[int32]*stats.Gauge64)
+		cfg.partitionLag[topic] = make(map[int32]*stats.Gauge64)
+		cfg.bootTimeOffsets[topic] = make(map[int32]int64)
+		prefix := cfg.metricPrefix(topic)
+		for _, part := range cfg.partitions {
+			offset, err := client.GetOffset(topic, part, sarama.OffsetNewest)
+			if err != nil {
+				log.Fatalf("kafka-cluster: failed to get newest offset for topic %s part %d: %s", topic, part, err)
+			}
+			cfg.bootTimeOffsets[topic][part] = offset
+			// metric cluster.notifier.kafka.partition.%d.offset is the current offset for the partition (%d) that we have consumed
+			// metric cluster.notifier.kafka.topic.%s.partition.%d.offset is the current offset for the partition (%d) of an additional topic (%s) that we have consumed
+			cfg.partitionOffset[topic][part] = stats.NewGauge64(fmt.Sprintf("%s.partition.%d.offset", prefix, part))
+			// metric cluster.notifier.kafka.partition.%d.log_size is the size of the kafka partition (%d), aka the newest available offset.
+			// metric cluster.notifier.kafka.topic.%s.partition.%d.log_size is the size of the kafka partition (%d) of an additional topic (%s), aka the newest available offset.
+			cfg.partitionLogSize[topic][part] = stats.NewGauge64(fmt.Sprintf("%s.partition.%d.log_size", prefix, part))
+			// metric cluster.notifier.kafka.partition.%d.lag is how many messages (mechunkWriteRequestsrics) there are in the kafka
+			// partition (%d) that we have not yet consumed.
+			// metric cluster.notifier.kafka.topic.%s.partition.%d.lag is how many messages there are in the kafka partition (%d)
+			// of an additional topic (%s) that we have not yet consumed.
+			cfg.partitionLag[topic][part] = stats.NewGauge64(fmt.Sprintf("%s.partition.%d.lag", prefix, part))
+		}
+	}
+	log.Infof("kafka-cluster: consuming from topics %v partitions %v", cfg.topics, cfg.partitions)
+}
+
+// publishTopic returns the topic we publish persist messages to
+func (cfg *NotifierKafkaConfig) publishTopic() string {
+	return cfg.topics[0]
+}
+
+// metricPrefix returns the prefix of the offset metrics of the partitions of the topic.
+// only the metrics of additional topics include the topic name, so the metrics of
+// the topic we publish to keep the names they had before multiple topics were supported.
+func (cfg *NotifierKafkaConfig) metricPrefix(topic string) string {
+	if topic == cfg.publishTopic() {
+		return cfg.notifierMetricPrefix()
+	}
+	return cfg.notifierMetricPrefix() + ".topic." + strings.Replace(topic, ".", "_", -1)
+}
+
+// notifierMetricPrefix returns the prefix of the metrics of the notifier
+func (cfg *NotifierKafkaConfig) notifierMetricPrefix() string {
+	if cfg.MetricPrefix == "" {
+		return "cluster.notifier.kafka"
+	}
+	return cfg.MetricPrefix
+}
+
+// PartitionBacklogTimeout sets the backlog process timeout of the given partition,
+// overriding backlog-process-timeout. it can be used for partitions that take longer
+// to process their backlog, such as high-volume ones.
+func (cfg *NotifierKafkaConfig) PartitionBacklogTimeout(partition int32, d time.Duration) {
+	if cfg.partitionTimeouts == nil {
+		cfg.partitionTimeouts = make(map[int32]time.Duration)
+	}
+	cfg.partitionTimeouts[partition] = d
+}
+
+// partitionBacklogProcessTimeout returns the backlog process timeout of the given partition
+func (cfg *NotifierKafkaConfig) partitionBacklogProcessTimeout(partition int32) time.Duration {
+	if d, ok := cfg.partitionTimeouts[partition]; ok {
+		return d
+	}
+	return cfg.backlogProcessTimeout
+}
+
+// PartitionLag describes how far behind we are in consuming a partition of a topic
+type PartitionLag struct {
+	Topic     string `json:"topic"`
+	Partition int32  `json:"partition"`
+	Offset    int64  `json:"offset"`
+	LogSize   int64  `json:"logSize"`
+	Lag       int64  `json:"lag"`
+}
+
+// Lags returns the lag of the partitions we consume, sorted by topic and partition.
+// it is empty if consuming is disabled.
+func (cfg *NotifierKafkaConfig) Lags() []PartitionLag {
+	var lags []PartitionLag
+	for _, topic := range cfg.topics {
+		for _, partition := range cfg.partitions {
+			lag, ok := cfg.partitionLag[topic][partition]
+			if !ok {
+				continue
+			}
+			// the gauges store ints, which may be negative
+			lags = append(lags, PartitionLag{
+				Topic:     topic,
+				Partition: partition,
+				Offset:    int64(cfg.partitionOffset[topic][partition].Peek()),
+				LogSize:   int64(cfg.partitionLogSize[topic][partition].Peek()),
+				Lag:       int64(lag.Peek()),
+			})
+		}
+	}
+	if lags == nil {
+		return []PartitionLag{}
+	}
+	sort.Slice(lags, func(i, j int) bool {
+		if lags[i].Topic != lags[j].Topic {
+			return lags[i].Topic < lags[j].Topic
+		}
+		return lags[i].Partition < lags[j].Partition
+	})
+	return lags
+}
+
+// SeparateClusters returns whether we produce to a different kafka cluster than we consume from
+func (cfg *NotifierKafkaConfig) SeparateClusters() bool {
+	return strings.Join(cfg.producerBrokers, ",") != strings.Join(cfg.consumerBrokers, ",")
+}
+
+// createTopic creates the topic on the cluster of the given client and brokers, using the configured number
+// of partitions, replication factor and min.insync.replicas, unless it already exists.
+func (cfg *NotifierKafkaConfig) createTopic(client sarama.Client, brokers []string, topic string) error {
+	topics, err := client.Topics()
+	if err != nil {
+		return fmt.Errorf("failed to list topics: %s", err)
+	}
+	for _, t := range topics {
+		if t == topic {
+			return nil
+		}
+	}
+
+	admin, err := sarama.NewClusterAdmin(brokers, cfg.config)
+	if err != nil {
+		return fmt.Errorf("failed to create cluster admin: %s", err)
+	}
+	defer admin.Close()
+
+	minInsyncReplicas := strconv.Itoa(cfg.TopicMinInsyncReplicas)
+	detail := &sarama.TopicDetail{
+		NumPartitions:     int32(cfg.TopicNumPartitions),
+		ReplicationFactor: int16(cfg.TopicReplicationFactor),
+		ConfigEntries: map[string]*string{
+			"min.insync.replicas": &minInsyncReplicas,
+		},
+	}
+	err = admin.CreateTopic(topic, detail, false)
+	if err == sarama.ErrTopicAlreadyExists {
+		// another instance created it in the meantime
+		return client.RefreshMetadata(topic)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to create topic %s: %s", topic, err)
+	}
+	log.Infof("kafka-cluster: created topic %s with %d partitions, replication factor %d and min.insync.replicas %d", topic, cfg.TopicNumPartitions, cfg.TopicReplicationFactor, cfg.TopicMinInsyncReplicas)
+
+	// make sure the client sees the new topic
+	return client.RefreshMetadata(topic)
 }
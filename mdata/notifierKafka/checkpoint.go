@@ -0,0 +1,104 @@
+package notifierKafka
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"time"
+
+	"github.com/Shopify/sarama"
+	log "github.com/sirupsen/logrus"
+)
+
+// loadCheckpoint reads the offsets to resume consuming from, by topic and partition.
+// a missing checkpoint file results in no offsets.
+func loadCheckpoint(path string) (map[string]map[int32]int64, error) {
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	offsets := make(map[string]map[int32]int64)
+	err = json.Unmarshal(data, &offsets)
+	return offsets, err
+}
+
+// writeCheckpoint persists the offsets to resume consuming from, by topic and partition.
+// it writes to a temporary file first, so that it replaces the checkpoint atomically.
+func writeCheckpoint(path string, offsets map[string]map[int32]int64) error {
+	data, err := json.Marshal(offsets)
+	if err != nil {
+		return err
+	}
+	tmp := filepath.Join(filepath.Dir(path), "."+filepath.Base(path)+".tmp")
+	err = ioutil.WriteFile(tmp, data, 0644)
+	if err == nil {
+		err = os.Rename(tmp, path)
+	}
+	if err != nil {
+		os.Remove(tmp)
+	}
+	return err
+}
+
+// nextOffsets returns the offsets of the next messages to consume, by topic and partition.
+// partitions for which we don't know the offset yet are left out.
+func (c *NotifierKafka) nextOffsets() map[string]map[int32]int64 {
+	offsets := make(map[string]map[int32]int64, len(c.nextOffset))
+	for topic, partitions := range c.nextOffset {
+		offsets[topic] = make(map[int32]int64, len(partitions))
+		for partition, next := range partitions {
+			offset := atomic.LoadInt64(next)
+			if offset >= 0 {
+				offsets[topic][partition] = offset
+			}
+		}
+	}
+	return offsets
+}
+
+// checkpointOffset returns the offset to resume consuming the partition of the topic from, per the checkpoint.
+// it falls back to the oldest offset if the partition is not in the checkpoint, or if kafka
+// no longer has the checkpointed offset.
+func (c *NotifierKafka) checkpointOffset(conn *connection, topic string, partition int32) int64 {
+	offset, ok := c.checkpoint[topic][partition]
+	if !ok {
+		log.Warnf("kafka-cluster: no checkpointed offset for partition %s:%d -> will use oldest instead", topic, partition)
+		return sarama.OffsetOldest
+	}
+	oldest, err := conn.client.GetOffset(topic, partition, sarama.OffsetOldest)
+	if err != nil {
+		log.Warnf("kafka-cluster: failed to get oldest offset of partition %s:%d: %s -> will use checkpointed offset %d", topic, partition, err, offset)
+		return offset
+	}
+	if offset < oldest {
+		log.Warnf("kafka-cluster: checkpointed offset %d of partition %s:%d is no longer available -> will use oldest instead", offset, topic, partition)
+		return sarama.OffsetOldest
+	}
+	return offset
+}
+
+// writeCheckpoints periodically persists the offsets we consumed up to, until the notifier is stopped
+func (c *NotifierKafka) writeCheckpoints() {
+	ticker := time.NewTicker(c.cfg.checkpointInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-c.shutdown:
+			return
+		case <-ticker.C:
+			c.saveCheckpoint()
+		}
+	}
+}
+
+func (c *NotifierKafka) saveCheckpoint() {
+	err := writeCheckpoint(c.cfg.CheckpointFile, c.nextOffsets())
+	if err != nil {
+		log.Errorf("kafka-cluster: failed to write offset checkpoint: %s", err)
+	}
+}
@@ -0,0 +1,69 @@
+package notifierKafka
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestCheckpointWriteLoad(t *testing.T) {
+	dir, err := ioutil.TempDir("", "notifierKafkaCheckpoint")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "offsets.json")
+
+	// no checkpoint yet
+	offsets, err := loadCheckpoint(path)
+	if err != nil {
+		t.Fatalf("expected no error for missing checkpoint, got %s", err)
+	}
+	if len(offsets) != 0 {
+		t.Fatalf("expected no offsets for missing checkpoint, got %v", offsets)
+	}
+
+	exp := map[string]map[int32]int64{
+		"metricpersist":       {0: 10, 3: 1234567},
+		"metricpersist-other": {0: 5},
+	}
+	err = writeCheckpoint(path, exp)
+	if err != nil {
+		t.Fatalf("failed to write checkpoint: %s", err)
+	}
+	offsets, err = loadCheckpoint(path)
+	if err != nil {
+		t.Fatalf("failed to load checkpoint: %s", err)
+	}
+	if !reflect.DeepEqual(offsets, exp) {
+		t.Fatalf("expected offsets %v, got %v", exp, offsets)
+	}
+
+	err = ioutil.WriteFile(path, []byte("{"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = loadCheckpoint(path)
+	if err == nil {
+		t.Fatalf("expected error for corrupt checkpoint")
+	}
+}
+
+func TestNextOffsets(t *testing.T) {
+	known := int64(42)
+	unknown := int64(-1)
+	c := &NotifierKafka{
+		nextOffset: map[string]map[int32]*int64{
+			"metricpersist": {
+				0: &known,
+				1: &unknown,
+			},
+		},
+	}
+	exp := map[string]map[int32]int64{"metricpersist": {0: 42}}
+	if offsets := c.nextOffsets(); !reflect.DeepEqual(offsets, exp) {
+		t.Fatalf("expected offsets %v, got %v", exp, offsets)
+	}
+}
@@ -0,0 +1,66 @@
+package notifierKafka
+
+import (
+	"github.com/Shopify/sarama"
+)
+
+// TopicMeta describes the partitions of a topic
+type TopicMeta struct {
+	Partitions []PartitionMeta
+}
+
+// PartitionMeta describes the replica assignment of a partition, by broker id
+type PartitionMeta struct {
+	PartitionId    int32
+	Leader         int32
+	Replicas       []int32
+	InSyncReplicas []int32
+}
+
+// TopicMetadata returns the partitions and replica assignments of the persist topics, by topic name.
+// if we consume from a separate cluster than we produce to, it describes the cluster we consume from.
+func (c *NotifierKafka) TopicMetadata() (map[string]TopicMeta, error) {
+	conn := c.connection()
+	client := conn.client
+	if client == nil {
+		client = conn.producerClient
+	}
+	metas := make(map[string]TopicMeta, len(c.cfg.topics))
+	for _, topic := range c.cfg.topics {
+		meta, err := topicMetadata(client, topic)
+		if err != nil {
+			return nil, err
+		}
+		metas[topic] = meta
+	}
+	return metas, nil
+}
+
+func topicMetadata(client sarama.Client, topic string) (TopicMeta, error) {
+	var meta TopicMeta
+	partitions, err := client.Partitions(topic)
+	if err != nil {
+		return meta, err
+	}
+	for _, partition := range partitions {
+		leader, err := client.Leader(topic, partition)
+		if err != nil {
+			return meta, err
+		}
+		replicas, err := client.Replicas(topic, partition)
+		if err != nil {
+			return meta, err
+		}
+		inSyncReplicas, err := client.InSyncReplicas(topic, partition)
+		if err != nil {
+			return meta, err
+		}
+		meta.Partitions = append(meta.Partitions, PartitionMeta{
+			PartitionId:    partition,
+			Leader:         leader.ID(),
+			Replicas:       replicas,
+			InSyncReplicas: inSyncReplicas,
+		})
+	}
+	return meta, nil
+}
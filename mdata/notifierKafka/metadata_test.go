@@ -0,0 +1,54 @@
+package notifierKafka
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/Shopify/sarama"
+)
+
+func TestTopicMetadata(t *testing.T) {
+	broker := sarama.NewMockBroker(t, 1)
+	defer broker.Close()
+
+	resp := new(sarama.MetadataResponse)
+	resp.AddBroker(broker.Addr(), broker.BrokerID())
+	resp.AddTopicPartition("metricpersist", 0, 1, []int32{1, 2, 3}, []int32{1, 3}, sarama.ErrNoError)
+	resp.AddTopicPartition("metricpersist", 1, 1, []int32{1, 2}, []int32{1, 2}, sarama.ErrNoError)
+	broker.SetHandlerByMap(map[string]sarama.MockResponse{
+		"MetadataRequest": sarama.NewMockWrapper(resp),
+	})
+
+	config := sarama.NewConfig()
+	config.Metadata.Retry.Max = 0
+	client, err := sarama.NewClient([]string{broker.Addr()}, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	c := &NotifierKafka{
+		cfg:  &NotifierKafkaConfig{topics: []string{"metricpersist"}},
+		conn: &connection{producerClient: client},
+	}
+	got, err := c.TopicMetadata()
+	if err != nil {
+		t.Fatalf("unexpected error %s", err)
+	}
+	exp := map[string]TopicMeta{
+		"metricpersist": {
+			Partitions: []PartitionMeta{
+				{PartitionId: 0, Leader: 1, Replicas: []int32{1, 2, 3}, InSyncReplicas: []int32{1, 3}},
+				{PartitionId: 1, Leader: 1, Replicas: []int32{1, 2}, InSyncReplicas: []int32{1, 2}},
+			},
+		},
+	}
+	if !reflect.DeepEqual(got, exp) {
+		t.Fatalf("expected %+v, got %+v", exp, got)
+	}
+
+	c.cfg.topics = []string{"metricpersist", "unknown"}
+	if _, err := c.TopicMetadata(); err == nil {
+		t.Fatal("expected an error for an unknown topic")
+	}
+}
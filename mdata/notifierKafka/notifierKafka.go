@@ -1,10 +1,9 @@
 package notifierKafka
 
 import (
-	"bytes"
-	"encoding/binary"
-	"encoding/json"
+	"fmt"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/raintank/schema"
@@ -15,133 +14,241 @@ import (
 	log "github.com/sirupsen/logrus"
 )
 
+// versionHeader is the header of the produced messages that holds the version of the producing binary
+const versionHeader = "x-mt-version"
+
 type NotifierKafka struct {
+	cfg      *NotifierKafkaConfig
 	instance string
+	headers  []sarama.RecordHeader // added to all produced messages
 	in       chan mdata.SavedChunk
-	buf      []mdata.SavedChunk
+	buf      []mdata.SavedChunk // only accessed by the produce goroutine
+	bufLen   int64              // len(buf), for Stats. accessed atomically
 	wg       sync.WaitGroup
 	bPool    *util.BufferPool
 	handler  mdata.NotifierHandler
-	client   sarama.Client
-	consumer sarama.Consumer
-	producer sarama.SyncProducer
+	wal      *wal
+	metrics  notifierMetrics
 	StopChan chan int
 
-	// signal to PartitionConsumers to shutdown
-	stopConsuming chan struct{}
-}
+	mu      sync.RWMutex
+	conn    *connection // replaced by the supervisor when the connection is lost
+	stopped bool
 
-func New(instance string, handler mdata.NotifierHandler) *NotifierKafka {
-	client, err := sarama.NewClient(brokers, config)
-	if err != nil {
-		log.Fatalf("kafka-cluster: failed to start client: %s", err)
-	}
-	consumer, err := sarama.NewConsumerFromClient(client)
-	if err != nil {
-		log.Fatalf("kafka-cluster: failed to initialize consumer: %s", err)
-	}
-	log.Info("kafka-cluster: consumer initialized without error")
+	reconnect chan uint64    // generations of connections that should be replaced
+	shutdown  chan struct{}  // signal to the supervisor and producer to shutdown
+	produced  chan struct{}  // closed by the producer once it published its buffer after shutdown
+	sendWg    sync.WaitGroup // tracks the sends in flight
+
+	// offsets of the next messages to consume, by topic and partition. -1 if not known yet
+	nextOffset map[string]map[int32]*int64
+	// offsets to resume consuming from, by topic and partition, if the offset is "checkpoint"
+	checkpoint map[string]map[int32]int64
+
+	// caches the partitions of metrics, as partitionCacheEntry's by schema.MKey
+	assignmentCache sync.Map
+	lastCachePrune  time.Time
+}
 
-	producer, err := sarama.NewSyncProducerFromClient(client)
+// New creates a NotifierKafka based on the given config, which must have been processed already.
+func New(cfg *NotifierKafkaConfig, instance string, handler mdata.NotifierHandler) *NotifierKafka {
+	conn, err := connect(cfg, 0)
 	if err != nil {
-		log.Fatalf("kafka-cluster: failed to initialize producer: %s", err)
+		log.Fatalf("kafka-cluster: %s", err)
 	}
 
 	c := NotifierKafka{
+		cfg:      cfg,
 		instance: instance,
 		in:       make(chan mdata.SavedChunk),
 		bPool:    util.NewBufferPool(),
 		handler:  handler,
-		client:   client,
-		consumer: consumer,
-		producer: producer,
+		conn:     conn,
+		metrics:  newNotifierMetrics(cfg.notifierMetricPrefix()),
+
+		StopChan:  make(chan int),
+		reconnect: make(chan uint64, 1),
+		shutdown:  make(chan struct{}),
+		produced:  make(chan struct{}),
 
-		StopChan:      make(chan int),
-		stopConsuming: make(chan struct{}),
+		nextOffset: make(map[string]map[int32]*int64),
+	}
+	c.headers = versionHeaders(cfg.Version, cfg.config.Version)
+	for _, topic := range cfg.topics {
+		c.nextOffset[topic] = make(map[int32]*int64)
+		for _, partition := range cfg.partitions {
+			next := int64(-1)
+			c.nextOffset[topic][partition] = &next
+		}
+	}
+	if cfg.Offset == "checkpoint" {
+		c.checkpoint, err = loadCheckpoint(cfg.CheckpointFile)
+		if err != nil {
+			log.Fatalf("kafka-cluster: failed to load offset checkpoint: %s", err)
+		}
+	}
+	if cfg.WALDirectory != "" {
+		c.wal, err = newWAL(cfg.WALDirectory)
+		if err != nil {
+			log.Fatalf("kafka-cluster: failed to initialize wal: %s", err)
+		}
+	}
+	if cfg.Registerer != nil && cfg.ConsumeEnabled {
+		err = cfg.Registerer.Register(NewLagCollector(cfg.partitionLag))
+		if err != nil {
+			log.Errorf("kafka-cluster: failed to register prometheus lag collector: %s", err)
+		}
+	}
+	if cfg.UseAsyncProducer {
+		c.handleAsync(conn)
+	}
+	if cfg.ConsumeEnabled {
+		c.start()
+		if cfg.CheckpointFile != "" {
+			go c.writeCheckpoints()
+		}
 	}
-	c.start()
 	go c.produce()
+	go c.supervise()
 
 	return &c
 }
 
+// connection returns the current connection
+func (c *NotifierKafka) connection() *connection {
+	c.mu.RLock()
+	conn := c.conn
+	c.mu.RUnlock()
+	return conn
+}
+
+// startOffset returns the configured offset to start consuming the partition of the topic from
+func (c *NotifierKafka) startOffset(conn *connection, topic string, partition int32) int64 {
+	switch c.cfg.Offset {
+	case "oldest":
+		return -2
+	case "newest":
+		return -1
+	case "checkpoint":
+		return c.checkpointOffset(conn, topic, partition)
+	}
+	offset, err := conn.client.GetOffset(topic, partition, time.Now().Add(-1*c.cfg.offsetDuration).UnixNano()/int64(time.Millisecond))
+	if err != nil {
+		offset = sarama.OffsetOldest
+		log.Warnf("kafka-cluster: failed to get offset %s: %s -> will use oldest instead", c.cfg.offsetDuration, err)
+	}
+	return offset
+}
+
 func (c *NotifierKafka) start() {
-	var err error
 	pre := time.Now()
-	processBacklog := new(sync.WaitGroup)
-	for _, partition := range partitions {
-		var offset int64
-		switch offsetStr {
-		case "oldest":
-			offset = -2
-		case "newest":
-			offset = -1
-		default:
-			offset, err = c.client.GetOffset(topic, partition, time.Now().Add(-1*offsetDuration).UnixNano()/int64(time.Millisecond))
-			if err != nil {
-				offset = sarama.OffsetOldest
-				log.Warnf("kafka-cluster: failed to get offset %s: %s -> will use oldest instead", offsetDuration, err)
+	backlogProcessed := make(map[string]map[int32]chan struct{})
+	c.mu.Lock()
+	for _, topic := range c.cfg.topics {
+		backlogProcessed[topic] = make(map[int32]chan struct{})
+		for _, partition := range c.cfg.partitions {
+			offset := c.startOffset(c.conn, topic, partition)
+			bootTimeOffset := c.cfg.bootTimeOffsets[topic][partition]
+			c.cfg.partitionLogSize[topic][partition].Set(int(bootTimeOffset))
+			if offset >= 0 {
+				c.cfg.partitionOffset[topic][partition].Set(int(offset))
+				c.cfg.partitionLag[topic][partition].Set(int(bootTimeOffset - offset))
 			}
+			backlogProcessed[topic][partition] = make(chan struct{})
+			c.consume(c.conn, topic, partition, offset, backlogProcessed[topic][partition])
 		}
-		partitionLogSize[partition].Set(int(bootTimeOffsets[partition]))
-		if offset >= 0 {
-			partitionOffset[partition].Set(int(offset))
-			partitionLag[partition].Set(int(bootTimeOffsets[partition] - offset))
-		}
-		processBacklog.Add(1)
-		go c.consumePartition(topic, partition, offset, processBacklog)
 	}
+	c.mu.Unlock()
 	// wait for our backlog to be processed before returning.  This will block metrictank from consuming metrics until
 	// we have processed old metricPersist messages. The end result is that we wont overwrite chunks in cassandra that
 	// have already been previously written.
-	// We don't wait more than backlogProcessTimeout for the backlog to be processed.
+	// We don't wait more than the backlog process timeout of each partition for its backlog to be processed,
+	// across all topics.
 	log.Info("kafka-cluster: waiting for metricPersist backlog to be processed.")
-	backlogProcessed := make(chan struct{}, 1)
-	go func() {
-		processBacklog.Wait()
-		backlogProcessed <- struct{}{}
-	}()
-
-	select {
-	case <-time.After(backlogProcessTimeout):
-		log.Warnf("kafka-cluster: Processing metricPersist backlog has taken too long, giving up lock after %s.", backlogProcessTimeout)
-	case <-backlogProcessed:
+	var timedOut []string
+	for _, topic := range c.cfg.topics {
+		for _, partition := range c.cfg.partitions {
+			select {
+			case <-backlogProcessed[topic][partition]:
+				continue
+			default:
+			}
+			// all timeouts are relative to when we started consuming, so waiting for the
+			// partitions one by one doesn't add up their timeouts.
+			remaining := c.cfg.partitionBacklogProcessTimeout(partition) - time.Since(pre)
+			if remaining < 0 {
+				remaining = 0
+			}
+			timer := time.NewTimer(remaining)
+			select {
+			case <-backlogProcessed[topic][partition]:
+			case <-timer.C:
+				timedOut = append(timedOut, fmt.Sprintf("%s:%d", topic, partition))
+			}
+			timer.Stop()
+		}
+	}
+	if len(timedOut) > 0 {
+		log.Warnf("kafka-cluster: Processing metricPersist backlog has taken too long for partitions %v, giving up lock after %s.", timedOut, time.Since(pre))
+	} else {
 		log.Infof("kafka-cluster: metricPersist backlog processed in %s.", time.Since(pre))
 	}
-
 }
 
-func (c *NotifierKafka) consumePartition(topic string, partition int32, currentOffset int64, processBacklog *sync.WaitGroup) {
+// consume starts consuming the partition of the topic from the given connection.
+// backlogProcessed gets closed once the backlog present at boot time has been processed.
+// it is nil if we're not starting up.
+// must be called with the lock held.
+func (c *NotifierKafka) consume(conn *connection, topic string, partition int32, offset int64, backlogProcessed chan struct{}) {
+	if offset >= 0 {
+		atomic.StoreInt64(c.nextOffset[topic][partition], offset)
+	}
 	c.wg.Add(1)
+	conn.wg.Add(1)
+	go c.consumePartition(conn, topic, partition, offset, backlogProcessed)
+}
+
+func (c *NotifierKafka) consumePartition(conn *connection, topic string, partition int32, currentOffset int64, backlogProcessed chan struct{}) {
 	defer c.wg.Done()
+	defer conn.wg.Done()
 
-	pc, err := c.consumer.ConsumePartition(topic, partition, currentOffset)
+	pc, err := conn.consumer.ConsumePartition(topic, partition, currentOffset)
 	if err != nil {
-		log.Fatalf("kafka-cluster: failed to start partitionConsumer for %s:%d. %s", topic, partition, err)
+		if backlogProcessed != nil {
+			log.Fatalf("kafka-cluster: failed to start partitionConsumer for %s:%d. %s", topic, partition, err)
+		}
+		log.Errorf("kafka-cluster: failed to start partitionConsumer for %s:%d. %s", topic, partition, err)
+		c.requestReconnect(conn.generation)
+		return
 	}
 	log.Infof("kafka-cluster: consuming from %s:%d from offset %d", topic, partition, currentOffset)
 
 	messages := pc.Messages()
 	ticker := time.NewTicker(5 * time.Second)
-	startingUp := true
+	startingUp := backlogProcessed != nil
 	// the bootTimeOffset is the next available offset. There may not be a message with that
 	// offset yet, so we subtract 1 to get the highest offset that we can fetch.
-	bootTimeOffset := bootTimeOffsets[partition] - 1
-	partitionOffsetMetric := partitionOffset[partition]
-	partitionLogSizeMetric := partitionLogSize[partition]
-	partitionLagMetric := partitionLag[partition]
+	bootTimeOffset := c.cfg.bootTimeOffsets[topic][partition] - 1
+	partitionOffsetMetric := c.cfg.partitionOffset[topic][partition]
+	partitionLogSizeMetric := c.cfg.partitionLogSize[topic][partition]
+	partitionLagMetric := c.cfg.partitionLag[topic][partition]
+	nextOffset := c.nextOffset[topic][partition]
 	for {
 		select {
 		case msg := <-messages:
 			log.Debugf("kafka-cluster: received message: Topic %s, Partition: %d, Offset: %d, Key: %x", msg.Topic, msg.Partition, msg.Offset, msg.Key)
-			c.handler.Handle(msg.Value)
+			c.checkVersion(msg)
+			if value, ok := c.verify(msg); ok {
+				c.handler.Handle(value)
+			}
 			currentOffset = msg.Offset
+			atomic.StoreInt64(nextOffset, msg.Offset+1)
 		case <-ticker.C:
 			if startingUp && currentOffset >= bootTimeOffset {
-				processBacklog.Done()
+				close(backlogProcessed)
 				startingUp = false
 			}
-			offset, err := c.client.GetOffset(topic, partition, sarama.OffsetNewest)
+			offset, err := conn.client.GetOffset(topic, partition, sarama.OffsetNewest)
 			if err != nil {
 				log.Errorf("kafka-mdm failed to get log-size of partition %s:%d. %s", topic, partition, err)
 			} else {
@@ -155,7 +262,7 @@ func (c *NotifierKafka) consumePartition(topic string, partition int32, currentO
 			if err == nil {
 				partitionLagMetric.Set(int(offset - currentOffset))
 			}
-		case <-c.stopConsuming:
+		case <-conn.stopConsuming:
 			pc.Close()
 			log.Infof("kafka-cluster: consumer for %s:%d ended.", topic, partition)
 			return
@@ -163,16 +270,76 @@ func (c *NotifierKafka) consumePartition(topic string, partition int32, currentO
 	}
 }
 
+// versionHeaders returns the headers to add to the produced messages to identify the version of our binary.
+// headers require kafka 0.11 or newer.
+func versionHeaders(version string, kafkaVersion sarama.KafkaVersion) []sarama.RecordHeader {
+	if version == "" || !kafkaVersion.IsAtLeast(sarama.V0_11_0_0) {
+		return nil
+	}
+	return []sarama.RecordHeader{{Key: []byte(versionHeader), Value: []byte(version)}}
+}
+
+// checkVersion logs messages that were produced by another version than ours, as this
+// helps debugging clusters running mixed versions
+func (c *NotifierKafka) checkVersion(msg *sarama.ConsumerMessage) {
+	if c.cfg.Version == "" || !log.IsLevelEnabled(log.DebugLevel) {
+		return
+	}
+	version, ok := messageVersion(msg)
+	if !ok {
+		log.Debugf("kafka-cluster: received message without version header: Topic %s, Partition: %d, Offset: %d", msg.Topic, msg.Partition, msg.Offset)
+		return
+	}
+	if version != c.cfg.Version {
+		log.Debugf("kafka-cluster: received message from version %s (we are %s): Topic %s, Partition: %d, Offset: %d", version, c.cfg.Version, msg.Topic, msg.Partition, msg.Offset)
+	}
+}
+
+// messageVersion returns the version of the binary that produced the message, if it is known
+func messageVersion(msg *sarama.ConsumerMessage) (string, bool) {
+	for _, h := range msg.Headers {
+		if h != nil && string(h.Key) == versionHeader {
+			return string(h.Value), true
+		}
+	}
+	return "", false
+}
+
+// verify returns the value of the message without its signature, if it could be verified.
+// if no signing keys are configured, messages are not signed and the value is returned as is.
+func (c *NotifierKafka) verify(msg *sarama.ConsumerMessage) ([]byte, bool) {
+	if len(c.cfg.signingKeys) == 0 {
+		return msg.Value, true
+	}
+	value, ok := verify(c.cfg.signingKeys, msg.Value)
+	if !ok {
+		c.metrics.authFailures.Inc()
+		log.Warnf("kafka-cluster: dropping message with invalid signature: Topic %s, Partition: %d, Offset: %d", msg.Topic, msg.Partition, msg.Offset)
+	}
+	return value, ok
+}
+
 // Stop will initiate a graceful stop of the Consumer (permanent)
 //
 // NOTE: receive on StopChan to block until this process completes
 func (c *NotifierKafka) Stop() {
+	c.mu.Lock()
+	c.stopped = true
+	conn := c.conn
+	c.mu.Unlock()
+	close(c.shutdown)
+
 	// closes notifications and messages channels, amongst others
-	close(c.stopConsuming)
-	c.producer.Close()
+	conn.stopConsumers()
 
 	go func() {
+		// the producer publishes the chunks it has buffered before we close it
+		<-c.produced
+		conn.closeProducer()
 		c.wg.Wait()
+		if c.cfg.ConsumeEnabled && c.cfg.CheckpointFile != "" {
+			c.saveCheckpoint()
+		}
 		close(c.StopChan)
 	}()
 }
@@ -182,17 +349,27 @@ func (c *NotifierKafka) Send(sc mdata.SavedChunk) {
 }
 
 func (c *NotifierKafka) produce() {
+	if c.wal != nil {
+		c.replayWAL()
+	}
 	ticker := time.NewTicker(time.Second)
 	max := 5000
 	for {
 		select {
 		case chunk := <-c.in:
 			c.buf = append(c.buf, chunk)
+			atomic.StoreInt64(&c.bufLen, int64(len(c.buf)))
 			if len(c.buf) == max {
 				c.flush()
 			}
 		case <-ticker.C:
 			c.flush()
+		case <-c.shutdown:
+			ticker.Stop()
+			c.flush()
+			c.sendWg.Wait()
+			close(c.produced)
+			return
 		}
 	}
 }
@@ -211,49 +388,206 @@ func (c *NotifierKafka) flush() {
 		amkey, err := schema.AMKeyFromString(msg.Key)
 		if err != nil {
 			log.Errorf("kafka-cluster: failed to parse key %q", msg.Key)
+			c.metrics.messagesDropped.Inc()
 			continue
 		}
 
-		partition, ok := c.handler.PartitionOf(amkey.MKey)
+		partition, ok := c.partitionOf(amkey.MKey)
 		if !ok {
 			log.Errorf("kafka-cluster: failed to lookup metricDef with id %s", msg.Key)
+			c.metrics.messagesDropped.Inc()
 			continue
 		}
-		buf := bytes.NewBuffer(c.bPool.Get())
-		binary.Write(buf, binary.LittleEndian, uint8(mdata.PersistMessageBatchV1))
-		encoder := json.NewEncoder(buf)
 		pMsg = mdata.PersistMessageBatch{Instance: c.instance, SavedChunks: c.buf[i : i+1]}
-		err = encoder.Encode(&pMsg)
+		buf, err := mdata.EncodePersistMessageBatch(c.bPool.Get(), c.cfg.PersistMessageVersion, &pMsg)
 		if err != nil {
-			log.Fatalf("kafka-cluster: failed to marshal persistMessage to json.")
+			log.Fatalf("kafka-cluster: failed to marshal persistMessage: %s", err)
 		}
-		messagesSize.Value(buf.Len())
+		if len(c.cfg.signingKeys) > 0 {
+			buf = append(buf, sign(c.cfg.signingKeys[0], buf)...)
+		}
+		c.metrics.messagesSize.Value(len(buf))
 		kafkaMsg := &sarama.ProducerMessage{
-			Topic:     topic,
-			Value:     sarama.ByteEncoder(buf.Bytes()),
+			Topic:     c.cfg.publishTopic(),
+			Value:     sarama.ByteEncoder(buf),
 			Partition: partition,
+			Headers:   c.headers,
 		}
 		payload = append(payload, kafkaMsg)
 	}
 
 	c.buf = nil
+	atomic.StoreInt64(&c.bufLen, 0)
+	c.pruneAssignmentCache()
+	if len(payload) == 0 {
+		return
+	}
+
+	var segments []string
+	if c.wal != nil {
+		var err error
+		segments, err = c.wal.write(payload)
+		if err != nil {
+			log.Errorf("kafka-cluster: failed to write metricPersist messages to wal: %s", err)
+		}
+	}
 
+	c.sendWg.Add(1)
 	go func() {
-		log.Debugf("kafka-cluster: sending %d batch metricPersist messages", len(payload))
-		sent := false
-		for !sent {
-			err := c.producer.SendMessages(payload)
-			if err != nil {
-				log.Warnf("kafka-cluster: publisher %s", err)
-			} else {
-				sent = true
+		c.send(payload, segments)
+		c.sendWg.Done()
+	}()
+}
+
+type partitionCacheEntry struct {
+	partition int32
+	expires   time.Time
+}
+
+// resolver returns the PartitionResolver to determine the partition of metrics with
+func (c *NotifierKafka) resolver() PartitionResolver {
+	if c.cfg.PartitionResolver != nil {
+		return c.cfg.PartitionResolver
+	}
+	return c.handler
+}
+
+// partitionOf returns the partition of the metric, from the assignment cache if possible
+func (c *NotifierKafka) partitionOf(key schema.MKey) (int32, bool) {
+	if c.cfg.partitionCacheTTL == 0 {
+		return c.resolver().PartitionOf(key)
+	}
+	now := time.Now()
+	if e, ok := c.assignmentCache.Load(key); ok {
+		entry := e.(partitionCacheEntry)
+		if now.Before(entry.expires) {
+			return entry.partition, true
+		}
+	}
+	partition, ok := c.resolver().PartitionOf(key)
+	if ok {
+		c.assignmentCache.Store(key, partitionCacheEntry{partition, now.Add(c.cfg.partitionCacheTTL)})
+	} else {
+		c.assignmentCache.Delete(key)
+	}
+	return partition, ok
+}
+
+// pruneAssignmentCache removes expired entries from the assignment cache,
+// so that metrics we don't publish messages for anymore don't keep taking up memory.
+// it runs at most once per ttl.
+func (c *NotifierKafka) pruneAssignmentCache() {
+	if c.cfg.partitionCacheTTL == 0 {
+		return
+	}
+	now := time.Now()
+	if now.Sub(c.lastCachePrune) < c.cfg.partitionCacheTTL {
+		return
+	}
+	c.lastCachePrune = now
+	c.assignmentCache.Range(func(key, value interface{}) bool {
+		if !now.Before(value.(partitionCacheEntry).expires) {
+			c.assignmentCache.Delete(key)
+		}
+		return true
+	})
+}
+
+// send sends the messages, retrying until it succeeds.
+// failing to send them triggers a reconnect.
+// once sent, the given wal segments are removed.
+func (c *NotifierKafka) send(payload []*sarama.ProducerMessage, segments []string) {
+	log.Debugf("kafka-cluster: sending %d batch metricPersist messages", len(payload))
+	if c.cfg.UseAsyncProducer {
+		c.sendAsync(payload, segments)
+		return
+	}
+	sent := false
+	for !sent {
+		conn := c.connection()
+		err := conn.producer.SendMessages(payload)
+		if err != nil {
+			log.Warnf("kafka-cluster: publisher %s", err)
+			c.metrics.flushErrors.Inc()
+			select {
+			case <-c.shutdown:
+				// the connection won't be replaced anymore. the messages stay in the wal, if any
+				log.Errorf("kafka-cluster: failed to publish %d metricPersist messages while shutting down", len(payload))
+				return
+			default:
 			}
-			time.Sleep(time.Second)
+			c.requestReconnect(conn.generation)
+		} else {
+			sent = true
 		}
-		messagesPublished.Add(len(payload))
-		// put our buffers back in the bufferPool
-		for _, msg := range payload {
-			c.bPool.Put([]byte(msg.Value.(sarama.ByteEncoder)))
+		time.Sleep(time.Second)
+	}
+	c.metrics.messagesPublished.Add(len(payload))
+	if c.wal != nil {
+		c.wal.remove(segments)
+	}
+	// put our buffers back in the bufferPool
+	for _, msg := range payload {
+		c.bPool.Put([]byte(msg.Value.(sarama.ByteEncoder)))
+	}
+}
+
+// replayWAL sends the messages that were left unsent by a previous run
+func (c *NotifierKafka) replayWAL() {
+	payload, segments, err := c.wal.replay(c.cfg.publishTopic())
+	if err != nil {
+		log.Errorf("kafka-cluster: failed to replay wal: %s", err)
+		return
+	}
+	if len(payload) == 0 {
+		return
+	}
+	for _, msg := range payload {
+		msg.Headers = c.headers
+	}
+	log.Infof("kafka-cluster: replaying %d metricPersist messages from %d wal segments", len(payload), len(segments))
+	c.send(payload, segments)
+}
+
+// NotifierKafkaMetrics holds the values of the kafka cluster notifier counters
+type NotifierKafkaMetrics struct {
+	MessagesPublished  int64
+	MessagesDropped    int64
+	FlushErrors        int64
+	AsyncProduceErrors int64
+}
+
+// Metrics returns the current values of the kafka cluster notifier counters
+func (c *NotifierKafka) Metrics() NotifierKafkaMetrics {
+	return NotifierKafkaMetrics{
+		MessagesPublished:  int64(c.metrics.messagesPublished.Peek()),
+		MessagesDropped:    int64(c.metrics.messagesDropped.Peek()),
+		FlushErrors:        int64(c.metrics.flushErrors.Peek()),
+		AsyncProduceErrors: int64(c.metrics.asyncProduceErrors.Peek()),
+	}
+}
+
+// NotifierStats is a snapshot of the state of the producer and consumer of a NotifierKafka
+type NotifierStats struct {
+	BufferDepth       int // number of chunks waiting to be published
+	MessagesPublished int64
+	PerPartitionLag   map[int32]int64 // by consumed partition, summed across topics
+	ReconnectCount    int64
+}
+
+// Stats returns the current producer and consumer statistics.
+// it only reads atomic counters, so it is cheap and takes no locks.
+func (c *NotifierKafka) Stats() NotifierStats {
+	lags := make(map[int32]int64, len(c.cfg.partitions))
+	for _, partitions := range c.cfg.partitionLag {
+		for partition, lag := range partitions {
+			lags[partition] += int64(lag.Peek())
 		}
-	}()
+	}
+	return NotifierStats{
+		BufferDepth:       int(atomic.LoadInt64(&c.bufLen)),
+		MessagesPublished: int64(c.metrics.messagesPublished.Peek()),
+		PerPartitionLag:   lags,
+		ReconnectCount:    int64(c.metrics.reconnects.Peek()),
+	}
 }
@@ -0,0 +1,373 @@
+package notifierKafka
+
+import (
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/grafana/metrictank/mdata"
+	"github.com/grafana/metrictank/stats"
+	"github.com/grafana/metrictank/util"
+	"github.com/raintank/schema"
+)
+
+func TestMetrics(t *testing.T) {
+	c := &NotifierKafka{metrics: newNotifierMetrics("test.metrics.a")}
+	other := &NotifierKafka{metrics: newNotifierMetrics("test.metrics.b")}
+	before, otherBefore := c.Metrics(), other.Metrics()
+
+	c.metrics.messagesPublished.Add(3)
+	c.metrics.messagesDropped.Inc()
+	c.metrics.flushErrors.Add(2)
+	c.metrics.asyncProduceErrors.Inc()
+	other.metrics.messagesPublished.Inc()
+
+	exp := NotifierKafkaMetrics{
+		MessagesPublished:  before.MessagesPublished + 3,
+		MessagesDropped:    before.MessagesDropped + 1,
+		FlushErrors:        before.FlushErrors + 2,
+		AsyncProduceErrors: before.AsyncProduceErrors + 1,
+	}
+	if got := c.Metrics(); got != exp {
+		t.Fatalf("expected metrics %+v, got %+v", exp, got)
+	}
+	// the metrics of notifiers with different prefixes are independent
+	exp = otherBefore
+	exp.MessagesPublished++
+	if got := other.Metrics(); got != exp {
+		t.Fatalf("expected metrics %+v, got %+v", exp, got)
+	}
+}
+
+type countingHandler struct {
+	calls int
+}
+
+func (h *countingHandler) Handle([]byte) {}
+
+func (h *countingHandler) PartitionOf(key schema.MKey) (int32, bool) {
+	h.calls++
+	return int32(key.Org), key.Org != 0
+}
+
+// fakeSyncProducer records the messages it publishes
+type fakeSyncProducer struct {
+	sync.Mutex
+	published []*sarama.ProducerMessage
+	closed    bool
+}
+
+func (p *fakeSyncProducer) SendMessage(msg *sarama.ProducerMessage) (int32, int64, error) {
+	return msg.Partition, 0, p.SendMessages([]*sarama.ProducerMessage{msg})
+}
+
+func (p *fakeSyncProducer) SendMessages(msgs []*sarama.ProducerMessage) error {
+	p.Lock()
+	defer p.Unlock()
+	if p.closed {
+		return sarama.ErrClosedClient
+	}
+	p.published = append(p.published, msgs...)
+	return nil
+}
+
+func (p *fakeSyncProducer) Close() error {
+	p.Lock()
+	p.closed = true
+	p.Unlock()
+	return nil
+}
+
+func TestFlushPersistMessageVersion(t *testing.T) {
+	key := schema.AMKey{MKey: schema.MKey{Org: 1}}.String()
+	for _, version := range []int{mdata.PersistMessageBatchV1, mdata.PersistMessageBatchV2} {
+		producer := newFakeAsyncProducer(0)
+		c := &NotifierKafka{
+			cfg: &NotifierKafkaConfig{
+				topics:                []string{"metricpersist"},
+				UseAsyncProducer:      true,
+				PersistMessageVersion: version,
+			},
+			instance: "mt1",
+			bPool:    util.NewBufferPool(),
+			handler:  &countingHandler{},
+			conn:     &connection{asyncProducer: producer},
+			metrics:  newNotifierMetrics("test.flush"),
+			shutdown: make(chan struct{}),
+		}
+		c.buf = []mdata.SavedChunk{{Key: key, T0: 60}}
+		c.flush()
+
+		msg := <-producer.successes
+		producer.AsyncClose()
+		if msg.Topic != "metricpersist" || msg.Partition != 1 {
+			t.Fatalf("version %d: expected message for metricpersist:1, got %s:%d", version, msg.Topic, msg.Partition)
+		}
+		data := []byte(msg.Value.(sarama.ByteEncoder))
+		if int(data[0]) != version {
+			t.Fatalf("version %d: expected a message of version %d, got %d", version, version, data[0])
+		}
+		batch, err := mdata.DecodePersistMessageBatch(data)
+		if err != nil {
+			t.Fatalf("version %d: unexpected error decoding: %s", version, err)
+		}
+		exp := mdata.PersistMessageBatch{Instance: "mt1", SavedChunks: []mdata.SavedChunk{{Key: key, T0: 60}}}
+		if !reflect.DeepEqual(batch, exp) {
+			t.Fatalf("version %d: expected batch %+v, got %+v", version, exp, batch)
+		}
+	}
+}
+
+func TestPartitionOfCache(t *testing.T) {
+	handler := &countingHandler{}
+	c := &NotifierKafka{
+		cfg:     &NotifierKafkaConfig{partitionCacheTTL: time.Hour},
+		handler: handler,
+	}
+	key := schema.MKey{Org: 3}
+	for i := 0; i < 3; i++ {
+		partition, ok := c.partitionOf(key)
+		if !ok || partition != 3 {
+			t.Fatalf("expected partition 3, got %d (ok %t)", partition, ok)
+		}
+	}
+	if handler.calls != 1 {
+		t.Fatalf("expected 1 call to PartitionOf, got %d", handler.calls)
+	}
+
+	// unknown metrics are not cached
+	for i := 0; i < 2; i++ {
+		if _, ok := c.partitionOf(schema.MKey{}); ok {
+			t.Fatalf("expected unknown metric not to be found")
+		}
+	}
+	if handler.calls != 3 {
+		t.Fatalf("expected 3 calls to PartitionOf, got %d", handler.calls)
+	}
+
+	// expired entries are looked up again, and pruned
+	c.assignmentCache.Store(key, partitionCacheEntry{3, time.Now().Add(-time.Second)})
+	c.partitionOf(key)
+	if handler.calls != 4 {
+		t.Fatalf("expected 4 calls to PartitionOf, got %d", handler.calls)
+	}
+	c.assignmentCache.Store(key, partitionCacheEntry{3, time.Now().Add(-time.Second)})
+	c.pruneAssignmentCache()
+	if _, ok := c.assignmentCache.Load(key); ok {
+		t.Fatalf("expected expired entry to be pruned")
+	}
+}
+
+func TestPartitionOfNoCache(t *testing.T) {
+	handler := &countingHandler{}
+	c := &NotifierKafka{
+		cfg:     &NotifierKafkaConfig{},
+		handler: handler,
+	}
+	for i := 0; i < 3; i++ {
+		c.partitionOf(schema.MKey{Org: 1})
+	}
+	if handler.calls != 3 {
+		t.Fatalf("expected 3 calls to PartitionOf, got %d", handler.calls)
+	}
+}
+
+func TestPartitionBacklogProcessTimeout(t *testing.T) {
+	cfg := &NotifierKafkaConfig{backlogProcessTimeout: time.Minute}
+	cfg.PartitionBacklogTimeout(3, 2*time.Minute)
+	if d := cfg.partitionBacklogProcessTimeout(3); d != 2*time.Minute {
+		t.Fatalf("expected timeout of 2m for partition 3, got %s", d)
+	}
+	if d := cfg.partitionBacklogProcessTimeout(1); d != time.Minute {
+		t.Fatalf("expected default timeout of 1m for partition 1, got %s", d)
+	}
+}
+
+// TestStopPublishesBuffer verifies that the chunks that are buffered when the notifier
+// gets stopped are published before StopChan gets closed.
+func TestStopPublishesBuffer(t *testing.T) {
+	producer := &fakeSyncProducer{}
+	c := &NotifierKafka{
+		cfg: &NotifierKafkaConfig{
+			topics:                []string{"metricpersist"},
+			ConsumeEnabled:        false,
+			PersistMessageVersion: mdata.PersistMessageBatchV1,
+		},
+		instance:  "mt1",
+		in:        make(chan mdata.SavedChunk),
+		bPool:     util.NewBufferPool(),
+		handler:   &countingHandler{},
+		conn:      &connection{producer: producer, stopConsuming: make(chan struct{})},
+		metrics:   newNotifierMetrics("test.stop"),
+		StopChan:  make(chan int),
+		reconnect: make(chan uint64, 1),
+		shutdown:  make(chan struct{}),
+		produced:  make(chan struct{}),
+	}
+	go c.produce()
+	for i := 0; i < 2; i++ {
+		c.Send(mdata.SavedChunk{Key: schema.AMKey{MKey: schema.MKey{Org: 1}}.String(), T0: uint32(60 * (i + 1))})
+	}
+	c.Stop()
+
+	select {
+	case <-c.StopChan:
+	case <-time.After(10 * time.Second):
+		t.Fatalf("timed out waiting for the notifier to stop")
+	}
+	producer.Lock()
+	defer producer.Unlock()
+	if len(producer.published) != 2 {
+		t.Fatalf("expected 2 messages to be published before stopping, got %d", len(producer.published))
+	}
+	if !producer.closed {
+		t.Fatalf("expected the producer to be closed")
+	}
+}
+
+func TestNextBackoff(t *testing.T) {
+	backoff := reconnectBackoffMin
+	var got []time.Duration
+	for i := 0; i < 8; i++ {
+		got = append(got, backoff)
+		backoff = nextBackoff(backoff)
+	}
+	exp := []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 8 * time.Second, 16 * time.Second, 32 * time.Second, time.Minute, time.Minute}
+	if !reflect.DeepEqual(got, exp) {
+		t.Fatalf("expected backoffs %v, got %v", exp, got)
+	}
+}
+
+func TestRequestReconnect(t *testing.T) {
+	c := &NotifierKafka{
+		reconnect: make(chan uint64, 1),
+	}
+	c.requestReconnect(3)
+	// must not block while a reconnect is pending
+	c.requestReconnect(3)
+	if gen := <-c.reconnect; gen != 3 {
+		t.Fatalf("expected reconnect request for generation 3, got %d", gen)
+	}
+	select {
+	case gen := <-c.reconnect:
+		t.Fatalf("expected a single pending reconnect request, got another one for generation %d", gen)
+	default:
+	}
+}
+
+func TestLags(t *testing.T) {
+	gauges := func(name string) map[string]map[int32]*stats.Gauge64 {
+		return map[string]map[int32]*stats.Gauge64{
+			"b": {0: stats.NewGauge64("test.lags.b.0." + name), 2: stats.NewGauge64("test.lags.b.2." + name)},
+			"a": {0: stats.NewGauge64("test.lags.a.0." + name), 2: stats.NewGauge64("test.lags.a.2." + name)},
+		}
+	}
+	cfg := &NotifierKafkaConfig{
+		topics:           []string{"b", "a"},
+		partitions:       []int32{2, 0},
+		partitionOffset:  gauges("offset"),
+		partitionLogSize: gauges("log_size"),
+		partitionLag:     gauges("lag"),
+	}
+	cfg.partitionOffset["b"][2].Set(90)
+	cfg.partitionLogSize["b"][2].Set(100)
+	cfg.partitionLag["b"][2].Set(10)
+	exp := []PartitionLag{
+		{Topic: "a", Partition: 0},
+		{Topic: "a", Partition: 2},
+		{Topic: "b", Partition: 0},
+		{Topic: "b", Partition: 2, Offset: 90, LogSize: 100, Lag: 10},
+	}
+	if got := cfg.Lags(); !reflect.DeepEqual(got, exp) {
+		t.Fatalf("expected lags %v, got %v", exp, got)
+	}
+	if got := (&NotifierKafkaConfig{}).Lags(); len(got) != 0 {
+		t.Fatalf("expected no lags when not consuming, got %v", got)
+	}
+}
+
+func TestStats(t *testing.T) {
+	// like New does, so Send blocks until the producer has taken the chunk
+	c := &NotifierKafka{
+		in:       make(chan mdata.SavedChunk),
+		shutdown: make(chan struct{}),
+		produced: make(chan struct{}),
+		metrics:  newNotifierMetrics("test.stats"),
+		cfg: &NotifierKafkaConfig{
+			partitions: []int32{0, 1},
+			partitionLag: map[string]map[int32]*stats.Gauge64{
+				"a": {0: stats.NewGauge64("test.stats.a.0.lag"), 1: stats.NewGauge64("test.stats.a.1.lag")},
+				"b": {0: stats.NewGauge64("test.stats.b.0.lag"), 1: stats.NewGauge64("test.stats.b.1.lag")},
+			},
+		},
+	}
+	go c.produce()
+	defer close(c.shutdown)
+	before := c.Stats()
+	if before.BufferDepth != 0 {
+		t.Fatalf("expected an empty buffer, got depth %d", before.BufferDepth)
+	}
+
+	c.Send(mdata.SavedChunk{})
+	c.Send(mdata.SavedChunk{})
+	c.metrics.messagesPublished.Add(1000)
+	c.metrics.reconnects.Inc()
+	c.cfg.partitionLag["a"][1].Set(42)
+	c.cfg.partitionLag["b"][1].Set(8)
+
+	// Send returns once the producer received the chunk, it may not have buffered it yet
+	var after NotifierStats
+	for i := 0; i < 100; i++ {
+		after = c.Stats()
+		if after.BufferDepth == 2 {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	exp := NotifierStats{
+		BufferDepth:       2,
+		MessagesPublished: before.MessagesPublished + 1000,
+		PerPartitionLag:   map[int32]int64{0: 0, 1: 50},
+		ReconnectCount:    before.ReconnectCount + 1,
+	}
+	if !reflect.DeepEqual(after, exp) {
+		t.Fatalf("expected stats %+v, got %+v", exp, after)
+	}
+}
+
+func TestMetricPrefix(t *testing.T) {
+	cfg := &NotifierKafkaConfig{topics: []string{"metricpersist", "metricpersist.dc2"}}
+	if got := cfg.metricPrefix("metricpersist"); got != "cluster.notifier.kafka" {
+		t.Fatalf("expected the publish topic to use the prefix without topic, got %q", got)
+	}
+	if got := cfg.metricPrefix("metricpersist.dc2"); got != "cluster.notifier.kafka.topic.metricpersist_dc2" {
+		t.Fatalf("expected additional topics to use a prefix with topic, got %q", got)
+	}
+	cfg.MetricPrefix = "cluster.notifier.kafka-dc2"
+	if got := cfg.metricPrefix("metricpersist.dc2"); got != "cluster.notifier.kafka-dc2.topic.metricpersist_dc2" {
+		t.Fatalf("expected additional topics to use the configured prefix, got %q", got)
+	}
+}
+
+func TestVersionHeaders(t *testing.T) {
+	if h := versionHeaders("1.2.3", sarama.V0_10_2_0); h != nil {
+		t.Fatalf("expected no headers for kafka versions without header support, got %v", h)
+	}
+	if h := versionHeaders("", sarama.V2_0_0_0); h != nil {
+		t.Fatalf("expected no headers without version, got %v", h)
+	}
+	headers := versionHeaders("1.2.3", sarama.V2_0_0_0)
+	msg := &sarama.ConsumerMessage{}
+	for i := range headers {
+		msg.Headers = append(msg.Headers, &headers[i])
+	}
+	version, ok := messageVersion(msg)
+	if !ok || version != "1.2.3" {
+		t.Fatalf("expected version 1.2.3, got %q (found: %t)", version, ok)
+	}
+	if _, ok := messageVersion(&sarama.ConsumerMessage{}); ok {
+		t.Fatalf("expected no version for message without headers")
+	}
+}
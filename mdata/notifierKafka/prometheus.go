@@ -0,0 +1,43 @@
+package notifierKafka
+
+import (
+	"strconv"
+
+	"github.com/grafana/metrictank/stats"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// LagCollector exposes the consumer lag of the partitions of the kafka cluster notifier
+// as a prometheus gauge, labeled by topic and partition.
+type LagCollector struct {
+	lag  map[string]map[int32]*stats.Gauge64 // by topic and partition
+	desc *prometheus.Desc
+}
+
+func NewLagCollector(lag map[string]map[int32]*stats.Gauge64) *LagCollector {
+	return &LagCollector{
+		lag: lag,
+		desc: prometheus.NewDesc(
+			"metrictank_cluster_notifier_kafka_partition_lag",
+			"How many messages there are in the kafka partition that we have not yet consumed",
+			[]string{"topic", "partition"},
+			nil,
+		),
+	}
+}
+
+// Describe implements prometheus.Collector
+func (l *LagCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- l.desc
+}
+
+// Collect implements prometheus.Collector
+func (l *LagCollector) Collect(ch chan<- prometheus.Metric) {
+	for topic, partitions := range l.lag {
+		for partition, lag := range partitions {
+			// the gauge stores the lag as an int, which may be negative
+			value := float64(int64(lag.Peek()))
+			ch <- prometheus.MustNewConstMetric(l.desc, prometheus.GaugeValue, value, topic, strconv.Itoa(int(partition)))
+		}
+	}
+}
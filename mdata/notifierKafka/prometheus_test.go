@@ -0,0 +1,56 @@
+package notifierKafka
+
+import (
+	"testing"
+
+	"github.com/grafana/metrictank/stats"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestLagCollector(t *testing.T) {
+	lag := map[string]map[int32]*stats.Gauge64{
+		"metricpersist": {
+			0: new(stats.Gauge64),
+			1: new(stats.Gauge64),
+		},
+		"metricpersist-other": {
+			0: new(stats.Gauge64),
+		},
+	}
+	lag["metricpersist"][0].Set(10)
+	lag["metricpersist"][1].Set(-1)
+	lag["metricpersist-other"][0].Set(5)
+
+	registry := prometheus.NewPedanticRegistry()
+	err := registry.Register(NewLagCollector(lag))
+	if err != nil {
+		t.Fatalf("failed to register collector: %s", err)
+	}
+	families, err := registry.Gather()
+	if err != nil {
+		t.Fatalf("failed to gather metrics: %s", err)
+	}
+	if len(families) != 1 || families[0].GetName() != "metrictank_cluster_notifier_kafka_partition_lag" {
+		t.Fatalf("expected a single metrictank_cluster_notifier_kafka_partition_lag metric family, got %v", families)
+	}
+
+	exp := map[string]float64{"metricpersist:0": 10, "metricpersist:1": -1, "metricpersist-other:0": 5}
+	metrics := families[0].GetMetric()
+	if len(metrics) != len(exp) {
+		t.Fatalf("expected %d metrics, got %d", len(exp), len(metrics))
+	}
+	for _, m := range metrics {
+		labels := make(map[string]string)
+		for _, l := range m.GetLabel() {
+			labels[l.GetName()] = l.GetValue()
+		}
+		key := labels["topic"] + ":" + labels["partition"]
+		val, ok := exp[key]
+		if !ok {
+			t.Fatalf("unexpected topic and partition labels %q", key)
+		}
+		if m.GetGauge().GetValue() != val {
+			t.Fatalf("partition %s: expected lag %f, got %f", key, val, m.GetGauge().GetValue())
+		}
+	}
+}
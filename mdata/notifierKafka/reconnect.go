@@ -0,0 +1,245 @@
+package notifierKafka
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/Shopify/sarama"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	reconnectBackoffMin = time.Second
+	reconnectBackoffMax = time.Minute
+	clientCheckInterval = 10 * time.Second
+)
+
+// connection holds the kafka clients, consumer and producer of a NotifierKafka.
+// when the connection is lost, it is closed and replaced by a new one.
+type connection struct {
+	generation     uint64
+	client         sarama.Client        // client of the cluster we consume from. nil if consuming is disabled
+	consumer       sarama.Consumer      // nil if consuming is disabled
+	producerClient sarama.Client        // same as client, unless we produce to a separate cluster
+	producer       sarama.SyncProducer  // nil if use-async-producer is set
+	asyncProducer  sarama.AsyncProducer // nil unless use-async-producer is set
+
+	// guards sending to the asyncProducer, which must not happen anymore once it is being closed
+	asyncMu     sync.RWMutex
+	asyncClosed bool
+	asyncWg     sync.WaitGroup // tracks the goroutines handling the results of the asyncProducer
+
+	// signal to PartitionConsumers to shutdown
+	stopConsuming chan struct{}
+	stopOnce      sync.Once
+	closeOnce     sync.Once
+	wg            sync.WaitGroup // tracks the PartitionConsumers of this connection
+}
+
+// connect creates the clients, consumer and producer for the given config
+func connect(cfg *NotifierKafkaConfig, generation uint64) (*connection, error) {
+	conn := &connection{
+		generation:    generation,
+		stopConsuming: make(chan struct{}),
+	}
+	var err error
+	if cfg.ConsumeEnabled {
+		conn.client, err = sarama.NewClient(cfg.consumerBrokers, cfg.config)
+		if err != nil {
+			return nil, fmt.Errorf("failed to start client: %s", err)
+		}
+		conn.consumer, err = sarama.NewConsumerFromClient(conn.client)
+		if err != nil {
+			conn.client.Close()
+			return nil, fmt.Errorf("failed to initialize consumer: %s", err)
+		}
+		log.Info("kafka-cluster: consumer initialized without error")
+	}
+
+	conn.producerClient = conn.client
+	if conn.client == nil || cfg.SeparateClusters() {
+		conn.producerClient, err = sarama.NewClient(cfg.producerBrokers, cfg.config)
+		if err != nil {
+			conn.closeClients()
+			return nil, fmt.Errorf("failed to start producer client: %s", err)
+		}
+	}
+	if cfg.UseAsyncProducer {
+		conn.asyncProducer, err = sarama.NewAsyncProducerFromClient(conn.producerClient)
+	} else {
+		conn.producer, err = sarama.NewSyncProducerFromClient(conn.producerClient)
+	}
+	if err != nil {
+		conn.closeClients()
+		if conn.producerClient != conn.client {
+			conn.producerClient.Close()
+		}
+		return nil, fmt.Errorf("failed to initialize producer: %s", err)
+	}
+	return conn, nil
+}
+
+// closed returns whether any of the clients of the connection have been closed
+func (conn *connection) closed() bool {
+	if conn.client != nil && conn.client.Closed() {
+		return true
+	}
+	return conn.producerClient.Closed()
+}
+
+// stopConsumers signals the PartitionConsumers of the connection to shut down
+func (conn *connection) stopConsumers() {
+	conn.stopOnce.Do(func() {
+		close(conn.stopConsuming)
+	})
+}
+
+// closeProducer closes the producer of the connection.
+// the async producer first gets to finish the messages it has in flight.
+func (conn *connection) closeProducer() {
+	conn.closeOnce.Do(func() {
+		if conn.asyncProducer == nil {
+			conn.producer.Close()
+			return
+		}
+		conn.asyncMu.Lock()
+		conn.asyncClosed = true
+		conn.asyncMu.Unlock()
+		// we don't use Close, as it would consume the results that the goroutines
+		// of handleAsync need to see.
+		conn.asyncProducer.AsyncClose()
+		conn.asyncWg.Wait()
+	})
+}
+
+// produceAsync hands the message to the async producer.
+// it returns false if the producer is being closed.
+func (conn *connection) produceAsync(msg *sarama.ProducerMessage) bool {
+	conn.asyncMu.RLock()
+	defer conn.asyncMu.RUnlock()
+	if conn.asyncClosed {
+		return false
+	}
+	conn.asyncProducer.Input() <- msg
+	return true
+}
+
+// close shuts down the PartitionConsumers, producer and clients of the connection
+func (conn *connection) close() {
+	conn.stopConsumers()
+	conn.wg.Wait()
+	conn.closeProducer()
+	if conn.consumer != nil {
+		conn.consumer.Close()
+	}
+	conn.closeClients()
+	if conn.producerClient != conn.client && !conn.producerClient.Closed() {
+		conn.producerClient.Close()
+	}
+}
+
+func (conn *connection) closeClients() {
+	if conn.client != nil && !conn.client.Closed() {
+		conn.client.Close()
+	}
+}
+
+// requestReconnect asks the supervisor to replace the connection with the given generation.
+// requests for connections that have already been replaced are ignored by the supervisor.
+func (c *NotifierKafka) requestReconnect(generation uint64) {
+	select {
+	case c.reconnect <- generation:
+	default:
+		// a reconnect has already been requested
+	}
+}
+
+// supervise replaces the connection when it is lost, until the notifier is stopped.
+// a connection is considered lost when publishing to it fails or when one of its clients got closed.
+func (c *NotifierKafka) supervise() {
+	ticker := time.NewTicker(clientCheckInterval)
+	defer ticker.Stop()
+	for {
+		var generation uint64
+		select {
+		case <-c.shutdown:
+			return
+		case generation = <-c.reconnect:
+		case <-ticker.C:
+			conn := c.connection()
+			if !conn.closed() {
+				continue
+			}
+			generation = conn.generation
+		}
+		c.reestablish(generation)
+	}
+}
+
+// reestablish closes the connection with the given generation and sets up a new one,
+// retrying with exponential backoff until it succeeds or the notifier is stopped.
+func (c *NotifierKafka) reestablish(generation uint64) {
+	old := c.connection()
+	if old.generation != generation {
+		return
+	}
+	log.Warnf("kafka-cluster: connection lost, reconnecting")
+	old.close()
+
+	backoff := reconnectBackoffMin
+	for {
+		c.metrics.reconnects.Inc()
+		conn, err := connect(c.cfg, generation+1)
+		if err == nil {
+			c.mu.Lock()
+			if c.stopped {
+				c.mu.Unlock()
+				conn.close()
+				return
+			}
+			c.conn = conn
+			if c.cfg.UseAsyncProducer {
+				c.handleAsync(conn)
+			}
+			if c.cfg.ConsumeEnabled {
+				c.resume(conn)
+			}
+			c.mu.Unlock()
+			log.Info("kafka-cluster: reconnected")
+			return
+		}
+		log.Errorf("kafka-cluster: failed to reconnect: %s. retrying in %s", err, backoff)
+		select {
+		case <-c.shutdown:
+			return
+		case <-time.After(backoff):
+		}
+		backoff = nextBackoff(backoff)
+	}
+}
+
+// resume starts consuming all partitions of the new connection where we left off.
+// unlike start, it does not wait for any backlog to be processed.
+// must be called with the lock held.
+func (c *NotifierKafka) resume(conn *connection) {
+	for _, topic := range c.cfg.topics {
+		for _, partition := range c.cfg.partitions {
+			offset := atomic.LoadInt64(c.nextOffset[topic][partition])
+			if offset < 0 {
+				offset = c.startOffset(conn, topic, partition)
+			}
+			c.consume(conn, topic, partition, offset, nil)
+		}
+	}
+}
+
+// nextBackoff doubles the given backoff, up to reconnectBackoffMax
+func nextBackoff(backoff time.Duration) time.Duration {
+	backoff *= 2
+	if backoff > reconnectBackoffMax {
+		backoff = reconnectBackoffMax
+	}
+	return backoff
+}
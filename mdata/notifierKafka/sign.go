@@ -0,0 +1,31 @@
+package notifierKafka
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+)
+
+// signatures are HMAC-SHA256's of the message, appended to the message
+const signatureSize = sha256.Size
+
+// sign returns the signature of the message using the given key
+func sign(key, msg []byte) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write(msg)
+	return mac.Sum(nil)
+}
+
+// verify checks whether the signed message has a valid signature for any of the keys.
+// if so, it returns the message without the signature.
+func verify(keys [][]byte, signed []byte) ([]byte, bool) {
+	if len(signed) < signatureSize {
+		return nil, false
+	}
+	msg, signature := signed[:len(signed)-signatureSize], signed[len(signed)-signatureSize:]
+	for _, key := range keys {
+		if hmac.Equal(signature, sign(key, msg)) {
+			return msg, true
+		}
+	}
+	return nil, false
+}
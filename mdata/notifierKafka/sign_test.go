@@ -0,0 +1,35 @@
+package notifierKafka
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestSignVerify(t *testing.T) {
+	msg := []byte("some persist message")
+	oldKey, newKey, otherKey := []byte("old"), []byte("new"), []byte("other")
+	signed := append(append([]byte{}, msg...), sign(newKey, msg)...)
+
+	cases := []struct {
+		keys [][]byte
+		data []byte
+		exp  bool
+	}{
+		{[][]byte{newKey}, signed, true},
+		{[][]byte{newKey, oldKey}, signed, true},
+		// during key rotation, messages signed with the new key can be verified by instances that sign with the old key
+		{[][]byte{oldKey, newKey}, signed, true},
+		{[][]byte{otherKey}, signed, false},
+		{[][]byte{newKey}, msg, false},
+		{[][]byte{newKey}, signed[:10], false},
+	}
+	for i, c := range cases {
+		got, ok := verify(c.keys, c.data)
+		if ok != c.exp {
+			t.Fatalf("case %d: expected verification result %t, got %t", i, c.exp, ok)
+		}
+		if ok && !bytes.Equal(got, msg) {
+			t.Fatalf("case %d: expected message %q, got %q", i, msg, got)
+		}
+	}
+}
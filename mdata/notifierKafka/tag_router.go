@@ -0,0 +1,111 @@
+package notifierKafka
+
+import (
+	"encoding/binary"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/grafana/metrictank/idx"
+	"github.com/raintank/schema"
+)
+
+// PartitionResolver determines the partition to publish the persist messages of a metric to.
+// the mdata.NotifierHandler implements it, by returning the partition the metric is ingested on.
+type PartitionResolver interface {
+	PartitionOf(key schema.MKey) (int32, bool)
+}
+
+// DefGetter looks up metric definitions, like an idx.MetricIndex
+type DefGetter interface {
+	Get(key schema.MKey) (idx.Archive, bool)
+}
+
+// partitionRange is an inclusive range of partitions
+type partitionRange struct {
+	first int32
+	last  int32
+}
+
+// pick returns the partition within the range for the given metric.
+// the key is an md5 hash, so its first bytes spread metrics evenly across the range.
+func (r partitionRange) pick(key schema.MKey) int32 {
+	n := uint32(r.last-r.first) + 1
+	return r.first + int32(binary.LittleEndian.Uint32(key.Key[:4])%n)
+}
+
+func (r partitionRange) partitions() []int32 {
+	var partitions []int32
+	for p := r.first; p <= r.last; p++ {
+		partitions = append(partitions, p)
+	}
+	return partitions
+}
+
+// TagRouter routes the persist messages of metrics by tag: metrics with a configured tag
+// are published to the partitions configured for that tag, other metrics to the partition
+// they are ingested on. if a metric has several configured tags, the first one wins.
+// persist messages must reach the instances that consume the metric's data, so this only
+// works if metrics are partitioned by the same tags when they are ingested.
+type TagRouter struct {
+	routes map[string]partitionRange // by tag, as key=value
+	defs   DefGetter
+}
+
+// NewTagRouter creates a TagRouter for the tag-routes of the given config, which must have been processed already.
+func NewTagRouter(cfg *NotifierKafkaConfig, defs DefGetter) *TagRouter {
+	return &TagRouter{
+		routes: cfg.tagRoutes,
+		defs:   defs,
+	}
+}
+
+// PartitionOf returns the partition of the metric per its tags
+func (r *TagRouter) PartitionOf(key schema.MKey) (int32, bool) {
+	def, ok := r.defs.Get(key)
+	if !ok {
+		return 0, false
+	}
+	for _, tag := range def.Tags {
+		if route, ok := r.routes[tag]; ok {
+			return route.pick(key), true
+		}
+	}
+	return def.Partition, true
+}
+
+// parseTagRoutes parses a comma separated list of tag=value:partitions,
+// where partitions is a single partition or an inclusive range like 0-3.
+func parseTagRoutes(s string) (map[string]partitionRange, error) {
+	routes := make(map[string]partitionRange)
+	for _, spec := range strings.Split(s, ",") {
+		pos := strings.LastIndex(spec, ":")
+		if pos < 0 {
+			return nil, fmt.Errorf("invalid entry %q. must be tag=value:partitions", spec)
+		}
+		tag, partitions := spec[:pos], spec[pos+1:]
+		if !schema.ValidateTags([]string{tag}) {
+			return nil, fmt.Errorf("invalid tag in entry %q. must be tag=value", spec)
+		}
+		if _, ok := routes[tag]; ok {
+			return nil, fmt.Errorf("duplicate tag in entry %q", spec)
+		}
+		var r partitionRange
+		first, last := partitions, partitions
+		if pos := strings.Index(partitions, "-"); pos >= 0 {
+			first, last = partitions[:pos], partitions[pos+1:]
+		}
+		p, err := strconv.ParseInt(first, 10, 32)
+		if err != nil || p < 0 {
+			return nil, fmt.Errorf("invalid partitions in entry %q", spec)
+		}
+		r.first = int32(p)
+		p, err = strconv.ParseInt(last, 10, 32)
+		if err != nil || int32(p) < r.first {
+			return nil, fmt.Errorf("invalid partitions in entry %q", spec)
+		}
+		r.last = int32(p)
+		routes[tag] = r
+	}
+	return routes, nil
+}
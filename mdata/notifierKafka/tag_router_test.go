@@ -0,0 +1,89 @@
+package notifierKafka
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/grafana/metrictank/idx"
+	"github.com/raintank/schema"
+)
+
+type mockDefGetter map[schema.MKey]idx.Archive
+
+func (m mockDefGetter) Get(key schema.MKey) (idx.Archive, bool) {
+	def, ok := m[key]
+	return def, ok
+}
+
+func TestParseTagRoutes(t *testing.T) {
+	got, err := parseTagRoutes("env=prod:0-3,env=dev:4-7,team=a:b:8")
+	if err != nil {
+		t.Fatalf("unexpected error %s", err)
+	}
+	exp := map[string]partitionRange{
+		"env=prod": {0, 3},
+		"env=dev":  {4, 7},
+		"team=a:b": {8, 8},
+	}
+	if !reflect.DeepEqual(got, exp) {
+		t.Fatalf("expected %v, got %v", exp, got)
+	}
+
+	for _, s := range []string{"env=prod", "env:0", "env=prod:", "env=prod:a", "env=prod:3-1", "env=prod:-1", "env=prod:0,env=prod:1"} {
+		if _, err := parseTagRoutes(s); err == nil {
+			t.Fatalf("expected an error parsing %q", s)
+		}
+	}
+}
+
+func TestTagRouter(t *testing.T) {
+	newKey := func(b byte) schema.MKey {
+		return schema.MKey{Key: [16]byte{b}, Org: 1}
+	}
+	defs := mockDefGetter{}
+	add := func(key schema.MKey, partition int32, tags ...string) {
+		def := idx.Archive{}
+		def.Id = key
+		def.Partition = partition
+		def.Tags = tags
+		defs[key] = def
+	}
+	add(newKey(1), 9, "env=prod")
+	add(newKey(6), 9, "env=prod")
+	add(newKey(2), 9, "env=dev", "team=a")
+	add(newKey(3), 9, "env=test")
+	add(newKey(4), 9)
+
+	router := NewTagRouter(&NotifierKafkaConfig{tagRoutes: map[string]partitionRange{
+		"env=prod": {0, 3},
+		"env=dev":  {4, 4},
+	}}, defs)
+
+	cases := []struct {
+		key          schema.MKey
+		expPartition int32
+		expOk        bool
+	}{
+		{newKey(1), 1, true},
+		{newKey(6), 2, true},
+		{newKey(2), 4, true},
+		{newKey(3), 9, true}, // no route for the tag, so the partition of the metric
+		{newKey(4), 9, true},
+		{newKey(5), 0, false},
+	}
+	for _, c := range cases {
+		partition, ok := router.PartitionOf(c.key)
+		if partition != c.expPartition || ok != c.expOk {
+			t.Fatalf("%s: expected partition %d (ok %t), got %d (ok %t)", c.key, c.expPartition, c.expOk, partition, ok)
+		}
+	}
+
+	// the router takes precedence over the handler
+	c := &NotifierKafka{
+		cfg:     &NotifierKafkaConfig{PartitionResolver: router},
+		handler: &countingHandler{},
+	}
+	if partition, _ := c.partitionOf(newKey(2)); partition != 4 {
+		t.Fatalf("expected partition 4, got %d", partition)
+	}
+}
@@ -0,0 +1,162 @@
+package notifierKafka
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/Shopify/sarama"
+)
+
+// wal stores produced messages that have not been acknowledged by kafka yet,
+// so that they survive a restart of the process.
+// every flush results in one segment file per partition, in a directory per partition.
+// segments are removed once the messages they contain have been sent.
+// a segment consists of records of <uint32 length><message value>
+type wal struct {
+	dir string
+	seq uint64
+}
+
+func newWAL(dir string) (*wal, error) {
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		return nil, err
+	}
+	// seed the sequence with the current time, so that segments written after a
+	// restart sort after the ones left behind by the previous run
+	return &wal{
+		dir: dir,
+		seq: uint64(time.Now().UnixNano()),
+	}, nil
+}
+
+// write persists the given messages, grouped by partition, and returns the paths
+// of the segments written.
+func (w *wal) write(payload []*sarama.ProducerMessage) ([]string, error) {
+	byPartition := make(map[int32][]*sarama.ProducerMessage)
+	for _, msg := range payload {
+		byPartition[msg.Partition] = append(byPartition[msg.Partition], msg)
+	}
+	seq := atomic.AddUint64(&w.seq, 1)
+	var segments []string
+	for partition, msgs := range byPartition {
+		segment, err := w.writeSegment(partition, seq, msgs)
+		if err != nil {
+			return segments, err
+		}
+		segments = append(segments, segment)
+	}
+	return segments, nil
+}
+
+func (w *wal) writeSegment(partition int32, seq uint64, msgs []*sarama.ProducerMessage) (string, error) {
+	dir := filepath.Join(w.dir, strconv.Itoa(int(partition)))
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		return "", err
+	}
+	segment := filepath.Join(dir, fmt.Sprintf("%020d.wal", seq))
+	fd, err := os.OpenFile(segment, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+	if err != nil {
+		return "", err
+	}
+	buf := bufio.NewWriter(fd)
+	for _, msg := range msgs {
+		value := []byte(msg.Value.(sarama.ByteEncoder))
+		binary.Write(buf, binary.LittleEndian, uint32(len(value)))
+		buf.Write(value)
+	}
+	err = buf.Flush()
+	if err == nil {
+		err = fd.Sync()
+	}
+	fd.Close()
+	if err != nil {
+		os.Remove(segment)
+		return "", err
+	}
+	return segment, nil
+}
+
+// remove deletes segments whose messages have been sent
+func (w *wal) remove(segments []string) {
+	for _, segment := range segments {
+		os.Remove(segment)
+	}
+}
+
+// replay loads the messages of all segments, in the order they were written,
+// and returns them along with the paths of the segments they were read from.
+// the messages are addressed to the given topic.
+func (w *wal) replay(topic string) ([]*sarama.ProducerMessage, []string, error) {
+	var payload []*sarama.ProducerMessage
+	var segments []string
+	dirs, err := ioutil.ReadDir(w.dir)
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, dir := range dirs {
+		if !dir.IsDir() {
+			continue
+		}
+		partition, err := strconv.Atoi(dir.Name())
+		if err != nil {
+			continue
+		}
+		matches, err := filepath.Glob(filepath.Join(w.dir, dir.Name(), "*.wal"))
+		if err != nil {
+			return nil, nil, err
+		}
+		sort.Strings(matches)
+		for _, segment := range matches {
+			values, err := readSegment(segment)
+			if err != nil {
+				return nil, nil, fmt.Errorf("failed to read wal segment %s: %s", segment, err)
+			}
+			for _, value := range values {
+				payload = append(payload, &sarama.ProducerMessage{
+					Topic:     topic,
+					Value:     sarama.ByteEncoder(value),
+					Partition: int32(partition),
+				})
+			}
+			segments = append(segments, segment)
+		}
+	}
+	return payload, segments, nil
+}
+
+func readSegment(segment string) ([][]byte, error) {
+	fd, err := os.Open(segment)
+	if err != nil {
+		return nil, err
+	}
+	defer fd.Close()
+	reader := bufio.NewReader(fd)
+	var values [][]byte
+	for {
+		var size uint32
+		err := binary.Read(reader, binary.LittleEndian, &size)
+		if err == io.EOF {
+			return values, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		value := make([]byte, size)
+		_, err = io.ReadFull(reader, value)
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, value)
+	}
+}
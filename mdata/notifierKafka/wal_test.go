@@ -0,0 +1,78 @@
+package notifierKafka
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/Shopify/sarama"
+)
+
+func TestWALWriteReplayRemove(t *testing.T) {
+	dir, err := ioutil.TempDir("", "notifierKafkaWAL")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	w, err := newWAL(dir)
+	if err != nil {
+		t.Fatalf("failed to create wal: %s", err)
+	}
+	msg := func(partition int32, value string) *sarama.ProducerMessage {
+		return &sarama.ProducerMessage{Topic: "test", Partition: partition, Value: sarama.ByteEncoder(value)}
+	}
+	segments1, err := w.write([]*sarama.ProducerMessage{msg(0, "a"), msg(1, "b"), msg(0, "c")})
+	if err != nil {
+		t.Fatalf("failed to write to wal: %s", err)
+	}
+	if len(segments1) != 2 {
+		t.Fatalf("expected 2 segments, got %d", len(segments1))
+	}
+	segments2, err := w.write([]*sarama.ProducerMessage{msg(0, "d")})
+	if err != nil {
+		t.Fatalf("failed to write to wal: %s", err)
+	}
+
+	// a new wal over the same directory sees all unsent messages
+	w2, err := newWAL(dir)
+	if err != nil {
+		t.Fatalf("failed to create wal: %s", err)
+	}
+	payload, segments, err := w2.replay("test")
+	if err != nil {
+		t.Fatalf("failed to replay wal: %s", err)
+	}
+	if len(segments) != 3 {
+		t.Fatalf("expected 3 segments, got %d", len(segments))
+	}
+	exp := map[int32][]string{0: {"a", "c", "d"}, 1: {"b"}}
+	got := make(map[int32][]string)
+	for _, m := range payload {
+		if m.Topic != "test" {
+			t.Fatalf("expected topic test, got %q", m.Topic)
+		}
+		got[m.Partition] = append(got[m.Partition], string(m.Value.(sarama.ByteEncoder)))
+	}
+	for p, values := range exp {
+		if len(got[p]) != len(values) {
+			t.Fatalf("partition %d: expected %v, got %v", p, values, got[p])
+		}
+		for i := range values {
+			if got[p][i] != values[i] {
+				t.Fatalf("partition %d: expected %v, got %v", p, values, got[p])
+			}
+		}
+	}
+
+	// once sent, segments are removed and no longer replayed
+	w.remove(segments1)
+	w.remove(segments2)
+	payload, segments, err = w2.replay("test")
+	if err != nil {
+		t.Fatalf("failed to replay wal: %s", err)
+	}
+	if len(payload) != 0 || len(segments) != 0 {
+		t.Fatalf("expected empty wal, got %d messages in %d segments", len(payload), len(segments))
+	}
+}
@@ -0,0 +1,149 @@
+package notifierNSQ
+
+import (
+	"flag"
+	"strings"
+	"time"
+
+	"github.com/grafana/globalconf"
+	"github.com/grafana/metrictank/mdata"
+	"github.com/grafana/metrictank/stats"
+	"github.com/nsqio/go-nsq"
+	log "github.com/sirupsen/logrus"
+)
+
+// NotifierNSQConfig holds the settings of a NotifierNSQ.
+// the exported fields are the user facing settings, Process validates them
+// and derives the settings that New needs.
+type NotifierNSQConfig struct {
+	Enabled               bool
+	NsqdTCPAddrs          string
+	NsqdHTTPAddrs         string
+	LookupdHTTPAddrs      string
+	Topic                 string
+	Channel               string
+	MaxInFlight           int
+	BacklogProcessTimeout string
+	PersistMessageVersion int
+
+	// set by Process
+	nsqdTCPAddrs          []string
+	nsqdHTTPAddrs         []string
+	lookupdHTTPAddrs      []string
+	channel               string
+	producerConfig        *nsq.Config
+	consumerConfig        *nsq.Config
+	backlogProcessTimeout time.Duration
+}
+
+// return NotifierNSQConfig with default values set.
+func NewNotifierNSQConfig() *NotifierNSQConfig {
+	return &NotifierNSQConfig{
+		Enabled:               false,
+		NsqdTCPAddrs:          "nsqd:4150",
+		NsqdHTTPAddrs:         "nsqd:4151",
+		LookupdHTTPAddrs:      "",
+		Topic:                 "metricpersist",
+		Channel:               "",
+		MaxInFlight:           200,
+		BacklogProcessTimeout: "60s",
+		PersistMessageVersion: mdata.PersistMessageBatchV1,
+	}
+}
+
+// CliConfig is the config populated from the nsq-cluster flags / config section
+var CliConfig = NewNotifierNSQConfig()
+
+var FlagSet *flag.FlagSet
+
+// metric cluster.notifier.nsq.messages-published is a counter of messages published to the nsq cluster notifier
+var messagesPublished = stats.NewCounter32("cluster.notifier.nsq.messages-published")
+
+// metric cluster.notifier.nsq.flush-errors is a counter of failed attempts to publish messages to the nsq cluster notifier
+var flushErrors = stats.NewCounter32("cluster.notifier.nsq.flush-errors")
+
+// metric cluster.notifier.nsq.message_size is the sizes seen of messages through the nsq cluster notifier
+var messagesSize = stats.NewMeter32("cluster.notifier.nsq.message_size", false)
+
+// metric cluster.notifier.nsq.channel-depth is how many messages there are in our channel, across all nsqd's, that we have not yet consumed
+var channelDepth = stats.NewGauge64("cluster.notifier.nsq.channel-depth")
+
+func init() {
+	FlagSet = flag.NewFlagSet("nsq-cluster", flag.ExitOnError)
+	FlagSet.BoolVar(&CliConfig.Enabled, "enabled", CliConfig.Enabled, "")
+	FlagSet.StringVar(&CliConfig.NsqdTCPAddrs, "nsqd-tcp-address", CliConfig.NsqdTCPAddrs, "nsqd TCP address to publish to, and to consume from if lookupd-http-address is not set (may be given multiple times as a comma-separated list)")
+	FlagSet.StringVar(&CliConfig.NsqdHTTPAddrs, "nsqd-http-address", CliConfig.NsqdHTTPAddrs, "nsqd HTTP address to query the depth of our channel from (may be given multiple times as a comma-separated list). should list the same nsqd's as nsqd-tcp-address")
+	FlagSet.StringVar(&CliConfig.LookupdHTTPAddrs, "lookupd-http-address", CliConfig.LookupdHTTPAddrs, "nsqlookupd HTTP address to discover the nsqd's to consume from (may be given multiple times as a comma-separated list)")
+	FlagSet.StringVar(&CliConfig.Topic, "topic", CliConfig.Topic, "NSQ topic")
+	FlagSet.StringVar(&CliConfig.Channel, "channel", CliConfig.Channel, "NSQ channel to consume from. must be unique per instance, so that every instance receives all messages. defaults to the instance name")
+	FlagSet.IntVar(&CliConfig.MaxInFlight, "max-in-flight", CliConfig.MaxInFlight, "max number of messages to allow in flight")
+	FlagSet.StringVar(&CliConfig.BacklogProcessTimeout, "backlog-process-timeout", CliConfig.BacklogProcessTimeout, "Maximum time backlog processing can block during metrictank startup. Setting to a low value may result in data loss")
+	FlagSet.IntVar(&CliConfig.PersistMessageVersion, "persist-message-version", CliConfig.PersistMessageVersion, "format of the published persist messages. 1 for json, 2 for the more compact and faster msgp. only use 2 once all instances of the cluster run a version that understands it")
+	globalconf.Register("nsq-cluster", FlagSet, flag.ExitOnError)
+}
+
+func ConfigProcess(instance string) {
+	if !CliConfig.Enabled {
+		return
+	}
+	CliConfig.Process(instance)
+}
+
+// Process validates the config and prepares it for use by New
+func (cfg *NotifierNSQConfig) Process(instance string) {
+	if !nsq.IsValidTopicName(cfg.Topic) {
+		log.Fatalf("nsq-cluster: invalid topic %q", cfg.Topic)
+	}
+	cfg.channel = cfg.Channel
+	if cfg.channel == "" {
+		cfg.channel = instance
+	}
+	if !nsq.IsValidChannelName(cfg.channel) {
+		log.Fatalf("nsq-cluster: invalid channel %q. set channel if the instance name is not a valid channel name", cfg.channel)
+	}
+
+	cfg.nsqdTCPAddrs = splitList(cfg.NsqdTCPAddrs)
+	if len(cfg.nsqdTCPAddrs) == 0 {
+		log.Fatal("nsq-cluster: nsqd-tcp-address must be set")
+	}
+	cfg.nsqdHTTPAddrs = splitList(cfg.NsqdHTTPAddrs)
+	if len(cfg.nsqdHTTPAddrs) == 0 {
+		log.Fatal("nsq-cluster: nsqd-http-address must be set")
+	}
+	cfg.lookupdHTTPAddrs = splitList(cfg.LookupdHTTPAddrs)
+
+	cfg.producerConfig = nsq.NewConfig()
+	cfg.producerConfig.UserAgent = "metrictank-" + instance
+	err := cfg.producerConfig.Validate()
+	if err != nil {
+		log.Fatalf("nsq-cluster: invalid producer config: %s", err)
+	}
+
+	cfg.consumerConfig = nsq.NewConfig()
+	cfg.consumerConfig.UserAgent = "metrictank-" + instance
+	cfg.consumerConfig.MaxInFlight = cfg.MaxInFlight
+	err = cfg.consumerConfig.Validate()
+	if err != nil {
+		log.Fatalf("nsq-cluster: invalid consumer config: %s", err)
+	}
+
+	cfg.backlogProcessTimeout, err = time.ParseDuration(cfg.BacklogProcessTimeout)
+	if err != nil {
+		log.Fatalf("nsq-cluster: unable to parse backlog-process-timeout. %s", err)
+	}
+
+	if cfg.PersistMessageVersion != mdata.PersistMessageBatchV1 && cfg.PersistMessageVersion != mdata.PersistMessageBatchV2 {
+		log.Fatalf("nsq-cluster: invalid persist-message-version %d. must be %d or %d", cfg.PersistMessageVersion, mdata.PersistMessageBatchV1, mdata.PersistMessageBatchV2)
+	}
+}
+
+// splitList splits a comma separated list, ignoring empty entries
+func splitList(list string) []string {
+	var out []string
+	for _, s := range strings.Split(list, ",") {
+		if s != "" {
+			out = append(out, s)
+		}
+	}
+	return out
+}
@@ -0,0 +1,14 @@
+package notifierNSQ
+
+import (
+	log "github.com/sirupsen/logrus"
+)
+
+// nsqLogger passes the log messages of the nsq producers and consumer on to our logger.
+// the level filtering is done by go-nsq, so they are all logged as warnings.
+type nsqLogger struct{}
+
+func (nsqLogger) Output(calldepth int, s string) error {
+	log.Warnf("nsq-cluster: %s", s)
+	return nil
+}
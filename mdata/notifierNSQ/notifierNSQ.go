@@ -0,0 +1,215 @@
+package notifierNSQ
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/grafana/metrictank/mdata"
+	"github.com/nsqio/go-nsq"
+	log "github.com/sirupsen/logrus"
+)
+
+// NotifierNSQ publishes and consumes persist messages through NSQ.
+// unlike kafka, NSQ has no partitions: every instance consumes all messages
+// from its own channel, and the handler ignores the ones for metrics it doesn't have.
+type NotifierNSQ struct {
+	cfg       *NotifierNSQConfig
+	instance  string
+	in        chan mdata.SavedChunk
+	buf       []mdata.SavedChunk
+	handler   mdata.NotifierHandler
+	producers []*nsq.Producer
+	consumer  *nsq.Consumer
+	next      uint32 // used to spread publishing over the producers
+	shutdown  chan struct{}
+	produced  chan struct{}  // closed by the producer once it published its buffer after shutdown
+	sendWg    sync.WaitGroup // tracks the sends in flight
+	StopChan  chan int
+}
+
+// New creates a NotifierNSQ based on the given config, which must have been processed already.
+// it blocks until the backlog of persist messages has been processed.
+func New(cfg *NotifierNSQConfig, instance string, handler mdata.NotifierHandler) *NotifierNSQ {
+	c := &NotifierNSQ{
+		cfg:      cfg,
+		instance: instance,
+		in:       make(chan mdata.SavedChunk),
+		handler:  handler,
+		shutdown: make(chan struct{}),
+		produced: make(chan struct{}),
+		StopChan: make(chan int),
+	}
+	for _, addr := range cfg.nsqdTCPAddrs {
+		producer, err := nsq.NewProducer(addr, cfg.producerConfig)
+		if err != nil {
+			log.Fatalf("nsq-cluster: failed to create producer for %s: %s", addr, err)
+		}
+		producer.SetLogger(nsqLogger{}, nsq.LogLevelWarning)
+		c.producers = append(c.producers, producer)
+	}
+
+	consumer, err := nsq.NewConsumer(cfg.Topic, cfg.channel, cfg.consumerConfig)
+	if err != nil {
+		log.Fatalf("nsq-cluster: failed to create consumer: %s", err)
+	}
+	consumer.SetLogger(nsqLogger{}, nsq.LogLevelWarning)
+	consumer.AddHandler(c)
+	if len(cfg.lookupdHTTPAddrs) > 0 {
+		err = consumer.ConnectToNSQLookupds(cfg.lookupdHTTPAddrs)
+	} else {
+		err = consumer.ConnectToNSQDs(cfg.nsqdTCPAddrs)
+	}
+	if err != nil {
+		log.Fatalf("nsq-cluster: failed to connect consumer: %s", err)
+	}
+	c.consumer = consumer
+	log.Infof("nsq-cluster: consuming from topic %s channel %s", cfg.Topic, cfg.channel)
+
+	c.processBacklog()
+	go c.trackDepth()
+	go c.produce()
+
+	return c
+}
+
+// HandleMessage implements nsq.Handler
+func (c *NotifierNSQ) HandleMessage(m *nsq.Message) error {
+	log.Debugf("nsq-cluster: received message: %s", m.ID)
+	c.handler.Handle(m.Body)
+	return nil
+}
+
+// processBacklog waits for the messages that were queued in our channel while we were down to be processed.
+// This will block metrictank from consuming metrics until we have processed old metricPersist messages.
+// The end result is that we wont overwrite chunks in cassandra that have already been previously written.
+// We don't wait more than backlog-process-timeout for the backlog to be processed.
+func (c *NotifierNSQ) processBacklog() {
+	pre := time.Now()
+	log.Info("nsq-cluster: waiting for metricPersist backlog to be processed.")
+	timer := time.NewTimer(c.cfg.backlogProcessTimeout)
+	defer timer.Stop()
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		depth, inFlight, err := channelStats(c.cfg.nsqdHTTPAddrs, c.cfg.Topic, c.cfg.channel)
+		if err != nil {
+			log.Warnf("nsq-cluster: failed to get channel depth: %s", err)
+		} else {
+			channelDepth.Set(int(depth))
+			if depth == 0 && inFlight == 0 {
+				log.Infof("nsq-cluster: metricPersist backlog processed in %s.", time.Since(pre))
+				return
+			}
+		}
+		select {
+		case <-ticker.C:
+		case <-timer.C:
+			log.Warnf("nsq-cluster: Processing metricPersist backlog has taken too long, giving up lock after %s.", time.Since(pre))
+			return
+		}
+	}
+}
+
+// trackDepth periodically updates the channel depth metric, until the notifier is stopped
+func (c *NotifierNSQ) trackDepth() {
+	ticker := time.NewTicker(5 * time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-c.shutdown:
+			return
+		case <-ticker.C:
+			depth, _, err := channelStats(c.cfg.nsqdHTTPAddrs, c.cfg.Topic, c.cfg.channel)
+			if err != nil {
+				log.Errorf("nsq-cluster: failed to get channel depth: %s", err)
+				continue
+			}
+			channelDepth.Set(int(depth))
+		}
+	}
+}
+
+// Stop will initiate a graceful stop of the Consumer (permanent)
+//
+// NOTE: receive on StopChan to block until this process completes
+func (c *NotifierNSQ) Stop() {
+	close(c.shutdown)
+	c.consumer.Stop()
+	go func() {
+		// the producer publishes the chunks it has buffered before we stop the producers
+		<-c.produced
+		for _, producer := range c.producers {
+			producer.Stop()
+		}
+		<-c.consumer.StopChan
+		close(c.StopChan)
+	}()
+}
+
+func (c *NotifierNSQ) Send(sc mdata.SavedChunk) {
+	c.in <- sc
+}
+
+func (c *NotifierNSQ) produce() {
+	ticker := time.NewTicker(time.Second)
+	max := 5000
+	for {
+		select {
+		case chunk := <-c.in:
+			c.buf = append(c.buf, chunk)
+			if len(c.buf) == max {
+				c.flush()
+			}
+		case <-ticker.C:
+			c.flush()
+		case <-c.shutdown:
+			ticker.Stop()
+			c.flush()
+			c.sendWg.Wait()
+			close(c.produced)
+			return
+		}
+	}
+}
+
+// flush makes sure the batch gets sent, asynchronously.
+func (c *NotifierNSQ) flush() {
+	if len(c.buf) == 0 {
+		return
+	}
+	msg, err := mdata.EncodePersistMessageBatch(nil, c.cfg.PersistMessageVersion, &mdata.PersistMessageBatch{Instance: c.instance, SavedChunks: c.buf})
+	if err != nil {
+		log.Fatalf("nsq-cluster: failed to marshal persistMessage: %s", err)
+	}
+	messagesSize.Value(len(msg))
+	c.buf = nil
+	c.sendWg.Add(1)
+	go func() {
+		c.send(msg)
+		c.sendWg.Done()
+	}()
+}
+
+// send publishes the message, trying the nsqd's in turn until one of them accepts it
+// or the notifier is stopped.
+func (c *NotifierNSQ) send(msg []byte) {
+	for attempt := 1; ; attempt++ {
+		producer := c.producers[int(atomic.AddUint32(&c.next, 1))%len(c.producers)]
+		err := producer.Publish(c.cfg.Topic, msg)
+		if err == nil {
+			messagesPublished.Inc()
+			return
+		}
+		log.Warnf("nsq-cluster: failed to publish to %s: %s", producer, err)
+		flushErrors.Inc()
+		if attempt%len(c.producers) == 0 {
+			select {
+			case <-c.shutdown:
+				log.Errorf("nsq-cluster: failed to publish persist message while shutting down")
+				return
+			case <-time.After(time.Second):
+			}
+		}
+	}
+}
@@ -0,0 +1,101 @@
+package notifierNSQ
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/grafana/metrictank/mdata"
+	"github.com/nsqio/go-nsq"
+	"github.com/raintank/schema"
+)
+
+type recordingHandler struct {
+	messages [][]byte
+}
+
+func (h *recordingHandler) Handle(data []byte) {
+	h.messages = append(h.messages, data)
+}
+
+func (h *recordingHandler) PartitionOf(key schema.MKey) (int32, bool) {
+	return 0, false
+}
+
+func TestHandle(t *testing.T) {
+	chunks := []mdata.SavedChunk{
+		{Key: "1.01234567890123456789012345678901", T0: 600},
+		{Key: "1.01234567890123456789012345678901_sum_3600", T0: 3600},
+	}
+	exp := mdata.PersistMessageBatch{Instance: "mt1", SavedChunks: chunks}
+	for _, version := range []int{mdata.PersistMessageBatchV1, mdata.PersistMessageBatchV2} {
+		msg, err := mdata.EncodePersistMessageBatch(nil, version, &exp)
+		if err != nil {
+			t.Fatalf("version %d: unexpected error encoding: %s", version, err)
+		}
+		handler := &recordingHandler{}
+		c := &NotifierNSQ{handler: handler}
+		err = c.HandleMessage(nsq.NewMessage(nsq.MessageID{}, msg))
+		if err != nil {
+			t.Fatalf("version %d: unexpected error %s", version, err)
+		}
+		if len(handler.messages) != 1 {
+			t.Fatalf("version %d: expected 1 message to be handled, got %d", version, len(handler.messages))
+		}
+		batch, err := mdata.DecodePersistMessageBatch(handler.messages[0])
+		if err != nil {
+			t.Fatalf("version %d: failed to decode message: %s", version, err)
+		}
+		if !reflect.DeepEqual(batch, exp) {
+			t.Fatalf("version %d: expected %+v, got %+v", version, exp, batch)
+		}
+	}
+}
+
+// TestProduceShutdown verifies that the producer tries to publish its buffer on shutdown,
+// and gives up rather than retrying forever if that fails.
+func TestProduceShutdown(t *testing.T) {
+	producer, err := nsq.NewProducer("127.0.0.1:1", nsq.NewConfig())
+	if err != nil {
+		t.Fatalf("failed to create producer: %s", err)
+	}
+	producer.SetLogger(nsqLogger{}, nsq.LogLevelError)
+	defer producer.Stop()
+	c := &NotifierNSQ{
+		cfg:       &NotifierNSQConfig{Topic: "metricpersist", PersistMessageVersion: mdata.PersistMessageBatchV1},
+		instance:  "mt1",
+		in:        make(chan mdata.SavedChunk),
+		producers: []*nsq.Producer{producer},
+		shutdown:  make(chan struct{}),
+		produced:  make(chan struct{}),
+	}
+	go c.produce()
+	errs := flushErrors.Peek()
+	c.Send(mdata.SavedChunk{Key: "1.01234567890123456789012345678901", T0: 600})
+	close(c.shutdown)
+
+	select {
+	case <-c.produced:
+	case <-time.After(10 * time.Second):
+		t.Fatalf("timed out waiting for the producer to finish")
+	}
+	if flushErrors.Peek() == errs {
+		t.Fatalf("expected an attempt to publish the buffered chunk")
+	}
+}
+
+func TestSplitList(t *testing.T) {
+	cases := []struct {
+		in  string
+		exp []string
+	}{
+		{"", nil},
+		{"nsqd:4150", []string{"nsqd:4150"}},
+		{"nsqd1:4150,,nsqd2:4150,", []string{"nsqd1:4150", "nsqd2:4150"}},
+	}
+	for _, c := range cases {
+		if got := splitList(c.in); !reflect.DeepEqual(got, c.exp) {
+			t.Fatalf("splitList(%q): expected %v, got %v", c.in, c.exp, got)
+		}
+	}
+}
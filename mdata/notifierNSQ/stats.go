@@ -0,0 +1,74 @@
+package notifierNSQ
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+var statsClient = &http.Client{Timeout: 5 * time.Second}
+
+// nsqdStats is the subset of the response of nsqd's /stats endpoint that we need
+type nsqdStats struct {
+	Topics []struct {
+		Name     string `json:"topic_name"`
+		Channels []struct {
+			Name     string `json:"channel_name"`
+			Depth    int64  `json:"depth"`
+			InFlight int64  `json:"in_flight_count"`
+		} `json:"channels"`
+	} `json:"topics"`
+}
+
+// channelStats returns the number of queued and in flight messages of the channel of the topic,
+// summed across the nsqd's with the given HTTP addresses.
+// nsqd's that don't have the channel yet don't contribute to either.
+func channelStats(addrs []string, topic, channel string) (depth, inFlight int64, err error) {
+	for _, addr := range addrs {
+		stats, err := getNsqdStats(addr, topic, channel)
+		if err != nil {
+			return 0, 0, err
+		}
+		for _, t := range stats.Topics {
+			if t.Name != topic {
+				continue
+			}
+			for _, ch := range t.Channels {
+				if ch.Name == channel {
+					depth += ch.Depth
+					inFlight += ch.InFlight
+				}
+			}
+		}
+	}
+	return depth, inFlight, nil
+}
+
+func getNsqdStats(addr, topic, channel string) (nsqdStats, error) {
+	var stats nsqdStats
+	query := url.Values{}
+	query.Set("format", "json")
+	query.Set("topic", topic)
+	query.Set("channel", channel)
+	req, err := http.NewRequest("GET", fmt.Sprintf("http://%s/stats?%s", addr, query.Encode()), nil)
+	if err != nil {
+		return stats, err
+	}
+	// ask for the response without the status_code/data envelope of older versions
+	req.Header.Set("Accept", "application/vnd.nsq; version=1.0")
+	resp, err := statsClient.Do(req)
+	if err != nil {
+		return stats, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return stats, fmt.Errorf("nsqd %s returned status %s", addr, resp.Status)
+	}
+	err = json.NewDecoder(resp.Body).Decode(&stats)
+	if err != nil {
+		return stats, fmt.Errorf("failed to decode stats of nsqd %s: %s", addr, err)
+	}
+	return stats, nil
+}
@@ -0,0 +1,59 @@
+package notifierNSQ
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func nsqdStatsServer(t *testing.T, body string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/stats" || r.URL.Query().Get("format") != "json" {
+			t.Errorf("unexpected request %s", r.URL)
+		}
+		w.Write([]byte(body))
+	}))
+}
+
+func TestChannelStats(t *testing.T) {
+	nsqd1 := nsqdStatsServer(t, `{"version":"1.2.1","topics":[
+		{"topic_name":"metricpersist","channels":[
+			{"channel_name":"mt1","depth":10,"in_flight_count":2},
+			{"channel_name":"mt2","depth":100,"in_flight_count":20}
+		]},
+		{"topic_name":"other","channels":[{"channel_name":"mt1","depth":1000}]}
+	]}`)
+	defer nsqd1.Close()
+	nsqd2 := nsqdStatsServer(t, `{"version":"1.2.1","topics":[
+		{"topic_name":"metricpersist","channels":[{"channel_name":"mt1","depth":5,"in_flight_count":1}]}
+	]}`)
+	defer nsqd2.Close()
+	// nsqd's that don't have the topic yet
+	nsqd3 := nsqdStatsServer(t, `{"version":"1.2.1","topics":[]}`)
+	defer nsqd3.Close()
+
+	addrs := []string{
+		strings.TrimPrefix(nsqd1.URL, "http://"),
+		strings.TrimPrefix(nsqd2.URL, "http://"),
+		strings.TrimPrefix(nsqd3.URL, "http://"),
+	}
+	depth, inFlight, err := channelStats(addrs, "metricpersist", "mt1")
+	if err != nil {
+		t.Fatalf("unexpected error %s", err)
+	}
+	if depth != 15 || inFlight != 3 {
+		t.Fatalf("expected depth 15 and in flight 3, got %d and %d", depth, inFlight)
+	}
+}
+
+func TestChannelStatsError(t *testing.T) {
+	nsqd := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "boom", http.StatusInternalServerError)
+	}))
+	defer nsqd.Close()
+	_, _, err := channelStats([]string{strings.TrimPrefix(nsqd.URL, "http://")}, "metricpersist", "mt1")
+	if err == nil {
+		t.Fatal("expected an error for a failing nsqd")
+	}
+}
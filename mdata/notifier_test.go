@@ -0,0 +1,196 @@
+package mdata
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/raintank/schema"
+)
+
+func TestPersistMessageBatchFilterByOrg(t *testing.T) {
+	key := func(org uint32, b byte) string {
+		return schema.AMKey{MKey: schema.MKey{Key: [16]byte{b}, Org: org}}.String()
+	}
+	batch := PersistMessageBatch{
+		Instance: "mt1",
+		SavedChunks: []SavedChunk{
+			{Key: key(1, 1), T0: 10},
+			{Key: key(2, 2), T0: 20},
+			{Key: "not-a-key", T0: 30},
+			{Key: key(1, 3), T0: 40},
+		},
+	}
+	filtered := batch.FilterByOrg(1)
+	if filtered.Instance != "mt1" {
+		t.Fatalf("expected instance mt1, got %q", filtered.Instance)
+	}
+	if len(filtered.SavedChunks) != 2 || filtered.SavedChunks[0].T0 != 10 || filtered.SavedChunks[1].T0 != 40 {
+		t.Fatalf("expected chunks with t0 10 and 40, got %v", filtered.SavedChunks)
+	}
+	if len(batch.SavedChunks) != 4 {
+		t.Fatalf("original batch was modified: %v", batch.SavedChunks)
+	}
+	if filtered := batch.FilterByOrg(3); len(filtered.SavedChunks) != 0 {
+		t.Fatalf("expected no chunks for org 3, got %v", filtered.SavedChunks)
+	}
+}
+
+func TestGroupByOrg(t *testing.T) {
+	key := func(org uint32, b byte) string {
+		return schema.AMKey{MKey: schema.MKey{Key: [16]byte{b}, Org: org}}.String()
+	}
+	batch := PersistMessageBatch{
+		Instance: "mt1",
+		SavedChunks: []SavedChunk{
+			{Key: key(1, 1), T0: 10},
+			{Key: key(2, 2), T0: 20},
+			{Key: "not-a-key", T0: 30},
+			{Key: key(1, 3), T0: 40},
+		},
+	}
+	grouped, errs := GroupByOrg(&batch)
+	if len(grouped) != 2 {
+		t.Fatalf("expected batches for 2 orgs, got %d", len(grouped))
+	}
+	for org, exp := range map[uint32][]uint32{1: {10, 40}, 2: {20}} {
+		b := grouped[org]
+		if b == nil {
+			t.Fatalf("expected a batch for org %d", org)
+		}
+		if b.Instance != "mt1" {
+			t.Fatalf("org %d: expected instance mt1, got %q", org, b.Instance)
+		}
+		if len(b.SavedChunks) != len(exp) {
+			t.Fatalf("org %d: expected chunks with t0 %v, got %v", org, exp, b.SavedChunks)
+		}
+		for i, t0 := range exp {
+			if b.SavedChunks[i].T0 != t0 {
+				t.Fatalf("org %d: expected chunks with t0 %v, got %v", org, exp, b.SavedChunks)
+			}
+		}
+	}
+	if len(errs) != 1 || errs["not-a-key"] == nil {
+		t.Fatalf("expected an error for key not-a-key, got %v", errs)
+	}
+	if len(batch.SavedChunks) != 4 {
+		t.Fatalf("original batch was modified: %v", batch.SavedChunks)
+	}
+}
+
+func TestPersistMessageBatchValidate(t *testing.T) {
+	valid := getPersistMessageBatch(3)
+	if err := valid.Validate(); err != nil {
+		t.Fatalf("expected valid batch, got %s", err)
+	}
+
+	cases := []struct {
+		name  string
+		batch PersistMessageBatch
+		errs  int
+	}{
+		{"empty instance", PersistMessageBatch{SavedChunks: valid.SavedChunks}, 1},
+		{"non printable instance", PersistMessageBatch{Instance: "mt\x001", SavedChunks: valid.SavedChunks}, 1},
+		{"non ascii instance", PersistMessageBatch{Instance: "mté1", SavedChunks: valid.SavedChunks}, 1},
+		{"invalid keys", PersistMessageBatch{Instance: "mt1", SavedChunks: []SavedChunk{{Key: "foo"}, valid.SavedChunks[0], {Key: ""}}}, 2},
+		{"too many chunks", getPersistMessageBatch(maxBatchSize + 1), 1},
+		{"all violations", PersistMessageBatch{SavedChunks: append(getPersistMessageBatch(maxBatchSize).SavedChunks, SavedChunk{Key: "foo"})}, 3},
+	}
+	for _, c := range cases {
+		err := c.batch.Validate()
+		verr, ok := err.(ValidationError)
+		if !ok {
+			t.Fatalf("%s: expected ValidationError, got %v", c.name, err)
+		}
+		if len(verr) != c.errs {
+			t.Fatalf("%s: expected %d violations, got %d: %s", c.name, c.errs, len(verr), verr)
+		}
+	}
+}
+
+func getPersistMessageBatch(chunks int) PersistMessageBatch {
+	batch := PersistMessageBatch{Instance: "mt1"}
+	for i := 0; i < chunks; i++ {
+		amkey := schema.AMKey{
+			MKey:    schema.MKey{Key: [16]byte{byte(i), byte(i >> 8)}, Org: 1},
+			Archive: schema.NewArchive(schema.Sum, 600),
+		}
+		batch.SavedChunks = append(batch.SavedChunks, SavedChunk{Key: amkey.String(), T0: uint32(i * 600)})
+	}
+	return batch
+}
+
+func encodePersistMessageBatchV1(b *PersistMessageBatch) []byte {
+	data, _ := EncodePersistMessageBatch(nil, PersistMessageBatchV1, b)
+	return data
+}
+
+func encodePersistMessageBatchV2(b *PersistMessageBatch) []byte {
+	data, _ := EncodePersistMessageBatch(nil, PersistMessageBatchV2, b)
+	return data
+}
+
+func TestDecodePersistMessageBatch(t *testing.T) {
+	batch := getPersistMessageBatch(10)
+	for _, data := range [][]byte{encodePersistMessageBatchV1(&batch), encodePersistMessageBatchV2(&batch)} {
+		got, err := DecodePersistMessageBatch(data)
+		if err != nil {
+			t.Fatalf("version %d: unexpected error %s", data[0], err)
+		}
+		if !reflect.DeepEqual(got, batch) {
+			t.Fatalf("version %d: expected %v, got %v", data[0], batch, got)
+		}
+	}
+	for _, data := range [][]byte{nil, {3, 1, 2}, {PersistMessageBatchV2, 0xc1}} {
+		_, err := DecodePersistMessageBatch(data)
+		if err == nil {
+			t.Fatalf("expected an error decoding %v", data)
+		}
+	}
+	if _, err := EncodePersistMessageBatch(nil, 3, &batch); err == nil {
+		t.Fatalf("expected an error encoding an unknown version")
+	}
+}
+
+func BenchmarkPersistMessageBatchEncodeV1(b *testing.B) {
+	batch := getPersistMessageBatch(1000)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		encodePersistMessageBatchV1(&batch)
+	}
+}
+
+func BenchmarkPersistMessageBatchEncodeV2(b *testing.B) {
+	batch := getPersistMessageBatch(1000)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		encodePersistMessageBatchV2(&batch)
+	}
+}
+
+func BenchmarkPersistMessageBatchDecodeV1(b *testing.B) {
+	batch := getPersistMessageBatch(1000)
+	data := encodePersistMessageBatchV1(&batch)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		_, err := DecodePersistMessageBatch(data)
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkPersistMessageBatchDecodeV2(b *testing.B) {
+	batch := getPersistMessageBatch(1000)
+	data := encodePersistMessageBatchV2(&batch)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		_, err := DecodePersistMessageBatch(data)
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -10,3 +10,28 @@ type Result struct {
 	Iters  []tsz.Iter
 	Oldest uint32 // timestamp of oldest point we have, to know when and when not we may need to query slower storage
 }
+
+// ChunkMerge merges two slices of points that are sorted by timestamp
+// into a new sorted slice. when both contain a point with the same timestamp,
+// the one from a is kept. this allows merging in-memory data (a) with data
+// from the store (b), where in-memory data takes priority.
+func ChunkMerge(a, b []schema.Point) []schema.Point {
+	out := make([]schema.Point, 0, len(a)+len(b))
+	var i, j int
+	for i < len(a) && j < len(b) {
+		switch {
+		case a[i].Ts < b[j].Ts:
+			out = append(out, a[i])
+			i++
+		case a[i].Ts > b[j].Ts:
+			out = append(out, b[j])
+			j++
+		default:
+			out = append(out, a[i])
+			i++
+			j++
+		}
+	}
+	out = append(out, a[i:]...)
+	return append(out, b[j:]...)
+}
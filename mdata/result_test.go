@@ -0,0 +1,49 @@
+package mdata
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/raintank/schema"
+)
+
+func TestChunkMerge(t *testing.T) {
+	cases := []struct {
+		a   []schema.Point
+		b   []schema.Point
+		exp []schema.Point
+	}{
+		{
+			nil,
+			nil,
+			[]schema.Point{},
+		},
+		{
+			[]schema.Point{{Val: 1, Ts: 10}, {Val: 2, Ts: 20}},
+			nil,
+			[]schema.Point{{Val: 1, Ts: 10}, {Val: 2, Ts: 20}},
+		},
+		{
+			nil,
+			[]schema.Point{{Val: 1, Ts: 10}, {Val: 2, Ts: 20}},
+			[]schema.Point{{Val: 1, Ts: 10}, {Val: 2, Ts: 20}},
+		},
+		{
+			[]schema.Point{{Val: 1, Ts: 10}, {Val: 3, Ts: 30}},
+			[]schema.Point{{Val: 2, Ts: 20}, {Val: 4, Ts: 40}},
+			[]schema.Point{{Val: 1, Ts: 10}, {Val: 2, Ts: 20}, {Val: 3, Ts: 30}, {Val: 4, Ts: 40}},
+		},
+		{
+			// overlapping timestamps: a wins
+			[]schema.Point{{Val: 2, Ts: 20}, {Val: 3, Ts: 30}, {Val: 5, Ts: 50}},
+			[]schema.Point{{Val: 10, Ts: 10}, {Val: 20, Ts: 20}, {Val: 30, Ts: 30}, {Val: 40, Ts: 40}},
+			[]schema.Point{{Val: 10, Ts: 10}, {Val: 2, Ts: 20}, {Val: 3, Ts: 30}, {Val: 40, Ts: 40}, {Val: 5, Ts: 50}},
+		},
+	}
+	for i, c := range cases {
+		got := ChunkMerge(c.a, c.b)
+		if !reflect.DeepEqual(got, c.exp) {
+			t.Fatalf("case %d: expected %v, got %v", i, c.exp, got)
+		}
+	}
+}
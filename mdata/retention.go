@@ -0,0 +1,55 @@
+package mdata
+
+import (
+	"time"
+
+	"github.com/grafana/metrictank/conf"
+)
+
+// RetentionArchive describes a single archive of a retention policy:
+// its resolution and how long its data is kept.
+type RetentionArchive struct {
+	SecondsPerPoint int
+	NumberOfPoints  int
+	TTL             time.Duration
+}
+
+// RetentionPolicy is a list of archives, ordered from highest to lowest resolution,
+// e.g. raw data followed by 1h and 1d rollups.
+type RetentionPolicy []RetentionArchive
+
+// NewRetentionPolicy creates a RetentionPolicy from the retentions of a storage schema
+func NewRetentionPolicy(rets conf.Retentions) RetentionPolicy {
+	policy := make(RetentionPolicy, 0, len(rets))
+	for _, ret := range rets {
+		policy = append(policy, RetentionArchive{
+			SecondsPerPoint: ret.SecondsPerPoint,
+			NumberOfPoints:  ret.NumberOfPoints,
+			TTL:             time.Duration(ret.MaxRetention()) * time.Second,
+		})
+	}
+	return policy
+}
+
+// BestArchive returns the highest resolution archive that still retains the data
+// for the requested range. if none of the archives go back far enough, the lowest
+// resolution one, which has the longest TTL, is returned.
+// BestArchive panics if the policy is empty.
+func (p RetentionPolicy) BestArchive(from, until uint32) RetentionArchive {
+	return p.bestArchive(from, until, time.Now())
+}
+
+func (p RetentionPolicy) bestArchive(from, until uint32, now time.Time) RetentionArchive {
+	// all archives have data up to now, so whether an archive covers the
+	// range only depends on whether its TTL reaches back to from.
+	minTTL := time.Duration(0)
+	if int64(from) < now.Unix() {
+		minTTL = time.Duration(now.Unix()-int64(from)) * time.Second
+	}
+	for _, archive := range p {
+		if archive.TTL >= minTTL {
+			return archive
+		}
+	}
+	return p[len(p)-1]
+}
@@ -0,0 +1,40 @@
+package mdata
+
+import (
+	"testing"
+	"time"
+
+	"github.com/grafana/metrictank/conf"
+)
+
+func TestRetentionPolicyBestArchive(t *testing.T) {
+	rets, err := conf.ParseRetentions("10s:1d,1h:30d,1d:2y")
+	if err != nil {
+		t.Fatal(err)
+	}
+	policy := NewRetentionPolicy(rets)
+	now := time.Unix(1000*86400, 0)
+	nowUnix := uint32(now.Unix())
+
+	cases := []struct {
+		from  uint32
+		until uint32
+		exp   int
+	}{
+		{nowUnix - 3600, nowUnix, 10},
+		{nowUnix - 86400, nowUnix, 10},
+		{nowUnix - 86401, nowUnix, 3600},
+		{nowUnix - 7*86400, nowUnix - 86400, 3600},
+		{nowUnix - 90*86400, nowUnix, 86400},
+		// older than all archives: fall back to the one with the longest TTL
+		{nowUnix - 900*86400, nowUnix, 86400},
+		// ranges in the future are covered by the highest resolution archive
+		{nowUnix + 60, nowUnix + 120, 10},
+	}
+	for i, c := range cases {
+		got := policy.bestArchive(c.from, c.until, now)
+		if got.SecondsPerPoint != c.exp {
+			t.Fatalf("case %d: expected archive with interval %d, got %d", i, c.exp, got.SecondsPerPoint)
+		}
+	}
+}
@@ -0,0 +1,56 @@
+package mdata
+
+import (
+	"encoding/binary"
+	"math"
+
+	"github.com/grafana/metrictank/stats"
+	"github.com/raintank/schema"
+)
+
+// metric tank.metrics_sampled_out is points that were dropped by the ingest sampler
+var metricsSampledOut = stats.NewCounterRate32("tank.metrics_sampled_out")
+
+// SamplerFunc decides whether the point of the given series should be stored.
+// it returns true to store the point, false to drop it.
+// it is called for every incoming point, so it must be fast and safe for concurrent use.
+type SamplerFunc func(key schema.AMKey, t uint32, v float64) bool
+
+// sampler is consulted by AggMetric.Add. nil means all points are stored
+var sampler SamplerFunc
+
+// SetSampler sets the sampler for all points that get ingested.
+// nil disables sampling.
+// it must be called before ingestion starts.
+func SetSampler(fn SamplerFunc) {
+	sampler = fn
+}
+
+// RateSampler returns a SamplerFunc that stores the given fraction (between 0 and 1) of the points of each series.
+// the decision is a hash of the series and the timestamp, rather than random, so that all
+// instances that ingest the same series (e.g. primary and secondary) store the same points.
+func RateSampler(rate float64) SamplerFunc {
+	if rate >= 1 {
+		return func(key schema.AMKey, t uint32, v float64) bool { return true }
+	}
+	if rate <= 0 {
+		return func(key schema.AMKey, t uint32, v float64) bool { return false }
+	}
+	threshold := uint64(rate * math.MaxUint64)
+	return func(key schema.AMKey, t uint32, v float64) bool {
+		return sampleHash(key, t) < threshold
+	}
+}
+
+// sampleHash mixes the key and the timestamp into a uniformly distributed uint64.
+// the finalizer of splitmix64 spreads the bits of the key and the timestamp over all bits.
+func sampleHash(key schema.AMKey, t uint32) uint64 {
+	h := binary.LittleEndian.Uint64(key.MKey.Key[:8]) ^ binary.LittleEndian.Uint64(key.MKey.Key[8:])
+	h ^= uint64(key.Archive)<<32 | uint64(t)
+	h ^= h >> 30
+	h *= 0xbf58476d1ce4e5b9
+	h ^= h >> 27
+	h *= 0x94d049bb133111eb
+	h ^= h >> 31
+	return h
+}
@@ -0,0 +1,92 @@
+package mdata
+
+import (
+	"testing"
+	"time"
+
+	"github.com/grafana/metrictank/cluster"
+	"github.com/grafana/metrictank/conf"
+	"github.com/grafana/metrictank/mdata/cache"
+	"github.com/grafana/metrictank/test"
+	"github.com/raintank/schema"
+)
+
+func TestRateSampler(t *testing.T) {
+	cases := []struct {
+		rate float64
+		min  int
+		max  int
+	}{
+		{0, 0, 0},
+		{-1, 0, 0},
+		{0.1, 800, 1200},
+		{0.5, 4700, 5300},
+		{1, 10000, 10000},
+		{2, 10000, 10000},
+	}
+	for _, c := range cases {
+		sample := RateSampler(c.rate)
+		var kept int
+		for i := 0; i < 100; i++ {
+			key := test.GetAMKey(i)
+			for ts := uint32(1); ts <= 100; ts++ {
+				if sample(key, ts, 0) {
+					kept++
+				}
+			}
+		}
+		if kept < c.min || kept > c.max {
+			t.Errorf("rate %f: expected between %d and %d of 10000 points to be kept, got %d", c.rate, c.min, c.max, kept)
+		}
+	}
+}
+
+func TestRateSamplerDeterministic(t *testing.T) {
+	a := RateSampler(0.3)
+	b := RateSampler(0.3)
+	key := test.GetAMKey(42)
+	for ts := uint32(1); ts <= 1000; ts++ {
+		if a(key, ts, 1) != b(key, ts, 2) {
+			t.Fatalf("samplers disagree about ts %d", ts)
+		}
+	}
+}
+
+func TestAggMetricSampler(t *testing.T) {
+	cluster.Init("default", "test", time.Now(), "http", 6060)
+	defer SetSampler(nil)
+	SetSampler(func(key schema.AMKey, ts uint32, v float64) bool {
+		return ts%2 == 0
+	})
+	metricsSampledOut.SetUint32(0)
+
+	ret := []conf.Retention{conf.NewRetentionMT(1, 1, 120, 5, 0)}
+	agg := NewAggMetric(mockstore, &cache.MockCache{}, test.GetAMKey(42), ret, 0, nil, false)
+	for ts := uint32(121); ts <= 130; ts++ {
+		agg.Add(ts, float64(ts))
+	}
+
+	res, err := agg.Get(120, 240)
+	if err != nil {
+		t.Fatalf("expected err nil, got %v", err)
+	}
+	var got []uint32
+	for _, iter := range res.Iters {
+		for iter.Next() {
+			ts, _ := iter.Values()
+			got = append(got, ts)
+		}
+	}
+	exp := []uint32{122, 124, 126, 128, 130}
+	if len(got) != len(exp) {
+		t.Fatalf("expected points %v, got %v", exp, got)
+	}
+	for i := range exp {
+		if got[i] != exp[i] {
+			t.Fatalf("expected points %v, got %v", exp, got)
+		}
+	}
+	if metricsSampledOut.Peek() != 5 {
+		t.Fatalf("expected 5 points to be sampled out, got %d", metricsSampledOut.Peek())
+	}
+}
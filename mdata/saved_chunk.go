@@ -0,0 +1,104 @@
+package mdata
+
+import (
+	"encoding/binary"
+	"fmt"
+
+	"github.com/raintank/schema"
+	"github.com/tinylib/msgp/msgp"
+)
+
+// savedChunkSize is the size of a binary encoded SavedChunk:
+// org (4 bytes), key (16 bytes), archive (2 bytes) and t0 (4 bytes)
+const savedChunkSize = 4 + 16 + 2 + 4
+
+var errInvalidSavedChunkSize = fmt.Errorf("binary encoded SavedChunk must be %d bytes", savedChunkSize)
+
+// MarshalBinary encodes the SavedChunk in a compact, fixed size format.
+// it fails if the key is not a valid schema.AMKey
+func (c SavedChunk) MarshalBinary() ([]byte, error) {
+	data := make([]byte, savedChunkSize)
+	err := c.putBinary(data)
+	if err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+// putBinary writes the binary encoding of the SavedChunk into data, which must be savedChunkSize bytes
+func (c SavedChunk) putBinary(data []byte) error {
+	amkey, err := schema.AMKeyFromString(c.Key)
+	if err != nil {
+		return err
+	}
+	binary.LittleEndian.PutUint32(data, amkey.MKey.Org)
+	copy(data[4:20], amkey.MKey.Key[:])
+	binary.LittleEndian.PutUint16(data[20:], uint16(amkey.Archive))
+	binary.LittleEndian.PutUint32(data[22:], c.T0)
+	return nil
+}
+
+// UnmarshalBinary decodes a SavedChunk encoded by MarshalBinary
+func (c *SavedChunk) UnmarshalBinary(data []byte) error {
+	if len(data) != savedChunkSize {
+		return errInvalidSavedChunkSize
+	}
+	var amkey schema.AMKey
+	amkey.MKey.Org = binary.LittleEndian.Uint32(data)
+	copy(amkey.MKey.Key[:], data[4:20])
+	amkey.Archive = schema.Archive(binary.LittleEndian.Uint16(data[20:]))
+	c.Key = amkey.String()
+	c.T0 = binary.LittleEndian.Uint32(data[22:])
+	return nil
+}
+
+// SavedChunk's are msgp encoded as bin objects holding their binary encoding.
+// these methods are written by hand so that the generated code for PersistMessageBatch uses them.
+
+// MarshalMsg implements msgp.Marshaler
+func (c SavedChunk) MarshalMsg(b []byte) ([]byte, error) {
+	var data [savedChunkSize]byte
+	err := c.putBinary(data[:])
+	if err != nil {
+		return b, err
+	}
+	return msgp.AppendBytes(b, data[:]), nil
+}
+
+// UnmarshalMsg implements msgp.Unmarshaler
+func (c *SavedChunk) UnmarshalMsg(bts []byte) ([]byte, error) {
+	data, o, err := msgp.ReadBytesZC(bts)
+	if err != nil {
+		return bts, err
+	}
+	err = c.UnmarshalBinary(data)
+	if err != nil {
+		return bts, err
+	}
+	return o, nil
+}
+
+// EncodeMsg implements msgp.Encodable
+func (c SavedChunk) EncodeMsg(en *msgp.Writer) error {
+	var data [savedChunkSize]byte
+	err := c.putBinary(data[:])
+	if err != nil {
+		return err
+	}
+	return en.WriteBytes(data[:])
+}
+
+// DecodeMsg implements msgp.Decodable
+func (c *SavedChunk) DecodeMsg(dc *msgp.Reader) error {
+	var data [savedChunkSize]byte
+	err := dc.ReadExactBytes(data[:])
+	if err != nil {
+		return err
+	}
+	return c.UnmarshalBinary(data[:])
+}
+
+// Msgsize implements msgp.Sizer
+func (c SavedChunk) Msgsize() int {
+	return msgp.BytesPrefixSize + savedChunkSize
+}
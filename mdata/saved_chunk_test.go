@@ -0,0 +1,55 @@
+package mdata
+
+import (
+	"testing"
+
+	"github.com/raintank/schema"
+)
+
+func TestSavedChunkBinaryRoundTrip(t *testing.T) {
+	mkey := schema.MKey{Key: [16]byte{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16}, Org: 12}
+	cases := []SavedChunk{
+		{Key: schema.AMKey{MKey: mkey}.String(), T0: 1500000000},
+		{Key: schema.AMKey{MKey: mkey, Archive: schema.NewArchive(schema.Cnt, 3600)}.String(), T0: 0},
+	}
+	for _, c := range cases {
+		data, err := c.MarshalBinary()
+		if err != nil {
+			t.Fatalf("%v: unexpected error %s", c, err)
+		}
+		if len(data) != savedChunkSize {
+			t.Fatalf("%v: expected %d bytes, got %d", c, savedChunkSize, len(data))
+		}
+		var got SavedChunk
+		err = got.UnmarshalBinary(data)
+		if err != nil {
+			t.Fatalf("%v: unexpected error %s", c, err)
+		}
+		if got != c {
+			t.Fatalf("expected %v, got %v", c, got)
+		}
+	}
+}
+
+func TestSavedChunkBinaryErrors(t *testing.T) {
+	_, err := SavedChunk{Key: "not-a-key"}.MarshalBinary()
+	if err == nil {
+		t.Fatal("expected an error marshaling a SavedChunk with an invalid key")
+	}
+	var c SavedChunk
+	if err := c.UnmarshalBinary(make([]byte, savedChunkSize-1)); err != errInvalidSavedChunkSize {
+		t.Fatalf("expected error %q, got %v", errInvalidSavedChunkSize, err)
+	}
+}
+
+func TestPersistMessageBatchV2Size(t *testing.T) {
+	batch := getPersistMessageBatch(100)
+	v1 := encodePersistMessageBatchV1(&batch)
+	v2 := encodePersistMessageBatchV2(&batch)
+	if len(v2) >= len(v1) {
+		t.Fatalf("expected V2 encoding (%d bytes) to be smaller than V1 encoding (%d bytes)", len(v2), len(v1))
+	}
+	if exp := batch.Msgsize() + 1; len(v2) > exp {
+		t.Fatalf("expected V2 encoding to fit in the estimated %d bytes, got %d", exp, len(v2))
+	}
+}
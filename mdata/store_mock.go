@@ -68,6 +68,29 @@ func (c *MockStore) Search(ctx context.Context, metric schema.AMKey, ttl, start,
 	return res, nil
 }
 
+// Scan returns the chunks of all metrics of the org that match start / end, like Search does
+func (c *MockStore) Scan(ctx context.Context, orgId uint32, start, end uint32) (<-chan ScanResult, error) {
+	if start >= end {
+		return nil, errors.New("invalid range: start must be less than end")
+	}
+	var results []ScanResult
+	for key := range c.results {
+		if key.MKey.Org != orgId {
+			continue
+		}
+		itgens, _ := c.Search(ctx, key, 0, start, end)
+		if len(itgens) > 0 {
+			results = append(results, ScanResult{Key: key, Chunks: itgens})
+		}
+	}
+	out := make(chan ScanResult, len(results))
+	for _, res := range results {
+		out <- res
+	}
+	close(out)
+	return out, nil
+}
+
 func (c *MockStore) Stop() {
 }
 
@@ -0,0 +1,72 @@
+package mdata
+
+import (
+	"encoding/json"
+	"errors"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/raintank/schema"
+)
+
+var errNoDefinition = errors.New("time series has no metric definition")
+
+// TimeSeries pairs the points of a query result with the definition of the metric they belong to
+type TimeSeries struct {
+	Def    *schema.MetricDefinition
+	Points []schema.Point
+}
+
+func NewTimeSeries(def *schema.MetricDefinition, points []schema.Point) TimeSeries {
+	return TimeSeries{
+		Def:    def,
+		Points: points,
+	}
+}
+
+// graphiteSeries is a series as returned by the graphite render api
+type graphiteSeries struct {
+	Target     string            `json:"target"`
+	Tags       map[string]string `json:"tags"`
+	Datapoints []graphitePoint   `json:"datapoints"`
+}
+
+// graphitePoint marshals to [value, ts], with null for NaN and infinite values, which json can't represent
+type graphitePoint schema.Point
+
+func (p graphitePoint) MarshalJSON() ([]byte, error) {
+	b := []byte{'['}
+	if math.IsNaN(p.Val) || math.IsInf(p.Val, 0) {
+		b = append(b, "null"...)
+	} else {
+		b = strconv.AppendFloat(b, p.Val, 'f', -1, 64)
+	}
+	b = append(b, ',')
+	b = strconv.AppendUint(b, uint64(p.Ts), 10)
+	return append(b, ']'), nil
+}
+
+// ToGraphiteJSON formats the time series like a graphite render api json response:
+// a list with a single series, targeted by the name of the metric including its tags.
+func (ts TimeSeries) ToGraphiteJSON() ([]byte, error) {
+	if ts.Def == nil {
+		return nil, errNoDefinition
+	}
+	series := graphiteSeries{
+		Target:     ts.Def.NameWithTags(),
+		Tags:       make(map[string]string, len(ts.Def.Tags)+1),
+		Datapoints: make([]graphitePoint, len(ts.Points)),
+	}
+	for _, tag := range ts.Def.Tags {
+		kv := strings.SplitN(tag, "=", 2)
+		if len(kv) == 2 {
+			series.Tags[kv[0]] = kv[1]
+		}
+	}
+	series.Tags["name"] = ts.Def.Name
+	for i, p := range ts.Points {
+		series.Datapoints[i] = graphitePoint(p)
+	}
+	return json.Marshal([]graphiteSeries{series})
+}
@@ -0,0 +1,45 @@
+package mdata
+
+import (
+	"math"
+	"testing"
+
+	"github.com/raintank/schema"
+)
+
+func TestTimeSeriesToGraphiteJSON(t *testing.T) {
+	cases := []struct {
+		def    *schema.MetricDefinition
+		points []schema.Point
+		exp    string
+	}{
+		{
+			&schema.MetricDefinition{Name: "a.b.c"},
+			[]schema.Point{{Val: 1.5, Ts: 10}, {Val: math.NaN(), Ts: 20}, {Val: -3, Ts: 30}},
+			`[{"target":"a.b.c","tags":{"name":"a.b.c"},"datapoints":[[1.5,10],[null,20],[-3,30]]}]`,
+		},
+		{
+			&schema.MetricDefinition{Name: "inf"},
+			[]schema.Point{{Val: math.Inf(1), Ts: 10}, {Val: math.Inf(-1), Ts: 20}},
+			`[{"target":"inf","tags":{"name":"inf"},"datapoints":[[null,10],[null,20]]}]`,
+		},
+		{
+			&schema.MetricDefinition{Name: "cpu", Tags: []string{"host=b", "dc=a"}},
+			nil,
+			`[{"target":"cpu;dc=a;host=b","tags":{"dc":"a","host":"b","name":"cpu"},"datapoints":[]}]`,
+		},
+	}
+	for _, c := range cases {
+		got, err := NewTimeSeries(c.def, c.points).ToGraphiteJSON()
+		if err != nil {
+			t.Fatalf("%s: unexpected error %s", c.def.Name, err)
+		}
+		if string(got) != c.exp {
+			t.Fatalf("%s: expected %s, got %s", c.def.Name, c.exp, got)
+		}
+	}
+
+	if _, err := NewTimeSeries(nil, nil).ToGraphiteJSON(); err != errNoDefinition {
+		t.Fatalf("expected error %q, got %v", errNoDefinition, err)
+	}
+}
@@ -0,0 +1,221 @@
+package mdata
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"os"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"unsafe"
+
+	"github.com/grafana/metrictank/idx"
+	"github.com/raintank/schema"
+	log "github.com/sirupsen/logrus"
+)
+
+// pointWAL holds the *PointWAL set by ReplayPointWAL when the point wal is enabled. nil otherwise.
+// it is accessed atomically, as ClosePointWAL may run while inputs are still adding points.
+var pointWAL atomic.Value
+
+const (
+	walMagic      = "MTPTWAL1"
+	walHeaderSize = 64
+	walRecordSize = 32 // 16 bytes key, 4 bytes org, 4 bytes ts, 8 bytes value
+)
+
+// PointWAL logs the raw points added to the in-memory chunks, so that the chunks that were not
+// saved yet can be reconstructed after a crash of the process.
+// it is a memory mapped file of a fixed size, consisting of a header and a ring of records
+// that wraps around, overwriting the oldest records. the ring should be big enough to hold
+// all points of the chunks that have not been saved yet.
+//
+// the header consists of:
+//
+//	<8 bytes magic><uint64 ring size><uint64 write offset>
+//
+// the write offset is the total number of bytes ever reserved in the ring. appends reserve their
+// record by advancing it atomically, so that concurrent appends don't contend on a lock.
+// a crash of the process while appends are in flight may leave their records incomplete.
+// as the data lives in the page cache, it survives a crash of the process, but not necessarily
+// of the machine.
+type PointWAL struct {
+	sync.RWMutex // read locked while accessing data, write locked to close
+	fd           *os.File
+	data         []byte
+	ring         []byte
+	size         uint64
+	offset       *uint64 // points into the header. accessed atomically
+	closed       bool    // once set, data is unmapped and must not be accessed
+}
+
+// OpenPointWAL opens the wal at the given path, creating it if needed.
+// size is the size of the ring in bytes. it must be a power of two, and match the size of an existing wal.
+func OpenPointWAL(path string, size int) (*PointWAL, error) {
+	if size < walRecordSize || size&(size-1) != 0 {
+		return nil, fmt.Errorf("wal size must be a power of two of at least %d bytes, got %d", walRecordSize, size)
+	}
+	fd, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return nil, err
+	}
+	info, err := fd.Stat()
+	if err != nil {
+		fd.Close()
+		return nil, err
+	}
+	fileSize := int64(walHeaderSize + size)
+	created := info.Size() == 0
+	if created {
+		err = fd.Truncate(fileSize)
+		if err != nil {
+			fd.Close()
+			return nil, err
+		}
+	} else if info.Size() != fileSize {
+		fd.Close()
+		return nil, fmt.Errorf("%s: file is %d bytes, expected %d for a wal of size %d", path, info.Size(), fileSize, size)
+	}
+	data, err := syscall.Mmap(int(fd.Fd()), 0, int(fileSize), syscall.PROT_READ|syscall.PROT_WRITE, syscall.MAP_SHARED)
+	if err != nil {
+		fd.Close()
+		return nil, err
+	}
+	w := &PointWAL{
+		fd:     fd,
+		data:   data,
+		ring:   data[walHeaderSize:],
+		size:   uint64(size),
+		offset: (*uint64)(unsafe.Pointer(&data[16])),
+	}
+	if created {
+		copy(data, walMagic)
+		binary.LittleEndian.PutUint64(data[8:], uint64(size))
+		return w, nil
+	}
+	if !bytes.Equal(data[:8], []byte(walMagic)) {
+		w.Close()
+		return nil, fmt.Errorf("%s: not a point wal", path)
+	}
+	if s := binary.LittleEndian.Uint64(data[8:]); s != uint64(size) {
+		w.Close()
+		return nil, fmt.Errorf("%s: wal was created with size %d, not %d", path, s, size)
+	}
+	return w, nil
+}
+
+// Append logs a point. it is a no-op once the wal is closed.
+func (w *PointWAL) Append(key schema.MKey, ts uint32, val float64) {
+	w.RLock()
+	if w.closed {
+		w.RUnlock()
+		return
+	}
+	offset := atomic.AddUint64(w.offset, walRecordSize) - walRecordSize
+	rec := w.ring[offset&(w.size-1):]
+	copy(rec, key.Key[:])
+	binary.LittleEndian.PutUint32(rec[16:], key.Org)
+	binary.LittleEndian.PutUint32(rec[20:], ts)
+	binary.LittleEndian.PutUint64(rec[24:], math.Float64bits(val))
+	w.RUnlock()
+}
+
+// Replay calls fn for all points in the wal, from oldest to newest, and returns the number of points.
+// the record at the oldest position is skipped, as it may have been partially overwritten by a write
+// that was interrupted.
+func (w *PointWAL) Replay(fn func(key schema.MKey, ts uint32, val float64)) int {
+	w.RLock()
+	defer w.RUnlock()
+	if w.closed {
+		return 0
+	}
+	end := atomic.LoadUint64(w.offset)
+	var start uint64
+	if end > w.size-walRecordSize {
+		start = end - (w.size - walRecordSize)
+	}
+	var n int
+	for offset := start; offset < end; offset += walRecordSize {
+		rec := w.ring[offset&(w.size-1):]
+		var key schema.MKey
+		copy(key.Key[:], rec)
+		key.Org = binary.LittleEndian.Uint32(rec[16:])
+		fn(key, binary.LittleEndian.Uint32(rec[20:]), math.Float64frombits(binary.LittleEndian.Uint64(rec[24:])))
+		n++
+	}
+	return n
+}
+
+// Close unmaps the wal and syncs it to disk. it waits for any in-flight Append.
+func (w *PointWAL) Close() error {
+	w.Lock()
+	defer w.Unlock()
+	if w.closed {
+		return nil
+	}
+	w.closed = true
+	err := syscall.Munmap(w.data)
+	if err == nil {
+		err = w.fd.Sync()
+	}
+	if cerr := w.fd.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// ReplayPointWAL adds the points in the point wal to the metrics, if the wal is enabled,
+// and logs all points added from then on.
+// points of series that are not in the index are skipped, as are points of chunks that
+// were saved already according to the persist notifiers.
+// it should be called after the index is loaded, the persist notifiers processed their backlog,
+// and before the inputs are started.
+func ReplayPointWAL(metrics Metrics, index idx.MetricIndex) {
+	if !walEnabled {
+		return
+	}
+	w, err := OpenPointWAL(walPath, walSize)
+	if err != nil {
+		log.Fatalf("point-wal: %s", err)
+	}
+	var unknown, saved int
+	n := w.Replay(func(key schema.MKey, ts uint32, val float64) {
+		archive, ok := index.Get(key)
+		if !ok {
+			unknown++
+			return
+		}
+		m := metrics.GetOrCreate(key, archive.SchemaId, archive.AggId)
+		if am, ok := m.(*AggMetric); ok && am.chunkSaved(ts) {
+			saved++
+			return
+		}
+		m.Add(ts, val)
+	})
+	log.Infof("point-wal: replayed %d points. skipped %d points of series not in the index and %d points of saved chunks", n-unknown-saved, unknown, saved)
+	pointWAL.Store(w)
+}
+
+// loadPointWAL returns the point wal, or nil if it is not enabled
+func loadPointWAL() *PointWAL {
+	w, _ := pointWAL.Load().(*PointWAL)
+	return w
+}
+
+// ClosePointWAL stops logging points and closes the point wal, if it is enabled.
+// it should be called after the inputs are stopped.
+func ClosePointWAL() {
+	w := loadPointWAL()
+	if w == nil {
+		return
+	}
+	pointWAL.Store((*PointWAL)(nil))
+	// inputs that loaded the wal before we cleared it may still call Append,
+	// which becomes a no-op once it is closed.
+	err := w.Close()
+	if err != nil {
+		log.Errorf("point-wal: failed to close: %s", err)
+	}
+}
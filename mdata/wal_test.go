@@ -0,0 +1,269 @@
+package mdata
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/grafana/metrictank/conf"
+	"github.com/grafana/metrictank/mdata/cache"
+	"github.com/grafana/metrictank/test"
+	"github.com/raintank/schema"
+)
+
+type walPoint struct {
+	key schema.MKey
+	ts  uint32
+	val float64
+}
+
+func replayAll(w *PointWAL) []walPoint {
+	var points []walPoint
+	w.Replay(func(key schema.MKey, ts uint32, val float64) {
+		points = append(points, walPoint{key, ts, val})
+	})
+	return points
+}
+
+func TestPointWALReplay(t *testing.T) {
+	dir, err := ioutil.TempDir("", "point-wal")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "point.wal")
+
+	// room for 4 records, of which the 3 newest are replayed
+	w, err := OpenPointWAL(path, 4*walRecordSize)
+	if err != nil {
+		t.Fatalf("could not open wal: %s", err)
+	}
+	key := test.GetMKey(1)
+	w.Append(key, 10, 1)
+	w.Append(key, 20, 2)
+	if err := w.Close(); err != nil {
+		t.Fatalf("could not close wal: %s", err)
+	}
+
+	w, err = OpenPointWAL(path, 4*walRecordSize)
+	if err != nil {
+		t.Fatalf("could not reopen wal: %s", err)
+	}
+	points := replayAll(w)
+	exp := []walPoint{{key, 10, 1}, {key, 20, 2}}
+	if len(points) != len(exp) || points[0] != exp[0] || points[1] != exp[1] {
+		t.Fatalf("expected points %v, got %v", exp, points)
+	}
+
+	// wrap around
+	other := test.GetMKey(2)
+	for i := 3; i <= 6; i++ {
+		w.Append(other, uint32(i*10), float64(i))
+	}
+	points = replayAll(w)
+	exp = []walPoint{{other, 40, 4}, {other, 50, 5}, {other, 60, 6}}
+	if len(points) != len(exp) {
+		t.Fatalf("expected points %v, got %v", exp, points)
+	}
+	for i := range exp {
+		if points[i] != exp[i] {
+			t.Fatalf("expected points %v, got %v", exp, points)
+		}
+	}
+	w.Close()
+
+	if _, err := OpenPointWAL(path, 8*walRecordSize); err == nil {
+		t.Fatalf("expected an error when opening the wal with another size")
+	}
+	if _, err := OpenPointWAL(filepath.Join(dir, "other.wal"), 100); err == nil {
+		t.Fatalf("expected an error for a size that is not a power of two")
+	}
+}
+
+// TestPointWALConcurrentAppend verifies that concurrent appends each get their own record
+func TestPointWALConcurrentAppend(t *testing.T) {
+	dir, err := ioutil.TempDir("", "point-wal")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	w, err := OpenPointWAL(filepath.Join(dir, "point.wal"), 1024*walRecordSize)
+	if err != nil {
+		t.Fatalf("could not open wal: %s", err)
+	}
+	defer w.Close()
+
+	writers, perWriter := 4, 200
+	var wg sync.WaitGroup
+	for i := 0; i < writers; i++ {
+		wg.Add(1)
+		go func(key schema.MKey) {
+			defer wg.Done()
+			for ts := 1; ts <= perWriter; ts++ {
+				w.Append(key, uint32(ts), float64(ts))
+			}
+		}(test.GetMKey(i))
+	}
+	wg.Wait()
+
+	last := make(map[schema.MKey]uint32)
+	for _, p := range replayAll(w) {
+		if p.ts != last[p.key]+1 || p.val != float64(p.ts) {
+			t.Fatalf("expected point %d of %s, got %v", last[p.key]+1, p.key, p)
+		}
+		last[p.key] = p.ts
+	}
+	if len(last) != writers {
+		t.Fatalf("expected points of %d series, got %d", writers, len(last))
+	}
+	for key, ts := range last {
+		if ts != uint32(perWriter) {
+			t.Fatalf("expected %d points of %s, got %d", perWriter, key, ts)
+		}
+	}
+}
+
+// TestAggMetricPointWAL verifies that only the points accepted by the AggMetric are logged,
+// and that points of saved chunks are recognized as such when replaying.
+func TestAggMetricPointWAL(t *testing.T) {
+	dir, err := ioutil.TempDir("", "point-wal")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	w, err := OpenPointWAL(filepath.Join(dir, "point.wal"), 16*walRecordSize)
+	if err != nil {
+		t.Fatalf("could not open wal: %s", err)
+	}
+	pointWAL.Store(w)
+	defer ClosePointWAL()
+
+	ret := []conf.Retention{conf.NewRetentionMT(1, 1, 10, 5, 0)}
+	m := NewAggMetric(mockstore, &cache.MockCache{}, test.GetAMKey(42), ret, 0, nil, false)
+	m.Add(10, 10)
+	m.Add(12, 12)
+	m.Add(11, 11) // out of order
+	m.Add(5, 5)   // goes into a previous chunk
+
+	key := test.GetAMKey(42).MKey
+	points := replayAll(w)
+	exp := []walPoint{{key, 10, 10}, {key, 12, 12}}
+	if len(points) != len(exp) || points[0] != exp[0] || points[1] != exp[1] {
+		t.Fatalf("expected points %v, got %v", exp, points)
+	}
+
+	if m.chunkSaved(12) {
+		t.Fatalf("expected chunk 10 not to be saved")
+	}
+	m.SyncChunkSaveState(10)
+	if !m.chunkSaved(12) {
+		t.Fatalf("expected chunk 10 to be saved")
+	}
+	if m.chunkSaved(20) {
+		t.Fatalf("expected chunk 20 not to be saved")
+	}
+}
+
+// TestPointWALAppendAfterClose verifies that appending to a closed wal, as inputs that
+// are still running during shutdown may do, doesn't touch the unmapped memory.
+func TestPointWALAppendAfterClose(t *testing.T) {
+	dir, err := ioutil.TempDir("", "point-wal")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	w, err := OpenPointWAL(filepath.Join(dir, "point.wal"), 4*walRecordSize)
+	if err != nil {
+		t.Fatalf("could not open wal: %s", err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("could not close wal: %s", err)
+	}
+	w.Append(test.GetMKey(1), 10, 1)
+	if n := w.Replay(func(schema.MKey, uint32, float64) {}); n != 0 {
+		t.Fatalf("expected no points to be replayed from a closed wal, got %d", n)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("expected closing the wal twice to succeed, got %s", err)
+	}
+}
+
+// TestPointWALCrashRecovery kills a process that is appending to the wal, and verifies that
+// the points it wrote survive. the newest point may be incomplete, as it may have been in the
+// middle of being written.
+// the process is this test binary, running this test with MT_POINT_WAL_CRASH_TEST set to the wal path.
+func TestPointWALCrashRecovery(t *testing.T) {
+	size := 1024 * walRecordSize
+	key := test.GetMKey(1)
+	if path := os.Getenv("MT_POINT_WAL_CRASH_TEST"); path != "" {
+		w, err := OpenPointWAL(path, size)
+		if err != nil {
+			os.Exit(1)
+		}
+		for i := uint32(1); ; i++ {
+			w.Append(key, i, float64(i))
+		}
+	}
+
+	dir, err := ioutil.TempDir("", "point-wal")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "point.wal")
+
+	cmd := exec.Command(os.Args[0], "-test.run=^TestPointWALCrashRecovery$")
+	cmd.Env = append(os.Environ(), "MT_POINT_WAL_CRASH_TEST="+path)
+	if err := cmd.Start(); err != nil {
+		t.Fatalf("could not start writer: %s", err)
+	}
+
+	// wait until the writer wrapped around a few times, then kill it mid-write
+	var w *PointWAL
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		if time.Now().After(deadline) {
+			cmd.Process.Kill()
+			t.Fatalf("writer did not write enough points in time")
+		}
+		// don't open the wal before the writer created it, or we would create it ourselves
+		if info, err := os.Stat(path); w == nil && err == nil && info.Size() > 0 {
+			w, _ = OpenPointWAL(path, size)
+		}
+		if w != nil && atomic.LoadUint64(w.offset) > uint64(3*size) {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	w.Close()
+	if err := cmd.Process.Kill(); err != nil {
+		t.Fatalf("could not kill writer: %s", err)
+	}
+	cmd.Wait()
+
+	w, err = OpenPointWAL(path, size)
+	if err != nil {
+		t.Fatalf("could not open wal after crash: %s", err)
+	}
+	defer w.Close()
+	last := uint32(atomic.LoadUint64(w.offset) / walRecordSize)
+	points := replayAll(w)
+	if len(points) != size/walRecordSize-1 {
+		t.Fatalf("expected %d points, got %d", size/walRecordSize-1, len(points))
+	}
+	// the points must be the last ones written, in order
+	for i, p := range points[:len(points)-1] {
+		exp := last - uint32(len(points)-1-i)
+		if p.key != key || p.ts != exp || p.val != float64(exp) {
+			t.Fatalf("point %d: expected %v, got %v", i, walPoint{key, exp, float64(exp)}, p)
+		}
+	}
+}
@@ -111,12 +111,11 @@ func NewCassandraStore(config *StoreConfig, ttls []uint32) (*CassandraStore, err
 	stats.NewGauge32("store.cassandra.num_writers").Set(config.WriteConcurrency)
 
 	cluster := gocql.NewCluster(strings.Split(config.Addrs, ",")...)
-	if config.SSL {
-		cluster.SslOpts = &gocql.SslOptions{
-			CaPath:                 config.CaPath,
-			EnableHostVerification: config.HostVerification,
-		}
+	sslOpts, err := config.SslOptions()
+	if err != nil {
+		return nil, err
 	}
+	cluster.SslOpts = sslOpts
 	if config.Auth {
 		cluster.Authenticator = gocql.PasswordAuthenticator{
 			Username: config.Username,
@@ -130,7 +129,6 @@ func NewCassandraStore(config *StoreConfig, ttls []uint32) (*CassandraStore, err
 	cluster.NumConns = config.WriteConcurrency
 	cluster.ProtoVersion = config.CqlProtocolVersion
 	cluster.DisableInitialHostLookup = config.DisableInitialHostLookup
-	var err error
 	tmpSession, err := cluster.CreateSession()
 	if err != nil {
 		log.Errorf("cassandra_store: failed to create cassandra session. %s", err.Error())
@@ -316,7 +314,7 @@ func (c *CassandraStore) processWriteQueue(queue chan *mdata.ChunkWriteRequest,
 			//log how long the chunk waited in the queue before we attempted to save to cassandra
 			cassPutWaitDuration.Value(time.Now().Sub(cwr.Timestamp))
 
-			buf := cwr.Chunk.Encode(cwr.Span)
+			buf := cwr.Chunk.EncodeWithCodec(cwr.Span, cwr.Codec)
 			chunkSizeAtSave.Value(len(buf))
 			success := false
 			attempts := 0
@@ -348,6 +346,17 @@ func (c *CassandraStore) processWriteQueue(queue chan *mdata.ChunkWriteRequest,
 	}
 }
 
+// WriteChunk writes the chunk to the table for the given ttl.
+// it is synchronous, so there is nothing to Flush.
+func (c *CassandraStore) WriteChunk(key schema.AMKey, t0, ttl uint32, data []byte) error {
+	return c.insertChunk(key.String(), t0, ttl, data)
+}
+
+// Flush implements mdata.ChunkWriter
+func (c *CassandraStore) Flush(ctx context.Context) error {
+	return nil
+}
+
 // Insert Chunks into Cassandra.
 //
 // key: is the metric_id
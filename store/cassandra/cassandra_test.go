@@ -116,3 +116,31 @@ func TestBackwardsCompatibleTimeoutFatalIfInvalid(t *testing.T) {
 		t.Fatalf("Process ran with err %v, want exit status 1", err)
 	}
 }
+
+// TestSSLConnection connects to a cassandra cluster that requires SSL.
+// it only runs when CASSANDRA_SSL_TEST is set, and is configured with:
+// * CASSANDRA_SSL_ADDRS: the cassandra hosts (default localhost:9042)
+// * CASSANDRA_SSL_CA_CERT: the CA certificate of the cluster. if empty, host verification is disabled
+// * CASSANDRA_SSL_CLIENT_CERT and CASSANDRA_SSL_CLIENT_KEY: the client certificate and key, if the cluster requires client authentication
+func TestSSLConnection(t *testing.T) {
+	if os.Getenv("CASSANDRA_SSL_TEST") == "" {
+		t.Skip("set CASSANDRA_SSL_TEST to run against a cassandra cluster with SSL")
+	}
+	cfg := NewStoreConfig()
+	cfg.Addrs = "localhost:9042"
+	if addrs := os.Getenv("CASSANDRA_SSL_ADDRS"); addrs != "" {
+		cfg.Addrs = addrs
+	}
+	cfg.SSL = true
+	cfg.CaPath = os.Getenv("CASSANDRA_SSL_CA_CERT")
+	cfg.HostVerification = cfg.CaPath != ""
+	cfg.ClientCertPath = os.Getenv("CASSANDRA_SSL_CLIENT_CERT")
+	cfg.ClientKeyPath = os.Getenv("CASSANDRA_SSL_CLIENT_KEY")
+	cfg.SchemaFile = "../../scripts/config/schema-store-cassandra.toml"
+
+	store, err := NewCassandraStore(cfg, []uint32{oneDay})
+	if err != nil {
+		t.Fatalf("failed to connect over SSL: %s", err)
+	}
+	store.Stop()
+}
@@ -1,8 +1,10 @@
 package cassandra
 
 import (
+	"errors"
 	"flag"
 
+	"github.com/gocql/gocql"
 	"github.com/grafana/globalconf"
 )
 
@@ -25,6 +27,8 @@ type StoreConfig struct {
 	DisableInitialHostLookup bool
 	SSL                      bool
 	CaPath                   string
+	ClientCertPath           string
+	ClientKeyPath            string
 	HostVerification         bool
 	Auth                     bool
 	Username                 string
@@ -53,6 +57,8 @@ func NewStoreConfig() *StoreConfig {
 		DisableInitialHostLookup: false,
 		SSL:                      false,
 		CaPath:                   "/etc/metrictank/ca.pem",
+		ClientCertPath:           "",
+		ClientKeyPath:            "",
 		HostVerification:         true,
 		Auth:                     false,
 		Username:                 "cassandra",
@@ -83,7 +89,9 @@ func ConfigSetup() *flag.FlagSet {
 	cas.BoolVar(&CliConfig.DisableInitialHostLookup, "disable-initial-host-lookup", CliConfig.DisableInitialHostLookup, "instruct the driver to not attempt to get host info from the system.peers table")
 	cas.BoolVar(&CliConfig.SSL, "ssl", CliConfig.SSL, "enable SSL connection to cassandra")
 	cas.StringVar(&CliConfig.CaPath, "ca-path", CliConfig.CaPath, "cassandra CA certificate path when using SSL")
-	cas.BoolVar(&CliConfig.HostVerification, "host-verification", CliConfig.HostVerification, "host (hostname and server cert) verification when using SSL")
+	cas.StringVar(&CliConfig.ClientCertPath, "client-cert-path", CliConfig.ClientCertPath, "client certificate path when using SSL, for cassandra clusters that require client authentication. requires client-key-path")
+	cas.StringVar(&CliConfig.ClientKeyPath, "client-key-path", CliConfig.ClientKeyPath, "client private key path when using SSL. requires client-cert-path")
+	cas.BoolVar(&CliConfig.HostVerification, "host-verification", CliConfig.HostVerification, "host (hostname and server cert) verification when using SSL. requires ca-path")
 	cas.BoolVar(&CliConfig.Auth, "auth", CliConfig.Auth, "enable cassandra authentication")
 	cas.StringVar(&CliConfig.Username, "username", CliConfig.Username, "username for authentication")
 	cas.StringVar(&CliConfig.Password, "password", CliConfig.Password, "password for authentication")
@@ -91,3 +99,23 @@ func ConfigSetup() *flag.FlagSet {
 	globalconf.Register("cassandra", cas, flag.ExitOnError)
 	return cas
 }
+
+// SslOptions returns the gocql ssl options for the config, or an error if the ssl settings are invalid.
+// it returns nil if SSL is not enabled
+func (c *StoreConfig) SslOptions() (*gocql.SslOptions, error) {
+	if !c.SSL {
+		return nil, nil
+	}
+	if c.HostVerification && c.CaPath == "" {
+		return nil, errors.New("ca-path must be set when host-verification is enabled")
+	}
+	if (c.ClientCertPath == "") != (c.ClientKeyPath == "") {
+		return nil, errors.New("client-cert-path and client-key-path must be set together")
+	}
+	return &gocql.SslOptions{
+		CaPath:                 c.CaPath,
+		CertPath:               c.ClientCertPath,
+		KeyPath:                c.ClientKeyPath,
+		EnableHostVerification: c.HostVerification,
+	}, nil
+}
@@ -0,0 +1,49 @@
+package cassandra
+
+import (
+	"testing"
+)
+
+func TestSslOptions(t *testing.T) {
+	cases := []struct {
+		name       string
+		ssl        bool
+		caPath     string
+		clientCert string
+		clientKey  string
+		verify     bool
+		ok         bool
+	}{
+		{"ssl disabled", false, "", "", "", true, true},
+		{"ca cert", true, "/etc/ca.pem", "", "", true, true},
+		{"verification without ca cert", true, "", "", "", true, false},
+		{"no verification without ca cert", true, "", "", "", false, true},
+		{"client cert and key", true, "/etc/ca.pem", "/etc/client.pem", "/etc/client.key", true, true},
+		{"client cert without key", true, "/etc/ca.pem", "/etc/client.pem", "", true, false},
+		{"client key without cert", true, "/etc/ca.pem", "", "/etc/client.key", true, false},
+	}
+	for _, c := range cases {
+		cfg := NewStoreConfig()
+		cfg.SSL = c.ssl
+		cfg.CaPath = c.caPath
+		cfg.ClientCertPath = c.clientCert
+		cfg.ClientKeyPath = c.clientKey
+		cfg.HostVerification = c.verify
+		opts, err := cfg.SslOptions()
+		if (err == nil) != c.ok {
+			t.Fatalf("%s: expected ok=%t, got err %v", c.name, c.ok, err)
+		}
+		if err != nil {
+			continue
+		}
+		if !c.ssl {
+			if opts != nil {
+				t.Fatalf("%s: expected no ssl options, got %+v", c.name, opts)
+			}
+			continue
+		}
+		if opts.CaPath != c.caPath || opts.CertPath != c.clientCert || opts.KeyPath != c.clientKey || opts.EnableHostVerification != c.verify {
+			t.Fatalf("%s: ssl options don't match the config: %+v", c.name, opts)
+		}
+	}
+}
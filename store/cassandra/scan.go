@@ -0,0 +1,147 @@
+package cassandra
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/grafana/metrictank/mdata"
+	"github.com/raintank/schema"
+)
+
+// scanRanges is the number of token ranges a table is split into when scanning it
+const scanRanges = 256
+
+const queryFmtScanKeys = "SELECT DISTINCT key FROM %s WHERE token(key) >= ? AND token(key) <= ?"
+
+// Scan streams the chunks of all metrics of the org that hold data between from (inclusive) and until (exclusive).
+// it scans the row keys of all tables range by range over the token ring, and searches the chunks
+// of every metric of the org that has rows in the requested time range.
+// a metric of which the chunks are spread over multiple tables (because its TTL changed) gets a result per table.
+func (c *CassandraStore) Scan(ctx context.Context, orgId uint32, from, until uint32) (<-chan mdata.ScanResult, error) {
+	if from >= until {
+		return nil, errInvalidRange
+	}
+	out := make(chan mdata.ScanResult)
+	go func() {
+		defer close(out)
+		send := func(res mdata.ScanResult) bool {
+			select {
+			case <-ctx.Done():
+				return false
+			case out <- res:
+				return true
+			}
+		}
+		for _, table := range c.scanTables() {
+			keys, err := c.scanKeys(ctx, table, orgId, from/Month_sec, (until-1)/Month_sec)
+			if err != nil {
+				send(mdata.ScanResult{Err: err})
+				return
+			}
+			for _, key := range keys {
+				itgens, err := c.SearchTable(ctx, key, table, from, until)
+				if err != nil {
+					send(mdata.ScanResult{Key: key, Err: err})
+					return
+				}
+				if ctx.Err() != nil {
+					return
+				}
+				if len(itgens) == 0 {
+					continue
+				}
+				if !send(mdata.ScanResult{Key: key, Chunks: itgens}) {
+					return
+				}
+			}
+		}
+	}()
+	return out, nil
+}
+
+// scanTables returns the tables of the store, without duplicates, ordered by name
+func (c *CassandraStore) scanTables() []Table {
+	seen := make(map[string]struct{})
+	var tables []Table
+	for _, table := range c.TTLTables {
+		if _, ok := seen[table.Name]; ok {
+			continue
+		}
+		seen[table.Name] = struct{}{}
+		tables = append(tables, table)
+	}
+	sort.Slice(tables, func(i, j int) bool { return tables[i].Name < tables[j].Name })
+	return tables
+}
+
+// scanKeys returns the keys of the metrics of the org that have a row in the table
+// for any of the months between startMonth and endMonth (inclusive)
+func (c *CassandraStore) scanKeys(ctx context.Context, table Table, orgId, startMonth, endMonth uint32) ([]schema.AMKey, error) {
+	query := fmt.Sprintf(queryFmtScanKeys, table.Name)
+	prefix := fmt.Sprintf("%d.", orgId)
+	seen := make(map[schema.AMKey]struct{})
+	var keys []schema.AMKey
+	for _, r := range tokenRanges(scanRanges) {
+		iter := c.Session.Query(query, r[0], r[1]).WithContext(ctx).Iter()
+		var rowKey string
+		for iter.Scan(&rowKey) {
+			if !strings.HasPrefix(rowKey, prefix) {
+				continue
+			}
+			key, month, err := parseRowKey(rowKey)
+			if err != nil || month < startMonth || month > endMonth {
+				continue
+			}
+			if _, ok := seen[key]; ok {
+				continue
+			}
+			seen[key] = struct{}{}
+			keys = append(keys, key)
+		}
+		err := iter.Close()
+		if err != nil {
+			if err == context.Canceled || err == context.DeadlineExceeded {
+				return nil, errCtxCanceled
+			}
+			errmetrics.Inc(err)
+			return nil, err
+		}
+	}
+	return keys, nil
+}
+
+// tokenRanges splits the token ring of the Murmur3Partitioner into n consecutive, inclusive ranges
+func tokenRanges(n int) [][2]int64 {
+	step := math.MaxUint64 / uint64(n)
+	ranges := make([][2]int64, n)
+	for i := range ranges {
+		// shift from [0, MaxUint64] to [MinInt64, MaxInt64]
+		ranges[i][0] = int64(uint64(i)*step ^ 1<<63)
+		if i > 0 {
+			ranges[i-1][1] = ranges[i][0] - 1
+		}
+	}
+	ranges[n-1][1] = math.MaxInt64
+	return ranges
+}
+
+// parseRowKey parses a row key of the form <AMKey>_<month>
+func parseRowKey(rowKey string) (schema.AMKey, uint32, error) {
+	pos := strings.LastIndex(rowKey, "_")
+	if pos == -1 {
+		return schema.AMKey{}, 0, fmt.Errorf("invalid row key %q", rowKey)
+	}
+	month, err := strconv.ParseUint(rowKey[pos+1:], 10, 32)
+	if err != nil {
+		return schema.AMKey{}, 0, fmt.Errorf("invalid row key %q: %s", rowKey, err)
+	}
+	key, err := schema.AMKeyFromString(rowKey[:pos])
+	if err != nil {
+		return schema.AMKey{}, 0, fmt.Errorf("invalid row key %q: %s", rowKey, err)
+	}
+	return key, uint32(month), nil
+}
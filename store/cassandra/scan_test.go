@@ -0,0 +1,61 @@
+package cassandra
+
+import (
+	"math"
+	"testing"
+
+	"github.com/raintank/schema"
+)
+
+func TestTokenRanges(t *testing.T) {
+	for _, n := range []int{1, 3, 256} {
+		ranges := tokenRanges(n)
+		if len(ranges) != n {
+			t.Fatalf("n=%d: expected %d ranges, got %d", n, n, len(ranges))
+		}
+		if ranges[0][0] != math.MinInt64 || ranges[n-1][1] != math.MaxInt64 {
+			t.Fatalf("n=%d: ranges %v don't cover the token ring", n, ranges)
+		}
+		for i, r := range ranges {
+			if r[0] > r[1] {
+				t.Fatalf("n=%d: range %d is empty: %v", n, i, r)
+			}
+			if i > 0 && ranges[i-1][1]+1 != r[0] {
+				t.Fatalf("n=%d: range %d doesn't follow range %d: %v %v", n, i, i-1, ranges[i-1], r)
+			}
+		}
+	}
+}
+
+func TestParseRowKey(t *testing.T) {
+	mkey, err := schema.MKeyFromString("1.01234567890123456789012345678901")
+	if err != nil {
+		t.Fatal(err)
+	}
+	archive, err := schema.ArchiveFromString("sum_600")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cases := []struct {
+		rowKey string
+		key    schema.AMKey
+		month  uint32
+	}{
+		{"1.01234567890123456789012345678901_640", schema.AMKey{MKey: mkey}, 640},
+		{"1.01234567890123456789012345678901_sum_600_641", schema.AMKey{MKey: mkey, Archive: archive}, 641},
+	}
+	for _, c := range cases {
+		key, month, err := parseRowKey(c.rowKey)
+		if err != nil {
+			t.Fatalf("row key %q: unexpected error %s", c.rowKey, err)
+		}
+		if key != c.key || month != c.month {
+			t.Fatalf("row key %q: expected %v %d, got %v %d", c.rowKey, c.key, c.month, key, month)
+		}
+	}
+	for _, rowKey := range []string{"", "1.01234567890123456789012345678901", "1.01234567890123456789012345678901_x", "foo_640"} {
+		if _, _, err := parseRowKey(rowKey); err == nil {
+			t.Fatalf("row key %q: expected an error", rowKey)
+		}
+	}
+}
@@ -0,0 +1,24 @@
+package util
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestExpandQueries(t *testing.T) {
+	cases := []struct {
+		in  string
+		out []string
+	}{
+		{"a.b.c", []string{"a.b.c"}},
+		{"a.{b,c}.d", []string{"a.b.d", "a.c.d"}},
+		{"{a,b}.{c,d}", []string{"a.c", "a.d", "b.c", "b.d"}},
+		{"a.{b.c", []string{"a.{b.c"}},
+	}
+	for i, c := range cases {
+		out := ExpandQueries(c.in)
+		if !reflect.DeepEqual(out, c.out) {
+			t.Errorf("case %d -> expected %v, got %v", i, c.out, out)
+		}
+	}
+}